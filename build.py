@@ -3,15 +3,21 @@ Builds the index.html file from configured blocks for multiple languages
 using a class-based approach with protocols.
 """
 
+import argparse
+import functools
+import io
 import json
+import logging
 import os
 import sys
-from typing import Any, Dict, List, Optional
+import tempfile
+import threading
+from typing import IO, Any, Callable, Dict, Iterator, List, Optional, Tuple
 
 from google.protobuf import descriptor_pool
 from google.protobuf.message import Message
 from google.protobuf.message_factory import GetMessageClass
-from jinja2 import Environment, FileSystemLoader
+from jinja2 import Environment, TemplateError
 
 # Ensure the project root (and thus 'generated' directory) is in the Python path
 # This allows for direct execution of this script.
@@ -25,23 +31,198 @@ if generated_dir not in sys.path:
 
 # Application-specific imports (Protobuf and services)
 # Generated Protobuf message class imports
+from build_protocols import (  # noqa: F401  (registers generators and sinks)
+    analytics,
+    consent,
+    content_api,
+    deploy_buckets,
+    deploy_hosts,
+    edge_split,
+    feeds,
+    form_email,
+    form_notifications,
+    form_storage,
+    forms,
+    outbound_links,
+    redirects,
+    security_headers,
+    site_files,
+    sitemaps,
+    staging,
+    structured_data,
+)
+from build_protocols.atomic_io import atomic_write, write_text_atomic
+from build_protocols.backend import BackendApp, run_backend
+from build_protocols.breadcrumbs import (
+    breadcrumb_list_json_ld,
+    resolve_breadcrumbs,
+)
+from build_protocols.build_cache import (
+    DEFAULT_CACHE_DIR,
+    BuildCache,
+    cache_stats,
+    clean_cache,
+    format_cache_stats,
+    parse_duration,
+)
+from build_protocols.build_logging import LOG_FORMATS, configure_logging
+from build_protocols.build_metrics import (
+    DEFAULT_MANIFEST_PATH,
+    BuildTimer,
+    build_manifest,
+    format_timing_table,
+    write_build_manifest,
+)
+from build_protocols.build_report import (
+    DEFAULT_BUILD_REPORT_PATH,
+    render_build_report,
+    translation_coverage,
+    variant_inventory,
+)
+from build_protocols.canonical import (
+    find_unresolved_canonicals,
+    resolve_canonical_url,
+)
 from build_protocols.config_management import DefaultAppConfigManager
 from build_protocols.data_loading import InMemoryDataCache, JsonProtoDataLoader
+from build_protocols.deploy import (
+    DEFAULT_EXCLUDE,
+    DEFAULT_INCLUDE,
+    DEFAULT_PREVIEWS_DIRECTORY,
+    DEPLOY_TARGET_REGISTRY,
+    DEPLOY_TIMEOUT_SETTINGS,
+    DeployError,
+    collect_site_files,
+    current_branch,
+    format_summary,
+    preview_directory,
+    select_host_config,
+)
+from build_protocols.dev_server import (
+    REBUILD_PATHS,
+    SourceWatcher,
+    create_dev_certificate,
+    create_ssl_context,
+    serve,
+)
+from build_protocols.form_email import send_test_email
+from build_protocols.form_storage import (
+    SUBMISSIONS_PATH,
+    SqliteFormSink,
+    SubmissionsHandler,
+)
+from build_protocols.forms import (
+    CONTACT_FORM_PATH,
+    ContactFormHandler,
+    create_form_sinks,
+)
 from build_protocols.html_generation import (
     HTML_GENERATOR_REGISTRY,
 )
 from build_protocols.interfaces import (
     AppConfigManager,
+    BuildContext,
     DataCache,
     DataLoader,
+    DeploySummary,
     HtmlBlockGenerator,
     PageBuilder,
+    SiteArtifactGenerator,
     TranslationProvider,
     Translations,
 )
-from build_protocols.page_assembly import DefaultPageBuilder
+from build_protocols.issues import (
+    DEFAULT_REPORT_FORMAT,
+    DEFAULT_REPORT_PATHS,
+    ERROR,
+    ISSUE_REPORTER_REGISTRY,
+    LOG_LEVELS,
+    WARNING,
+    Issue,
+    IssueError,
+    apply_issue_levels,
+    format_issue_summary,
+    parse_issue_levels,
+    summarize_issues,
+)
+from build_protocols.newsletter import NEWSLETTER_PATH, NewsletterHandler
+from build_protocols.page_assembly import DefaultPageBuilder, PageAssemblyError
+from build_protocols.performance import (
+    PageWeight,
+    check_budgets,
+    format_weight_breakdown,
+    measure_page,
+)
+from build_protocols.profiling import profiled, write_trace
+from build_protocols.rebuilds import (
+    REBUILD_STATUS_PATH,
+    WEBHOOK_PATH,
+    RebuildQueue,
+    RebuildStatusHandler,
+    WebhookHandler,
+    run_command_build,
+)
+from build_protocols.redirects import load_redirect_rules
+from build_protocols.seo import (
+    resolve_page_title,
+    resolve_seo_meta,
+    validate_meta_lengths,
+)
+from build_protocols.site_artifacts import merge_page_contexts, ordered_generators
+from build_protocols.site_diff import (
+    SiteDiff,
+    diff_directories,
+    diff_trees,
+    format_site_diff,
+    load_manifest_files,
+    snapshot_files,
+)
+from build_protocols.site_urls import (
+    absolute_url,
+    apply_base_path,
+    localized_filename,
+    page_filename,
+    page_url,
+    prefix_root_relative_urls,
+    site_base_path,
+)
+from build_protocols.spam_protection import SpamGuard
+from build_protocols.staging import get_staging_settings, staging_credentials
+from build_protocols.template_env import (
+    create_template_environment,
+    invalidate_templates,
+)
+from build_protocols.timeouts import OperationCancelled, cancel_on_signal
 from build_protocols.translation import DefaultTranslationProvider
+from build_protocols.variants import (
+    VARIANT_MODES,
+    seeded_variation,
+    variant_blocks,
+    variant_filename,
+    variation_ids,
+    with_variation,
+)
+from build_protocols.visual_regression import (
+    VisualResult,
+    find_chrome,
+    run_visual_tests,
+    serve_directory,
+    write_report,
+)
+from build_protocols.work_queue import (
+    DEFAULT_PROGRESS_SECONDS,
+    DEFAULT_WORKERS,
+    PageJob,
+    ProgressReporter,
+    run_jobs,
+)
+from generated.contact_form_config_pb2 import ContactFormConfig
 from generated.nav_item_pb2 import Navigation
+from generated.newsletter_pb2 import NewsletterConfig
+from generated.seo_meta_pb2 import SeoConfig
+
+# Named explicitly: run as a script, this module is `__main__`.
+logger = logging.getLogger("build")
 
 
 class BuildOrchestrator:
@@ -50,6 +231,10 @@ class BuildOrchestrator:
 
     This class coordinates the loading of configurations, data, and translations,
     and then assembles HTML pages for each supported language.
+
+    Pages may be built on several threads at once: what building a page
+    records on the orchestrator (written files, issues, canonical URLs and
+    translations) is guarded by `_lock`.
     """
 
     PROTO_PACKAGE_NAME = "website_content.v1"
@@ -62,6 +247,12 @@ class BuildOrchestrator:
         data_cache: DataCache[Message],
         page_builder: PageBuilder,
         html_generators: Dict[str, HtmlBlockGenerator],
+        artifact_generators: Dict[str, SiteArtifactGenerator],
+        jinja_env: Environment,
+        build_profile: str = "production",
+        base_path: Optional[str] = None,
+        variant_seed: Optional[str] = None,
+        all_variants: bool = False,
     ):
         """Initializes the BuildOrchestrator with necessary service components.
 
@@ -77,23 +268,63 @@ class BuildOrchestrator:
             page_builder: Assembles the final HTML page from various parts.
             html_generators: A dictionary mapping block names to their
                 respective HTML generator instances.
+            artifact_generators: A dictionary mapping names to site artifact
+                generators (feeds, etc.) run alongside page assembly.
+            jinja_env: The Jinja2 environment used for page-level templates
+                such as error pages.
+            build_profile: The build profile (e.g., "production" or
+                "preview"). Generators use it to skip production-only output
+                such as analytics.
+            base_path: The path the site is served under (e.g.,
+                "/previews/feature-x/"), if not the path of `base_url`.
+            variant_seed: Makes the choice of block variants without a
+                default reproducible (see `variants.py`).
+            all_variants: Also builds a page per block variant.
         """
         self.app_config_manager = app_config_manager
         self.translation_provider = translation_provider
         self.data_loader = data_loader
         self.data_cache = data_cache
+        self.data_snapshot: DataCache[Message] = data_cache
         self.page_builder = page_builder
         self.html_generators = html_generators
+        self.artifact_generators = artifact_generators
+        self.jinja_env = jinja_env
+        self.build_profile = build_profile
+        self.base_path = base_path
+        self.variant_seed = variant_seed
+        self.all_variants = all_variants
 
         self.app_config: Dict[str, Any] = {}
         self.nav_proto_data: Optional[Navigation] = None
+        self.seo_config: Optional[SeoConfig] = None
+        self.build_context: Optional[BuildContext] = None
+        self.written_files: List[str] = []
+        self.canonical_urls: Dict[str, str] = {}
+        self.variant_pages: Dict[str, Dict[str, str]] = {}
+        self.issues: List[Issue] = []
+        self.page_weights: Optional[List[PageWeight]] = None
+        self.issue_levels: Dict[str, Optional[str]] = {}
+        self.timer = BuildTimer()
+        self._lock = threading.Lock()
 
     def load_initial_configurations(self) -> None:
         """Loads base configurations like app config and navigation data.
 
-        This method populates `self.app_config` and `self.nav_proto_data`.
+        This method populates `self.app_config`, `self.nav_proto_data` and,
+        if `seo_data_file` is configured, `self.seo_config`.
         """
         self.app_config = self.app_config_manager.load_app_config()
+        base_path = self.base_path or self.app_config.get("base_path")
+        if base_path:
+            self.app_config = apply_base_path(self.app_config, base_path)
+        try:
+            self.issue_levels = parse_issue_levels(
+                self.app_config.get("issue_levels", {})
+            )
+        except ValueError as e:
+            logger.error("Ignoring issue_levels: %s", e)
+            self.issue_levels = {}
 
         nav_data_file = self.app_config.get(
             "navigation_data_file", "data/navigation.json"
@@ -106,40 +337,521 @@ class BuildOrchestrator:
             Navigation,  # type: ignore
         )
 
-    def _process_language(
+        seo_data_file = self.app_config.get("seo_data_file")
+        if seo_data_file:
+            self.seo_config = self.data_loader.load_dynamic_single_item_data(
+                seo_data_file,
+                SeoConfig,  # type: ignore
+            )
+
+    def _page_job(
+        self, output_path: str, lang: str, build: Callable[[], None]
+    ) -> PageJob:
+        """Wraps building a page as a job timed as an `assemble` phase."""
+
+        def run() -> None:
+            with self.timer.phase("assemble", lang=lang):
+                build()
+
+        return PageJob(output_path, run)
+
+    def _language_page_jobs(
         self,
         lang: str,
         default_lang: str,
         dynamic_data_loaders_config: Dict[str, Dict[str, Any]],
         navigation_items: List[Dict[str, Any]],
-    ) -> None:
-        """Processes and builds the page for a single language."""
-        print(f"Processing language: {lang}")
+    ) -> Iterator[PageJob]:
+        """Prepares a language and yields the jobs building its pages.
+
+        The language's translations, config file and SEO checks are handled
+        here, on the producing thread; the jobs only render and write.
+        """
+        logger.info("Processing language", extra={"lang": lang})
         translations = self.translation_provider.load_translations(lang)
+        if self.build_context is not None:
+            with self._lock:
+                self.build_context.translations_by_lang[lang] = translations
 
         self._generate_language_specific_config(lang, translations)
 
-        assembled_main_content = self._assemble_main_content_for_lang(
-            lang, translations, dynamic_data_loaders_config
+        output_filename = page_filename(lang, default_lang)
+        canonical_url = resolve_canonical_url(
+            self.app_config, "index", lang, default_lang
         )
+        if canonical_url:
+            with self._lock:
+                self.canonical_urls[output_filename] = canonical_url
 
         page_title = translations.get("page_title_default", "Simple Landing Page")
         # Add specific page titles per language if defined, e.g. "page_title_landing_es"
         page_title = translations.get(f"page_title_landing_{lang}", page_title)
 
-        full_html_content = self.page_builder.assemble_translated_page(
+        seo_meta = resolve_seo_meta(self.seo_config, "index", lang)
+        if self.seo_config is not None:
+            self._report_issues(
+                validate_meta_lengths(
+                    resolve_page_title(seo_meta, translations, page_title),
+                    translations.get(seo_meta.description.key, ""),
+                    "index",
+                    lang,
+                    output_filename,
+                )
+            )
+
+        page_context = merge_page_contexts(
+            [
+                self._collect_page_context(lang),
+                self._breadcrumb_context("index", lang, default_lang, translations),
+            ]
+        )
+
+        page_args: Dict[str, Any] = {
+            "lang": lang,
+            "translations": translations,
+            "navigation_items": navigation_items,
+            "page_title": page_title,
+            "extra_context": page_context,
+            "seo_meta": seo_meta,
+            "page_url": self._page_url(lang, default_lang),
+            "canonical_url": canonical_url,
+        }
+        blocks = self.app_config.get("blocks", [])
+        data_overrides = self._seeded_variants(output_filename, blocks)
+        yield self._page_job(
+            output_filename,
+            lang,
+            functools.partial(
+                self._build_page,
+                output_filename,
+                functools.partial(
+                    self._write_main_content_for_lang,
+                    lang,
+                    translations,
+                    dynamic_data_loaders_config,
+                    data_overrides=data_overrides,
+                ),
+                **page_args,
+            ),
+        )
+        if self.all_variants:
+            yield from self._variant_page_jobs(
+                output_filename,
+                blocks,
+                dynamic_data_loaders_config,
+                data_overrides,
+                {**page_args, "canonical_url": canonical_url or page_args["page_url"]},
+            )
+
+        yield from self._error_page_jobs(
+            lang,
+            default_lang,
+            translations,
+            dynamic_data_loaders_config,
+            navigation_items,
+        )
+
+    def _breadcrumb_context(
+        self, page_id: str, lang: str, default_lang: str, translations: Translations
+    ) -> Dict[str, Any]:
+        """Returns a page's breadcrumbs and their JSON-LD; empty without a trail."""
+        breadcrumbs = resolve_breadcrumbs(
+            self.app_config, page_id, lang, default_lang, translations
+        )
+        if not breadcrumbs:
+            return {}
+        return {
+            "breadcrumbs": breadcrumbs,
+            "structured_data": [breadcrumb_list_json_ld(breadcrumbs)],
+        }
+
+    def _seeded_variants(self, page_file: str, blocks: List[str]) -> Dict[str, Any]:
+        """Picks the variant of each block of a page with the `--variant-seed`.
+
+        Returns:
+            The data to render instead per block name; empty without a seed.
+        """
+        if self.variant_seed is None or self.build_context is None:
+            return {}
+        return {
+            block: with_variation(
+                data,
+                seeded_variation(data, self.variant_seed, f"{page_file}:{block}"),
+            )
+            for block, data in variant_blocks(
+                self.build_context.block_data, blocks
+            ).items()
+        }
+
+    def _variant_page_jobs(
+        self,
+        page_file: str,
+        blocks: List[str],
+        dynamic_data_loaders_config: Dict[str, Dict[str, Any]],
+        data_overrides: Dict[str, Any],
+        page_args: Dict[str, Any],
+    ) -> Iterator[PageJob]:
+        """Yields a job per variant of the page's blocks with variants.
+
+        Each variant page is recorded in `variant_pages` for the manifest.
+        """
+        if self.build_context is None:
+            return
+        lang = page_args["lang"]
+        block_data = self.build_context.block_data
+        for block, data in variant_blocks(block_data, blocks).items():
+            for variation_id in variation_ids(data):
+                output_filename = variant_filename(page_file, variation_id)
+                with self._lock:
+                    self.variant_pages.setdefault(page_file, {})[
+                        variation_id
+                    ] = output_filename
+                yield self._page_job(
+                    output_filename,
+                    lang,
+                    functools.partial(
+                        self._build_page,
+                        output_filename,
+                        functools.partial(
+                            self._write_main_content_for_lang,
+                            lang,
+                            page_args["translations"],
+                            dynamic_data_loaders_config,
+                            data_overrides={
+                                **data_overrides,
+                                block: with_variation(data, variation_id),
+                            },
+                        ),
+                        **page_args,
+                    ),
+                )
+
+    def _error_page_jobs(
+        self,
+        lang: str,
+        default_lang: str,
+        translations: Translations,
+        dynamic_data_loaders_config: Dict[str, Dict[str, Any]],
+        navigation_items: List[Dict[str, Any]],
+    ) -> Iterator[PageJob]:
+        """Yields jobs building the configured error pages (e.g., 404.html).
+
+        Each page renders its `template` followed by its optional `blocks`.
+        Error pages are served for arbitrary paths, so they set a `<base>`
+        pointing at the site root to keep styles and anchors working, and are
+        marked `noindex`.
+        """
+        settings = self.app_config.get("error_pages", {})
+        if not settings.get("enabled", False):
+            return
+
+        for code, page_cfg in settings.get("pages", {}).items():
+            output_filename = localized_filename(str(code), ".html", lang, default_lang)
+            yield self._page_job(
+                output_filename,
+                lang,
+                functools.partial(
+                    self._build_error_page,
+                    output_filename,
+                    str(code),
+                    page_cfg,
+                    lang,
+                    default_lang,
+                    translations,
+                    dynamic_data_loaders_config,
+                    navigation_items,
+                    self._seeded_variants(output_filename, page_cfg.get("blocks", [])),
+                ),
+            )
+
+    def _build_error_page(
+        self,
+        output_filename: str,
+        code: str,
+        page_cfg: Dict[str, Any],
+        lang: str,
+        default_lang: str,
+        translations: Translations,
+        dynamic_data_loaders_config: Dict[str, Dict[str, Any]],
+        navigation_items: List[Dict[str, Any]],
+        data_overrides: Dict[str, Any],
+    ) -> None:
+        """Renders an error page's template and builds the page around it."""
+        # Analytics, the consent banner and the like apply to error pages too,
+        # but the JSON-LD entities describe the landing page's content. The
+        # generators' robots value (e.g., staging's) wins over "noindex".
+        page_context = {
+            key: value
+            for key, value in self._collect_page_context(lang).items()
+            if key != "structured_data"
+        }
+        template_name = page_cfg.get("template", "blocks/error.html")
+        title_key = page_cfg.get("title_key", f"error_{code}_title")
+        try:
+            error_content = self.jinja_env.get_template(template_name).render(
+                code=code,
+                title_key=title_key,
+                message_key=page_cfg.get("message_key", f"error_{code}_message"),
+                home_url=page_filename(lang, default_lang),
+                translations=translations,
+            )
+        except Exception as e:  # pylint: disable=broad-except
+            logger.error(
+                "Could not render error page %s: %s. Skipping.",
+                code,
+                e,
+                extra={"lang": lang, "file": template_name},
+            )
+            return
+
+        self._build_page(
+            output_filename,
+            functools.partial(
+                self._write_error_page_content,
+                error_content=error_content,
+                extra_blocks=page_cfg.get("blocks", []),
+                lang=lang,
+                translations=translations,
+                data_loaders_config=dynamic_data_loaders_config,
+                data_overrides=data_overrides,
+            ),
             lang=lang,
             translations=translations,
-            main_content=assembled_main_content,
             navigation_items=navigation_items,
-            page_title=page_title,
+            page_title=translations.get(title_key, str(code)),
+            extra_context=merge_page_contexts(
+                [
+                    {"base_href": site_base_path(self.app_config), "robots": "noindex"},
+                    page_context,
+                    self._breadcrumb_context(code, lang, default_lang, translations),
+                ]
+            ),
+        )
+
+    def _write_error_page_content(
+        self,
+        out: IO[str],
+        error_content: str,
+        extra_blocks: List[str],
+        lang: str,
+        translations: Translations,
+        data_loaders_config: Dict[str, Dict[str, Any]],
+        data_overrides: Optional[Dict[str, Any]] = None,
+    ) -> None:
+        """Writes an error page's message followed by its extra blocks."""
+        out.write(error_content)
+        if extra_blocks:
+            out.write("\n")
+            self._write_main_content_for_lang(
+                lang,
+                translations,
+                data_loaders_config,
+                out,
+                extra_blocks,
+                data_overrides=data_overrides,
+            )
+
+    def _build_page(
+        self,
+        output_path: str,
+        write_main_content: Callable[[IO[str]], None],
+        **page_args: Any,
+    ) -> None:
+        """Assembles a page around its main content and writes it.
+
+        With `page_streaming` enabled, the page is streamed into its output
+        file as it renders (see `_stream_page`). Otherwise, or while
+        anything needs the whole page, it is rendered to a string first.
+
+        Args:
+            output_path: The path to write the page to.
+            write_main_content: Writes the page's main content to a stream.
+            **page_args: Passed on to the page builder.
+        """
+        if self._streams_pages():
+            self._stream_page(output_path, write_main_content, page_args)
+            return
+        main_content = io.StringIO()
+        write_main_content(main_content)
+        html = self.page_builder.assemble_translated_page(
+            main_content=main_content.getvalue(), **page_args
+        )
+        self._write_page(output_path, html)
+
+    def _streams_pages(self) -> bool:
+        """Returns whether pages can be streamed to disk.
+
+        Streaming needs the `page_streaming` section enabled, and is off
+        while a base path is applied or an artifact generator processes
+        pages, since both rewrite the page as a whole.
+        """
+        if not self.app_config.get("page_streaming", {}).get("enabled", False):
+            return False
+        if self.app_config.get("base_path"):
+            return False
+        if self.build_context is None:
+            return True
+        return not any(
+            generator.processes_pages(self.build_context)
+            for generator in self.artifact_generators.values()
+        )
+
+    def _stream_page(
+        self,
+        output_path: str,
+        write_main_content: Callable[[IO[str]], None],
+        page_args: Dict[str, Any],
+    ) -> None:
+        """Writes a page to its output file as it renders.
+
+        The file is replaced atomically once complete; if the page cannot
+        be assembled, the previous version is kept.
+        """
+        logger.info("Writing file", extra={"file": output_path})
+        try:
+            with atomic_write(output_path) as out:
+                self.page_builder.write_translated_page(
+                    out, write_main_content=write_main_content, **page_args
+                )
+            with self._lock:
+                self.written_files.append(output_path)
+        except (IOError, PageAssemblyError) as e:
+            logger.error("Could not write file: %s", e, extra={"file": output_path})
+
+    def _write_page(self, output_path: str, html: str) -> None:
+        """Passes a rendered page through the artifact generators and writes it."""
+        if self.build_context is not None:
+            for name, generator in self.artifact_generators.items():
+                try:
+                    html = generator.process_page(
+                        output_path, html, self.build_context
+                    )
+                except Exception as e:  # pylint: disable=broad-except
+                    logger.error(
+                        "Could not process page: %s.",
+                        e,
+                        extra={"component": name, "file": output_path},
+                    )
+        if self.app_config.get("base_path"):
+            html = prefix_root_relative_urls(html, self.app_config["base_path"])
+        self._write_output_file(output_path, html)
+
+    def _page_url(self, lang: str, default_lang: str) -> Optional[str]:
+        """Returns the absolute page URL, or None if no base_url is set."""
+        base_url = self.app_config.get("base_url")
+        if not base_url:
+            return None
+        return page_url(base_url, lang, default_lang)
+
+    def _collect_page_context(self, lang: str) -> Dict[str, Any]:
+        """Gathers extra page template variables from the artifact generators."""
+        if self.build_context is None:
+            return {}
+        return merge_page_contexts(
+            [
+                generator.get_page_context(lang, self.build_context)
+                for generator in self.artifact_generators.values()
+            ]
+        )
+
+    def _verify_canonical_targets(self) -> None:
+        """Warns about canonical links that do not point at a generated file."""
+        base_url = self.app_config.get("base_url")
+        if not base_url or not self.app_config.get("canonical", {}).get(
+            "verify", True
+        ):
+            return
+        redirect_settings = self.app_config.get("redirects", {})
+        redirect_sources = (
+            [rule.source for rule in load_redirect_rules(redirect_settings)]
+            if redirect_settings.get("enabled", False)
+            else []
+        )
+        self._report_issues(
+            find_unresolved_canonicals(
+                base_url, self.canonical_urls, self.written_files, redirect_sources
+            )
         )
 
-        output_filename = f"index_{lang}.html"
-        if lang == default_lang:
-            output_filename = "index.html"
+    def _report_issues(self, issues: List[Issue]) -> None:
+        """Logs issues found by a check and keeps them for the issue report.
+
+        The `issue_levels` section sets the severity of their categories.
+        """
+        issues = apply_issue_levels(issues, self.issue_levels)
+        for issue in issues:
+            message = issue.message
+            if issue.suggestion:
+                message += f" {issue.suggestion}"
+            logger.log(
+                LOG_LEVELS.get(issue.severity, logging.WARNING),
+                message,
+                extra={"component": issue.checker, "file": issue.location() or None},
+            )
+        with self._lock:
+            self.issues.extend(issues)
+
+    def _measure_pages(self) -> List[PageWeight]:
+        """Measures every generated page, once per build."""
+        if self.page_weights is not None:
+            return self.page_weights
+        base_url = self.app_config.get("base_url", "")
+        self.page_weights = []
+        for output_path in self.written_files:
+            if not output_path.endswith(".html"):
+                continue
+            try:
+                with open(output_path, "r", encoding="utf-8") as page_file:
+                    html = page_file.read()
+            except IOError as e:
+                logger.warning(
+                    "Could not measure page: %s",
+                    e,
+                    extra={"component": "performance", "file": output_path},
+                )
+                continue
+            self.page_weights.append(measure_page(output_path, html, base_url))
+        return self.page_weights
+
+    def _check_performance_budgets(self) -> None:
+        """Measures every generated page and checks the performance budgets.
+
+        Violations are errors in a strict build and warnings otherwise.
+        """
+        settings = self.app_config.get("performance_budgets", {})
+        if not settings.get("enabled", False):
+            return
+        severity = ERROR if settings.get("strict", False) else WARNING
+        violations: List[Issue] = []
+        for weight in self._measure_pages():
+            if settings.get("report", True):
+                logger.info(
+                    format_weight_breakdown(weight),
+                    extra={"component": "performance", "file": weight.page},
+                )
+            violations.extend(
+                check_budgets(weight, settings.get("budgets", {}), severity)
+            )
+
+        self._report_issues(violations)
 
-        self._write_output_file(output_filename, full_html_content)
+    def _generate_site_artifacts(self) -> None:
+        """Runs every artifact generator and writes the files they produce."""
+        if self.build_context is None:
+            return
+        for name, generator in self.artifact_generators.items():
+            try:
+                with self.timer.phase("artifacts", component=name):
+                    artifacts = generator.generate_artifacts(self.build_context)
+            except Exception as e:  # pylint: disable=broad-except
+                logger.error(
+                    "Could not generate site artifacts: %s. Skipping.",
+                    e,
+                    extra={"component": name},
+                )
+                continue
+            for output_path, content in artifacts.items():
+                self._write_output_file(output_path, content)
 
     def build_all_languages(self) -> None:
         """Builds pages for all supported languages.
@@ -147,8 +859,18 @@ class BuildOrchestrator:
         This is the main entry point for the build process after initialization.
         It orchestrates loading, data preloading, and iterates through each
         supported language to generate the respective HTML output.
+
+        Raises:
+            IssueError: If a check reported an issue with the severity error
+                (e.g., a performance budget in a strict build). The output,
+                the issue report and the build manifest are written first.
         """
-        self.load_initial_configurations()
+        self.timer = BuildTimer()
+        self.issues = []
+        self.page_weights = None
+        self.variant_pages = {}
+        with self.timer.phase("config"):
+            self.load_initial_configurations()
 
         supported_langs: List[str] = self.app_config.get(
             "supported_langs", ["en", "es"]
@@ -165,8 +887,9 @@ class BuildOrchestrator:
         for block_name, config_item in block_loaders_config_raw.items():
             message_type_name = config_item.get("message_type_name")
             if not message_type_name:
-                print(
-                    f"Warning: Missing 'message_type_name' for block '{block_name}'. Skipping."
+                logger.warning(
+                    "Missing 'message_type_name'. Skipping.",
+                    extra={"block": block_name},
                 )
                 continue
 
@@ -174,15 +897,20 @@ class BuildOrchestrator:
             descriptor = pool.FindMessageTypeByName(full_message_name)
 
             if descriptor is None:
-                print(
-                    f"Warning: Could not find protobuf message type '{full_message_name}' for block '{block_name}'. Ensure .proto files are compiled and imported. Skipping."
+                logger.warning(
+                    "Could not find protobuf message type '%s'. Ensure .proto files "
+                    "are compiled and imported. Skipping.",
+                    full_message_name,
+                    extra={"block": block_name},
                 )
                 continue
 
             message_type_class = GetMessageClass(descriptor)
             if not message_type_class:  # Should not happen if descriptor is found
-                print(
-                    f"Warning: Could not get message class for '{full_message_name}' for block '{block_name}'. Skipping."
+                logger.warning(
+                    "Could not get message class for '%s'. Skipping.",
+                    full_message_name,
+                    extra={"block": block_name},
                 )
                 continue
 
@@ -191,8 +919,24 @@ class BuildOrchestrator:
             resolved_item_config["message_type"] = message_type_class
             dynamic_data_loaders_config_resolved[block_name] = resolved_item_config
 
-        self.data_cache.preload_data(
-            dynamic_data_loaders_config_resolved, self.data_loader
+        with self.timer.phase("data"):
+            self.data_cache.preload_data(
+                dynamic_data_loaders_config_resolved, self.data_loader
+            )
+        # Every page of this build reads the same data, even if a shared
+        # cache is invalidated while the build runs.
+        self.data_snapshot = self.data_cache.snapshot()
+
+        self.build_context = BuildContext(
+            app_config=self.app_config,
+            default_lang=default_lang,
+            supported_langs=supported_langs,
+            build_profile=self.build_profile,
+            variant_pages=self.variant_pages,
+            block_data={
+                block_name: self.data_snapshot.get_item(loader_cfg["data_file"])
+                for block_name, loader_cfg in dynamic_data_loaders_config_resolved.items()
+            },
         )
 
         os.makedirs("public/generated_configs", exist_ok=True)
@@ -211,15 +955,139 @@ class BuildOrchestrator:
                     }
                 )
 
-        for lang in supported_langs:
-            self._process_language(
-                lang=lang,
-                default_lang=default_lang,
-                dynamic_data_loaders_config=dynamic_data_loaders_config_resolved,  # Use resolved config
-                navigation_items=processed_nav_items,
+        queue_settings = self.app_config.get("page_queue", {})
+        queue_enabled = queue_settings.get("enabled", False)
+        progress = ProgressReporter(
+            total=len(supported_langs) * self._pages_per_language(),
+            interval_seconds=queue_settings.get(
+                "progress_seconds", DEFAULT_PROGRESS_SECONDS
+            ),
+        )
+        run_jobs(
+            (
+                job
+                for lang in supported_langs
+                for job in self._language_page_jobs(
+                    lang=lang,
+                    default_lang=default_lang,
+                    dynamic_data_loaders_config=dynamic_data_loaders_config_resolved,
+                    navigation_items=processed_nav_items,
+                )
+            ),
+            workers=(
+                queue_settings.get("workers", DEFAULT_WORKERS) if queue_enabled else 1
+            ),
+            max_pending=queue_settings.get("max_pending"),
+            progress=progress,
+        )
+        progress.finish()
+
+        self._generate_site_artifacts()
+        with self.timer.phase("checks"):
+            self._verify_canonical_targets()
+            self._check_performance_budgets()
+            self._write_issue_report()
+
+        logger.info("Build process complete.")
+        if self.issues:
+            logger.info(format_issue_summary(self.issues))
+        logger.info("Build timings:\n%s", format_timing_table(self.timer))
+        self._write_build_manifest()
+        self._write_build_report()
+
+        errors = summarize_issues(self.issues)[ERROR]
+        if errors:
+            raise IssueError(f"{errors} issue(s) with severity error.")
+
+    def _pages_per_language(self) -> int:
+        """Counts the pages built per language: the index, its variant pages
+        with `--variants all`, and the error pages."""
+        pages = 1
+        if self.all_variants and self.build_context is not None:
+            pages += sum(
+                len(variation_ids(data))
+                for data in variant_blocks(
+                    self.build_context.block_data, self.app_config.get("blocks", [])
+                ).values()
+            )
+        error_pages = self.app_config.get("error_pages", {})
+        if error_pages.get("enabled", False):
+            pages += len(error_pages.get("pages", {}))
+        return pages
+
+    def _write_issue_report(self) -> None:
+        """Writes the issues of the build in the configured report format."""
+        settings = self.app_config.get("issue_report", {})
+        if not settings.get("enabled", False):
+            return
+        report_format = settings.get("format", DEFAULT_REPORT_FORMAT)
+        reporter = ISSUE_REPORTER_REGISTRY.get(report_format)
+        if reporter is None:
+            logger.error(
+                "Unknown issue report format '%s'; use one of %s.",
+                report_format,
+                ", ".join(sorted(ISSUE_REPORTER_REGISTRY)),
+            )
+            return
+        path = settings.get("path", DEFAULT_REPORT_PATHS.get(report_format))
+        try:
+            write_text_atomic(path, reporter(self.issues) + "\n")
+        except IOError as e:
+            logger.error(
+                "Could not write the issue report: %s", e, extra={"file": path}
             )
 
-        print("Build process complete.")
+    def _write_build_manifest(self) -> None:
+        """Writes the written files and phase timings to the build manifest."""
+        settings = self.app_config.get("build_manifest", {})
+        if not settings.get("enabled", False):
+            return
+        path = settings.get("path", DEFAULT_MANIFEST_PATH)
+        try:
+            write_build_manifest(
+                path,
+                build_manifest(
+                    self.timer,
+                    self.written_files,
+                    self.build_profile,
+                    self.variant_pages,
+                ),
+            )
+        except IOError as e:
+            logger.error(
+                "Could not write the build manifest: %s", e, extra={"file": path}
+            )
+
+    def _write_build_report(self) -> None:
+        """Writes the HTML dashboard of the build."""
+        settings = self.app_config.get("build_report", {})
+        if not settings.get("enabled", False):
+            return
+        path = settings.get("path", DEFAULT_BUILD_REPORT_PATH)
+        context = self.build_context
+        try:
+            html = render_build_report(
+                self.jinja_env,
+                build_profile=self.build_profile,
+                timer=self.timer,
+                page_weights=self._measure_pages(),
+                issues=self.issues,
+                coverage=(
+                    translation_coverage(
+                        context.translations_by_lang, context.default_lang
+                    )
+                    if context
+                    else []
+                ),
+                variants=variant_inventory(context.block_data) if context else [],
+            )
+            write_text_atomic(path, html)
+        except (IOError, TemplateError) as e:
+            logger.error(
+                "Could not write the build report: %s", e, extra={"file": path}
+            )
+            return
+        logger.info("Build report written to %s", path, extra={"file": path})
 
     def _generate_language_specific_config(
         self, lang: str, translations: Translations
@@ -233,7 +1101,7 @@ class BuildOrchestrator:
         Raises:
             IOError: If there is an error writing the configuration file.
         """
-        # This method prints errors to stdout rather than raising an IOError
+        # This method logs errors rather than raising an IOError
         # directly to allow the build process to continue for other languages
         # if one configuration file fails to write.
         lang_specific_config = self.app_config_manager.generate_language_config(
@@ -244,49 +1112,62 @@ class BuildOrchestrator:
         )
         generated_config_path = f"public/generated_configs/config_{lang}.json"
         try:
-            with open(generated_config_path, "w", encoding="utf-8") as config_file:
+            with atomic_write(generated_config_path) as config_file:
                 json.dump(
                     lang_specific_config,
                     config_file,
                     indent=4,
                     ensure_ascii=False,
                 )
-            print(f"Generated language-specific config: {generated_config_path}")
+            logger.info(
+                "Generated language-specific config",
+                extra={"lang": lang, "file": generated_config_path},
+            )
         except IOError as e:
-            # Consider logging this error instead of just printing.
-            print(
-                f"Error writing language-specific config {generated_config_path}: {e}"
+            logger.error(
+                "Could not write language-specific config: %s",
+                e,
+                extra={"lang": lang, "file": generated_config_path},
             )
 
-    def _assemble_main_content_for_lang(
+    def _write_main_content_for_lang(
         self,
         lang: str,
         translations: Translations,
         data_loaders_config: Dict[str, Dict[str, Any]],
-    ) -> str:
-        """Assembles the main content by processing and translating HTML blocks.
+        out: IO[str],
+        block_filenames: Optional[List[str]] = None,
+        data_overrides: Optional[Dict[str, Any]] = None,
+    ) -> None:
+        """Writes the main content by processing and translating HTML blocks.
 
         Iterates through configured HTML blocks, loads their templates,
-        injects dynamic data using HTML generators, and translates the
-        resulting content.
+        injects dynamic data using HTML generators, and writes the
+        resulting content to `out`, one block after another, as it renders.
+        A block that fails is logged and skipped; if it fails part way
+        through, the part already written stays.
 
         Args:
             lang: The language code for which to assemble content.
             translations: The translation data for the current language.
             data_loaders_config: Configuration for data loading for each
                 block.
-
-        Returns:
-            A string containing the assembled and translated main HTML content.
+            out: The text stream the blocks are written to.
+            block_filenames: Optional blocks to assemble instead of the
+                `blocks` listed in the app config.
+            data_overrides: Data to render instead of the loaded data, per
+                block name (e.g., a selected hero variant).
         """
-        blocks_html_parts: List[str] = []
-        block_filenames: List[str] = self.app_config.get("blocks", [])
+        separator = ""
+        if block_filenames is None:
+            block_filenames = self.app_config.get("blocks", [])
 
         for block_file_name in block_filenames:
             if not isinstance(block_file_name, str):
-                print(
-                    "Warning: Invalid block file entry in config: "
-                    f"{block_file_name}. Skipping."
+                logger.warning(
+                    "Invalid block file entry in config: %s. Skipping.",
+                    block_file_name,
+                    extra={"lang": lang},
                 )
                 continue
 
@@ -294,7 +1175,6 @@ class BuildOrchestrator:
             # is now handled by Jinja2 within each HtmlBlockGenerator.
             # The generators will use their Jinja environment to load templates from
             # `templates/blocks/`
-            generated_html_for_block = ""
             try:
                 if (
                     block_file_name in data_loaders_config
@@ -304,7 +1184,11 @@ class BuildOrchestrator:
                     html_generator = self.html_generators[block_file_name]
 
                     # Data loading remains the same
-                    data_items: Any = self.data_cache.get_item(loader_cfg["data_file"])
+                    data_items: Any = self.data_snapshot.get_item(
+                        loader_cfg["data_file"]
+                    )
+                    if data_overrides and block_file_name in data_overrides:
+                        data_items = data_overrides[block_file_name]
                     if loader_cfg.get("is_list", True) and data_items is None:
                         data_items = []
                     elif not loader_cfg.get("is_list", True) and data_items is None:
@@ -312,9 +1196,10 @@ class BuildOrchestrator:
                         pass
 
                     # HtmlBlockGenerator now handles its own template loading & rendering
-                    generated_html_for_block = html_generator.generate_html(
-                        data_items, translations
-                    )
+                    with self.timer.phase("render", lang=lang, block=block_file_name):
+                        out.write(separator)
+                        separator = "\n"
+                        html_generator.write_html(data_items, translations, out)
                 else:
                     # If block is not in html_generators, it might be a simple static block
                     # This path needs clarification: for now, assume all configured blocks
@@ -322,8 +1207,9 @@ class BuildOrchestrator:
                     # templates/blocks/ directly if it's purely static.
                     # Or, this is an error in configuration.
                     # For now, we'll just log a warning if a block has no generator.
-                    print(
-                        f"Warning: No HTML generator found for block: {block_file_name}. Skipping data injection."
+                    logger.warning(
+                        "No HTML generator found. Skipping data injection.",
+                        extra={"lang": lang, "block": block_file_name},
                     )
                     # Attempt to read static block content if needed, but this wasn't the old behavior.
                     # The old behavior relied on a placeholder for replacement.
@@ -359,13 +1245,16 @@ class BuildOrchestrator:
                             block_template_path, "r", encoding="utf-8"
                         ) as block_file:
                             static_block_content = block_file.read()
-                        generated_html_for_block = static_block_content
-                        print(
-                            f"Info: Treating block {block_file_name} as static HTML for translation only."
+                        out.write(separator + static_block_content)
+                        separator = "\n"
+                        logger.info(
+                            "Treating block as static HTML for translation only.",
+                            extra={"lang": lang, "block": block_file_name},
                         )
                     except FileNotFoundError:
-                        print(
-                            f"Warning: Static block file {block_file_name} not found. Skipping."
+                        logger.warning(
+                            "Static block file not found. Skipping.",
+                            extra={"lang": lang, "block": block_file_name},
                         )
                         continue
 
@@ -394,24 +1283,26 @@ class BuildOrchestrator:
 
                 # Decision: The individual block templates are responsible for their own translation
                 # using the `translations` object passed to them.
-                # So, `generated_html_for_block` should be final.
-                blocks_html_parts.append(generated_html_for_block)
+                # So, the block output written above is final.
 
             except FileNotFoundError:  # This would now be an issue with Jinja's loader
-                print(
-                    f"Warning: Template for block {block_file_name} not found by Jinja. Skipping."
+                logger.warning(
+                    "Template not found by Jinja. Skipping.",
+                    extra={"lang": lang, "block": block_file_name},
                 )
             except Exception as e:
-                print(
-                    f"Error processing block {block_file_name} for lang {lang}: "
-                    f"{e}. Skipping."
+                logger.error(
+                    "Could not process block: %s. Skipping.",
+                    e,
+                    extra={"lang": lang, "block": block_file_name},
                 )
 
-        return "\n".join(blocks_html_parts)
-
     def _write_output_file(self, filename: str, content: str) -> None:
         """Writes content to the specified output file.
 
+        The file is replaced atomically, so an interrupted build never
+        leaves it truncated.
+
         Args:
             filename: The name of the file to write.
             content: The string content to write to the file.
@@ -419,37 +1310,62 @@ class BuildOrchestrator:
         Raises:
             IOError: If there is an error writing the file.
         """
-        # This method prints errors to stdout rather than raising an IOError
+        # This method logs errors rather than raising an IOError
         # directly to allow the build process to continue if one file fails.
-        print(f"Writing {filename}")
+        logger.info("Writing file", extra={"file": filename})
         try:
-            with open(filename, "w", encoding="utf-8") as output_file:
-                output_file.write(content)
+            write_text_atomic(filename, content)
+            with self._lock:
+                self.written_files.append(filename)
         except IOError as e:
-            # Consider logging this error.
-            print(f"Error writing file {filename}: {e}")
+            logger.error("Could not write file: %s", e, extra={"file": filename})
 
 
-def main() -> None:
-    """Initializes services and runs the build orchestrator.
+def create_orchestrator(
+    build_profile: str,
+    base_path: Optional[str] = None,
+    jinja_env: Optional[Environment] = None,
+    data_cache: Optional[InMemoryDataCache[Message]] = None,
+    build_cache: Optional[BuildCache] = None,
+    variant_seed: Optional[str] = None,
+    all_variants: bool = False,
+) -> BuildOrchestrator:
+    """Initializes services and wires them into a build orchestrator.
 
     This function sets up all the necessary components (managers, providers,
-    loaders, etc.) and then invokes the BuildOrchestrator to perform the
-    website build.
+    loaders, etc.). Each call returns fresh instances; repeated builds (e.g.,
+    in serve mode) share parsed templates and loaded data only through a
+    `jinja_env` and `data_cache` passed in.
+
+    Args:
+        build_profile: The build profile passed to the orchestrator.
+        base_path: The path the site is served under, if not the path of
+            `base_url` (e.g., for a branch preview).
+        jinja_env: The template environment to render with; a new one from
+            `create_template_environment` by default.
+        data_cache: The cache of loaded block data; a new, empty one by
+            default. Invalidate changed data files in it between builds.
+        build_cache: The on-disk cache (`.landingcache/`) that keeps parsed
+            data files between builds; none by default.
+        variant_seed: Seeds the choice of block variants without a default.
+        all_variants: Whether to build a page per block variant too.
+
+    Returns:
+        A BuildOrchestrator ready to run `build_all_languages`.
     """
-    # Initialize Jinja2 Environment
-    jinja_env = Environment(
-        loader=FileSystemLoader("templates"),
-        autoescape=True,  # Enable autoescaping
-    )
+    # One environment renders every template, so each is parsed once.
+    if jinja_env is None:
+        jinja_env = create_template_environment()
 
     # Instantiate service components with more descriptive names
     app_config_manager_instance = DefaultAppConfigManager()
     translation_provider_instance = DefaultTranslationProvider()
     # Note: JsonProtoDataLoader and InMemoryDataCache are generic.
     # We specify Message here as they will handle various protobuf message types.
-    data_loader_instance = JsonProtoDataLoader[Message]()
-    data_cache_instance = InMemoryDataCache[Message]()
+    data_loader_instance = JsonProtoDataLoader[Message](build_cache)
+    data_cache_instance = (
+        data_cache if data_cache is not None else InMemoryDataCache[Message]()
+    )
     page_builder_instance = DefaultPageBuilder(
         translation_provider=translation_provider_instance,
         jinja_env=jinja_env,  # Pass env to PageBuilder
@@ -462,18 +1378,609 @@ def main() -> None:
         block_name: GeneratorClass(jinja_env=jinja_env)
         for block_name, GeneratorClass in HTML_GENERATOR_REGISTRY.items()
     }
+    artifact_generator_instances: Dict[str, SiteArtifactGenerator] = {
+        name: GeneratorClass(jinja_env=jinja_env)
+        for name, GeneratorClass in ordered_generators()
+    }
 
-    # Create and run the orchestrator
-    orchestrator = BuildOrchestrator(
+    return BuildOrchestrator(
         app_config_manager=app_config_manager_instance,
         translation_provider=translation_provider_instance,
         data_loader=data_loader_instance,
         data_cache=data_cache_instance,
         page_builder=page_builder_instance,
         html_generators=html_generator_instances,
+        artifact_generators=artifact_generator_instances,
+        jinja_env=jinja_env,
+        build_profile=build_profile,
+        base_path=base_path,
+        variant_seed=variant_seed,
+        all_variants=all_variants,
+    )
+
+
+def _load_block_config(
+    app_config: Dict[str, Any], block_name: str, message_type: Any
+) -> Optional[Message]:
+    """Loads the single-item data of a block, or None if it has none."""
+    loader_config = app_config.get("block_data_loaders", {}).get(block_name)
+    if not loader_config:
+        return None
+    return JsonProtoDataLoader[Message]().load_dynamic_single_item_data(
+        loader_config["data_file"], message_type
+    )
+
+
+def invalidate_build_caches(
+    jinja_env: Environment,
+    data_cache: InMemoryDataCache[Message],
+    changes: List[str],
+) -> None:
+    """Drops the parsed templates and loaded data that changed files affect."""
+    invalidate_templates(jinja_env, changes)
+    if os.path.join("public", "config.json") in changes:
+        # The config maps data files to blocks and message types.
+        data_cache.clear()
+    else:
+        data_cache.invalidate_sources(changes)
+
+
+def incremental_build(build_profile: str) -> Callable[[], None]:
+    """Returns a build that reuses its caches from one call to the next.
+
+    Like `serve`, it keeps parsed templates, loaded data and rendered
+    blocks (`BuildCache`) between builds, and drops only what the files
+    changed since the previous build affect.
+    """
+    jinja_env = create_template_environment()
+    data_cache = InMemoryDataCache[Message]()
+    build_cache = BuildCache()
+    watcher = SourceWatcher(REBUILD_PATHS)
+
+    def build() -> None:
+        invalidate_build_caches(jinja_env, data_cache, watcher.changed())
+        create_orchestrator(
+            build_profile,
+            jinja_env=jinja_env,
+            data_cache=data_cache,
+            build_cache=build_cache,
+        ).build_all_languages()
+
+    return build
+
+
+def _add_rebuild_routes(app: BackendApp, rebuild_config: Dict[str, Any]) -> None:
+    """Serves the CMS webhooks and status endpoint of `backend.rebuild`."""
+    command = rebuild_config.get("command")
+    build_profile = os.environ.get("BUILD_PROFILE", "production")
+    queue = RebuildQueue(
+        (
+            run_command_build(command, rebuild_config.get("timeout_seconds"))
+            if command
+            else incremental_build(build_profile)
+        ),
+        debounce_seconds=float(rebuild_config.get("debounce_seconds", 5)),
+    )
+    for provider, settings in rebuild_config.get("providers", {}).items():
+        try:
+            handler = WebhookHandler(provider, settings, queue)
+        except ValueError as e:
+            logger.warning("Not serving %s webhooks: %s", provider, e)
+            continue
+        app.add_route(WEBHOOK_PATH.format(provider=provider), handler)
+    app.add_route(REBUILD_STATUS_PATH, RebuildStatusHandler(queue), methods=("GET",))
+
+
+def create_backend_app() -> BackendApp:
+    """Creates the WSGI app serving the site's backend endpoints.
+
+    Endpoints use the data of the blocks posting to them (`contact-form.html`,
+    `newsletter.html`), so a form and its backend share one source.
+
+    Returns:
+        A BackendApp, ready to be served by `run_backend` or a WSGI server.
+    """
+    app_config = DefaultAppConfigManager().load_app_config()
+    backend_config = app_config.get("backend", {})
+    supported_langs: List[str] = app_config.get("supported_langs", ["en", "es"])
+    default_lang: str = app_config.get("default_lang", "en")
+    translation_provider = DefaultTranslationProvider()
+    translations_by_lang = {
+        lang: translation_provider.load_translations(lang) for lang in supported_langs
+    }
+
+    app = BackendApp(
+        allowed_origins=backend_config.get("allowed_origins", []),
+        trust_forwarded_for=backend_config.get("trust_forwarded_for", False),
+        trusted_proxies=backend_config.get("trusted_proxies", 1),
+    )
+    token_secret = os.environ.get(
+        backend_config.get("form_token_secret_env", "FORM_TOKEN_SECRET"), ""
+    )
+    form_config = _load_block_config(app_config, "contact-form.html", ContactFormConfig)
+    if form_config is not None:
+        sinks = create_form_sinks(
+            list(form_config.sinks), backend_config.get("form_sinks", {})
+        )
+        app.add_route(
+            CONTACT_FORM_PATH,
+            ContactFormHandler(
+                form_config,
+                translations_by_lang,
+                default_lang,
+                sinks,
+                spam_guard=SpamGuard(
+                    form_config.spam_protection,
+                    captcha_secret=os.environ.get(
+                        backend_config.get("captcha_secret_env", "CAPTCHA_SECRET"), ""
+                    ),
+                    token_secret=token_secret,
+                ),
+            ),
+            methods=("GET", "POST"),
+        )
+        storage_sink = sinks.get("sqlite")
+        if isinstance(storage_sink, SqliteFormSink):
+            admin_token = os.environ.get(
+                storage_sink.settings.get("admin_token_env", "SUBMISSIONS_TOKEN"), ""
+            )
+            if admin_token:
+                app.add_route(
+                    SUBMISSIONS_PATH,
+                    SubmissionsHandler(storage_sink.store, admin_token),
+                    methods=("GET",),
+                )
+            else:
+                logger.warning(
+                    "Not serving %s: no admin token is set.", SUBMISSIONS_PATH
+                )
+
+    newsletter_settings = backend_config.get("newsletter", {})
+    newsletter_config = _load_block_config(
+        app_config, "newsletter.html", NewsletterConfig
+    )
+    if newsletter_settings.get("provider") and newsletter_config is not None:
+        app.add_route(
+            NEWSLETTER_PATH,
+            NewsletterHandler(
+                newsletter_config,
+                newsletter_settings,
+                translations_by_lang,
+                default_lang,
+                spam_guard=SpamGuard(
+                    newsletter_config.spam_protection, token_secret=token_secret
+                ),
+            ),
+        )
+
+    rebuild_config = backend_config.get("rebuild", {})
+    if rebuild_config.get("enabled", False):
+        _add_rebuild_routes(app, rebuild_config)
+    return app
+
+
+def deploy_site(
+    target_name: str, dry_run: bool = False, preview_branch: Optional[str] = None
+) -> DeploySummary:
+    """Builds the site and publishes it to a deploy target.
+
+    Args:
+        target_name: The registered target, configured by its section of
+            `deploy.targets` in the app config.
+        dry_run: Report what would change without changing anything.
+        preview_branch: Publish a preview of this branch into its own
+            subdirectory instead of the main site.
+
+    Returns:
+        What the deploy changed (or would change).
+
+    Raises:
+        DeployError: If the target is not configured or publishing failed.
+    """
+    app_config = DefaultAppConfigManager().load_app_config()
+    deploy_config = app_config.get("deploy", {})
+    target_settings = deploy_config.get("targets", {}).get(target_name)
+    if target_settings is None:
+        raise DeployError(f"Add a '{target_name}' section to deploy.targets.")
+    previews_directory = deploy_config.get("previews", {}).get(
+        "directory", DEFAULT_PREVIEWS_DIRECTORY
+    )
+    settings = {
+        "cache_control": deploy_config.get("cache_control", {}),
+        "delete": deploy_config.get("delete", True),
+        **{
+            name: deploy_config[name]
+            for name in DEPLOY_TIMEOUT_SETTINGS
+            if name in deploy_config
+        },
+        **target_settings,
+    }
+    base_path = None
+    if preview_branch is not None:
+        settings["subdirectory"] = preview_directory(previews_directory, preview_branch)
+        base_path = site_base_path(app_config) + settings["subdirectory"] + "/"
+    else:
+        settings["preserve"] = [previews_directory]
+    # Checked before building so a misconfigured target fails fast.
+    target = DEPLOY_TARGET_REGISTRY[target_name](settings)
+
+    orchestrator = create_orchestrator(
+        os.environ.get("BUILD_PROFILE", "production"), base_path=base_path
+    )
+    orchestrator.build_all_languages()
+    files = collect_site_files(
+        orchestrator.written_files,
+        deploy_config.get("include", DEFAULT_INCLUDE),
+        deploy_config.get("exclude", DEFAULT_EXCLUDE),
+    )
+    files = select_host_config(files, app_config, target.host_formats)
+    with cancel_on_signal(target.deadline):
+        try:
+            summary = target.deploy(files, dry_run=dry_run)
+        except OperationCancelled as e:
+            raise DeployError(f"Deploy stopped: {e}") from e
+    if base_path and app_config.get("base_url"):
+        print(f"Preview: {absolute_url(app_config['base_url'], base_path)}")
+    return summary
+
+
+def diff_builds(
+    old_dir: Optional[str] = None,
+    new_dir: Optional[str] = None,
+    base_path: Optional[str] = None,
+) -> SiteDiff:
+    """Compares two output directories, or the last build with a new one.
+
+    Args:
+        old_dir: The output of the earlier build.
+        new_dir: The output of the later build. Without both directories the
+            files of the last build manifest are compared with a fresh build.
+        base_path: The base path of the fresh build.
+
+    Returns:
+        The added, removed and changed files.
+
+    Raises:
+        ValueError: If there is no build manifest to compare with.
+        OSError: If a directory or the manifest cannot be read.
+    """
+    if old_dir is not None and new_dir is not None:
+        return diff_directories(old_dir, new_dir)
+
+    app_config = DefaultAppConfigManager().load_app_config()
+    manifest_path = app_config.get("build_manifest", {}).get(
+        "path", DEFAULT_MANIFEST_PATH
+    )
+    if not os.path.exists(manifest_path):
+        raise ValueError(
+            f"No build manifest at {manifest_path}; enable build_manifest and "
+            "build once, or pass two output directories."
+        )
+    previous_files = load_manifest_files(manifest_path)
+    with tempfile.TemporaryDirectory(prefix="landing-diff-") as snapshot_dir:
+        # Files of the last build deleted since then are not compared.
+        previous_files = snapshot_files(previous_files, snapshot_dir)
+        orchestrator = create_orchestrator(
+            os.environ.get("BUILD_PROFILE", "production"), base_path=base_path
+        )
+        orchestrator.build_all_languages()
+        new_files = sorted(
+            os.path.normpath(path).replace(os.sep, "/")
+            for path in orchestrator.written_files
+        )
+        return diff_trees(snapshot_dir, ".", previous_files, new_files)
+
+
+def test_visual(update: bool = False) -> List[VisualResult]:
+    """Builds the site and compares screenshots of its pages with baselines.
+
+    Args:
+        update: Accept the new screenshots as baselines.
+
+    Returns:
+        The result of each page at each viewport of `visual_regression`.
+
+    Raises:
+        RuntimeError: If Chrome is missing or a screenshot failed.
+    """
+    app_config = DefaultAppConfigManager().load_app_config()
+    settings = app_config.get("visual_regression", {})
+    chrome = find_chrome(settings.get("chrome"))
+    jinja_env = create_template_environment()
+    orchestrator = create_orchestrator(
+        os.environ.get("BUILD_PROFILE", "production"), jinja_env=jinja_env
     )
     orchestrator.build_all_languages()
+    pages = sorted(
+        os.path.normpath(path).replace(os.sep, "/")
+        for path in orchestrator.written_files
+        if path.endswith(".html")
+    )
+    with serve_directory(os.getcwd()) as base_url:
+        results = run_visual_tests(pages, base_url, settings, chrome, update=update)
+    report_path = write_report(results, settings, jinja_env)
+    logger.info("Wrote visual regression report", extra={"file": report_path})
+    return results
+
+
+def main(argv: Optional[List[str]] = None) -> None:
+    """Runs the build, the development server (`serve`), the backend or a deploy.
+
+    Args:
+        argv: Command line arguments without the program name. Defaults to a
+            plain build.
+    """
+    parser = argparse.ArgumentParser(description="Builds the landing pages.")
+    parser.add_argument(
+        "--base-path",
+        help="Build for serving under this path (e.g., /previews/feature-x/).",
+    )
+    verbosity = parser.add_mutually_exclusive_group()
+    verbosity.add_argument(
+        "-v", "--verbose", action="store_true", help="Log debug messages too."
+    )
+    verbosity.add_argument(
+        "-q", "--quiet", action="store_true", help="Log only warnings and errors."
+    )
+    parser.add_argument(
+        "--log-format",
+        choices=LOG_FORMATS,
+        default="text",
+        help="Write log lines as text or as JSON objects.",
+    )
+    parser.add_argument(
+        "--cpuprofile", metavar="FILE", help="Write a cProfile profile of the build."
+    )
+    parser.add_argument(
+        "--memprofile",
+        metavar="FILE",
+        help="Write a tracemalloc snapshot taken at the end of the build.",
+    )
+    parser.add_argument(
+        "--no-cache",
+        action="store_true",
+        help=f"Neither read nor write the build cache in {DEFAULT_CACHE_DIR}/.",
+    )
+    parser.add_argument(
+        "--variant-seed",
+        metavar="SEED",
+        help="Pick block variants without a default reproducibly for SEED.",
+    )
+    parser.add_argument(
+        "--variants",
+        choices=VARIANT_MODES,
+        default="default",
+        help="Build each page with its default variants, or also a page per "
+        "variant (e.g., index.a.html).",
+    )
+    parser.add_argument(
+        "--trace",
+        metavar="FILE",
+        help="Write the build phases as a Trace Event (chrome://tracing) file.",
+    )
+    subparsers = parser.add_subparsers(dest="command")
+    serve_parser = subparsers.add_parser(
+        "serve", help="Serve the site with live reload and rebuild on changes."
+    )
+    serve_parser.add_argument("--host", default="127.0.0.1")
+    serve_parser.add_argument("--port", type=int, default=8000)
+    serve_parser.add_argument(
+        "--https",
+        action="store_true",
+        help="Serve over TLS. HTTP/1.1 only: the standard library server, "
+        "which runs live reload, has no HTTP/2 support.",
+    )
+    serve_parser.add_argument(
+        "--cert", help="The certificate (PEM) for --https; created if omitted."
+    )
+    serve_parser.add_argument("--key", help="The private key (PEM) of --cert.")
+    serve_parser.add_argument(
+        "--production-headers",
+        action="store_true",
+        help="Send production cache and security headers.",
+    )
+    backend_parser = subparsers.add_parser(
+        "backend", help="Run the backend endpoints (e.g., the contact form)."
+    )
+    backend_parser.add_argument("--host", default="127.0.0.1")
+    backend_parser.add_argument("--port", type=int, default=8080)
+    test_email_parser = subparsers.add_parser(
+        "test-email", help="Send a sample submission through the email form sink."
+    )
+    test_email_parser.add_argument("--lang", default="en")
+    deploy_parser = subparsers.add_parser(
+        "deploy", help="Build the site and publish it to a hosting target."
+    )
+    deploy_parser.add_argument("target", choices=sorted(DEPLOY_TARGET_REGISTRY))
+    deploy_parser.add_argument(
+        "--dry-run", action="store_true", help="Only list what would change."
+    )
+    deploy_parser.add_argument(
+        "--preview",
+        action="store_true",
+        help="Publish a preview of the current branch into its own subdirectory.",
+    )
+    deploy_parser.add_argument(
+        "--branch", help="The branch to name the preview after (default: current)."
+    )
+    diff_parser = subparsers.add_parser(
+        "diff",
+        help="Compare two output directories, or the last build with a new one.",
+    )
+    diff_parser.add_argument(
+        "directories",
+        nargs="*",
+        metavar="DIR",
+        help="The old and new output directories (default: rebuild and compare "
+        "with the files of the last build manifest).",
+    )
+    diff_parser.add_argument(
+        "--names-only", action="store_true", help="List changed files without diffs."
+    )
+    cache_parser = subparsers.add_parser(
+        "cache", help=f"Inspect or clean the build cache in {DEFAULT_CACHE_DIR}/."
+    )
+    cache_subparsers = cache_parser.add_subparsers(dest="cache_command", required=True)
+    cache_subparsers.add_parser("stats", help="Show the size and age of each cache.")
+    clean_parser = cache_subparsers.add_parser(
+        "clean", help="Delete cache entries, by default all of them."
+    )
+    clean_parser.add_argument(
+        "--older-than",
+        metavar="AGE",
+        type=parse_duration,
+        help="Only delete entries no build used for AGE (e.g., 30d, 12h).",
+    )
+    test_parser = subparsers.add_parser("test", help="Run checks of the built site.")
+    test_parser.add_argument("suite", choices=["visual"])
+    test_parser.add_argument(
+        "--update", action="store_true", help="Accept the results as baselines."
+    )
+    args = parser.parse_args(argv or [])
+    # The build options and the commands that build with them (None is the
+    # plain build); other commands refuse them instead of ignoring them.
+    # `serve` serves the site from "/", so it cannot use a base path.
+    build_option_commands: Dict[str, Tuple[Optional[str], ...]] = {
+        "base_path": (None, "diff"),
+        "cpuprofile": (None,),
+        "memprofile": (None,),
+        "no_cache": (None, "serve"),
+        "trace": (None,),
+        "variant_seed": (None, "serve"),
+        "variants": (None, "serve"),
+    }
+    for option, commands in build_option_commands.items():
+        if args.command not in commands and getattr(args, option) != (
+            parser.get_default(option)
+        ):
+            parser.error(
+                f"--{option.replace('_', '-')} does not apply to {args.command}"
+            )
+    configure_logging(int(args.verbose) - int(args.quiet), args.log_format)
+
+    if args.command == "test-email":
+        app_config = DefaultAppConfigManager().load_app_config()
+        email_settings = app_config.get("backend", {}).get("form_sinks", {})
+        try:
+            send_test_email(email_settings.get("email", {}), lang=args.lang)
+        except Exception as e:  # pylint: disable=broad-except
+            sys.exit(f"Sending the test email failed: {e}")
+        print("Test email sent.")
+        return
+
+    if args.command == "deploy":
+        try:
+            preview_branch = None
+            if args.preview or args.branch:
+                preview_branch = args.branch or current_branch()
+            summary = deploy_site(
+                args.target, dry_run=args.dry_run, preview_branch=preview_branch
+            )
+        except Exception as e:  # pylint: disable=broad-except
+            sys.exit(f"Deploy failed: {e}")
+        print(format_summary(summary, dry_run=args.dry_run))
+        return
+
+    if args.command == "diff":
+        if len(args.directories) not in (0, 2):
+            parser.error("diff takes no directories or an old and a new one")
+        try:
+            site_diff = diff_builds(*args.directories, base_path=args.base_path)
+        except (ValueError, OSError, IssueError) as e:
+            sys.exit(f"Diff failed: {e}")
+        print(format_site_diff(site_diff, names_only=args.names_only))
+        if not site_diff.is_empty():
+            sys.exit(1)
+        return
+
+    if args.command == "cache":
+        if args.cache_command == "stats":
+            print(format_cache_stats(cache_stats(DEFAULT_CACHE_DIR), DEFAULT_CACHE_DIR))
+        else:
+            files, size_bytes = clean_cache(DEFAULT_CACHE_DIR, args.older_than)
+            print(f"Deleted {files} cache entries ({size_bytes} bytes).")
+        return
+
+    if args.command == "test":
+        try:
+            results = test_visual(update=args.update)
+        except (RuntimeError, OSError, ValueError, IssueError) as e:
+            sys.exit(f"Visual tests failed: {e}")
+        changed = [result for result in results if result.status == "changed"]
+        print(f"{len(changed)} of {len(results)} screenshots changed.")
+        if changed:
+            sys.exit(1)
+        return
+
+    if args.command == "backend":
+        run_backend(create_backend_app(), host=args.host, port=args.port)
+        return
+
+    if args.command == "serve":
+        build_profile = os.environ.get("BUILD_PROFILE", "development")
+        ssl_context = None
+        if args.https or args.cert:
+            try:
+                if args.cert:
+                    cert_file, key_file = args.cert, args.key or args.cert
+                else:
+                    cert_file, key_file = create_dev_certificate()
+                ssl_context = create_ssl_context(cert_file, key_file)
+            except (RuntimeError, OSError) as e:
+                sys.exit(f"Cannot serve over HTTPS: {e}")
+        app_config = DefaultAppConfigManager().load_app_config()
+        response_headers, cache_control = {}, None
+        if args.production_headers:
+            response_headers = app_config.get("security_headers", {}).get("headers", {})
+            cache_control = app_config.get("deploy", {}).get("cache_control", {})
+        staging_settings = get_staging_settings(app_config, build_profile)
+        basic_auth = staging_credentials(staging_settings) if staging_settings else None
+        jinja_env = create_template_environment()
+        data_cache = InMemoryDataCache[Message]()
+        build_cache = None if args.no_cache else BuildCache()
+
+        def build_site() -> List[str]:
+            orchestrator = create_orchestrator(
+                build_profile,
+                jinja_env=jinja_env,
+                data_cache=data_cache,
+                build_cache=build_cache,
+                variant_seed=args.variant_seed,
+                all_variants=args.variants == "all",
+            )
+            orchestrator.build_all_languages()
+            return orchestrator.written_files
+
+        serve(
+            build_site,
+            on_change=lambda changes: invalidate_build_caches(
+                jinja_env, data_cache, changes
+            ),
+            host=args.host,
+            port=args.port,
+            ssl_context=ssl_context,
+            response_headers=response_headers,
+            cache_control=cache_control,
+            basic_auth=basic_auth,
+        )
+        return
+
+    orchestrator = create_orchestrator(
+        os.environ.get("BUILD_PROFILE", "production"),
+        base_path=args.base_path,
+        build_cache=None if args.no_cache else BuildCache(),
+        variant_seed=args.variant_seed,
+        all_variants=args.variants == "all",
+    )
+    try:
+        with profiled(args.cpuprofile, args.memprofile):
+            orchestrator.build_all_languages()
+    except IssueError as e:
+        sys.exit(f"Build failed: {e}")
+    finally:
+        if args.trace:
+            write_trace(args.trace, orchestrator.timer)
 
 
 if __name__ == "__main__":
-    main()
+    main(sys.argv[1:])
@@ -0,0 +1,107 @@
+"""
+Injects the tracking snippet of the configured analytics provider.
+
+Supported providers and the templates that render their snippets:
+
+- `ga4`: Google Analytics 4 (`templates/analytics/ga4.html`)
+- `plausible`: Plausible (`templates/analytics/plausible.html`)
+- `matomo`: Matomo (`templates/analytics/matomo.html`), needs `options.url`
+- `umami`: Umami (`templates/analytics/umami.html`)
+
+The snippet is added to every page's `head_snippets` only when the build runs
+under one of the configured `profiles` (see `BuildContext.build_profile`),
+so preview builds never send traffic to the production property. Every
+script is tagged with `data-consent-category` so consent management can gate
+it.
+
+    "analytics": {
+      "enabled": true,
+      "provider": "plausible",
+      "site_id": "example.com",
+      "options": {},
+      "profiles": ["production"]
+    }
+
+For GA4, `options` is passed to `gtag('config', ...)`; for Plausible and
+Umami, `options.script_url` points at a self-hosted script.
+"""
+
+import logging
+from typing import Any, Dict, Optional
+
+from jinja2 import Environment
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+
+logger = logging.getLogger(__name__)
+
+ANALYTICS_TEMPLATES: Dict[str, str] = {
+    "ga4": "analytics/ga4.html",
+    "plausible": "analytics/plausible.html",
+    "matomo": "analytics/matomo.html",
+    "umami": "analytics/umami.html",
+}
+REQUIRED_OPTIONS: Dict[str, str] = {"matomo": "url"}
+DEFAULT_PROFILES = ["production"]
+CONSENT_CATEGORY = "analytics"
+
+
+@register_artifact_generator("analytics")
+class AnalyticsSnippetGenerator(BaseArtifactGenerator):
+    """Adds the analytics provider's snippet to the head of every page."""
+
+    def __init__(self, jinja_env: Environment):
+        super().__init__(jinja_env)
+        self._snippet: Optional[str] = None
+        self._rendered = False
+
+    def _render_snippet(self, build_context: BuildContext) -> Optional[str]:
+        """Renders the snippet once, or returns None if analytics is off."""
+        if self._rendered:
+            return self._snippet
+        self._rendered = True
+
+        settings: Dict[str, Any] = build_context.app_config.get("analytics", {})
+        if not settings.get("enabled", False):
+            return None
+        profiles = settings.get("profiles", DEFAULT_PROFILES)
+        if build_context.build_profile not in profiles:
+            logger.info(
+                "Analytics disabled for build profile '%s'.",
+                build_context.build_profile,
+            )
+            return None
+
+        provider = settings.get("provider", "")
+        template_name = ANALYTICS_TEMPLATES.get(provider)
+        if template_name is None:
+            logger.warning("Unknown analytics provider '%s'. Skipping.", provider)
+            return None
+        site_id = settings.get("site_id", "")
+        if not site_id:
+            logger.warning("Analytics provider '%s' needs a 'site_id'.", provider)
+            return None
+        options: Dict[str, Any] = settings.get("options", {})
+        required_option = REQUIRED_OPTIONS.get(provider)
+        if required_option and not options.get(required_option):
+            logger.warning(
+                "Analytics provider '%s' needs 'options.%s'.",
+                provider,
+                required_option,
+            )
+            return None
+
+        self._snippet = self.jinja_env.get_template(template_name).render(
+            site_id=site_id,
+            options=options,
+            consent_category=CONSENT_CATEGORY,
+        )
+        return self._snippet
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Contributes the rendered snippet as a `head_snippets` entry."""
+        snippet = self._render_snippet(build_context)
+        return {"head_snippets": [snippet]} if snippet else {}
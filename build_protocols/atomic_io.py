@@ -0,0 +1,70 @@
+"""
+Writes build output so that readers never see a half-written file.
+
+Every file is written to a temporary file next to its destination and
+renamed over it once complete. The rename is atomic, so an interrupted
+build (Ctrl+C, a crash, a failed render) leaves either the previous
+version of a file or the new one, never a truncated mix, and the dev
+server never serves a page that is still being written.
+
+Content can be streamed into the temporary file (e.g., with `json.dump`)
+instead of being built up as one string first.
+"""
+
+import os
+import uuid
+from contextlib import contextmanager
+from typing import IO, Any, Iterator
+
+# Large pages and assets are written in few system calls.
+WRITE_BUFFER_BYTES = 1 << 16
+
+
+@contextmanager
+def atomic_write(path: str, encoding: str = "utf-8") -> Iterator[IO[str]]:
+    """Opens a text file for writing that replaces `path` when closed.
+
+    The file is discarded, and `path` left untouched, if the block raises.
+    Missing parent directories are created.
+
+    Raises:
+        OSError: If the file cannot be written or renamed.
+    """
+    with _atomic_open(path, "x", encoding=encoding) as f:
+        yield f
+
+
+@contextmanager
+def _atomic_open(path: str, mode: str, **open_args: Any) -> Iterator[IO[Any]]:
+    directory = os.path.dirname(path)
+    if directory:
+        os.makedirs(directory, exist_ok=True)
+    # Same directory, so the rename never crosses file systems; a new name
+    # per write, so concurrent writers never share a temporary file.
+    temp_path = os.path.join(
+        directory, f".{os.path.basename(path)}.{uuid.uuid4().hex[:12]}.tmp"
+    )
+    try:
+        with open(
+            temp_path, mode, buffering=WRITE_BUFFER_BYTES, **open_args
+        ) as temp_file:
+            yield temp_file
+        os.replace(temp_path, path)
+    except BaseException:
+        try:
+            os.remove(temp_path)
+        except OSError:
+            pass
+        raise
+
+
+def write_text_atomic(path: str, content: str, encoding: str = "utf-8") -> None:
+    """Writes a whole text file atomically (see `atomic_write`)."""
+    with atomic_write(path, encoding) as f:
+        f.write(content)
+
+
+def write_bytes_atomic(path: str, content: bytes) -> None:
+    """Writes a whole binary file atomically (see `atomic_write`)."""
+    with _atomic_open(path, "xb") as f:
+        f.write(content)
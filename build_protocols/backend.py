@@ -0,0 +1,265 @@
+"""
+A small WSGI application serving the site's optional backend endpoints.
+
+The landing pages are static; the backend is a separate, optional process
+for what a static host cannot do, such as receiving contact form
+submissions. Features register their endpoints with `BackendApp.add_route`;
+the app takes care of routing, request body parsing (URL-encoded, multipart
+and JSON), body size limits, JSON responses and CORS.
+
+Run it with `python build.py backend`, or mount `build.create_backend_app()`
+in any WSGI server. It is configured by the `backend` section of
+`public/config.json`:
+
+    "backend": {
+      "allowed_origins": ["https://example.com"],
+      "form_sinks": { "log": {} }
+    }
+
+`allowed_origins` lists the origins allowed to call the endpoints from the
+browser (CORS); `"*"` allows any origin. Behind a reverse proxy, set
+`trust_forwarded_for` so client addresses come from `X-Forwarded-For`, and
+`trusted_proxies` to the number of proxies in front of the backend (default
+1). Each proxy appends the address it received the request from, so the
+client is the `trusted_proxies`-th entry from the right; the entries left
+of it are whatever the client sent and are ignored.
+"""
+
+import json
+import logging
+from dataclasses import dataclass, field
+from email.parser import BytesParser
+from email.policy import HTTP
+from http import HTTPStatus
+from typing import Any, Callable, Dict, Iterable, List, Tuple
+from urllib.parse import parse_qsl
+from socketserver import ThreadingMixIn
+from wsgiref.simple_server import WSGIServer, make_server
+
+logger = logging.getLogger(__name__)
+
+MAX_BODY_BYTES = 64 * 1024
+
+Headers = List[Tuple[str, str]]
+
+
+@dataclass
+class BackendRequest:
+    """An incoming request, decoupled from the WSGI environ."""
+
+    method: str
+    path: str
+    query: Dict[str, str] = field(default_factory=dict)
+    headers: Dict[str, str] = field(default_factory=dict)
+    """Header values keyed by lowercase header name."""
+    body: bytes = b""
+    remote_addr: str = ""
+    query_string: str = ""
+    """The raw query string, as sent (e.g., for signatures covering it)."""
+
+    def form(self) -> Dict[str, str]:
+        """Parses a URL-encoded, multipart or JSON object body into fields.
+
+        Repeated fields keep their last value; non-string JSON values are
+        converted to strings. Unparsable bodies yield no fields.
+        """
+        content_type = self.headers.get("content-type", "")
+        if content_type.startswith("application/json"):
+            payload = self.json()
+            if not isinstance(payload, dict):
+                return {}
+            return {str(key): str(value) for key, value in payload.items()}
+        if content_type.startswith("multipart/form-data"):
+            return self._multipart_fields(content_type)
+        return dict(parse_qsl(self.body.decode("utf-8", errors="replace")))
+
+    def json(self) -> Any:
+        """Parses a JSON body, or returns None if it is not valid JSON."""
+        try:
+            return json.loads(self.body.decode("utf-8"))
+        except (UnicodeDecodeError, json.JSONDecodeError):
+            return None
+
+    def _multipart_fields(self, content_type: str) -> Dict[str, str]:
+        """Extracts the text fields of a multipart/form-data body."""
+        message = BytesParser(policy=HTTP).parsebytes(
+            f"Content-Type: {content_type}\r\n\r\n".encode("latin-1") + self.body
+        )
+        if not message.is_multipart():
+            return {}
+        fields: Dict[str, str] = {}
+        for part in message.iter_parts():
+            name = part.get_param("name", header="content-disposition")
+            if name and part.get_filename() is None:
+                fields[str(name)] = str(part.get_content())
+        return fields
+
+
+@dataclass
+class BackendResponse:
+    """A response produced by a route handler."""
+
+    status: int
+    body: bytes = b""
+    headers: Headers = field(default_factory=list)
+
+
+RouteHandler = Callable[[BackendRequest], BackendResponse]
+
+
+def json_response(status: int, payload: Any) -> BackendResponse:
+    """Builds a JSON response."""
+    return BackendResponse(
+        status,
+        json.dumps(payload, ensure_ascii=False).encode("utf-8"),
+        [("Content-Type", "application/json; charset=utf-8")],
+    )
+
+
+def html_response(status: int, html: str) -> BackendResponse:
+    """Builds an HTML page response."""
+    return BackendResponse(
+        status, html.encode("utf-8"), [("Content-Type", "text/html; charset=utf-8")]
+    )
+
+
+def wants_json(request: BackendRequest) -> bool:
+    """Returns whether the client asked for JSON (scripts do, plain forms don't)."""
+    accept = request.headers.get("accept", "")
+    return "json" in accept or "text/html" not in accept
+
+
+class BackendApp:
+    """Routes WSGI requests to the registered endpoint handlers."""
+
+    def __init__(
+        self,
+        allowed_origins: Iterable[str] = (),
+        trust_forwarded_for: bool = False,
+        trusted_proxies: int = 1,
+    ):
+        self.allowed_origins = set(allowed_origins)
+        self.trust_forwarded_for = trust_forwarded_for
+        self.trusted_proxies = max(1, trusted_proxies)
+        self._routes: Dict[str, Tuple[Tuple[str, ...], RouteHandler]] = {}
+
+    def add_route(
+        self, path: str, handler: RouteHandler, methods: Iterable[str] = ("POST",)
+    ) -> None:
+        """Registers the handler of an endpoint."""
+        if path in self._routes:
+            logger.warning("Backend route '%s' is being overridden.", path)
+        self._routes[path] = (tuple(methods), handler)
+
+    def __call__(
+        self, environ: Dict[str, Any], start_response: Callable[..., Any]
+    ) -> List[bytes]:
+        response = self._dispatch(environ)
+        response.headers.extend(self._cors_headers(environ.get("HTTP_ORIGIN", "")))
+        status = HTTPStatus(response.status)
+        start_response(f"{status.value} {status.phrase}", response.headers)
+        return [response.body]
+
+    def _dispatch(self, environ: Dict[str, Any]) -> BackendResponse:
+        """Finds and runs the handler of a request."""
+        path = environ.get("PATH_INFO", "") or "/"
+        method = environ.get("REQUEST_METHOD", "GET").upper()
+        route = self._routes.get(path)
+        if route is None:
+            return json_response(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+        methods, handler = route
+        if method == "OPTIONS":
+            return BackendResponse(
+                HTTPStatus.NO_CONTENT,
+                headers=[("Allow", ", ".join(methods + ("OPTIONS",)))],
+            )
+        if method not in methods:
+            response = json_response(
+                HTTPStatus.METHOD_NOT_ALLOWED, {"error": "Method not allowed"}
+            )
+            response.headers.append(("Allow", ", ".join(methods)))
+            return response
+
+        try:
+            length = int(environ.get("CONTENT_LENGTH") or 0)
+        except ValueError:
+            length = 0
+        if length > MAX_BODY_BYTES:
+            return json_response(
+                HTTPStatus.REQUEST_ENTITY_TOO_LARGE, {"error": "Request too large"}
+            )
+        body = environ["wsgi.input"].read(length) if length > 0 else b""
+        request = BackendRequest(
+            method=method,
+            path=path,
+            query=dict(parse_qsl(environ.get("QUERY_STRING", ""))),
+            headers=_request_headers(environ),
+            body=body,
+            remote_addr=self._client_address(environ),
+            query_string=environ.get("QUERY_STRING", ""),
+        )
+        try:
+            return handler(request)
+        except Exception:  # pylint: disable=broad-except
+            logger.exception("Unhandled error in backend route '%s'.", path)
+            return json_response(
+                HTTPStatus.INTERNAL_SERVER_ERROR, {"error": "Internal server error"}
+            )
+
+    def _client_address(self, environ: Dict[str, Any]) -> str:
+        """Returns the client's IP, as reported by a trusted proxy if any.
+
+        Only the entries appended by the trusted proxies count: a client can
+        send any `X-Forwarded-For` of its own, which they prepend to.
+        """
+        forwarded_for = [
+            entry.strip()
+            for entry in environ.get("HTTP_X_FORWARDED_FOR", "").split(",")
+            if entry.strip()
+        ]
+        if self.trust_forwarded_for and forwarded_for:
+            return forwarded_for[max(0, len(forwarded_for) - self.trusted_proxies)]
+        return environ.get("REMOTE_ADDR", "")
+
+    def _cors_headers(self, origin: str) -> Headers:
+        """Returns the CORS headers for an allowed request origin."""
+        if not origin or not (
+            "*" in self.allowed_origins or origin in self.allowed_origins
+        ):
+            return []
+        return [
+            ("Access-Control-Allow-Origin", origin),
+            ("Access-Control-Allow-Methods", "GET, POST, OPTIONS"),
+            ("Access-Control-Allow-Headers", "Accept, Content-Type"),
+            ("Vary", "Origin"),
+        ]
+
+
+def _request_headers(environ: Dict[str, Any]) -> Dict[str, str]:
+    """Collects the request headers from a WSGI environ."""
+    headers = {
+        key[len("HTTP_") :].replace("_", "-").lower(): str(value)
+        for key, value in environ.items()
+        if key.startswith("HTTP_")
+    }
+    if environ.get("CONTENT_TYPE"):
+        headers["content-type"] = environ["CONTENT_TYPE"]
+    return headers
+
+
+class ThreadingWSGIServer(ThreadingMixIn, WSGIServer):
+    """Handles each request in its own thread, so a slow one (e.g., a form
+    sink retrying a delivery) does not hold up the others."""
+
+    daemon_threads = True
+
+
+def run_backend(app: BackendApp, host: str, port: int) -> None:
+    """Serves the backend with the standard library's WSGI server."""
+    with make_server(host, port, app, server_class=ThreadingWSGIServer) as server:
+        print(f"Backend listening on http://{host}:{port}/ (Ctrl+C to stop)")
+        try:
+            server.serve_forever()
+        except KeyboardInterrupt:
+            print("Stopping backend.")
+
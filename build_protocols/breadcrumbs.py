@@ -0,0 +1,122 @@
+"""
+Computes breadcrumbs from the configured page hierarchy.
+
+Breadcrumbs are derived once per page and language and used twice: as the
+`breadcrumbs` template variable rendered by `blocks/breadcrumbs.html`, and as
+a schema.org `BreadcrumbList` entity added to the page's `structured_data`.
+Both come from the same list, so markup and JSON-LD cannot drift apart.
+
+Configured by the `breadcrumbs` section of `public/config.json`. The built
+pages are the landing page, "index", and the error pages, named by their
+code (e.g., "404"). Each page names its `parent`, a `title_key` translation
+key and optionally its `path` relative to `base_url` (per language in
+`lang_paths`); the path defaults to the page's file in the language, e.g.,
+`404_es.html`:
+
+    "breadcrumbs": {
+      "enabled": true,
+      "pages": {
+        "index": { "title_key": "breadcrumb_home" },
+        "404": { "parent": "index", "title_key": "error_404_title" }
+      }
+    }
+
+A trail with a single entry (e.g., the landing page itself) is not rendered.
+"""
+
+import logging
+from typing import Any, Dict, List, Optional
+
+from .interfaces import Translations
+from .site_urls import absolute_url, localized_filename
+
+logger = logging.getLogger(__name__)
+
+Breadcrumb = Dict[str, str]
+"""A breadcrumb as template data: `title` and `url` (absolute if possible)."""
+
+
+def _page_path(
+    page_id: str, page_cfg: Dict[str, Any], lang: str, default_lang: str
+) -> str:
+    """Returns the site-relative path of a page in a language."""
+    path = page_cfg.get("lang_paths", {}).get(lang, page_cfg.get("path"))
+    if path is not None:
+        return str(path)
+    filename = localized_filename(page_id, ".html", lang, default_lang)
+    if page_id == "index" and filename == "index.html":
+        return ""
+    return filename
+
+
+def resolve_breadcrumbs(
+    app_config: Dict[str, Any],
+    page_id: str,
+    lang: str,
+    default_lang: str,
+    translations: Translations,
+) -> List[Breadcrumb]:
+    """Builds the breadcrumb trail from the site root to a page.
+
+    Args:
+        app_config: The application configuration.
+        page_id: The identifier of the current page (e.g., "index", "404").
+        lang: The language code of the page.
+        default_lang: The site's default language code.
+        translations: The translations for the page language.
+
+    Returns:
+        The breadcrumbs, root first. Empty if breadcrumbs are disabled, the
+        page is not configured, or the trail would only contain the page.
+    """
+    settings = app_config.get("breadcrumbs", {})
+    if not settings.get("enabled", False):
+        return []
+    pages: Dict[str, Dict[str, Any]] = settings.get("pages", {})
+    base_url: str = app_config.get("base_url", "")
+
+    trail: List[Breadcrumb] = []
+    visited = set()
+    current: Optional[str] = page_id
+    while current is not None:
+        if current in visited:
+            logger.warning("Breadcrumb parents of '%s' form a cycle.", page_id)
+            return []
+        page_cfg = pages.get(current)
+        if page_cfg is None:
+            if current != page_id:
+                logger.warning("Unknown breadcrumb parent page '%s'.", current)
+            return []
+        visited.add(current)
+
+        path = _page_path(current, page_cfg, lang, default_lang)
+        title_key = page_cfg.get("title_key", current)
+        trail.append(
+            {
+                "title": translations.get(title_key, title_key),
+                "url": absolute_url(base_url, path) if base_url else path or "./",
+            }
+        )
+        current = page_cfg.get("parent")
+
+    trail.reverse()
+    return trail if len(trail) > 1 else []
+
+
+def breadcrumb_list_json_ld(breadcrumbs: List[Breadcrumb]) -> Dict[str, Any]:
+    """Builds a schema.org BreadcrumbList entity from breadcrumbs.
+
+    The last entry is the current page, which per Google's guidelines needs
+    no `item` URL.
+    """
+    elements = []
+    for position, crumb in enumerate(breadcrumbs, start=1):
+        element = {"@type": "ListItem", "position": position, "name": crumb["title"]}
+        if position < len(breadcrumbs):
+            element["item"] = crumb["url"]
+        elements.append(element)
+    return {
+        "@context": "https://schema.org",
+        "@type": "BreadcrumbList",
+        "itemListElement": elements,
+    }
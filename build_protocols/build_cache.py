@@ -0,0 +1,199 @@
+"""
+Keeps build caches in one directory, `.landingcache/`.
+
+Each cache is a namespace (a subdirectory) of content-addressed entries:
+an entry's key is a hash of everything its value is derived from, so a
+changed input simply misses and stale entries are never read. The build
+currently caches:
+
+- `data`: block data files parsed into protobuf messages (see
+  `JsonProtoDataLoader`), keyed by the file content and message schema.
+
+Reading an entry refreshes its modification time, so the age of an entry is
+the time since a build last used it. Two commands keep the directory
+transparent and bounded:
+
+    python build.py cache stats
+    python build.py cache clean --older-than 30d
+
+`clean` without `--older-than` empties the cache. Deleting the directory by
+hand is always safe; the next build repopulates it. `python build.py
+--no-cache` builds without reading or writing it.
+"""
+
+import os
+import re
+import time
+from dataclasses import dataclass
+from datetime import datetime
+from typing import Dict, List, Optional, Tuple
+
+from .atomic_io import write_bytes_atomic
+
+DEFAULT_CACHE_DIR = ".landingcache"
+DURATION_RE = re.compile(r"^\s*(\d+(?:\.\d+)?)\s*([smhdw]?)\s*$")
+DURATION_UNITS = {"": 1, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+
+
+@dataclass
+class CacheStats:
+    """The size and age of one cache namespace."""
+
+    namespace: str
+    files: int = 0
+    size_bytes: int = 0
+    oldest: Optional[float] = None
+    """Modification time of the least recently used entry."""
+    newest: Optional[float] = None
+    """Modification time of the most recently used entry."""
+
+
+class BuildCache:
+    """Stores cache entries as files below a root directory.
+
+    Entries are written atomically, so concurrent builds and an interrupted
+    build never leave a half-written entry behind. An entry that cannot be
+    read or written is treated as missing: the cache only ever saves work.
+    """
+
+    def __init__(self, root: str = DEFAULT_CACHE_DIR):
+        self.root = root
+
+    def path(self, namespace: str, key: str) -> str:
+        """Returns the file of an entry; keys are spread over subdirectories."""
+        return os.path.join(self.root, namespace, key[:2], key)
+
+    def get(self, namespace: str, key: str) -> Optional[bytes]:
+        """Returns an entry's value and marks it used, or None on a miss."""
+        path = self.path(namespace, key)
+        try:
+            with open(path, "rb") as f:
+                value = f.read()
+            os.utime(path)
+        except OSError:
+            return None
+        return value
+
+    def put(self, namespace: str, key: str, value: bytes) -> None:
+        """Stores an entry, ignoring write errors."""
+        try:
+            write_bytes_atomic(self.path(namespace, key), value)
+        except OSError:
+            pass
+
+
+def _cache_files(root: str) -> List[Tuple[str, str, os.stat_result]]:
+    """Lists the entries below `root` as (namespace, path, stat) tuples."""
+    entries: List[Tuple[str, str, os.stat_result]] = []
+    if not os.path.isdir(root):
+        return entries
+    for namespace in sorted(os.listdir(root)):
+        namespace_dir = os.path.join(root, namespace)
+        if not os.path.isdir(namespace_dir):
+            continue
+        for directory, _, filenames in os.walk(namespace_dir):
+            for filename in filenames:
+                path = os.path.join(directory, filename)
+                try:
+                    entries.append((namespace, path, os.stat(path)))
+                except OSError:
+                    continue
+    return entries
+
+
+def cache_stats(root: str = DEFAULT_CACHE_DIR) -> List[CacheStats]:
+    """Sums the entries of each namespace below `root`."""
+    stats: Dict[str, CacheStats] = {}
+    for namespace, _, stat in _cache_files(root):
+        entry = stats.setdefault(namespace, CacheStats(namespace))
+        entry.files += 1
+        entry.size_bytes += stat.st_size
+        if entry.oldest is None or stat.st_mtime < entry.oldest:
+            entry.oldest = stat.st_mtime
+        if entry.newest is None or stat.st_mtime > entry.newest:
+            entry.newest = stat.st_mtime
+    return list(stats.values())
+
+
+def clean_cache(
+    root: str = DEFAULT_CACHE_DIR,
+    older_than_seconds: Optional[float] = None,
+    now: Optional[float] = None,
+) -> Tuple[int, int]:
+    """Deletes entries not used for `older_than_seconds`, or all of them.
+
+    Directories left empty are removed as well.
+
+    Returns:
+        The number of files and bytes deleted.
+    """
+    cutoff = None
+    if older_than_seconds is not None:
+        cutoff = (time.time() if now is None else now) - older_than_seconds
+    files, size_bytes = 0, 0
+    for _, path, stat in _cache_files(root):
+        if cutoff is not None and stat.st_mtime >= cutoff:
+            continue
+        try:
+            os.remove(path)
+        except OSError:
+            continue
+        files += 1
+        size_bytes += stat.st_size
+    for directory, _, _ in sorted(os.walk(root), reverse=True):
+        if directory != root:
+            try:
+                os.rmdir(directory)
+            except OSError:
+                pass  # Not empty.
+    return files, size_bytes
+
+
+def parse_duration(text: str) -> float:
+    """Parses a duration such as "90s", "12h", "30d" or "2w" into seconds.
+
+    A number without a unit is in seconds.
+
+    Raises:
+        ValueError: If `text` is not a duration.
+    """
+    match = DURATION_RE.match(text)
+    if not match:
+        raise ValueError(f"Invalid duration '{text}'; use e.g. 90s, 12h, 30d, 2w.")
+    return float(match.group(1)) * DURATION_UNITS[match.group(2)]
+
+
+def _format_size(size_bytes: int) -> str:
+    size = float(size_bytes)
+    for unit in ("B", "KB", "MB"):
+        if size < 1024:
+            return f"{size:.0f} {unit}" if unit == "B" else f"{size:.1f} {unit}"
+        size /= 1024
+    return f"{size:.1f} GB"
+
+
+def _format_time(timestamp: Optional[float]) -> str:
+    if timestamp is None:
+        return "-"
+    return datetime.fromtimestamp(timestamp).strftime("%Y-%m-%d %H:%M")
+
+
+def format_cache_stats(stats: List[CacheStats], root: str = DEFAULT_CACHE_DIR) -> str:
+    """Formats the stats as a table with a total line."""
+    if not stats:
+        return f"{root} is empty."
+    lines = [
+        f"{'Cache':<12} {'Files':>7} {'Size':>10}  {'Least recently used':<19}"
+        "  Most recently used"
+    ]
+    for entry in stats:
+        lines.append(
+            f"{entry.namespace:<12} {entry.files:>7} "
+            f"{_format_size(entry.size_bytes):>10}  {_format_time(entry.oldest):<19}"
+            f"  {_format_time(entry.newest)}"
+        )
+    lines.append(
+        f"{'total':<12} {sum(entry.files for entry in stats):>7} "
+        f"{_format_size(sum(entry.size_bytes for entry in stats)):>10}"
+    )
+    return "\n".join(lines)
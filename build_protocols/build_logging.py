@@ -0,0 +1,104 @@
+"""
+Configures the log output of `build.py`.
+
+Build messages are logged with structured fields, passed as `extra`:
+
+    logger.warning("Template not found.", extra={"block": "hero.html"})
+
+The fields in `LOG_FIELDS` (`component`, `lang`, `block`, `file`) make the
+output filterable: the text format appends them as `key=value` pairs, and
+`--log-format=json` writes one JSON object per line, e.g.
+
+    {"time": "2024-01-01T00:00:00+00:00", "level": "warning",
+     "component": "build", "block": "hero.html", "message": "..."}
+
+`component` defaults to the logging module (e.g., `sitemaps` for
+`build_protocols.sitemaps`). `--verbose` adds debug messages and `--quiet`
+keeps only warnings and errors.
+"""
+
+import json
+import logging
+import sys
+from datetime import datetime, timezone
+from typing import Any, Dict, Optional, TextIO
+
+LOG_FIELDS = ("component", "lang", "block", "file")
+LOG_FORMATS = ("text", "json")
+LEVEL_PREFIXES = {
+    logging.WARNING: "Warning: ",
+    logging.ERROR: "Error: ",
+    logging.CRITICAL: "Error: ",
+}
+
+
+def record_fields(record: logging.LogRecord) -> Dict[str, Any]:
+    """Returns the structured fields of a log record that are set."""
+    fields = {
+        name: getattr(record, name)
+        for name in LOG_FIELDS
+        if getattr(record, name, None) not in (None, "")
+    }
+    fields.setdefault("component", record.name.rsplit(".", 1)[-1])
+    return fields
+
+
+class TextFormatter(logging.Formatter):
+    """Formats records as a message followed by its fields."""
+
+    def format(self, record: logging.LogRecord) -> str:
+        fields = record_fields(record)
+        text = LEVEL_PREFIXES.get(record.levelno, "") + record.getMessage()
+        details = " ".join(
+            f"{name}={value}" for name, value in fields.items() if name != "component"
+        )
+        if details:
+            text += f" ({details})"
+        if record.exc_info:
+            text += "\n" + self.formatException(record.exc_info)
+        return text
+
+
+class JsonFormatter(logging.Formatter):
+    """Formats records as one JSON object per line."""
+
+    def format(self, record: logging.LogRecord) -> str:
+        entry: Dict[str, Any] = {
+            "time": datetime.fromtimestamp(record.created, timezone.utc).isoformat(
+                timespec="milliseconds"
+            ),
+            "level": record.levelname.lower(),
+            **record_fields(record),
+            "message": record.getMessage(),
+        }
+        if record.exc_info:
+            entry["exception"] = self.formatException(record.exc_info)
+        return json.dumps(entry, ensure_ascii=False, default=str)
+
+
+def configure_logging(
+    verbosity: int = 0,
+    log_format: str = "text",
+    stream: Optional[TextIO] = None,
+) -> None:
+    """Sends log records of every module to one handler.
+
+    Args:
+        verbosity: -1 for warnings and errors only, 0 for progress messages
+            as well, 1 for debug messages as well.
+        log_format: "text" or "json".
+        stream: Where to write; defaults to standard error.
+    """
+    if verbosity < 0:
+        level = logging.WARNING
+    elif verbosity == 0:
+        level = logging.INFO
+    else:
+        level = logging.DEBUG
+    handler = logging.StreamHandler(stream or sys.stderr)
+    handler.setFormatter(JsonFormatter() if log_format == "json" else TextFormatter())
+    root = logging.getLogger()
+    for existing in list(root.handlers):
+        root.removeHandler(existing)
+    root.addHandler(handler)
+    root.setLevel(level)
@@ -0,0 +1,196 @@
+"""
+Measures how long each phase of a build takes.
+
+The orchestrator times its phases with a `BuildTimer`:
+
+- `config`: loading `public/config.json`, navigation and SEO data;
+- `data`: preloading the block data files;
+- `render`: rendering one block for one language;
+- `assemble`: building one page, including its `render`s;
+- `artifacts`: running one site artifact generator (feeds, sitemaps, ...);
+- `checks`: verifying canonical links and performance budgets.
+
+At the end of a build the timings are logged as a table and, with the
+`build_manifest` section of `public/config.json` enabled, written to the
+build manifest along with the files the build wrote:
+
+    "build_manifest": { "enabled": true, "path": "build-manifest.json" }
+
+Comparing manifests between commits shows which phase got slower; the
+manifest's `pages_per_second` (HTML pages written per second of build time)
+tracks overall rendering throughput the same way.
+
+Phases may be timed from several threads at once.
+"""
+
+import json
+import os
+import threading
+import time
+from contextlib import contextmanager
+from dataclasses import asdict, dataclass, field
+from datetime import datetime, timezone
+from typing import Any, Dict, Iterator, List, Optional
+
+from .atomic_io import atomic_write
+
+DEFAULT_MANIFEST_PATH = "build-manifest.json"
+MANIFEST_VERSION = 1
+
+
+@dataclass
+class PhaseTiming:
+    """The duration of one timed phase."""
+
+    phase: str
+    seconds: float
+    offset_seconds: float = 0.0
+    """When the phase started, in seconds since the build started."""
+    lang: Optional[str] = None
+    block: Optional[str] = None
+    component: Optional[str] = None
+
+
+@dataclass
+class BuildTimer:
+    """Records phase durations of a build, in the order they finish."""
+
+    timings: List[PhaseTiming] = field(default_factory=list)
+    started_at: float = field(default_factory=time.time)
+    _start: float = field(default_factory=time.perf_counter, init=False, repr=False)
+    _lock: threading.Lock = field(
+        default_factory=threading.Lock, init=False, repr=False, compare=False
+    )
+
+    @contextmanager
+    def phase(self, name: str, **labels: Optional[str]) -> Iterator[None]:
+        """Times the enclosed code as a phase, even if it raises.
+
+        Args:
+            name: The phase name, e.g. "render".
+            **labels: Optional `lang`, `block` and `component` labels.
+        """
+        start = time.perf_counter()
+        try:
+            yield
+        finally:
+            timing = PhaseTiming(
+                name,
+                time.perf_counter() - start,
+                offset_seconds=start - self._start,
+                **labels,
+            )
+            with self._lock:
+                self.timings.append(timing)
+
+    def total_seconds(self) -> float:
+        """Returns the time since the timer was created."""
+        return time.perf_counter() - self._start
+
+    def summary(self) -> Dict[str, Dict[str, float]]:
+        """Sums the timings per phase: count, total and slowest seconds."""
+        phases: Dict[str, Dict[str, float]] = {}
+        with self._lock:
+            timings = list(self.timings)
+        for timing in timings:
+            entry = phases.setdefault(
+                timing.phase, {"count": 0, "total": 0.0, "max": 0.0}
+            )
+            entry["count"] += 1
+            entry["total"] += timing.seconds
+            entry["max"] = max(entry["max"], timing.seconds)
+        return phases
+
+
+def pages_per_second(timer: BuildTimer, written_files: List[str]) -> float:
+    """Returns the HTML pages written per second of build time."""
+    pages = sum(1 for path in written_files if path.endswith(".html"))
+    seconds = timer.total_seconds()
+    return pages / seconds if seconds > 0 else 0.0
+
+
+def timing_label(timing: PhaseTiming) -> str:
+    """Names what a timing measured, e.g. "en hero.html"; empty if unlabelled."""
+    return " ".join(
+        value for value in (timing.component, timing.lang, timing.block) if value
+    )
+
+
+def format_timing_table(timer: BuildTimer, slowest: int = 5) -> str:
+    """Formats the per-phase totals plus the slowest labelled timings."""
+    lines = [f"{'Phase':<10} {'Count':>5} {'Total ms':>10} {'Max ms':>10}"]
+    for phase, entry in timer.summary().items():
+        lines.append(
+            f"{phase:<10} {int(entry['count']):>5} "
+            f"{entry['total'] * 1000:>10.1f} {entry['max'] * 1000:>10.1f}"
+        )
+    lines.append(f"{'total':<10} {'':>5} {timer.total_seconds() * 1000:>10.1f}")
+    labelled = [timing for timing in timer.timings if timing_label(timing)]
+    if labelled and slowest:
+        lines.append("Slowest:")
+        for timing in sorted(labelled, key=lambda t: t.seconds, reverse=True)[
+            :slowest
+        ]:
+            lines.append(
+                f"  {timing.phase} {timing_label(timing)}: "
+                f"{timing.seconds * 1000:.1f} ms"
+            )
+    return "\n".join(lines)
+
+
+def build_manifest(
+    timer: BuildTimer,
+    written_files: List[str],
+    build_profile: str,
+    variant_pages: Optional[Dict[str, Dict[str, str]]] = None,
+) -> Dict[str, Any]:
+    """Describes a finished build: its files and phase timings.
+
+    Args:
+        timer: The build's phase timings.
+        written_files: Every file the build wrote.
+        build_profile: The build profile.
+        variant_pages: Per page, the page built for each block variant, by
+            variation ID (see `variants.py`); listed under `variants`.
+    """
+    manifest: Dict[str, Any] = {
+        "version": MANIFEST_VERSION,
+        "built_at": datetime.fromtimestamp(timer.started_at, timezone.utc).isoformat(
+            timespec="seconds"
+        ),
+        "build_profile": build_profile,
+        "files": sorted(
+            os.path.normpath(path).replace(os.sep, "/") for path in written_files
+        ),
+        "total_seconds": round(timer.total_seconds(), 4),
+        "pages_per_second": round(pages_per_second(timer, written_files), 2),
+        "phases": {
+            phase: {
+                "count": int(entry["count"]),
+                "total_seconds": round(entry["total"], 4),
+                "max_seconds": round(entry["max"], 4),
+            }
+            for phase, entry in timer.summary().items()
+        },
+        "timings": [
+            {
+                key: round(value, 4) if isinstance(value, float) else value
+                for key, value in asdict(timing).items()
+                if value is not None
+            }
+            for timing in timer.timings
+        ],
+    }
+    if variant_pages:
+        manifest["variants"] = {
+            page: dict(sorted(variants.items()))
+            for page, variants in sorted(variant_pages.items())
+        }
+    return manifest
+
+
+def write_build_manifest(path: str, manifest: Dict[str, Any]) -> None:
+    """Writes the build manifest as JSON."""
+    with atomic_write(path) as f:
+        json.dump(manifest, f, indent=2, ensure_ascii=False)
+        f.write("\n")
@@ -0,0 +1,145 @@
+"""
+Writes an HTML dashboard of the build, for reviewing its health without
+reading logs.
+
+With the `build_report` section of `public/config.json` enabled, every
+build ends by writing `build-report.html` (or `path`):
+
+    "build_report": { "enabled": true, "path": "build-report.html" }
+
+The report shows:
+
+- every generated page with its own size, its total weight and number of
+  requests (measured as for the performance budgets, see `performance.py`);
+- the time spent per build phase and the slowest blocks, languages and
+  generators (see `build_metrics.py`);
+- the issues of the build checks, grouped by category (see `issues.py`);
+- per language, the share of the default language's translation keys it
+  translates, and the keys it lacks;
+- the A/B variants of every block whose data has variations (e.g., the
+  hero), with the default variant.
+
+Like the build manifest, the report is not deployed.
+"""
+
+from dataclasses import dataclass, field
+from datetime import datetime, timezone
+from typing import Any, Dict, Iterable, List
+
+from jinja2 import Environment
+
+from .build_metrics import BuildTimer, timing_label
+from .interfaces import Translations
+from .issues import SEVERITIES, Issue, summarize_issues
+from .performance import PageWeight
+
+DEFAULT_BUILD_REPORT_PATH = "build-report.html"
+BUILD_REPORT_TEMPLATE = "reports/build-report.html"
+SLOWEST_TIMINGS = 10
+MISSING_KEYS_SHOWN = 20
+
+
+@dataclass
+class TranslationCoverage:
+    """How much of the default language a language translates."""
+
+    lang: str
+    translated: int
+    total: int
+    missing: List[str] = field(default_factory=list)
+
+    @property
+    def percent(self) -> float:
+        """The translated share of the default language's keys."""
+        return 100.0 * self.translated / self.total if self.total else 100.0
+
+
+@dataclass
+class VariantInventory:
+    """The variants of one A/B tested item of a block."""
+
+    block: str
+    variation_ids: List[str]
+    default_id: str = ""
+
+
+def translation_coverage(
+    translations_by_lang: Dict[str, Translations], default_lang: str
+) -> List[TranslationCoverage]:
+    """Compares each language's translation keys with the default language's."""
+    reference = set(translations_by_lang.get(default_lang, {}))
+    coverage = []
+    for lang, translations in sorted(translations_by_lang.items()):
+        missing = sorted(key for key in reference if not translations.get(key))
+        coverage.append(
+            TranslationCoverage(
+                lang, len(reference) - len(missing), len(reference), missing
+            )
+        )
+    return coverage
+
+
+def variant_inventory(block_data: Dict[str, Any]) -> List[VariantInventory]:
+    """Lists the variants of every block item that has `variations`."""
+    inventory = []
+    for block, data in sorted(block_data.items()):
+        items = data if isinstance(data, list) else [data]
+        for item in items:
+            variations = getattr(item, "variations", None) if item else None
+            if not variations:
+                continue
+            inventory.append(
+                VariantInventory(
+                    block,
+                    [variation.variation_id for variation in variations],
+                    getattr(item, "default_variation_id", ""),
+                )
+            )
+    return inventory
+
+
+def group_issues(issues: Iterable[Issue]) -> Dict[str, List[Issue]]:
+    """Groups issues by category, most severe category first."""
+    groups: Dict[str, List[Issue]] = {}
+    for issue in issues:
+        groups.setdefault(issue.category, []).append(issue)
+    return dict(
+        sorted(
+            groups.items(),
+            key=lambda group: (
+                min(SEVERITIES.index(issue.severity) for issue in group[1]),
+                group[0],
+            ),
+        )
+    )
+
+
+def render_build_report(
+    jinja_env: Environment,
+    build_profile: str,
+    timer: BuildTimer,
+    page_weights: List[PageWeight],
+    issues: List[Issue],
+    coverage: List[TranslationCoverage],
+    variants: List[VariantInventory],
+) -> str:
+    """Renders the report from the build's measurements."""
+    labelled = [timing for timing in timer.timings if timing_label(timing)]
+    slowest = sorted(labelled, key=lambda timing: timing.seconds, reverse=True)
+    return jinja_env.get_template(BUILD_REPORT_TEMPLATE).render(
+        build_profile=build_profile,
+        built_at=datetime.fromtimestamp(timer.started_at, timezone.utc),
+        total_seconds=timer.total_seconds(),
+        phases=timer.summary(),
+        slowest=[
+            (timing, timing_label(timing))
+            for timing in slowest[:SLOWEST_TIMINGS]
+        ],
+        pages=sorted(page_weights, key=lambda weight: weight.page),
+        issue_counts=summarize_issues(issues),
+        issue_groups=group_issues(issues),
+        coverage=coverage,
+        missing_keys_shown=MISSING_KEYS_SHOWN,
+        variants=variants,
+    )
+
@@ -0,0 +1,136 @@
+"""
+Resolves canonical URLs for pages and verifies they point at generated files.
+
+Every page gets a `<link rel="canonical">`. By default a page is its own
+canonical (its language-specific URL under `base_url`). The optional
+`canonical` section of `public/config.json` overrides the target per page,
+and per language within a page:
+
+    "canonical": {
+      "verify": true,
+      "pages": {
+        "index": {
+          "path": "",
+          "lang_paths": { "es": "index_es.html" }
+        }
+      }
+    }
+
+Paths are relative to `base_url`; absolute URLs (e.g., to a syndicated
+original) are used as-is and are not verified. A canonical URL must not be
+the source of a configured redirect (see `redirects.py`). Canonical URLs
+that do not resolve are reported as `unresolved-canonical` or
+`redirected-canonical` issues (see `issues.py`).
+"""
+
+import os
+from typing import Any, Dict, Iterable, List, Optional
+from urllib.parse import urlsplit
+
+from .issues import WARNING, Issue
+from .site_urls import absolute_url, page_url
+
+
+def resolve_canonical_url(
+    app_config: Dict[str, Any], page_id: str, lang: str, default_lang: str
+) -> Optional[str]:
+    """Returns the canonical URL of a page in a language.
+
+    Args:
+        app_config: The application configuration.
+        page_id: The identifier of the page (e.g., "index").
+        lang: The language code of the page.
+        default_lang: The site's default language code.
+
+    Returns:
+        The absolute canonical URL, or None if no `base_url` is configured.
+    """
+    base_url = app_config.get("base_url")
+    if not base_url:
+        return None
+
+    page_settings = app_config.get("canonical", {}).get("pages", {}).get(page_id)
+    if page_settings is None:
+        return page_url(base_url, lang, default_lang)
+
+    path = page_settings.get("lang_paths", {}).get(lang, page_settings.get("path"))
+    if path is None:
+        return page_url(base_url, lang, default_lang)
+    return absolute_url(base_url, path)
+
+
+def canonical_target_file(base_url: str, url: str) -> Optional[str]:
+    """Maps a canonical URL to the output file that serves it.
+
+    Args:
+        base_url: The public root of the site.
+        url: An absolute canonical URL.
+
+    Returns:
+        The output path relative to the site root (directory URLs map to
+        their `index.html`), or None if the URL is not under `base_url`.
+    """
+    site_root = absolute_url(base_url, "")
+    if not url.startswith(site_root):
+        return None
+    path = urlsplit(url[len(site_root) :]).path
+    if not path or path.endswith("/"):
+        path = f"{path}index.html"
+    return path
+
+
+def find_unresolved_canonicals(
+    base_url: str,
+    canonical_urls: Dict[str, str],
+    written_files: Iterable[str],
+    redirect_sources: Iterable[str] = (),
+) -> List[Issue]:
+    """Finds canonical URLs whose target file was not generated.
+
+    Args:
+        base_url: The public root of the site.
+        canonical_urls: Canonical URL per generated page file.
+        written_files: Paths of every file written by the build.
+        redirect_sources: Site-relative paths that the host redirects (e.g.,
+            "/home.html"). Canonical URLs must not point at them.
+
+    Returns:
+        An issue per page whose canonical target does not exist; empty if all
+        targets resolve. External canonical URLs are not checked.
+    """
+    generated = {
+        os.path.normpath(path).replace(os.sep, "/") for path in written_files
+    }
+    redirected = {
+        canonical_target_file(base_url, absolute_url(base_url, source))
+        for source in redirect_sources
+    }
+    problems: List[Issue] = []
+    for page_file, url in sorted(canonical_urls.items()):
+        target = canonical_target_file(base_url, url)
+        if target is None:
+            continue
+        if target in redirected:
+            problems.append(
+                Issue(
+                    WARNING,
+                    "redirected-canonical",
+                    f"Canonical URL {url} is redirected.",
+                    "canonical",
+                    file=page_file,
+                    suggestion="Point it at the redirect target.",
+                )
+            )
+        elif target not in generated:
+            problems.append(
+                Issue(
+                    WARNING,
+                    "unresolved-canonical",
+                    f"Canonical URL {url} does not resolve to a generated file "
+                    f"({target}).",
+                    "canonical",
+                    file=page_file,
+                    suggestion="Fix the page's `canonical` setting.",
+                )
+            )
+    return problems
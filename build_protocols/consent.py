@@ -0,0 +1,146 @@
+"""
+Adds a cookie consent banner and gates scripts behind the visitor's consent.
+
+Any `<script>` tagged with `data-consent-category="<id>"` (such as the
+analytics snippet) is emitted as `type="text/plain"`, so the browser does not
+run it; its original type is kept in `data-consent-type`. The banner rendered
+from `blocks/consent-banner.html` stores the visitor's choice and re-inserts
+the scripts of granted categories as executable scripts. Scripts of
+`required` categories are left untouched.
+
+The banner's texts and categories come from a `ConsentBanner` message (see
+`proto/consent_banner.proto`). The `regulation` profile decides the model:
+
+- `gdpr`: opt-in. Nothing optional runs until the visitor accepts; the banner
+  offers accept, reject and per-category choices.
+- `ccpa`: opt-out. Scripts run unless the visitor opts out ("Do Not Sell or
+  Share") or their browser sends the Global Privacy Control signal.
+
+    "consent": {
+      "enabled": true,
+      "regulation": "gdpr",
+      "data_file": "data/consent_banner.json"
+    }
+"""
+
+import logging
+import re
+from typing import Any, Dict, Optional, Set
+
+from jinja2 import Environment
+
+from generated.consent_banner_pb2 import ConsentBanner
+
+from .data_loading import load_dynamic_single_item_data
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+
+logger = logging.getLogger(__name__)
+
+REGULATIONS = {"gdpr", "ccpa"}
+BANNER_TEMPLATE = "blocks/consent-banner.html"
+
+_SCRIPT_TAG_RE = re.compile(r"<script\b[^>]*>", re.IGNORECASE)
+_CATEGORY_ATTR_RE = re.compile(r"""\sdata-consent-category=["']([^"']*)["']""")
+_TYPE_ATTR_RE = re.compile(r"""\stype=["']([^"']*)["']""", re.IGNORECASE)
+
+
+def gate_consent_scripts(html: str, exempt_categories: Set[str]) -> str:
+    """Turns consent-tagged scripts into inert `text/plain` placeholders.
+
+    Args:
+        html: The rendered page.
+        exempt_categories: Categories whose scripts may always run.
+
+    Returns:
+        The page with every tagged script of a non-exempt category gated.
+    """
+
+    def gate(match: "re.Match[str]") -> str:
+        tag = match.group(0)
+        category = _CATEGORY_ATTR_RE.search(tag)
+        if category is None or category.group(1) in exempt_categories:
+            return tag
+        script_type = _TYPE_ATTR_RE.search(tag)
+        if script_type is None:
+            return f'<script type="text/plain"{tag[len("<script") :]}'
+        if script_type.group(1) == "text/plain":
+            return tag
+        return (
+            tag[: script_type.start()]
+            + f' type="text/plain" data-consent-type="{script_type.group(1)}"'
+            + tag[script_type.end() :]
+        )
+
+    return _SCRIPT_TAG_RE.sub(gate, html)
+
+
+# Gates the scripts other generators' page contexts and processing added.
+@register_artifact_generator("consent", priority=800)
+class ConsentBannerGenerator(BaseArtifactGenerator):
+    """Renders the consent banner and gates consent-tagged scripts."""
+
+    def __init__(self, jinja_env: Environment):
+        super().__init__(jinja_env)
+        self._loaded: Dict[str, Optional[ConsentBanner]] = {}
+
+    def _get_settings(self, app_config: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+        """Returns the `consent` section if it is enabled and valid."""
+        settings = app_config.get("consent", {})
+        if not settings.get("enabled", False):
+            return None
+        if settings.get("regulation", "gdpr") not in REGULATIONS:
+            logger.warning(
+                "Unknown consent regulation '%s'. Skipping consent banner.",
+                settings.get("regulation"),
+            )
+            return None
+        return settings
+
+    def _load_banner(self, settings: Dict[str, Any]) -> Optional[ConsentBanner]:
+        """Loads and memoizes the ConsentBanner message."""
+        data_file = settings.get("data_file", "")
+        if not data_file:
+            return None
+        if data_file not in self._loaded:
+            self._loaded[data_file] = load_dynamic_single_item_data(
+                data_file, ConsentBanner  # type: ignore
+            )
+        return self._loaded[data_file]
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Contributes the rendered banner as a `body_snippets` entry."""
+        settings = self._get_settings(build_context.app_config)
+        banner = self._load_banner(settings) if settings else None
+        if settings is None or banner is None:
+            return {}
+
+        snippet = self.jinja_env.get_template(BANNER_TEMPLATE).render(
+            banner=banner,
+            regulation=settings.get("regulation", "gdpr"),
+            category_ids=[category.id for category in banner.categories],
+            required_category_ids=[
+                category.id for category in banner.categories if category.required
+            ],
+            translations=build_context.translations_by_lang.get(lang, {}),
+        )
+        return {"body_snippets": [snippet]}
+
+    def processes_pages(self, build_context: BuildContext) -> bool:
+        """Pages are processed while the section is enabled."""
+        return self._get_settings(build_context.app_config) is not None
+
+    def process_page(
+        self, output_path: str, html: str, build_context: BuildContext
+    ) -> str:
+        """Gates the page's consent-tagged scripts."""
+        settings = self._get_settings(build_context.app_config)
+        banner = self._load_banner(settings) if settings else None
+        if settings is None or banner is None:
+            return html
+        required = {
+            category.id for category in banner.categories if category.required
+        }
+        return gate_consent_scripts(html, required)
@@ -0,0 +1,165 @@
+"""
+Exports the content data as static JSON endpoints.
+
+Client-side features (search, "load more", widgets on other sites) can fetch
+the same content the pages are built from, without a server. For every
+supported language, each configured collection is written under
+`output_dir`:
+
+    api/index.json                   A manifest listing every endpoint
+    api/{lang}/{name}/index.json     A list collection, e.g. api/en/blog/index.json
+    api/{lang}/{name}/{id}.json      Each item of a list collection with an `id`
+    api/{lang}/{name}.json           A single-item collection, e.g. api/en/hero.json
+
+Collections map endpoint names to blocks of `block_data_loaders`, in the
+`content_api` section of `public/config.json`:
+
+    "content_api": {
+      "enabled": true,
+      "output_dir": "api",
+      "collections": {
+        "blog": "blog.html",
+        "testimonials": "testimonials.html"
+      }
+    }
+
+Items are serialized with protobuf's JSON mapping, keeping the `.proto` field
+names (snake_case, as in `data/*.json`) so the output does not change with
+the code generator. Every translation key object (`{"key": ...}`, an
+`I18nString`) also gets the `text` it translates to in that language.
+"""
+
+import json
+import logging
+import posixpath
+import re
+from typing import Any, Dict, List, Optional
+
+from google.protobuf import json_format
+from google.protobuf.message import Message
+
+from .interfaces import BuildContext, Translations
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import site_base_path
+
+logger = logging.getLogger(__name__)
+
+API_VERSION = 1
+SAFE_ID_RE = re.compile(r"^[A-Za-z0-9_-][A-Za-z0-9_.-]*$")
+
+
+def message_to_dict(message: Message) -> Dict[str, Any]:
+    """Serializes a message with the proto field names."""
+    return json_format.MessageToDict(message, preserving_proto_field_name=True)
+
+
+def localize(value: Any, translations: Translations) -> Any:
+    """Adds the translated `text` to every translation key object in a value."""
+    if isinstance(value, list):
+        return [localize(item, translations) for item in value]
+    if not isinstance(value, dict):
+        return value
+    if set(value) == {"key"} and isinstance(value["key"], str):
+        key = value["key"]
+        return {"key": key, "text": translations.get(key, key)}
+    return {name: localize(item, translations) for name, item in value.items()}
+
+
+def _dump(payload: Any) -> str:
+    """Formats an endpoint's JSON."""
+    return json.dumps(payload, ensure_ascii=False, indent=2) + "\n"
+
+
+@register_artifact_generator("content_api")
+class ContentApiGenerator(BaseArtifactGenerator):
+    """Writes block data as per-language static JSON endpoints."""
+
+    def _get_settings(self, app_config: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+        """Returns the content_api config section if enabled, else None."""
+        settings = app_config.get("content_api", {})
+        if not settings.get("enabled", False):
+            return None
+        return dict(settings)
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates every collection's endpoints for every language."""
+        settings = self._get_settings(build_context.app_config)
+        if settings is None:
+            return {}
+
+        output_dir: str = settings.get("output_dir", "api").strip("/")
+        artifacts: Dict[str, str] = {}
+        manifest: Dict[str, Dict[str, str]] = {}
+        for name, block_name in settings.get("collections", {}).items():
+            if block_name not in build_context.block_data:
+                logger.warning(
+                    "Content API collection '%s' refers to unknown block '%s'.",
+                    name,
+                    block_name,
+                )
+                continue
+            data = build_context.block_data[block_name]
+            if data is None:
+                continue
+            manifest[name] = {}
+            for lang in build_context.supported_langs:
+                translations = build_context.translations_by_lang.get(lang, {})
+                endpoints = self._collection_endpoints(
+                    posixpath.join(output_dir, lang), name, lang, data, translations
+                )
+                artifacts.update(endpoints)
+                manifest[name][lang] = site_base_path(
+                    build_context.app_config
+                ) + next(iter(endpoints))
+
+        if artifacts:
+            artifacts[posixpath.join(output_dir, "index.json")] = _dump(
+                {
+                    "version": API_VERSION,
+                    "default_lang": build_context.default_lang,
+                    "languages": build_context.supported_langs,
+                    "collections": manifest,
+                }
+            )
+        return artifacts
+
+    def _collection_endpoints(
+        self,
+        lang_dir: str,
+        name: str,
+        lang: str,
+        data: Any,
+        translations: Translations,
+    ) -> Dict[str, str]:
+        """Returns a collection's endpoints for one language, index first."""
+        if not isinstance(data, list):
+            item = localize(message_to_dict(data), translations)
+            return {
+                posixpath.join(lang_dir, f"{name}.json"): _dump(
+                    {"lang": lang, "collection": name, "item": item}
+                )
+            }
+
+        items: List[Dict[str, Any]] = [
+            localize(message_to_dict(message), translations) for message in data
+        ]
+        endpoints = {
+            posixpath.join(lang_dir, name, "index.json"): _dump(
+                {"lang": lang, "collection": name, "count": len(items), "items": items}
+            )
+        }
+        for item in items:
+            item_id = item.get("id")
+            if not item_id:
+                continue
+            if not SAFE_ID_RE.match(item_id):
+                logger.warning(
+                    "Skipping the endpoint of %s item '%s': unsafe file name.",
+                    name,
+                    item_id,
+                )
+                continue
+            endpoints[posixpath.join(lang_dir, name, f"{item_id}.json")] = _dump(
+                {"lang": lang, "collection": name, "item": item}
+            )
+        return endpoints
@@ -4,26 +4,45 @@ Provides data loading and caching capabilities for protobuf messages from JSON f
 This module includes:
 - `JsonProtoDataLoader`: A class that implements the `DataLoader` protocol
   to load data from JSON files and parse it into specified protobuf messages.
+  Given a `BuildCache`, it keeps the parsed messages in its `data` cache,
+  keyed by the file content and message schema, and later builds decode
+  them from there instead of parsing the JSON again.
 - `InMemoryDataCache`: A class that implements the `DataCache` protocol
-  for simple in-memory storage of loaded data.
+  for in-memory storage of loaded data. It is safe to share between threads
+  (e.g., a rebuild in `python build.py serve` and the builds it replaces):
+  reads run concurrently under a `ReadWriteLock` and writes exclusively.
+  Entries can expire after a TTL and are invalidated by their source file;
+  `snapshot()` gives a build a consistent view while the cache changes.
 - Module-level convenience functions (`load_dynamic_list_data`,
   `load_dynamic_single_item_data`) that use a default instance of
   `JsonProtoDataLoader` for ease of use or backward compatibility.
 """
 
+import hashlib
 import json
 import logging
-from typing import Any, Dict, List, Optional, Type, Union
+import os
+import struct
+import threading
+import time
+from contextlib import contextmanager
+from dataclasses import dataclass
+from typing import Any, Callable, Dict, Iterable, Iterator, List, Optional, Type, Union
 
 from google.protobuf import json_format
 from google.protobuf.message import Message
 
+from .build_cache import BuildCache
 from .interfaces import DataCache, DataLoader, T
 
 # Configure basic logging
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+DATA_CACHE_NAMESPACE = "data"
+# Prefixes each serialized message in a data cache entry.
+MESSAGE_LENGTH = struct.Struct(">I")
+
 
 class JsonProtoDataLoader(DataLoader[T]):
     """
@@ -31,6 +50,15 @@ class JsonProtoDataLoader(DataLoader[T]):
     Implements the `DataLoader` protocol using a generic type `T` for messages.
     """
 
+    def __init__(self, build_cache: Optional[BuildCache] = None):
+        """Initializes the loader.
+
+        Args:
+            build_cache: Where to keep parsed data between builds; None
+                parses every file on every load.
+        """
+        self.build_cache = build_cache
+
     def load_dynamic_list_data(
         self, data_file_path: str, message_type: Type[T]
     ) -> List[T]:
@@ -47,18 +75,23 @@ class JsonProtoDataLoader(DataLoader[T]):
         """
         items: List[T] = []
         try:
-            with open(data_file_path, "r", encoding="utf-8") as f:
-                data_list_json = json.load(f)
-                if not isinstance(data_list_json, list):
-                    logger.warning(
-                        "Data in %s is not a list. Returning empty list.",
-                        data_file_path,
-                    )
-                    return []
-                for item_data in data_list_json:
-                    message = message_type()
-                    json_format.ParseDict(item_data, message)
-                    items.append(message)
+            with open(data_file_path, "rb") as f:
+                content = f.read()
+            cached = self._cached_messages(content, message_type)
+            if cached is not None:
+                return cached
+            data_list_json = json.loads(content)
+            if not isinstance(data_list_json, list):
+                logger.warning(
+                    "Data in %s is not a list. Returning empty list.",
+                    data_file_path,
+                )
+                return []
+            for item_data in data_list_json:
+                message = message_type()
+                json_format.ParseDict(item_data, message)
+                items.append(message)
+            self._cache_messages(content, message_type, items)
         except FileNotFoundError:
             logger.warning(
                 "Data file %s not found. Returning empty list.", data_file_path
@@ -97,11 +130,16 @@ class JsonProtoDataLoader(DataLoader[T]):
             Warnings are logged in such cases.
         """
         try:
-            with open(data_file_path, "r", encoding="utf-8") as f:
-                data_json = json.load(f)
-                message: T = message_type()
-                json_format.ParseDict(data_json, message)
-                return message
+            with open(data_file_path, "rb") as f:
+                content = f.read()
+            cached = self._cached_messages(content, message_type)
+            if cached is not None and len(cached) == 1:
+                return cached[0]
+            data_json = json.loads(content)
+            message: T = message_type()
+            json_format.ParseDict(data_json, message)
+            self._cache_messages(content, message_type, [message])
+            return message
         except FileNotFoundError:
             logger.warning("Data file %s not found. Returning None.", data_file_path)
         except json.JSONDecodeError:
@@ -122,20 +160,169 @@ class JsonProtoDataLoader(DataLoader[T]):
             )
         return None
 
+    @staticmethod
+    def _cache_key(content: bytes, message_type: Type[T]) -> str:
+        """Hashes a data file with the schema it is parsed into.
 
-class InMemoryDataCache(DataCache[T]):
+        The schema is the message's whole `.proto` file, so regenerated
+        messages never decode entries written for an older schema.
+        """
+        descriptor = message_type.DESCRIPTOR
+        digest = hashlib.sha256(descriptor.file.serialized_pb)
+        digest.update(descriptor.full_name.encode("utf-8") + b"\0")
+        digest.update(content)
+        return digest.hexdigest()
+
+    def _cached_messages(
+        self, content: bytes, message_type: Type[T]
+    ) -> Optional[List[T]]:
+        """Returns the cached messages of a data file, or None on a miss."""
+        if self.build_cache is None:
+            return None
+        entry = self.build_cache.get(
+            DATA_CACHE_NAMESPACE, self._cache_key(content, message_type)
+        )
+        if entry is None:
+            return None
+        try:
+            return decode_messages(entry, message_type)
+        except Exception:  # pylint: disable=broad-except
+            return None  # A corrupt entry is parsed again and overwritten.
+
+    def _cache_messages(
+        self, content: bytes, message_type: Type[T], messages: List[T]
+    ) -> None:
+        """Stores the messages parsed from a data file."""
+        if self.build_cache is not None:
+            self.build_cache.put(
+                DATA_CACHE_NAMESPACE,
+                self._cache_key(content, message_type),
+                encode_messages(messages),
+            )
+
+
+def encode_messages(messages: Iterable[Message]) -> bytes:
+    """Serializes messages, each prefixed by its length."""
+    parts = []
+    for message in messages:
+        serialized = message.SerializeToString()
+        parts.append(MESSAGE_LENGTH.pack(len(serialized)) + serialized)
+    return b"".join(parts)
+
+
+def decode_messages(content: bytes, message_type: Type[T]) -> List[T]:
+    """Parses messages serialized by `encode_messages`.
+
+    Raises:
+        ValueError: If `content` is truncated.
+        google.protobuf.message.DecodeError: If a message does not parse.
     """
-    Simple in-memory cache for dynamic data, generic over message type T.
-    Implements the `DataCache` protocol.
+    messages: List[T] = []
+    offset = 0
+    while offset < len(content):
+        if offset + MESSAGE_LENGTH.size > len(content):
+            raise ValueError("Truncated message length.")
+        (length,) = MESSAGE_LENGTH.unpack_from(content, offset)
+        offset += MESSAGE_LENGTH.size
+        if offset + length > len(content):
+            raise ValueError("Truncated message.")
+        message = message_type()
+        message.ParseFromString(content[offset : offset + length])
+        messages.append(message)
+        offset += length
+    return messages
+
+
+class ReadWriteLock:
+    """A lock held by any number of readers or by one writer.
+
+    Waiting writers keep new readers out, so a steady stream of reads cannot
+    starve a write. Not reentrant.
     """
 
     def __init__(self) -> None:
-        """Initializes an empty in-memory cache."""
+        self._condition = threading.Condition()
+        self._readers = 0
+        self._writing = False
+        self._waiting_writers = 0
+
+    @contextmanager
+    def read(self) -> Iterator[None]:
+        """Holds the lock for reading."""
+        with self._condition:
+            while self._writing or self._waiting_writers:
+                self._condition.wait()
+            self._readers += 1
+        try:
+            yield
+        finally:
+            with self._condition:
+                self._readers -= 1
+                if not self._readers:
+                    self._condition.notify_all()
+
+    @contextmanager
+    def write(self) -> Iterator[None]:
+        """Holds the lock for writing."""
+        with self._condition:
+            self._waiting_writers += 1
+            try:
+                while self._writing or self._readers:
+                    self._condition.wait()
+            finally:
+                self._waiting_writers -= 1
+            self._writing = True
+        try:
+            yield
+        finally:
+            with self._condition:
+                self._writing = False
+                self._condition.notify_all()
+
+
+@dataclass(frozen=True)
+class CacheEntry:
+    """A cached value with the file it was loaded from."""
+
+    value: Union[List[Message], Message, None]
+    source: str
+    expires_at: Optional[float] = None
+
+
+def _normalize_source(path: str) -> str:
+    return os.path.normpath(path).replace(os.sep, "/")
+
+
+class InMemoryDataCache(DataCache[T]):
+    """
+    In-memory cache for dynamic data, generic over message type T.
+    Implements the `DataCache` protocol and is safe to use from several
+    threads.
+    """
+
+    def __init__(
+        self,
+        ttl_seconds: Optional[float] = None,
+        clock: Callable[[], float] = time.monotonic,
+    ) -> None:
+        """Initializes an empty in-memory cache.
+
+        Args:
+            ttl_seconds: How long an entry stays valid; None keeps entries
+                until they are invalidated.
+            clock: Returns the current time in seconds (for tests).
+        """
         # The internal cache stores Union[List[Message], Message, None] to handle
         # various protobuf message types loaded by a generic DataLoader.
         # The type variable T in DataCache[T] implies that users of this cache
         # expect items of type T or List[T].
-        self._cache: Dict[str, Union[List[Message], Message, None]] = {}
+        self._entries: Dict[str, CacheEntry] = {}
+        self._lock = ReadWriteLock()
+        self._ttl_seconds = ttl_seconds
+        self._clock = clock
+
+    def _is_live(self, entry: CacheEntry) -> bool:
+        return entry.expires_at is None or entry.expires_at > self._clock()
 
     def get_item(self, key: str) -> Optional[Union[List[T], T]]:
         """Retrieves an item or a list of items from the cache by key.
@@ -144,30 +331,84 @@ class InMemoryDataCache(DataCache[T]):
             key: The key (typically the data file path) for the cached item.
 
         Returns:
-            The cached item(s) (List[T] or T) or None if the key is not found.
+            The cached item(s) (List[T] or T), or None if the key is not
+            found or its entry expired.
             A `type: ignore` is used here because the internal cache holds
             `Message` types for flexibility, while the interface promises `T`.
             This assumes `T` will be compatible with `Message` (e.g., `T` is a
             subclass of `Message` or `Message` itself), which is generally
             true for protobuf messages.
         """
-        item = self._cache.get(key)
-        if item is None:
+        with self._lock.read():
+            entry = self._entries.get(key)
+        if entry is None or not self._is_live(entry):
             return None
         # This type assertion is based on the assumption that items are stored
         # correctly by `set_item` and `preload_data`, and that T is compatible
         # with Message. If T were bound (e.g., T = TypeVar('T', bound=Message)),
         # this ignore might be avoidable or replaced with a cast.
-        return item  # type: ignore
+        return entry.value  # type: ignore
 
-    def set_item(self, key: str, value: Union[List[T], T, None]) -> None:
+    def set_item(
+        self,
+        key: str,
+        value: Union[List[T], T, None],
+        source: Optional[str] = None,
+    ) -> None:
         """Sets or updates an item in the cache.
 
         Args:
             key: The key (typically the data file path) for the item.
             value: The item (List[T], T, or None) to cache.
+            source: The file the item was loaded from; defaults to `key`.
+        """
+        expires_at = None
+        if self._ttl_seconds is not None:
+            expires_at = self._clock() + self._ttl_seconds
+        entry = CacheEntry(
+            value,  # type: ignore
+            _normalize_source(source if source is not None else key),
+            expires_at,
+        )
+        with self._lock.write():
+            self._entries[key] = entry
+
+    def invalidate_sources(self, paths: Iterable[str]) -> List[str]:
+        """Drops the entries loaded from any of the given files.
+
+        Returns:
+            The keys of the dropped entries.
+        """
+        sources = {_normalize_source(path) for path in paths}
+        with self._lock.write():
+            dropped = [
+                key for key, entry in self._entries.items() if entry.source in sources
+            ]
+            for key in dropped:
+                del self._entries[key]
+        if dropped:
+            logger.debug("Invalidated cached data: %s", ", ".join(dropped))
+        return dropped
+
+    def clear(self) -> None:
+        """Drops every entry."""
+        with self._lock.write():
+            self._entries.clear()
+
+    def snapshot(self) -> "InMemoryDataCache[T]":
+        """Returns a copy of the live entries, unaffected by later changes.
+
+        A build reads its data from a snapshot, so every page sees the same
+        data even if a file is invalidated and reloaded meanwhile.
         """
-        self._cache[key] = value
+        copy: InMemoryDataCache[T] = InMemoryDataCache()
+        with self._lock.read():
+            copy._entries = {
+                key: CacheEntry(entry.value, entry.source)
+                for key, entry in self._entries.items()
+                if self._is_live(entry)
+            }
+        return copy
 
     def preload_data(
         self,
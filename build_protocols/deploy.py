@@ -0,0 +1,411 @@
+"""
+Publishes the built site with `python build.py deploy <target>`.
+
+The command builds the site, collects its files and hands them to the
+target named on the command line. A site consists of the files written by
+the build plus the static files under the `include` paths (default
+`public/`, for the stylesheet and locales), minus the `exclude` patterns.
+Everything is configured by the `deploy` section of `public/config.json`:
+
+    "deploy": {
+      "include": ["public"],
+      "exclude": ["public/config.json"],
+      "delete": true,
+      "cache_control": {
+        "html": "no-cache",
+        "fingerprinted": "public, max-age=31536000, immutable",
+        "default": "public, max-age=3600"
+      },
+      "previews": { "directory": "previews" },
+      "timeout_seconds": 600,
+      "request_timeout_seconds": 60,
+      "deadline_seconds": 1800,
+      "targets": {
+        "s3": { "bucket": "my-site", "prefix": "", "region": "eu-west-1" }
+      }
+    }
+
+`BucketDeployTarget` syncs the files to object storage: it only uploads
+files whose content changed, sets `Cache-Control` per file (see
+`cache_control_for`) and, with `delete`, removes objects the site no longer
+has. Targets implement the `DeployTarget` protocol, live in their own
+modules and register themselves with `@register_deploy_target`.
+
+Header, redirect and edge files written for hosts other than the target
+(see `security_headers.py`, `redirects.py` and `edge_split.py`) are left out
+of a deploy; a target lists the formats its host reads in `host_formats`.
+
+`deploy <target> --preview` publishes a branch build into its own
+subdirectory, `<previews.directory>/<branch>/`, built with that path as its
+base path so links and assets work there. The branch is taken from the CI
+environment or git (or `--branch`). Targets that publish into a
+subdirectory (buckets and `gh-pages`) leave everything outside it alone,
+and deploys of the main site keep the previews directory.
+
+A deploy cannot hang on one call: every request to a host or storage API
+times out after `request_timeout_seconds` (default 60) and every command
+(git, wrangler) after `timeout_seconds` (default 600). `deadline_seconds`
+bounds the whole publishing step, and SIGTERM (a cancelled CI job) stops it
+after the file being uploaded (see `timeouts.py`). Targets keep their
+`Deadline` in `deadline` and check it before each operation; uploads are
+reported by a `ProgressReporter`.
+"""
+
+import fnmatch
+import hashlib
+import logging
+import mimetypes
+import os
+import posixpath
+import re
+import subprocess
+from typing import Any, Callable, Dict, Iterable, List, Optional, Tuple, Type
+
+from .interfaces import DeploySummary, DeployTarget
+from .edge_split import EDGE_SPLIT_FILENAMES
+from .redirects import REDIRECT_FILENAMES
+from .security_headers import HEADER_FILENAMES
+from .staging import STAGING_FILENAMES
+from .timeouts import DEFAULT_REQUEST_TIMEOUT_SECONDS, Deadline
+from .work_queue import ProgressReporter
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_INCLUDE = ["public"]
+DEFAULT_EXCLUDE = ["public/config.json"]
+DEFAULT_CACHE_CONTROL = {
+    "html": "no-cache",
+    "fingerprinted": "public, max-age=31536000, immutable",
+    "default": "public, max-age=3600",
+}
+# A content hash in the file name (e.g., style.3f2a9c1d.css or app-3f2a9c1d.js)
+# means the URL changes whenever the content does, so it may be cached forever.
+FINGERPRINT_RE = re.compile(r"[.-][0-9a-fA-F]{8,}\.[^./]+$")
+HOST_CONFIG_SECTIONS = {
+    "security_headers": HEADER_FILENAMES,
+    "redirects": REDIRECT_FILENAMES,
+    "staging": STAGING_FILENAMES,
+    "edge_split": EDGE_SPLIT_FILENAMES,
+}
+TEXT_TYPES = ("text/", "application/json", "application/javascript", "image/svg")
+DEFAULT_PREVIEWS_DIRECTORY = "previews"
+# CI variables holding the branch being built (GitHub Actions, GitLab, Netlify).
+BRANCH_ENV_VARS = ("GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BRANCH")
+GIT_BRANCH_TIMEOUT_SECONDS = 30
+# Deploy settings that targets may also override in their own section.
+DEPLOY_TIMEOUT_SETTINGS = (
+    "timeout_seconds",
+    "request_timeout_seconds",
+    "deadline_seconds",
+)
+
+# Registry for deploy targets
+DEPLOY_TARGET_REGISTRY: Dict[str, Type[DeployTarget]] = {}
+
+
+class DeployError(Exception):
+    """Raised when the site could not be published."""
+
+
+def register_deploy_target(
+    name: str,
+) -> Callable[[Type[DeployTarget]], Type[DeployTarget]]:
+    """
+    A decorator to register a deploy target class under a name.
+    """
+
+    def decorator(cls: Type[DeployTarget]) -> Type[DeployTarget]:
+        if name in DEPLOY_TARGET_REGISTRY:
+            logger.warning("Deploy target '%s' is being overridden by %s", name, cls)
+        DEPLOY_TARGET_REGISTRY[name] = cls
+        return cls
+
+    return decorator
+
+
+def _site_path(path: str) -> str:
+    """Returns a local path relative to the site root, "/"-separated."""
+    return os.path.relpath(path).replace(os.sep, "/")
+
+
+def current_branch() -> str:
+    """Returns the branch being deployed, from the CI environment or git.
+
+    Raises:
+        DeployError: If the branch cannot be determined.
+    """
+    for name in BRANCH_ENV_VARS:
+        if os.environ.get(name):
+            return os.environ[name]
+    try:
+        branch = subprocess.run(
+            ["git", "rev-parse", "--abbrev-ref", "HEAD"],
+            capture_output=True,
+            text=True,
+            check=True,
+            timeout=GIT_BRANCH_TIMEOUT_SECONDS,
+        ).stdout.strip()
+    except (OSError, subprocess.SubprocessError) as e:
+        raise DeployError("Could not determine the branch; pass --branch.") from e
+    if branch == "HEAD":
+        raise DeployError("Detached HEAD: pass --branch for the preview.")
+    return branch
+
+
+def preview_directory(previews_directory: str, branch: str) -> str:
+    """Returns the site path of a branch's preview, e.g. "previews/feature-x".
+
+    Branch names are reduced to lowercase letters, digits and dashes, so
+    "feature/New_Form" is published as "feature-new-form".
+    """
+    slug = re.sub(r"[^a-z0-9]+", "-", branch.lower()).strip("-")
+    if not slug:
+        raise DeployError(f"Branch '{branch}' has no usable name for a preview.")
+    return posixpath.join(previews_directory.strip("/"), slug)
+
+
+def is_preserved(site_path: str, preserve: Iterable[str]) -> bool:
+    """Returns whether a site path is inside one of the preserved paths."""
+    return any(
+        site_path == path or site_path.startswith(path.rstrip("/") + "/")
+        for path in preserve
+    )
+
+
+def collect_site_files(
+    written_files: Iterable[str],
+    include: Iterable[str] = DEFAULT_INCLUDE,
+    exclude: Iterable[str] = DEFAULT_EXCLUDE,
+) -> Dict[str, str]:
+    """Lists the files that make up the site.
+
+    Args:
+        written_files: The files written by the build.
+        include: Static files or directories to publish as well.
+        exclude: Glob patterns of site paths to leave out.
+
+    Returns:
+        The local path of every file, keyed by its site path.
+    """
+    paths: List[str] = list(written_files)
+    for include_path in include:
+        if os.path.isfile(include_path):
+            paths.append(include_path)
+            continue
+        for directory, _, filenames in os.walk(include_path):
+            paths.extend(os.path.join(directory, name) for name in filenames)
+
+    exclude = list(exclude)
+    files: Dict[str, str] = {}
+    for path in paths:
+        site_path = _site_path(path)
+        if any(fnmatch.fnmatch(site_path, pattern) for pattern in exclude):
+            continue
+        files[site_path] = path
+    return dict(sorted(files.items()))
+
+
+def select_host_config(
+    files: Dict[str, str], app_config: Dict[str, Any], host_formats: Iterable[str]
+) -> Dict[str, str]:
+    """Keeps only the header and redirect files the target's host reads.
+
+    The files written for other hosts (e.g., nginx snippets) configure a
+    server rather than being part of the site, so they are not published.
+    Warns when an enabled section does not write the target's format.
+
+    Args:
+        files: The site's files, keyed by site path.
+        app_config: The app config, for the `security_headers` and
+            `redirects` sections.
+        host_formats: The formats the target's host reads (e.g., "netlify").
+    """
+    host_formats = set(host_formats)
+    selected = dict(files)
+    for section, filenames in HOST_CONFIG_SECTIONS.items():
+        settings = app_config.get(section, {})
+        for host_format, filename in filenames.items():
+            if host_format not in host_formats:
+                selected.pop(filename, None)
+            elif settings.get("enabled", False) and host_format not in settings.get(
+                "formats", list(filenames)
+            ):
+                logger.warning(
+                    "Add '%s' to %s.formats to publish %s.",
+                    host_format,
+                    section,
+                    filename,
+                )
+    return selected
+
+
+def cache_control_for(site_path: str, cache_control: Dict[str, str]) -> str:
+    """Returns the `Cache-Control` header value for a file.
+
+    HTML must be revalidated so new deploys show up at once; fingerprinted
+    assets never change under their URL; anything else gets a short cache.
+    """
+    settings = {**DEFAULT_CACHE_CONTROL, **cache_control}
+    if site_path.endswith((".html", ".htm")):
+        return settings["html"]
+    if FINGERPRINT_RE.search(posixpath.basename(site_path)):
+        return settings["fingerprinted"]
+    return settings["default"]
+
+
+def content_type_for(site_path: str) -> str:
+    """Returns the `Content-Type` header value for a file."""
+    content_type = mimetypes.guess_type(site_path)[0] or "application/octet-stream"
+    if content_type.startswith(TEXT_TYPES):
+        content_type += "; charset=utf-8"
+    return content_type
+
+
+def file_md5(path: str) -> str:
+    """Returns the hex MD5 digest of a file, as object stores report it."""
+    digest = hashlib.md5()
+    with open(path, "rb") as f:
+        for chunk in iter(lambda: f.read(65536), b""):
+            digest.update(chunk)
+    return digest.hexdigest()
+
+
+def compare_files(
+    files: Dict[str, str],
+    remote: Dict[str, Optional[str]],
+    digest: Callable[[str], str],
+    preserve: Iterable[str] = (),
+) -> DeploySummary:
+    """Compares the site with the files a target already has.
+
+    Args:
+        files: The local path of every file, keyed by its site path.
+        remote: The digest of every file on the target, keyed by its site
+            path; None when unknown.
+        digest: Computes a local file's digest the way the target does.
+        preserve: Site paths on the target that are never deleted (e.g.,
+            the branch previews).
+
+    Returns:
+        New and changed files as `uploaded` (with their total size), the
+        others as `unchanged` and files only the target has as `deleted`.
+    """
+    summary = DeploySummary()
+    for site_path, local_path in files.items():
+        if remote.get(site_path) == digest(local_path):
+            summary.unchanged.append(site_path)
+            continue
+        summary.uploaded.append(site_path)
+        summary.uploaded_bytes += os.path.getsize(local_path)
+    summary.deleted = sorted(
+        path
+        for path in set(remote) - set(files)
+        if not is_preserved(path, preserve)
+    )
+    return summary
+
+
+def format_summary(summary: DeploySummary, dry_run: bool = False) -> str:
+    """Describes a deploy in one line."""
+    verb = "Would upload" if dry_run else "Uploaded"
+    return (
+        f"{verb} {len(summary.uploaded)} files "
+        f"({summary.uploaded_bytes / 1024:.1f} KB), "
+        f"{len(summary.unchanged)} unchanged, "
+        f"{'would delete' if dry_run else 'deleted'} {len(summary.deleted)}."
+    )
+
+
+class BucketDeployTarget(DeployTarget):
+    """A base class for targets that sync files to an object storage bucket.
+
+    Subclasses list, upload and delete objects; this class decides what to
+    sync. Object keys are site paths under the optional `prefix` (and the
+    `subdirectory` of a preview deploy).
+    """
+
+    description = "bucket"
+    host_formats: Tuple[str, ...] = ()
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.bucket: str = settings.get("bucket", "")
+        if not self.bucket:
+            raise DeployError(f"The {self.description} target needs a 'bucket'.")
+        self.prefix = "/".join(
+            path
+            for path in (
+                settings.get("prefix", "").strip("/"),
+                settings.get("subdirectory", "").strip("/"),
+            )
+            if path
+        )
+        self.preserve: List[str] = settings.get("preserve", [])
+        self.cache_control: Dict[str, str] = settings.get("cache_control", {})
+        self.delete_removed: bool = settings.get("delete", True)
+        self.request_timeout: float = settings.get(
+            "request_timeout_seconds", DEFAULT_REQUEST_TIMEOUT_SECONDS
+        )
+        self.deadline = Deadline(settings.get("deadline_seconds"))
+
+    def key_for(self, site_path: str) -> str:
+        """Returns the object key of a site path."""
+        return f"{self.prefix}/{site_path}" if self.prefix else site_path
+
+    def deploy(self, files: Dict[str, str], dry_run: bool = False) -> DeploySummary:
+        """Uploads new and changed files, then deletes removed ones."""
+        prefix = f"{self.prefix}/" if self.prefix else ""
+        remote = {
+            key[len(prefix) :]: md5
+            for key, md5 in self.list_objects(prefix).items()
+            if key.startswith(prefix)
+        }
+        summary = compare_files(files, remote, file_md5, self.preserve)
+        progress = ProgressReporter(
+            total=len(summary.uploaded), verb="Uploaded", noun="files"
+        )
+        for site_path in summary.uploaded:
+            cache_control = cache_control_for(site_path, self.cache_control)
+            logger.info(
+                "Uploading with Cache-Control: %s",
+                cache_control,
+                extra={"file": site_path},
+            )
+            if not dry_run:
+                self.deadline.check(f"uploading {site_path}")
+                self.upload(
+                    self.key_for(site_path),
+                    files[site_path],
+                    content_type_for(site_path),
+                    cache_control,
+                )
+                progress.advance()
+        if summary.uploaded and not dry_run:
+            progress.finish()
+
+        if not self.delete_removed:
+            summary.deleted = []
+        for site_path in summary.deleted:
+            logger.info("Deleting", extra={"file": site_path})
+        if summary.deleted and not dry_run:
+            self.deadline.check("deleting removed files")
+            self.delete([self.key_for(path) for path in summary.deleted])
+        return summary
+
+    def list_objects(self, prefix: str) -> Dict[str, Optional[str]]:
+        """Returns the hex MD5 of every object under a prefix, keyed by key.
+
+        The MD5 is None when the store does not know it (e.g., for multipart
+        uploads), so the object is uploaded again.
+        """
+        raise NotImplementedError
+
+    def upload(
+        self, key: str, local_path: str, content_type: str, cache_control: str
+    ) -> None:
+        """Uploads a file as an object, within `request_timeout` seconds."""
+        raise NotImplementedError
+
+    def delete(self, keys: List[str]) -> None:
+        """Deletes objects."""
+        raise NotImplementedError
@@ -0,0 +1,125 @@
+"""
+Deploys the site to Amazon S3 or Google Cloud Storage buckets.
+
+Two deploy targets sync the site to a bucket set up for static website
+hosting (see `BucketDeployTarget` in `deploy.py`):
+
+- `s3`: an Amazon S3 `bucket`, in an optional `region`; an `endpoint_url`
+  selects an S3-compatible store such as Cloudflare R2 or MinIO. Needs the
+  `boto3` package.
+- `gcs`: a Google Cloud Storage `bucket` of an optional `project`. Needs the
+  `google-cloud-storage` package.
+
+Each is configured by its section of `deploy.targets` in
+`public/config.json`, e.g. `"s3": { "bucket": "my-site", "prefix": "" }`.
+Credentials come from each SDK's usual sources (environment variables,
+shared credential files, instance roles), so none are stored in the config.
+"""
+
+import base64
+import binascii
+from typing import Any, Dict, List, Optional
+
+from .deploy import BucketDeployTarget, DeployError, register_deploy_target
+
+S3_DELETE_BATCH = 1000
+
+
+@register_deploy_target("s3")
+class S3DeployTarget(BucketDeployTarget):
+    """Syncs the site to an Amazon S3 (or S3-compatible) bucket."""
+
+    description = "S3"
+
+    def __init__(self, settings: Dict[str, Any]):
+        super().__init__(settings)
+        try:
+            # pylint: disable=import-outside-toplevel
+            import boto3
+            from botocore.config import Config
+        except ImportError as e:
+            raise DeployError("Deploying to S3 needs boto3: pip install boto3") from e
+        self.client = boto3.client(
+            "s3",
+            region_name=settings.get("region") or None,
+            endpoint_url=settings.get("endpoint_url") or None,
+            config=Config(
+                connect_timeout=self.request_timeout,
+                read_timeout=self.request_timeout,
+            ),
+        )
+
+    def list_objects(self, prefix: str) -> Dict[str, Optional[str]]:
+        objects: Dict[str, Optional[str]] = {}
+        paginator = self.client.get_paginator("list_objects_v2")
+        for page in paginator.paginate(Bucket=self.bucket, Prefix=prefix):
+            for item in page.get("Contents", []):
+                etag = item.get("ETag", "").strip('"')
+                # Multipart uploads have an ETag that is not the MD5 ("...-3").
+                objects[item["Key"]] = None if "-" in etag else etag
+        return objects
+
+    def upload(
+        self, key: str, local_path: str, content_type: str, cache_control: str
+    ) -> None:
+        self.client.upload_file(
+            local_path,
+            self.bucket,
+            key,
+            ExtraArgs={"ContentType": content_type, "CacheControl": cache_control},
+        )
+
+    def delete(self, keys: List[str]) -> None:
+        for start in range(0, len(keys), S3_DELETE_BATCH):
+            batch = keys[start : start + S3_DELETE_BATCH]
+            self.client.delete_objects(
+                Bucket=self.bucket,
+                Delete={"Objects": [{"Key": key} for key in batch], "Quiet": True},
+            )
+
+
+@register_deploy_target("gcs")
+class GcsDeployTarget(BucketDeployTarget):
+    """Syncs the site to a Google Cloud Storage bucket."""
+
+    description = "GCS"
+
+    def __init__(self, settings: Dict[str, Any]):
+        super().__init__(settings)
+        try:
+            # pylint: disable-next=import-outside-toplevel
+            from google.cloud import storage
+        except ImportError as e:
+            raise DeployError(
+                "Deploying to GCS needs google-cloud-storage: "
+                "pip install google-cloud-storage"
+            ) from e
+        self.client = storage.Client(project=settings.get("project") or None)
+        self.gcs_bucket = self.client.bucket(self.bucket)
+
+    def list_objects(self, prefix: str) -> Dict[str, Optional[str]]:
+        objects: Dict[str, Optional[str]] = {}
+        for blob in self.client.list_blobs(
+            self.bucket, prefix=prefix or None, timeout=self.request_timeout
+        ):
+            # GCS reports MD5s base64-encoded; composite objects have none.
+            try:
+                md5 = base64.b64decode(blob.md5_hash).hex() if blob.md5_hash else None
+            except (binascii.Error, ValueError):
+                md5 = None
+            objects[blob.name] = md5
+        return objects
+
+    def upload(
+        self, key: str, local_path: str, content_type: str, cache_control: str
+    ) -> None:
+        blob = self.gcs_bucket.blob(key)
+        blob.cache_control = cache_control
+        blob.upload_from_filename(
+            local_path, content_type=content_type, timeout=self.request_timeout
+        )
+
+    def delete(self, keys: List[str]) -> None:
+        for key in keys:
+            self.deadline.check(f"deleting {key}")
+            self.gcs_bucket.blob(key).delete(timeout=self.request_timeout)
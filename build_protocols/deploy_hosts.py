@@ -0,0 +1,395 @@
+"""
+Deploys the site to GitHub Pages, Netlify or Cloudflare Pages.
+
+- `gh-pages`: commits the site to the `branch` (default `gh-pages`) of this
+  repository and pushes it to `remote` (default `origin`). The commit is
+  built with git plumbing, so the working tree is left alone, and is
+  skipped when the site did not change. `.nojekyll` is added so files
+  starting with `_` are served, plus a `CNAME` file for a custom domain
+  (`cname`). Preview deploys replace only their subdirectory of the branch.
+- `netlify`: creates a deploy of the Netlify site `site_id` through its API
+  and uploads only the files Netlify does not have yet. Set `draft` for a
+  preview deploy. The access token is read from the environment variable
+  named by `auth_token_env` (default `NETLIFY_AUTH_TOKEN`).
+- `cloudflare`: runs `wrangler pages deploy` for the Cloudflare Pages
+  project `project_name` (and optional `branch`). Wrangler reads its
+  credentials from `CLOUDFLARE_API_TOKEN` and `CLOUDFLARE_ACCOUNT_ID`.
+
+Netlify and Cloudflare Pages replace the whole site on every deploy, so
+they publish branch previews their own way (`draft` deploys and `branch`
+deployments) instead of into a subdirectory.
+
+Netlify and Cloudflare Pages read the `_headers` and `_redirects` files
+written by the `security_headers` and `redirects` stages, so enable their
+`netlify` format to publish headers and redirects there. Cloudflare Pages
+has no `Basic-Auth` rule: it would send the staging password to every
+visitor as a response header, so staging builds are not deployed there.
+Cloudflare Pages also runs the `_worker.js` of the `edge_split` stage.
+
+Every git and wrangler command runs under the deploy's `timeout_seconds`
+and every Netlify API call under its `request_timeout_seconds`, both cut
+to what is left of `deadline_seconds` (see `timeouts.py`).
+"""
+
+import hashlib
+import json
+import logging
+import os
+import shutil
+import subprocess
+import tempfile
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Dict, List, Optional, Tuple
+
+from .deploy import DeployError, compare_files, is_preserved, register_deploy_target
+from .interfaces import DeploySummary, DeployTarget
+from .security_headers import HEADER_FILENAMES
+from .timeouts import (
+    DEFAULT_COMMAND_TIMEOUT_SECONDS,
+    DEFAULT_REQUEST_TIMEOUT_SECONDS,
+    Deadline,
+)
+from .work_queue import ProgressReporter
+
+logger = logging.getLogger(__name__)
+
+NETLIFY_API_URL = "https://api.netlify.com/api/v1"
+USER_AGENT = "landing-template-deploy"
+
+
+def run_command(
+    args: List[str],
+    input_text: Optional[str] = None,
+    env: Optional[Dict[str, str]] = None,
+    timeout: Optional[float] = None,
+) -> str:
+    """Runs a command and returns its standard output.
+
+    Raises:
+        DeployError: If the command is missing, fails or takes longer than
+            `timeout` seconds (it is killed then).
+    """
+    try:
+        result = subprocess.run(
+            args,
+            input=input_text,
+            env={**os.environ, **(env or {})},
+            capture_output=True,
+            text=True,
+            check=False,
+            timeout=timeout,
+        )
+    except subprocess.TimeoutExpired as e:
+        raise DeployError(
+            f"'{' '.join(args[:3])}' timed out after {e.timeout:g} s"
+        ) from e
+    except OSError as e:
+        raise DeployError(f"Could not run {args[0]}: {e}") from e
+    if result.returncode != 0:
+        raise DeployError(
+            f"'{' '.join(args[:3])}' failed: {result.stderr.strip() or result.stdout}"
+        )
+    return result.stdout
+
+
+def _write_extra_files(extra: Dict[str, str], directory: str) -> Dict[str, str]:
+    """Writes generated files into a directory; returns them by site path."""
+    files: Dict[str, str] = {}
+    for site_path, content in extra.items():
+        path = os.path.join(directory, site_path)
+        with open(path, "w", encoding="utf-8") as f:
+            f.write(content)
+        files[site_path] = path
+    return files
+
+
+@register_deploy_target("gh-pages")
+class GitHubPagesDeployTarget(DeployTarget):
+    """Commits the site to a GitHub Pages branch and pushes it."""
+
+    host_formats: Tuple[str, ...] = ()
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.remote: str = settings.get("remote", "origin")
+        self.branch: str = settings.get("branch", "gh-pages")
+        self.message: str = settings.get("message", "Deploy site")
+        self.subdirectory: str = settings.get("subdirectory", "").strip("/")
+        self.preserve: List[str] = settings.get("preserve", [])
+        self.command_timeout: float = settings.get(
+            "timeout_seconds", DEFAULT_COMMAND_TIMEOUT_SECONDS
+        )
+        self.deadline = Deadline(settings.get("deadline_seconds"))
+
+    def git(self, *args: str, **kwargs: Any) -> str:
+        """Runs a git command in the repository."""
+        timeout = self.deadline.timeout(self.command_timeout, f"git {args[0]}")
+        return run_command(["git", *args], timeout=timeout, **kwargs).strip()
+
+    def _parent_commit(self) -> Optional[str]:
+        """Fetches the branch and returns its latest commit, if it exists."""
+        remote_ref = f"refs/remotes/{self.remote}/{self.branch}"
+        try:
+            self.git("fetch", "--quiet", self.remote, f"+{self.branch}:{remote_ref}")
+        except DeployError as e:
+            logger.info("Not fetching %s: %s", self.branch, e)
+        for ref in (remote_ref, f"refs/heads/{self.branch}"):
+            try:
+                return self.git("rev-parse", "--verify", "--quiet", ref)
+            except DeployError:
+                continue
+        return None
+
+    def _published_blobs(self, parent: Optional[str]) -> Dict[str, Optional[str]]:
+        """Returns the blob hash of every file on the branch, by site path."""
+        if parent is None:
+            return {}
+        blobs: Dict[str, Optional[str]] = {}
+        for line in self.git("ls-tree", "-r", "--full-tree", parent).splitlines():
+            info, site_path = line.split("\t", 1)
+            blobs[site_path] = info.split()[2]
+        return blobs
+
+    def deploy(self, files: Dict[str, str], dry_run: bool = False) -> DeploySummary:
+        """Commits the site as the branch's new tree and pushes it."""
+        extra = {".nojekyll": ""}
+        if self.settings.get("cname"):
+            extra["CNAME"] = self.settings["cname"] + "\n"
+        if self.subdirectory:
+            # A preview lives next to the main site, which provides these.
+            extra = {}
+            files = {
+                f"{self.subdirectory}/{site_path}": local_path
+                for site_path, local_path in files.items()
+            }
+        with tempfile.TemporaryDirectory() as temp_dir:
+            files = dict(
+                sorted({**files, **_write_extra_files(extra, temp_dir)}.items())
+            )
+            local_paths = list(files.values())
+            blob_list = self.git(
+                "hash-object",
+                *([] if dry_run else ["-w"]),
+                "--stdin-paths",
+                input_text="\n".join(local_paths) + "\n",
+            ).split()
+            blobs = dict(zip(local_paths, blob_list))
+            parent = self._parent_commit()
+            published = self._published_blobs(parent)
+            # Files outside a preview's subdirectory, or preserved by a deploy
+            # of the main site, stay in the tree.
+            kept = {
+                site_path: blob
+                for site_path, blob in published.items()
+                if (
+                    not is_preserved(site_path, [self.subdirectory])
+                    if self.subdirectory
+                    else is_preserved(site_path, self.preserve)
+                )
+            }
+            summary = compare_files(
+                files,
+                {path: blob for path, blob in published.items() if path not in kept},
+                blobs.__getitem__,
+            )
+            if dry_run or not (summary.uploaded or summary.deleted):
+                return summary
+
+            # A throwaway index keeps the repository's own index untouched.
+            index_env = {"GIT_INDEX_FILE": os.path.join(temp_dir, "index")}
+            self.git(
+                "update-index",
+                "--add",
+                "--index-info",
+                input_text="".join(
+                    [
+                        f"100644 blob {blobs[local_path]}\t{site_path}\n"
+                        for site_path, local_path in files.items()
+                    ]
+                    + [
+                        f"100644 blob {blob}\t{site_path}\n"
+                        for site_path, blob in kept.items()
+                        if site_path not in files
+                    ]
+                ),
+                env=index_env,
+            )
+            tree = self.git("write-tree", env=index_env)
+        parent_args = ["-p", parent] if parent else []
+        commit = self.git("commit-tree", tree, *parent_args, "-m", self.message)
+        self.git("update-ref", f"refs/heads/{self.branch}", commit)
+        logger.info("Pushing %s to %s", self.branch, self.remote)
+        self.git("push", "--quiet", self.remote, f"{self.branch}:{self.branch}")
+        return summary
+
+
+def file_sha1(path: str) -> str:
+    """Returns the hex SHA-1 digest of a file, as Netlify identifies files."""
+    digest = hashlib.sha1()
+    with open(path, "rb") as f:
+        for chunk in iter(lambda: f.read(65536), b""):
+            digest.update(chunk)
+    return digest.hexdigest()
+
+
+@register_deploy_target("netlify")
+class NetlifyDeployTarget(DeployTarget):
+    """Deploys the site to Netlify through its file digest API."""
+
+    host_formats: Tuple[str, ...] = ("netlify",)
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.site_id: str = settings.get("site_id", "")
+        self.token = os.environ.get(
+            settings.get("auth_token_env", "NETLIFY_AUTH_TOKEN"), ""
+        )
+        if not self.site_id or not self.token:
+            raise DeployError(
+                "The Netlify target needs a 'site_id' and an access token in "
+                f"${settings.get('auth_token_env', 'NETLIFY_AUTH_TOKEN')}."
+            )
+        if settings.get("subdirectory"):
+            raise DeployError(
+                "Netlify deploys replace the whole site; set 'draft' for previews."
+            )
+        self.request_timeout: float = settings.get(
+            "request_timeout_seconds", DEFAULT_REQUEST_TIMEOUT_SECONDS
+        )
+        self.deadline = Deadline(settings.get("deadline_seconds"))
+
+    def api(
+        self,
+        method: str,
+        path: str,
+        body: Optional[bytes] = None,
+        content_type: str = "application/json",
+    ) -> Any:
+        """Calls the Netlify API and returns the decoded JSON answer."""
+        timeout = self.deadline.timeout(self.request_timeout, f"{method} {path}")
+        request = urllib.request.Request(
+            f"{NETLIFY_API_URL}{path}",
+            data=body,
+            headers={
+                "Authorization": f"Bearer {self.token}",
+                "Content-Type": content_type,
+                "User-Agent": USER_AGENT,
+            },
+            method=method,
+        )
+        try:
+            with urllib.request.urlopen(request, timeout=timeout) as response:
+                answer = response.read()
+        except urllib.error.HTTPError as e:
+            raise DeployError(f"Netlify answered {e.code} to {method} {path}") from e
+        except OSError as e:
+            raise DeployError(f"Could not reach Netlify: {e}") from e
+        return json.loads(answer.decode("utf-8")) if answer else None
+
+    def deploy(self, files: Dict[str, str], dry_run: bool = False) -> DeploySummary:
+        """Creates a deploy and uploads the files Netlify asks for."""
+        published = {
+            item["path"].lstrip("/"): item.get("sha")
+            for item in self.api("GET", f"/sites/{self.site_id}/files") or []
+        }
+        # Netlify deploys are atomic: files left out of a deploy disappear.
+        summary = compare_files(files, published, file_sha1)
+        if dry_run:
+            return summary
+
+        digests = {site_path: file_sha1(path) for site_path, path in files.items()}
+        deploy = self.api(
+            "POST",
+            f"/sites/{self.site_id}/deploys",
+            json.dumps(
+                {
+                    "files": {f"/{path}": sha for path, sha in digests.items()},
+                    "draft": bool(self.settings.get("draft", False)),
+                }
+            ).encode("utf-8"),
+        )
+        required = set(deploy.get("required", []))
+        progress = ProgressReporter(total=len(required), verb="Uploaded", noun="files")
+        for site_path, sha in digests.items():
+            if sha not in required:
+                continue
+            required.discard(sha)  # Identical files are uploaded once.
+            logger.info("Uploading", extra={"file": site_path})
+            with open(files[site_path], "rb") as f:
+                self.api(
+                    "PUT",
+                    f"/deploys/{deploy['id']}/files/{urllib.parse.quote(site_path)}",
+                    f.read(),
+                    "application/octet-stream",
+                )
+            progress.advance()
+        if progress.done:
+            progress.finish()
+        logger.info(
+            "Netlify deploy: %s", deploy.get("deploy_ssl_url") or deploy.get("id")
+        )
+        return summary
+
+
+def _has_basic_auth(headers_path: str) -> bool:
+    """Whether a `_headers` file has a Netlify `Basic-Auth` rule."""
+    with open(headers_path, encoding="utf-8") as f:
+        return any(line.strip().startswith("Basic-Auth:") for line in f)
+
+
+@register_deploy_target("cloudflare")
+class CloudflarePagesDeployTarget(DeployTarget):
+    """Deploys the site to Cloudflare Pages with the wrangler CLI."""
+
+    host_formats: Tuple[str, ...] = ("netlify", "cloudflare")
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.project_name: str = settings.get("project_name", "")
+        if not self.project_name:
+            raise DeployError("The Cloudflare target needs a 'project_name'.")
+        if settings.get("subdirectory"):
+            raise DeployError(
+                "Cloudflare Pages deploys replace the whole site; set 'branch' "
+                "for preview deployments."
+            )
+        self.command: List[str] = settings.get("wrangler", ["npx", "wrangler"])
+        self.command_timeout: float = settings.get(
+            "timeout_seconds", DEFAULT_COMMAND_TIMEOUT_SECONDS
+        )
+        self.deadline = Deadline(settings.get("deadline_seconds"))
+
+    def deploy(self, files: Dict[str, str], dry_run: bool = False) -> DeploySummary:
+        """Copies the site into a staging directory and deploys it.
+
+        Wrangler skips files the project already has, but does not report
+        which, so every file is listed as uploaded.
+        """
+        headers_file = files.get(HEADER_FILENAMES["netlify"])
+        if headers_file and _has_basic_auth(headers_file):
+            raise DeployError(
+                "Cloudflare Pages would publish the staging password as a "
+                "response header; deploy staging builds to Netlify or nginx."
+            )
+        summary = compare_files(files, {}, lambda path: "")
+        if dry_run:
+            return summary
+        with tempfile.TemporaryDirectory() as staging_dir:
+            for site_path, local_path in files.items():
+                target = os.path.join(staging_dir, site_path)
+                os.makedirs(os.path.dirname(target), exist_ok=True)
+                shutil.copyfile(local_path, target)
+            args = [
+                *self.command,
+                "pages",
+                "deploy",
+                staging_dir,
+                f"--project-name={self.project_name}",
+            ]
+            if self.settings.get("branch"):
+                args.append(f"--branch={self.settings['branch']}")
+            timeout = self.deadline.timeout(self.command_timeout, "wrangler deploy")
+            logger.info(run_command(args, timeout=timeout).strip())
+        return summary
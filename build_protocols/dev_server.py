@@ -0,0 +1,442 @@
+"""
+Serves the build output with live reload for local development.
+
+`python build.py serve` builds the site once, serves what a deploy would
+publish (the files the build wrote plus `public/`, see `collect_site_files`
+in `deploy.py`) over HTTP and polls the build inputs for changes:
+
+- A change under `REBUILD_PATHS` (templates, data, locales, config) triggers
+  a rebuild, then a reload.
+- A change under `RELOAD_PATHS` (static assets such as the stylesheet) only
+  triggers a reload, since the build does not process those files.
+
+Nothing else in the project root is reachable: the server answers 404 for
+its other files (e.g., `submissions.sqlite3`), for host configuration files
+such as `_headers`, and for every dotfile and dot-directory (e.g., `.git/`
+or the `.devcerts/` private key) except a `.well-known/` the build wrote.
+
+Every served HTML page gets a small script that listens on the
+`LIVE_RELOAD_ENDPOINT` Server-Sent Events stream and reloads the page when
+the server announces a new version. The CSP meta fallback (see
+`security_headers.py`) is removed from served pages, as its hashes would
+block that script; the generated files on disk are left untouched.
+
+With `--https` the site is served over TLS, so service workers, secure
+cookies and mixed-content warnings behave as in production. Without an
+explicit `--cert`/`--key`, a certificate for localhost is created once in
+`DEV_CERT_DIR`: by `mkcert` when it is installed (its local CA is trusted
+by the browser), otherwise a self-signed one by `openssl` (the browser
+asks to accept it). The standard library server speaks HTTP/1.1 only, so
+HTTP/2 (e.g., its multiplexing and push) cannot be tested locally; use a
+deploy preview for that.
+
+Responses are not cached by default. `--production-headers` sends the
+production `Cache-Control` values (see `cache_control_for` in `deploy.py`)
+and the configured security headers instead. Serving a staging profile
+(see `staging.py`) requires its username and password.
+"""
+
+import base64
+import fnmatch
+import hmac
+import os
+import re
+import shutil
+import ssl
+import subprocess
+import threading
+import time
+from http.server import SimpleHTTPRequestHandler, ThreadingHTTPServer
+from typing import Any, Callable, Dict, FrozenSet, Iterable, List, Optional, Tuple
+
+from .deploy import (
+    DEFAULT_EXCLUDE,
+    DEFAULT_INCLUDE,
+    cache_control_for,
+    collect_site_files,
+    select_host_config,
+)
+
+LIVE_RELOAD_ENDPOINT = "/__livereload"
+LIVE_RELOAD_SCRIPT = (
+    "<script>"
+    f'new EventSource("{LIVE_RELOAD_ENDPOINT}")'
+    '.addEventListener("reload", () => location.reload());'
+    "</script>"
+)
+REBUILD_PATHS = (
+    "templates",
+    "data",
+    os.path.join("public", "locales"),
+    os.path.join("public", "config.json"),
+)
+RELOAD_PATHS = (os.path.join("public", "style.css"),)
+KEEPALIVE_SECONDS = 15.0
+DEV_CERT_DIR = ".devcerts"
+DEV_CERT_HOSTS = ("localhost", "127.0.0.1", "::1")
+# Pinning HTTPS for localhost in the browser would break every other local
+# server, so the production HSTS header is never sent by the dev server.
+DEV_SKIPPED_HEADERS = {"strict-transport-security"}
+# The one dot-directory a site publishes (RFC 8615, e.g., security.txt).
+WELL_KNOWN_DIRECTORY = ".well-known"
+
+_CSP_META_RE = re.compile(
+    r"""<meta\s[^>]*http-equiv=["']Content-Security-Policy["'][^>]*>\s*""",
+    re.IGNORECASE,
+)
+_BODY_END_RE = re.compile(r"</body\s*>", re.IGNORECASE)
+
+
+def inject_live_reload(html: str) -> str:
+    """Adds the live reload script to a page and drops its CSP meta tag."""
+    html = _CSP_META_RE.sub("", html)
+    body_ends = list(_BODY_END_RE.finditer(html))
+    if not body_ends:
+        return html + LIVE_RELOAD_SCRIPT
+    position = body_ends[-1].start()
+    return html[:position] + LIVE_RELOAD_SCRIPT + html[position:]
+
+
+def snapshot_mtimes(paths: Iterable[str]) -> Dict[str, float]:
+    """Returns the modification time of every file under the given paths."""
+    mtimes: Dict[str, float] = {}
+    for path in paths:
+        if os.path.isfile(path):
+            mtimes[path] = os.path.getmtime(path)
+            continue
+        for directory, _, filenames in os.walk(path):
+            for filename in filenames:
+                file_path = os.path.join(directory, filename)
+                try:
+                    mtimes[file_path] = os.path.getmtime(file_path)
+                except OSError:
+                    continue  # Deleted while walking.
+    return mtimes
+
+
+def served_site_files(written_files: Iterable[str]) -> FrozenSet[str]:
+    """Returns the site paths of the built files the dev server serves.
+
+    Host configuration files (e.g., `_headers` with a staging password) are
+    left out, as no host serves them as files either.
+    """
+    files = collect_site_files(written_files, include=[], exclude=DEFAULT_EXCLUDE)
+    return frozenset(select_host_config(files, {}, ()))
+
+
+def is_served(
+    site_path: str,
+    served_files: FrozenSet[str],
+    static_paths: Iterable[str] = DEFAULT_INCLUDE,
+) -> bool:
+    """Whether the dev server serves a "/"-separated path below its root.
+
+    Args:
+        site_path: The requested file, relative to the served directory.
+        served_files: The site paths of the files the build wrote.
+        static_paths: Directories (or files) served as they are.
+    """
+    parts = site_path.split("/")
+    if site_path.startswith("../") or site_path == "..":
+        return False
+    if any(part.startswith(".") for part in parts[1:]):
+        return False
+    if parts[0].startswith("."):
+        return parts[0] == WELL_KNOWN_DIRECTORY and site_path in served_files
+    if site_path in served_files:
+        return True
+    if any(fnmatch.fnmatch(site_path, pattern) for pattern in DEFAULT_EXCLUDE):
+        return False
+    return any(
+        site_path == static or site_path.startswith(static + "/")
+        for static in static_paths
+    )
+
+
+class SourceWatcher:
+    """Detects added, modified and deleted files by polling their mtimes."""
+
+    def __init__(self, paths: Iterable[str]):
+        self.paths = list(paths)
+        self._mtimes = snapshot_mtimes(self.paths)
+
+    def changed(self) -> List[str]:
+        """Returns the files changed since the previous call, sorted."""
+        current = snapshot_mtimes(self.paths)
+        changes = {
+            path
+            for path in set(current) | set(self._mtimes)
+            if current.get(path) != self._mtimes.get(path)
+        }
+        self._mtimes = current
+        return sorted(changes)
+
+
+class LiveReloadState:
+    """A version counter that reload streams wait on."""
+
+    def __init__(self) -> None:
+        self.version = 0
+        self._condition = threading.Condition()
+
+    def notify(self) -> None:
+        """Announces a new version to every waiting stream."""
+        with self._condition:
+            self.version += 1
+            self._condition.notify_all()
+
+    def wait_for_change(self, version: int, timeout: float) -> int:
+        """Blocks until the version differs from `version` or time runs out."""
+        with self._condition:
+            self._condition.wait_for(lambda: self.version != version, timeout)
+            return self.version
+
+
+def create_dev_certificate(
+    cert_dir: str = DEV_CERT_DIR, hosts: Tuple[str, ...] = DEV_CERT_HOSTS
+) -> Tuple[str, str]:
+    """Returns a certificate and key for local HTTPS, creating them once.
+
+    Uses `mkcert` when installed, otherwise `openssl` (self-signed).
+
+    Returns:
+        The paths of the certificate and private key files (PEM).
+
+    Raises:
+        RuntimeError: If neither tool is available or creating them failed.
+    """
+    cert_file = os.path.join(cert_dir, "localhost.pem")
+    key_file = os.path.join(cert_dir, "localhost-key.pem")
+    if os.path.isfile(cert_file) and os.path.isfile(key_file):
+        return cert_file, key_file
+    os.makedirs(cert_dir, exist_ok=True)
+    if shutil.which("mkcert"):
+        command = ["mkcert", "-cert-file", cert_file, "-key-file", key_file, *hosts]
+    elif shutil.which("openssl"):
+        alt_names = ",".join(
+            f"{'IP' if ':' in host or host[0].isdigit() else 'DNS'}:{host}"
+            for host in hosts
+        )
+        command = "openssl req -x509 -newkey rsa:2048 -nodes -days 365".split() + [
+            "-subj",
+            f"/CN={hosts[0]}",
+            "-addext",
+            f"subjectAltName={alt_names}",
+            "-keyout",
+            key_file,
+            "-out",
+            cert_file,
+        ]
+    else:
+        raise RuntimeError("Install mkcert or openssl, or pass --cert and --key.")
+    result = subprocess.run(command, capture_output=True, text=True, check=False)
+    if result.returncode != 0:
+        raise RuntimeError(f"Creating a certificate failed: {result.stderr.strip()}")
+    print(f"Created a certificate for {', '.join(hosts)} in {cert_dir}/")
+    return cert_file, key_file
+
+
+def create_ssl_context(cert_file: str, key_file: str) -> ssl.SSLContext:
+    """Returns a server TLS context for a certificate and its key."""
+    context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+    context.minimum_version = ssl.TLSVersion.TLSv1_2
+    context.load_cert_chain(cert_file, key_file)
+    context.set_alpn_protocols(["http/1.1"])
+    return context
+
+
+class DevRequestHandler(SimpleHTTPRequestHandler):
+    """Serves files with live reload injected into HTML.
+
+    Responses are not cached unless `cache_control` holds production
+    `Cache-Control` settings; `response_headers` are added to every one.
+    """
+
+    reload_state: LiveReloadState = LiveReloadState()
+    response_headers: Dict[str, str] = {}
+    cache_control: Optional[Dict[str, str]] = None
+    basic_auth: Optional[Tuple[str, str]] = None
+    """The username and password required for every request, if any."""
+    served_files: FrozenSet[str] = frozenset()
+    """The site paths of the files the last build wrote (see `is_served`)."""
+
+    def _served_path(self) -> Optional[str]:
+        """Returns the file a request asks for, or None if it is not served.
+
+        A directory stands for its `index.html`, so none is ever listed.
+        """
+        path = self.translate_path(self.path)
+        if os.path.isdir(path):
+            path = os.path.join(path, "index.html")
+        site_path = os.path.relpath(path, self.directory).replace(os.sep, "/")
+        return path if is_served(site_path, self.served_files) else None
+
+    def send_head(self) -> Any:
+        path = self._served_path()
+        if path is None or not os.path.isfile(path):
+            self.send_error(404)
+            return None
+        return super().send_head()
+
+    def _authorized(self) -> bool:
+        """Checks the request's credentials, answering 401 if they are wrong."""
+        if self.basic_auth is None:
+            return True
+        expected = base64.b64encode(":".join(self.basic_auth).encode("utf-8"))
+        received = self.headers.get("Authorization", "").encode("utf-8")
+        if hmac.compare_digest(received, b"Basic " + expected):
+            return True
+        self.send_response(401)
+        self.send_header("WWW-Authenticate", 'Basic realm="Staging", charset="UTF-8"')
+        self.send_header("Content-Length", "0")
+        self.end_headers()
+        return False
+
+    def do_HEAD(self) -> None:  # noqa: N802  (http.server naming)
+        if self._authorized():
+            super().do_HEAD()
+
+    def end_headers(self) -> None:
+        if self.cache_control is None or self.path == LIVE_RELOAD_ENDPOINT:
+            self.send_header("Cache-Control", "no-store")
+        else:
+            path = self.path.split("?", 1)[0]
+            if path.endswith("/"):
+                path += "index.html"
+            self.send_header(
+                "Cache-Control", cache_control_for(path, self.cache_control)
+            )
+        for name, value in self.response_headers.items():
+            if name.lower() not in DEV_SKIPPED_HEADERS:
+                self.send_header(name, value)
+        super().end_headers()
+
+    def do_GET(self) -> None:  # noqa: N802  (http.server naming)
+        if not self._authorized():
+            return
+        if self.path == LIVE_RELOAD_ENDPOINT:
+            self._stream_reload_events()
+            return
+        path = self._served_path()
+        if path is None or not path.endswith(".html") or not os.path.isfile(path):
+            super().do_GET()
+            return
+        with open(path, "r", encoding="utf-8") as page_file:
+            body = inject_live_reload(page_file.read()).encode("utf-8")
+        self.send_response(200)
+        self.send_header("Content-Type", "text/html; charset=utf-8")
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+    def _stream_reload_events(self) -> None:
+        """Sends a `reload` event whenever the reload state changes."""
+        self.send_response(200)
+        self.send_header("Content-Type", "text/event-stream")
+        self.end_headers()
+        version = self.reload_state.version
+        try:
+            while True:
+                current = self.reload_state.wait_for_change(version, KEEPALIVE_SECONDS)
+                if current == version:
+                    self.wfile.write(b": keepalive\n\n")
+                else:
+                    version = current
+                    self.wfile.write(f"event: reload\ndata: {version}\n\n".encode())
+                self.wfile.flush()
+        except (BrokenPipeError, ConnectionResetError):
+            return  # The page was closed or reloaded.
+
+
+def _matches(path: str, prefixes: Tuple[str, ...]) -> bool:
+    """Returns whether a file lies under one of the given paths."""
+    return any(
+        path == prefix or path.startswith(prefix + os.sep) for prefix in prefixes
+    )
+
+
+def serve(
+    build: Callable[[], Iterable[str]],
+    host: str = "127.0.0.1",
+    port: int = 8000,
+    interval: float = 0.5,
+    directory: Optional[str] = None,
+    ssl_context: Optional[ssl.SSLContext] = None,
+    response_headers: Optional[Dict[str, str]] = None,
+    cache_control: Optional[Dict[str, str]] = None,
+    basic_auth: Optional[Tuple[str, str]] = None,
+    on_change: Optional[Callable[[List[str]], Any]] = None,
+) -> None:
+    """Builds the site, serves it and rebuilds on changes until interrupted.
+
+    Args:
+        build: Runs a full build and returns the files it wrote. Errors are
+            printed and do not stop serving; the files of the previous
+            build stay served.
+        host: The interface to listen on.
+        port: The port to listen on.
+        interval: Seconds between two polls of the watched files.
+        directory: The directory the build writes to; defaults to the
+            working directory.
+        ssl_context: Serves over HTTPS with this TLS context.
+        response_headers: Headers added to every response.
+        cache_control: Production `Cache-Control` settings; None disables
+            caching.
+        basic_auth: The username and password every request must send.
+        on_change: Called with the changed files before rebuilding, e.g. to
+            invalidate cached templates.
+    """
+
+    reload_state = LiveReloadState()
+    handler = type(
+        "BoundDevRequestHandler",
+        (DevRequestHandler,),
+        {
+            "reload_state": reload_state,
+            "response_headers": response_headers or {},
+            "cache_control": cache_control,
+            "basic_auth": basic_auth,
+        },
+    )
+
+    def rebuild() -> None:
+        try:
+            handler.served_files = served_site_files(build())
+        except Exception as e:  # pylint: disable=broad-except
+            print(f"Error: Build failed: {e}")
+
+    rebuild()
+    server = ThreadingHTTPServer(
+        (host, port),
+        lambda *args: handler(*args, directory=directory or os.getcwd()),
+    )
+    server.daemon_threads = True
+    scheme = "http"
+    if ssl_context is not None:
+        # The handshake then runs in each request's thread, not in accept().
+        server.socket = ssl_context.wrap_socket(
+            server.socket, server_side=True, do_handshake_on_connect=False
+        )
+        scheme = "https"
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    print(f"Serving on {scheme}://{host}:{port}/ (Ctrl+C to stop)")
+
+    watcher = SourceWatcher(REBUILD_PATHS + RELOAD_PATHS)
+    try:
+        while True:
+            time.sleep(interval)
+            changes = watcher.changed()
+            if not changes:
+                continue
+            print(f"Changed: {', '.join(changes)}")
+            if on_change is not None:
+                on_change(changes)
+            if any(_matches(path, REBUILD_PATHS) for path in changes):
+                rebuild()
+                watcher.changed()  # Skip changes made by the build itself.
+            reload_state.notify()
+    except KeyboardInterrupt:
+        print("Stopping server.")
+    finally:
+        server.shutdown()
+        server.server_close()
@@ -0,0 +1,197 @@
+"""
+Splits traffic between the A/B variant pages at the edge.
+
+`--variants all` builds a page per block variant next to each page it varies
+(see `variants.py`). With the `edge_split` section of `public/config.json`
+enabled, the build also writes the code that serves them: a visitor's first
+request to a varied page picks a variant at random, weighted by `weights`
+(per variation ID, default 1 each), and a `cookie` keeps them on it for
+`cookie_max_age_days`:
+
+    "edge_split": {
+      "enabled": true,
+      "formats": ["netlify-edge", "cloudflare"],
+      "cookie": "ab_variant",
+      "cookie_max_age_days": 30,
+      "weights": { "a": 50, "b": 50 }
+    }
+
+- `netlify-edge`: a Netlify Edge Function, `netlify/edge-functions/ab-split.js`,
+  that rewrites requests to the picked variant page. Netlify deploys edge
+  functions from the repository, so commit it (or run `netlify deploy`);
+  `deploy netlify` uploads site files only and never publishes it.
+- `cloudflare`: a Cloudflare Pages `_worker.js` that serves the picked
+  variant page and every other request from the site's assets. It is
+  published by `deploy cloudflare` only.
+
+The variant pages come from the same record the build manifest lists under
+`variants`. Without `--variants all` there are none, and nothing is written.
+"""
+
+import json
+import logging
+import os
+from typing import Any, Dict, List
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import site_base_path
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_COOKIE = "ab_variant"
+DEFAULT_COOKIE_MAX_AGE_DAYS = 30
+# "netlify-edge" is not a host format: Netlify deploys edge functions from
+# the repository, so deploys never publish the function as a site file.
+EDGE_SPLIT_FILENAMES: Dict[str, str] = {
+    "netlify-edge": "netlify/edge-functions/ab-split.js",
+    "cloudflare": "_worker.js",
+}
+
+_PICK_VARIANT_JS = """\
+function pickVariant(variants) {
+  const ids = Object.keys(variants);
+  const weight = (id) => SPLIT.weights[id] ?? 1;
+  let roll = Math.random() * ids.reduce((sum, id) => sum + weight(id), 0);
+  for (const id of ids) {
+    roll -= weight(id);
+    if (roll < 0) return id;
+  }
+  return ids[ids.length - 1];
+}
+"""
+
+NETLIFY_EDGE_FUNCTION = """\
+// Generated by the edge_split section of public/config.json; do not edit.
+const SPLIT = {split};
+
+{pick_variant}
+export default async (request, context) => {{
+  const variants = SPLIT.routes[new URL(request.url).pathname];
+  if (!variants) return;
+  let id = context.cookies.get(SPLIT.cookie);
+  if (!Object.hasOwn(variants, id)) {{
+    id = pickVariant(variants);
+    context.cookies.set({{
+      name: SPLIT.cookie,
+      value: id,
+      path: "/",
+      maxAge: SPLIT.maxAge,
+      sameSite: "Lax",
+    }});
+  }}
+  return new URL(variants[id], request.url);
+}};
+
+export const config = {{ path: {paths} }};
+"""
+
+CLOUDFLARE_WORKER = """\
+// Generated by the edge_split section of public/config.json; do not edit.
+const SPLIT = {split};
+
+{pick_variant}
+function readCookie(request, name) {{
+  for (const pair of (request.headers.get("Cookie") || "").split(/;\\s*/)) {{
+    if (pair.startsWith(name + "=")) return pair.slice(name.length + 1);
+  }}
+  return null;
+}}
+
+export default {{
+  async fetch(request, env) {{
+    const url = new URL(request.url);
+    const variants = SPLIT.routes[url.pathname];
+    if (!variants) return env.ASSETS.fetch(request);
+    let id = readCookie(request, SPLIT.cookie);
+    const assigned = Object.hasOwn(variants, id);
+    if (!assigned) id = pickVariant(variants);
+    // Pages serves "page.html" as "page" and redirects the former.
+    const asset = await env.ASSETS.fetch(
+      new URL(variants[id].replace(/\\.html$/, ""), url),
+    );
+    if (assigned) return asset;
+    const response = new Response(asset.body, asset);
+    response.headers.append(
+      "Set-Cookie",
+      `${{SPLIT.cookie}}=${{id}}; Path=/; Max-Age=${{SPLIT.maxAge}}; SameSite=Lax`,
+    );
+    return response;
+  }},
+}};
+"""
+
+EDGE_SPLIT_TEMPLATES: Dict[str, str] = {
+    "netlify-edge": NETLIFY_EDGE_FUNCTION,
+    "cloudflare": CLOUDFLARE_WORKER,
+}
+
+
+def page_paths(page_file: str, base_path: str) -> List[str]:
+    """Returns the URL paths a page is requested under.
+
+    E.g., ("index_es.html", "/") -> ["/index_es.html", "/index_es"], and the
+    site root as well for "index.html".
+    """
+    stem = os.path.splitext(page_file)[0]
+    paths = [f"{base_path}{page_file}", f"{base_path}{stem}"]
+    if page_file == "index.html":
+        paths.insert(0, base_path)
+    return paths
+
+
+def split_routes(
+    variant_pages: Dict[str, Dict[str, str]], base_path: str
+) -> Dict[str, Dict[str, str]]:
+    """Maps each varied page's URL paths to its variant pages' paths.
+
+    Args:
+        variant_pages: Per page, the page built for each variation ID.
+        base_path: The path the site is served under, e.g. "/".
+    """
+    routes: Dict[str, Dict[str, str]] = {}
+    for page_file, variants in sorted(variant_pages.items()):
+        targets = {
+            variation_id: f"{base_path}{variant_file}"
+            for variation_id, variant_file in sorted(variants.items())
+        }
+        for path in page_paths(page_file, base_path):
+            routes[path] = targets
+    return routes
+
+
+@register_artifact_generator("edge_split")
+class EdgeSplitGenerator(BaseArtifactGenerator):
+    """Writes edge code that splits traffic between variant pages."""
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates the edge code per configured format."""
+        settings = build_context.app_config.get("edge_split", {})
+        if not settings.get("enabled", False):
+            return {}
+        if not build_context.variant_pages:
+            logger.info("No variant pages to split traffic between.")
+            return {}
+
+        routes = split_routes(
+            build_context.variant_pages, site_base_path(build_context.app_config)
+        )
+        split: Dict[str, Any] = {
+            "cookie": settings.get("cookie", DEFAULT_COOKIE),
+            "maxAge": 86400
+            * settings.get("cookie_max_age_days", DEFAULT_COOKIE_MAX_AGE_DAYS),
+            "weights": settings.get("weights", {}),
+            "routes": routes,
+        }
+        artifacts: Dict[str, str] = {}
+        for edge_format in settings.get("formats", list(EDGE_SPLIT_TEMPLATES)):
+            template = EDGE_SPLIT_TEMPLATES.get(edge_format)
+            if template is None:
+                logger.warning("Unknown edge_split format '%s'. Skipping.", edge_format)
+                continue
+            artifacts[EDGE_SPLIT_FILENAMES[edge_format]] = template.format(
+                split=json.dumps(split, indent=2),
+                pick_variant=_PICK_VARIANT_JS,
+                paths=json.dumps(list(routes)),
+            )
+        return artifacts
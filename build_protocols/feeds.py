@@ -0,0 +1,355 @@
+"""
+Generates RSS 2.0, Atom and JSON Feed files for blog posts.
+
+The `FeedArtifactGenerator` reads the blog block's `BlogPost` data and writes
+one feed per configured format and supported language (e.g., `feed.xml`,
+`atom_es.xml`, `feed.json`). It also contributes `<link rel="alternate">`
+entries to every page so feed readers can discover the feeds.
+
+Feeds are configured by the `feeds` section of `public/config.json` and need
+the top-level `base_url`, since feed links must be absolute:
+
+    "base_url": "https://example.com/",
+    "feeds": {
+      "enabled": true,
+      "block": "blog.html",
+      "formats": ["rss", "atom", "json"],
+      "title_key": "blog_title",
+      "description_key": "blog_feed_description"
+    }
+"""
+
+import json
+import logging
+import mimetypes
+import os
+import xml.etree.ElementTree as ET
+from datetime import datetime, timezone
+from email.utils import format_datetime
+from typing import Any, Dict, List, NamedTuple, Optional
+from urllib.parse import urljoin
+
+from generated.blog_post_pb2 import BlogPost
+
+from .interfaces import BuildContext, Translations
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import absolute_url, localized_filename, page_url
+
+logger = logging.getLogger(__name__)
+
+ATOM_NAMESPACE = "http://www.w3.org/2005/Atom"
+JSON_FEED_VERSION = "https://jsonfeed.org/version/1.1"
+
+
+class FeedFormat(NamedTuple):
+    """Naming and MIME type of one feed format."""
+
+    stem: str
+    extension: str
+    mime_type: str
+
+
+FEED_FORMATS: Dict[str, FeedFormat] = {
+    "rss": FeedFormat("feed", ".xml", "application/rss+xml"),
+    "atom": FeedFormat("atom", ".xml", "application/atom+xml"),
+    "json": FeedFormat("feed", ".json", "application/feed+json"),
+}
+
+
+class FeedEntry(NamedTuple):
+    """A blog post resolved for one language, ready to be serialized."""
+
+    url: str
+    title: str
+    summary: str
+    published: Optional[datetime]
+    image_url: str
+    image_type: str
+    image_length: int
+
+
+def parse_published_date(value: str) -> Optional[datetime]:
+    """Parses an ISO 8601 date or datetime string into an aware datetime.
+
+    Args:
+        value: A value such as "2024-05-01" or "2024-05-01T10:00:00Z".
+            Values without a timezone are assumed to be UTC.
+
+    Returns:
+        The parsed datetime, or None if `value` is empty or malformed.
+    """
+    if not value:
+        return None
+    try:
+        parsed = datetime.fromisoformat(value.replace("Z", "+00:00"))
+    except ValueError:
+        logger.warning("Invalid published_date '%s' in blog post.", value)
+        return None
+    if parsed.tzinfo is None:
+        parsed = parsed.replace(tzinfo=timezone.utc)
+    return parsed
+
+
+@register_artifact_generator("feeds")
+class FeedArtifactGenerator(BaseArtifactGenerator):
+    """Generates per-language RSS, Atom and JSON feeds from blog posts."""
+
+    def _get_settings(self, app_config: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+        """Returns the feeds config section if feeds are enabled, else None."""
+        settings = app_config.get("feeds", {})
+        if not settings.get("enabled", False):
+            return None
+        if not app_config.get("base_url"):
+            return None
+        return dict(settings)
+
+    def _get_formats(self, settings: Dict[str, Any]) -> List[str]:
+        """Returns the configured, known feed formats in config order."""
+        formats: List[str] = []
+        for feed_format in settings.get("formats", list(FEED_FORMATS)):
+            if feed_format in FEED_FORMATS:
+                formats.append(feed_format)
+            else:
+                logger.warning("Unknown feed format '%s'. Skipping.", feed_format)
+        return formats
+
+    def _feed_path(self, feed_format: str, lang: str, default_lang: str) -> str:
+        """Returns the output path of a feed relative to the site root."""
+        spec = FEED_FORMATS[feed_format]
+        return localized_filename(spec.stem, spec.extension, lang, default_lang)
+
+    def _feed_title(self, settings: Dict[str, Any], translations: Translations) -> str:
+        """Returns the translated feed title."""
+        title_key = settings.get("title_key", "blog_title")
+        return translations.get(title_key, translations.get("page_title_default", ""))
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Adds a `<link rel="alternate">` entry per feed of the page language."""
+        settings = self._get_settings(build_context.app_config)
+        if settings is None:
+            return {}
+
+        base_url: str = build_context.app_config["base_url"]
+        translations = build_context.translations_by_lang.get(lang, {})
+        title = self._feed_title(settings, translations)
+        links = [
+            {
+                "rel": "alternate",
+                "type": FEED_FORMATS[feed_format].mime_type,
+                "href": absolute_url(
+                    base_url,
+                    self._feed_path(feed_format, lang, build_context.default_lang),
+                ),
+                "title": title,
+            }
+            for feed_format in self._get_formats(settings)
+        ]
+        return {"alternate_links": links}
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates every configured feed for every supported language."""
+        app_config = build_context.app_config
+        settings = self._get_settings(app_config)
+        if settings is None:
+            if app_config.get("feeds", {}).get("enabled", False):
+                logger.warning("Feeds are enabled but 'base_url' is not set. Skipping.")
+            return {}
+
+        posts: List[BlogPost] = (
+            build_context.block_data.get(settings.get("block", "blog.html")) or []
+        )
+        base_url: str = app_config["base_url"]
+        artifacts: Dict[str, str] = {}
+
+        for lang in build_context.supported_langs:
+            translations = build_context.translations_by_lang.get(lang, {})
+            lang_page_url = page_url(base_url, lang, build_context.default_lang)
+            entries = [
+                self._build_entry(post, lang_page_url, base_url, translations)
+                for post in posts
+            ]
+            # Newest first; undated posts keep their data file order at the end.
+            entries.sort(
+                key=lambda entry: (
+                    entry.published or datetime.min.replace(tzinfo=timezone.utc)
+                ),
+                reverse=True,
+            )
+            feed_info = {
+                "title": self._feed_title(settings, translations),
+                "description": translations.get(
+                    settings.get("description_key", ""), ""
+                ),
+                "page_url": lang_page_url,
+                "lang": lang,
+            }
+
+            for feed_format in self._get_formats(settings):
+                path = self._feed_path(feed_format, lang, build_context.default_lang)
+                feed_url = absolute_url(base_url, path)
+                if feed_format == "rss":
+                    artifacts[path] = self._render_rss(feed_info, feed_url, entries)
+                elif feed_format == "atom":
+                    artifacts[path] = self._render_atom(feed_info, feed_url, entries)
+                else:
+                    artifacts[path] = self._render_json_feed(
+                        feed_info, feed_url, entries
+                    )
+        return artifacts
+
+    def _build_entry(
+        self,
+        post: BlogPost,
+        lang_page_url: str,
+        base_url: str,
+        translations: Translations,
+    ) -> FeedEntry:
+        """Resolves a BlogPost into a translated, absolute-URL feed entry."""
+        url = urljoin(lang_page_url, post.cta.uri) if post.cta.uri else lang_page_url
+        image_url, image_type, image_length = "", "", 0
+        image_src = post.image.src
+        # Inline data URIs cannot be referenced from a feed.
+        if image_src and not image_src.startswith("data:"):
+            image_url = absolute_url(base_url, image_src)
+            image_type = mimetypes.guess_type(image_src)[0] or "image/jpeg"
+            local_path = image_src.lstrip("/")
+            if os.path.isfile(local_path):
+                image_length = os.path.getsize(local_path)
+
+        return FeedEntry(
+            url=url,
+            title=translations.get(post.title.key, post.title.key),
+            summary=translations.get(post.excerpt.key, post.excerpt.key),
+            published=parse_published_date(post.published_date),
+            image_url=image_url,
+            image_type=image_type,
+            image_length=image_length,
+        )
+
+    def _updated(self, entries: List[FeedEntry]) -> datetime:
+        """Returns the newest entry date, or the current time if none is dated."""
+        dates = [entry.published for entry in entries if entry.published]
+        return max(dates) if dates else datetime.now(timezone.utc)
+
+    def _serialize_xml(self, root: ET.Element) -> str:
+        """Serializes an element tree as a UTF-8 XML document string."""
+        ET.indent(root)
+        body = ET.tostring(root, encoding="unicode")
+        return f'<?xml version="1.0" encoding="utf-8"?>\n{body}\n'
+
+    def _render_rss(
+        self, feed_info: Dict[str, str], feed_url: str, entries: List[FeedEntry]
+    ) -> str:
+        """Renders an RSS 2.0 document."""
+        rss = ET.Element("rss", {"version": "2.0", "xmlns:atom": ATOM_NAMESPACE})
+        channel = ET.SubElement(rss, "channel")
+        ET.SubElement(channel, "title").text = feed_info["title"]
+        ET.SubElement(channel, "link").text = feed_info["page_url"]
+        ET.SubElement(channel, "description").text = feed_info["description"]
+        ET.SubElement(channel, "language").text = feed_info["lang"]
+        ET.SubElement(channel, "lastBuildDate").text = format_datetime(
+            self._updated(entries)
+        )
+        ET.SubElement(
+            channel,
+            "atom:link",
+            {"href": feed_url, "rel": "self", "type": FEED_FORMATS["rss"].mime_type},
+        )
+
+        for entry in entries:
+            item = ET.SubElement(channel, "item")
+            ET.SubElement(item, "title").text = entry.title
+            ET.SubElement(item, "link").text = entry.url
+            ET.SubElement(item, "guid", {"isPermaLink": "true"}).text = entry.url
+            ET.SubElement(item, "description").text = entry.summary
+            if entry.published:
+                ET.SubElement(item, "pubDate").text = format_datetime(entry.published)
+            if entry.image_url:
+                ET.SubElement(
+                    item,
+                    "enclosure",
+                    {
+                        "url": entry.image_url,
+                        "length": str(entry.image_length),
+                        "type": entry.image_type,
+                    },
+                )
+        return self._serialize_xml(rss)
+
+    def _render_atom(
+        self, feed_info: Dict[str, str], feed_url: str, entries: List[FeedEntry]
+    ) -> str:
+        """Renders an Atom 1.0 document."""
+        feed = ET.Element("feed", {"xmlns": ATOM_NAMESPACE, "xml:lang": feed_info["lang"]})
+        ET.SubElement(feed, "title").text = feed_info["title"]
+        if feed_info["description"]:
+            ET.SubElement(feed, "subtitle").text = feed_info["description"]
+        ET.SubElement(feed, "id").text = feed_url
+        ET.SubElement(feed, "updated").text = self._updated(entries).isoformat()
+        ET.SubElement(feed, "link", {"href": feed_url, "rel": "self"})
+        ET.SubElement(feed, "link", {"href": feed_info["page_url"]})
+
+        for entry in entries:
+            atom_entry = ET.SubElement(feed, "entry")
+            ET.SubElement(atom_entry, "title").text = entry.title
+            ET.SubElement(atom_entry, "id").text = entry.url
+            ET.SubElement(atom_entry, "link", {"href": entry.url})
+            updated = entry.published or self._updated(entries)
+            ET.SubElement(atom_entry, "updated").text = updated.isoformat()
+            if entry.published:
+                ET.SubElement(atom_entry, "published").text = (
+                    entry.published.isoformat()
+                )
+            ET.SubElement(atom_entry, "summary").text = entry.summary
+            if entry.image_url:
+                ET.SubElement(
+                    atom_entry,
+                    "link",
+                    {
+                        "rel": "enclosure",
+                        "href": entry.image_url,
+                        "type": entry.image_type,
+                        "length": str(entry.image_length),
+                    },
+                )
+        return self._serialize_xml(feed)
+
+    def _render_json_feed(
+        self, feed_info: Dict[str, str], feed_url: str, entries: List[FeedEntry]
+    ) -> str:
+        """Renders a JSON Feed 1.1 document."""
+        items: List[Dict[str, Any]] = []
+        for entry in entries:
+            item: Dict[str, Any] = {
+                "id": entry.url,
+                "url": entry.url,
+                "title": entry.title,
+                "summary": entry.summary,
+                "content_text": entry.summary,
+            }
+            if entry.published:
+                item["date_published"] = entry.published.isoformat()
+            if entry.image_url:
+                item["image"] = entry.image_url
+                item["attachments"] = [
+                    {
+                        "url": entry.image_url,
+                        "mime_type": entry.image_type,
+                        "size_in_bytes": entry.image_length,
+                    }
+                ]
+            items.append(item)
+
+        feed: Dict[str, Any] = {
+            "version": JSON_FEED_VERSION,
+            "title": feed_info["title"],
+            "home_page_url": feed_info["page_url"],
+            "feed_url": feed_url,
+            "language": feed_info["lang"],
+            "items": items,
+        }
+        if feed_info["description"]:
+            feed["description"] = feed_info["description"]
+        return json.dumps(feed, indent=2, ensure_ascii=False) + "\n"
@@ -0,0 +1,174 @@
+"""
+Delivers form submissions by email over SMTP.
+
+The `email` form sink sends every submission to the configured recipients.
+It is configured by `backend.form_sinks.email` in `public/config.json`:
+
+    "email": {
+      "host": "smtp.example.com",
+      "port": 587,
+      "security": "starttls",
+      "username": "forms@example.com",
+      "password_env": "SMTP_PASSWORD",
+      "from": "Landing Page <forms@example.com>",
+      "to": ["owner@example.com"],
+      "subject": {
+        "en": "New message from {name}",
+        "es": "Nuevo mensaje de {name}"
+      },
+      "body_template": "email/form-submission.txt",
+      "retries": 2,
+      "retry_delay": 2.0
+    }
+
+`security` is "starttls" (default), "ssl" (implicit TLS, usually port 465)
+or "none". The password is read from the environment variable named by
+`password_env`, so it never has to be committed. The submitter's address
+(the `email` field, if any) becomes the Reply-To.
+
+The subject and body are chosen by the submission's language: `subject`
+maps languages to a format string filled with the submitted fields, and the
+body template is looked up as `{name}_{lang}.txt` before `{name}.txt` under
+`templates/`, the same way localized pages are named.
+"""
+
+import logging
+import os
+import smtplib
+import ssl
+import time
+from email.message import EmailMessage
+from email.utils import formatdate, make_msgid
+from typing import Any, Dict, List
+
+from jinja2 import Environment, FileSystemLoader
+
+from .forms import deliver_with_retries, register_form_sink
+from .interfaces import FormSink, FormSubmission
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_SUBJECT = "New {form} form submission"
+DEFAULT_BODY_TEMPLATE = "email/form-submission.txt"
+SECURITY_MODES = ("starttls", "ssl", "none")
+
+
+class _FieldDefaults(dict):
+    """Format mapping that leaves unknown placeholders empty."""
+
+    def __missing__(self, key: str) -> str:
+        return ""
+
+
+@register_form_sink("email")
+class EmailFormSink(FormSink):
+    """Sends each submission as a plain text email."""
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.security = settings.get("security", "starttls")
+        if self.security not in SECURITY_MODES:
+            raise ValueError(
+                f"Unknown SMTP security '{self.security}'; use one of "
+                f"{', '.join(SECURITY_MODES)}."
+            )
+        self.recipients: List[str] = list(settings.get("to", []))
+        if not settings.get("host") or not self.recipients:
+            raise ValueError("The email form sink needs a 'host' and 'to' addresses.")
+        self.jinja_env = Environment(
+            loader=FileSystemLoader(settings.get("templates_dir", "templates")),
+            autoescape=False,  # Plain text mail
+        )
+
+    def send(self, submission: FormSubmission) -> None:
+        """Sends the submission, retrying transient failures.
+
+        Raises:
+            smtplib.SMTPException, OSError: If every attempt failed.
+        """
+        message = self.build_message(submission)
+        deliver_with_retries(
+            lambda: self._deliver(message),
+            self.settings,
+            "form email",
+            retry_on=(smtplib.SMTPException, OSError),
+            # Wrong credentials will not fix themselves; don't retry them.
+            is_permanent=lambda e: isinstance(e, smtplib.SMTPAuthenticationError),
+        )
+
+    def build_message(self, submission: FormSubmission) -> EmailMessage:
+        """Renders the email for a submission in its language."""
+        fields = _FieldDefaults(submission.fields, form=submission.form)
+        subjects = self.settings.get("subject", {})
+        subject = subjects.get(submission.lang) or next(
+            iter(subjects.values()), DEFAULT_SUBJECT
+        )
+        template_name = self.settings.get("body_template", DEFAULT_BODY_TEMPLATE)
+        stem, extension = os.path.splitext(template_name)
+        template = self.jinja_env.select_template(
+            [f"{stem}_{submission.lang}{extension}", template_name]
+        )
+
+        message = EmailMessage()
+        # Submitted values may contain line breaks, which headers cannot.
+        message["Subject"] = " ".join(subject.format_map(fields).split())
+        message["From"] = self.settings.get("from") or self.settings.get(
+            "username", ""
+        )
+        message["To"] = ", ".join(self.recipients)
+        reply_to = submission.fields.get("email", "")
+        if reply_to and not any(char in reply_to for char in "\r\n"):
+            message["Reply-To"] = reply_to
+        message["Date"] = formatdate(localtime=True)
+        message["Message-ID"] = make_msgid()
+        message.set_content(template.render(submission=submission))
+        return message
+
+    def _deliver(self, message: EmailMessage) -> None:
+        """Opens an SMTP connection and sends one message."""
+        host = self.settings["host"]
+        timeout = float(self.settings.get("timeout", 10.0))
+        context = ssl.create_default_context()
+        if self.security == "ssl":
+            port = int(self.settings.get("port", 465))
+            smtp: smtplib.SMTP = smtplib.SMTP_SSL(
+                host, port, timeout=timeout, context=context
+            )
+        else:
+            port = int(self.settings.get("port", 587))
+            smtp = smtplib.SMTP(host, port, timeout=timeout)
+        with smtp:
+            if self.security == "starttls":
+                smtp.starttls(context=context)
+            username = self.settings.get("username")
+            if username:
+                password = self.settings.get("password") or os.environ.get(
+                    self.settings.get("password_env", "SMTP_PASSWORD"), ""
+                )
+                smtp.login(username, password)
+            smtp.send_message(message)
+
+
+def send_test_email(settings: Dict[str, Any], lang: str = "en") -> None:
+    """Sends a sample submission through the email sink.
+
+    Args:
+        settings: The `backend.form_sinks.email` settings.
+        lang: The language to render the sample in.
+
+    Raises:
+        ValueError: If the settings are incomplete.
+        smtplib.SMTPException, OSError: If the email could not be sent.
+    """
+    EmailFormSink(settings).send(
+        FormSubmission(
+            form="contact",
+            fields={
+                "name": "Test",
+                "email": "test@example.com",
+                "message": "This is a test message from the form backend.",
+            },
+            lang=lang,
+            submitted_at=time.strftime("%Y-%m-%dT%H:%M:%S+00:00", time.gmtime()),
+        )
+    )
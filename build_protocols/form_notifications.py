@@ -0,0 +1,176 @@
+"""
+Forwards form submissions to chat and webhook services.
+
+Three form sinks post every submission over HTTPS:
+
+- `slack`: to a Slack incoming webhook (`webhook_url`).
+- `telegram`: to a chat (`chat_id`) through a Telegram bot (`bot_token`).
+- `webhook`: the submission as JSON to any `url`, with optional extra
+  `headers` (e.g., an authorization token).
+
+List any combination of them in the `sinks` of `ContactFormConfig`; each is
+configured by its section of `backend.form_sinks` in `public/config.json`:
+
+    "slack": { "webhook_url_env": "SLACK_WEBHOOK_URL" },
+    "telegram": { "bot_token_env": "TELEGRAM_BOT_TOKEN", "chat_id": "-100123" },
+    "webhook": { "url": "https://hooks.example.com/forms", "headers": {} }
+
+Secrets can be given directly (`webhook_url`, `bot_token`) or read from the
+environment variable named by the matching `*_env` setting. Failed posts are
+retried (`retries`, `retry_delay`) unless the service rejected the request
+itself (a 4xx status other than 429), then logged by the form handler.
+"""
+
+import json
+import os
+import urllib.error
+import urllib.request
+from dataclasses import asdict
+from typing import Any, Dict, Optional, Tuple
+
+from .forms import deliver_with_retries, register_form_sink
+from .interfaces import FormSink, FormSubmission
+
+TELEGRAM_API_URL = "https://api.telegram.org/bot{token}/sendMessage"
+USER_AGENT = "landing-template-form-backend"
+
+
+def format_submission_text(submission: FormSubmission) -> str:
+    """Formats a submission as a short plain text notification."""
+    lines = [f"New {submission.form} form submission ({submission.lang})"]
+    lines.extend(f"{name}: {value}" for name, value in submission.fields.items())
+    return "\n".join(lines)
+
+
+def post_json(
+    url: str,
+    payload: Any,
+    headers: Optional[Dict[str, str]] = None,
+    timeout: float = 10.0,
+) -> Any:
+    """POSTs a JSON payload and fails unless the response is a success.
+
+    Returns:
+        The decoded JSON response, or None if it is empty or not JSON.
+
+    Raises:
+        urllib.error.HTTPError: If the service answered with an error status.
+        OSError: If the service could not be reached.
+    """
+    request = urllib.request.Request(
+        url,
+        data=json.dumps(payload, ensure_ascii=False).encode("utf-8"),
+        headers={
+            "Content-Type": "application/json; charset=utf-8",
+            "User-Agent": USER_AGENT,
+            **(headers or {}),
+        },
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=timeout) as response:
+        body = response.read()
+    try:
+        return json.loads(body.decode("utf-8")) if body else None
+    except (UnicodeDecodeError, json.JSONDecodeError):
+        return None
+
+
+def _is_rejected(error: BaseException) -> bool:
+    """Returns whether a service rejected the request itself (no retry)."""
+    return (
+        isinstance(error, urllib.error.HTTPError)
+        and 400 <= error.code < 500
+        and error.code != 429
+    )
+
+
+def _secret(settings: Dict[str, Any], name: str) -> str:
+    """Reads a secret setting, directly or from its `{name}_env` variable."""
+    return settings.get(name) or os.environ.get(settings.get(f"{name}_env", ""), "")
+
+
+class HttpFormSink(FormSink):
+    """A base class for sinks that POST JSON to an HTTP endpoint."""
+
+    description = "form notification"
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+
+    def send(self, submission: FormSubmission) -> None:
+        """Posts the submission, retrying transient failures.
+
+        Raises:
+            OSError: If every attempt failed or the service rejected it.
+        """
+        url, payload = self.build_request(submission)
+        deliver_with_retries(
+            lambda: post_json(
+                url,
+                payload,
+                self.settings.get("headers"),
+                float(self.settings.get("timeout", 10.0)),
+            ),
+            self.settings,
+            self.description,
+            is_permanent=_is_rejected,
+        )
+
+    def build_request(self, submission: FormSubmission) -> Tuple[str, Any]:
+        """Returns the URL and JSON payload to post for a submission."""
+        raise NotImplementedError
+
+
+@register_form_sink("slack")
+class SlackFormSink(HttpFormSink):
+    """Posts submissions to a Slack incoming webhook."""
+
+    description = "Slack notification"
+
+    def __init__(self, settings: Dict[str, Any]):
+        super().__init__(settings)
+        self.webhook_url = _secret(settings, "webhook_url")
+        if not self.webhook_url:
+            raise ValueError("The slack form sink needs a 'webhook_url'.")
+
+    def build_request(self, submission: FormSubmission) -> Tuple[str, Any]:
+        return self.webhook_url, {"text": format_submission_text(submission)}
+
+
+@register_form_sink("telegram")
+class TelegramFormSink(HttpFormSink):
+    """Sends submissions to a Telegram chat through a bot."""
+
+    description = "Telegram notification"
+
+    def __init__(self, settings: Dict[str, Any]):
+        super().__init__(settings)
+        self.bot_token = _secret(settings, "bot_token")
+        self.chat_id = str(settings.get("chat_id", ""))
+        if not self.bot_token or not self.chat_id:
+            raise ValueError(
+                "The telegram form sink needs a 'bot_token' and 'chat_id'."
+            )
+
+    def build_request(self, submission: FormSubmission) -> Tuple[str, Any]:
+        return TELEGRAM_API_URL.format(token=self.bot_token), {
+            "chat_id": self.chat_id,
+            "text": format_submission_text(submission),
+            "disable_web_page_preview": True,
+        }
+
+
+@register_form_sink("webhook")
+class WebhookFormSink(HttpFormSink):
+    """Posts each submission as JSON to a generic webhook."""
+
+    description = "webhook notification"
+
+    def __init__(self, settings: Dict[str, Any]):
+        super().__init__(settings)
+        self.url = _secret(settings, "url")
+        if not self.url:
+            raise ValueError("The webhook form sink needs a 'url'.")
+
+    def build_request(self, submission: FormSubmission) -> Tuple[str, Any]:
+        return self.url, asdict(submission)
@@ -0,0 +1,196 @@
+"""
+Stores form submissions in SQLite and lists them for the site's owner.
+
+The `sqlite` form sink keeps every submission in a local database, so a
+small site has a submission inbox without any external service. It is
+configured by its section of `backend.form_sinks` in `public/config.json`:
+
+    "sqlite": {
+      "path": "submissions.sqlite3",
+      "admin_token_env": "SUBMISSIONS_TOKEN"
+    }
+
+The backend then serves `GET /api/submissions` to whoever sends the token
+from the environment variable named by `admin_token_env` as
+`Authorization: Bearer <token>`; without a token the endpoint is not
+served. It answers newest first as JSON, or as a CSV file with
+`?format=csv`. `form` filters by form, `limit` (default 100, at most 1000)
+and `offset` page through the results.
+"""
+
+import csv
+import hmac
+import io
+import json
+import os
+import sqlite3
+import threading
+from contextlib import closing
+from http import HTTPStatus
+from typing import Any, Dict, List, Optional
+
+from .backend import BackendRequest, BackendResponse, json_response
+from .forms import register_form_sink
+from .interfaces import FormSink, FormSubmission
+
+SUBMISSIONS_PATH = "/api/submissions"
+DEFAULT_DATABASE = "submissions.sqlite3"
+DEFAULT_LIMIT = 100
+MAX_LIMIT = 1000
+CSV_COLUMNS = ["id", "form", "lang", "submitted_at", "remote_addr"]
+# Spreadsheets run cells starting with these as formulas.
+CSV_FORMULA_PREFIXES = ("=", "+", "-", "@", "\t", "\r")
+
+SCHEMA = """
+CREATE TABLE IF NOT EXISTS submissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    form TEXT NOT NULL,
+    lang TEXT NOT NULL,
+    submitted_at TEXT NOT NULL,
+    remote_addr TEXT NOT NULL,
+    fields TEXT NOT NULL
+)
+"""
+
+
+class SubmissionStore:
+    """A SQLite table of form submissions; safe to share between threads."""
+
+    def __init__(self, path: str = DEFAULT_DATABASE):
+        self.path = path
+        self._lock = threading.Lock()
+        directory = os.path.dirname(path)
+        if directory:
+            os.makedirs(directory, exist_ok=True)
+        with self._connect() as connection:
+            connection.execute(SCHEMA)
+
+    def _connect(self) -> sqlite3.Connection:
+        return sqlite3.connect(self.path, timeout=10.0)
+
+    def add(self, submission: FormSubmission) -> int:
+        """Stores a submission and returns its id."""
+        with self._lock, closing(self._connect()) as connection, connection:
+            cursor = connection.execute(
+                "INSERT INTO submissions"
+                " (form, lang, submitted_at, remote_addr, fields)"
+                " VALUES (?, ?, ?, ?, ?)",
+                (
+                    submission.form,
+                    submission.lang,
+                    submission.submitted_at,
+                    submission.remote_addr,
+                    json.dumps(submission.fields, ensure_ascii=False),
+                ),
+            )
+            return int(cursor.lastrowid or 0)
+
+    def list(
+        self,
+        form: Optional[str] = None,
+        limit: int = DEFAULT_LIMIT,
+        offset: int = 0,
+    ) -> List[Dict[str, Any]]:
+        """Returns stored submissions, newest first."""
+        query = "SELECT id, form, lang, submitted_at, remote_addr, fields"
+        query += " FROM submissions"
+        params: List[Any] = []
+        if form:
+            query += " WHERE form = ?"
+            params.append(form)
+        query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+        params.extend([limit, offset])
+        with closing(self._connect()) as connection:
+            rows = connection.execute(query, params).fetchall()
+        return [
+            {
+                "id": row[0],
+                "form": row[1],
+                "lang": row[2],
+                "submitted_at": row[3],
+                "remote_addr": row[4],
+                "fields": json.loads(row[5]),
+            }
+            for row in rows
+        ]
+
+
+@register_form_sink("sqlite")
+class SqliteFormSink(FormSink):
+    """Stores submissions in a SQLite database."""
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+        self.store = SubmissionStore(settings.get("path", DEFAULT_DATABASE))
+
+    def send(self, submission: FormSubmission) -> None:
+        self.store.add(submission)
+
+
+def _csv_cell(value: Any) -> str:
+    """Formats a CSV cell so spreadsheets show it as text."""
+    text = str(value)
+    return "'" + text if text.startswith(CSV_FORMULA_PREFIXES) else text
+
+
+def format_submissions_csv(submissions: List[Dict[str, Any]]) -> str:
+    """Formats submissions as CSV, one column per submitted field."""
+    field_names: List[str] = []
+    for submission in submissions:
+        for name in submission["fields"]:
+            if name not in field_names:
+                field_names.append(name)
+    output = io.StringIO()
+    writer = csv.writer(output)
+    writer.writerow(CSV_COLUMNS + field_names)
+    for submission in submissions:
+        writer.writerow(
+            [_csv_cell(submission[column]) for column in CSV_COLUMNS]
+            + [_csv_cell(submission["fields"].get(name, "")) for name in field_names]
+        )
+    return output.getvalue()
+
+
+def _int_param(query: Dict[str, str], name: str, default: int) -> int:
+    """Reads a non-negative integer query parameter."""
+    try:
+        return max(0, int(query.get(name, default)))
+    except ValueError:
+        return default
+
+
+class SubmissionsHandler:
+    """Lists stored submissions to requests carrying the admin token."""
+
+    def __init__(self, store: SubmissionStore, admin_token: str):
+        self.store = store
+        self.admin_token = admin_token
+
+    def __call__(self, request: BackendRequest) -> BackendResponse:
+        received = request.headers.get("authorization", "").encode("utf-8")
+        expected = f"Bearer {self.admin_token}".encode("utf-8")
+        if not self.admin_token or not hmac.compare_digest(received, expected):
+            response = json_response(HTTPStatus.UNAUTHORIZED, {"error": "Unauthorized"})
+            response.headers.append(("WWW-Authenticate", "Bearer"))
+            return response
+
+        submissions = self.store.list(
+            form=request.query.get("form") or None,
+            limit=min(_int_param(request.query, "limit", DEFAULT_LIMIT), MAX_LIMIT),
+            offset=_int_param(request.query, "offset", 0),
+        )
+        if request.query.get("format") == "csv":
+            return BackendResponse(
+                HTTPStatus.OK,
+                format_submissions_csv(submissions).encode("utf-8"),
+                [
+                    ("Content-Type", "text/csv; charset=utf-8"),
+                    ("Content-Disposition", 'attachment; filename="submissions.csv"'),
+                    ("Cache-Control", "no-store"),
+                ],
+            )
+        response = json_response(
+            HTTPStatus.OK, {"count": len(submissions), "submissions": submissions}
+        )
+        response.headers.append(("Cache-Control", "no-store"))
+        return response
@@ -0,0 +1,495 @@
+"""
+Receives contact form submissions in the backend (see `backend.py`).
+
+The contact form posts to `form_action_uri` from `ContactFormConfig`; pointing
+it at the backend's `/api/contact` endpoint replaces a third-party form
+service. The endpoint validates the submitted fields against the `fields`
+rules of `ContactFormConfig` and hands valid submissions to each sink listed
+in its `sinks` (e.g., "log").
+
+Responses follow what the form's script expects:
+
+- Valid: `200 {"ok": true, "message": ...}` with the translated
+  `success_message_key`.
+- Invalid: `422 {"errors": [{"field": ..., "message": ...}]}` with one
+  translated message per failed field.
+- Undeliverable (every sink failed): `502 {"errors": [{"message": ...}]}`
+  with the translated `error_message_key`.
+
+Submissions first pass the form's spam protection (see `spam_protection.py`).
+A GET to the endpoint answers `{"token": ...}`, the signed form token the
+form's script submits for the minimum submit time.
+Messages are translated into the language sent in the form's `lang` field.
+Plain HTML form posts (no script) get the same messages as a small HTML
+page with the same status and a link back to the page; after an error it
+asks the visitor to go back, where the browser kept what they entered.
+
+Sinks implement the `FormSink` protocol, live in their own modules and
+register themselves with `@register_form_sink`. Their settings come from
+`backend.form_sinks.<name>` in `public/config.json`.
+"""
+
+import html
+import logging
+import re
+import time
+from datetime import datetime, timezone
+from http import HTTPStatus
+from typing import Any, Callable, Dict, List, Optional, Tuple, Type
+
+from .backend import (
+    BackendRequest,
+    BackendResponse,
+    html_response,
+    json_response,
+    wants_json,
+)
+from .interfaces import FormSink, FormSubmission, Translations
+from .spam_protection import SpamGuard
+from .timeouts import Deadline
+
+logger = logging.getLogger(__name__)
+
+CONTACT_FORM_PATH = "/api/contact"
+DEFAULT_RETRY_DEADLINE_SECONDS = 10.0
+LANG_FIELD = "lang"
+FORMATS = {
+    "email": re.compile(r"^[^@\s]+@[^@\s]+\.[^@\s]+$"),
+    "url": re.compile(r"^https?://\S+$"),
+    "phone": re.compile(r"^\+?[0-9 ()./-]{6,}$"),
+}
+DEFAULT_ERROR_KEYS = {
+    "required": "form_error_required",
+    "invalid": "form_error_invalid",
+    "too_short": "form_error_too_short",
+    "too_long": "form_error_too_long",
+}
+DEFAULT_ERROR_MESSAGES = {
+    "form_error_required": "This field is required.",
+    "form_error_invalid": "This value is not valid.",
+    "form_error_too_short": "This value is too short.",
+    "form_error_too_long": "This value is too long.",
+    "form_error_too_fast": "Please take a moment before sending the form.",
+    "form_error_rate_limited": "Too many messages. Please try again later.",
+    "form_error_captcha": "Please complete the captcha.",
+    "form_error_go_back": (
+        "Go back with your browser to correct your entries; they are kept there."
+    ),
+    "form_back_link": "Back to the page",
+}
+
+# Registry for form sinks
+FORM_SINK_REGISTRY: Dict[str, Type[FormSink]] = {}
+
+
+def register_form_sink(
+    name: str,
+) -> Callable[[Type[FormSink]], Type[FormSink]]:
+    """
+    A decorator to register a form sink class under a name.
+    """
+
+    def decorator(cls: Type[FormSink]) -> Type[FormSink]:
+        if name in FORM_SINK_REGISTRY:
+            logger.warning("Form sink '%s' is being overridden by %s", name, cls)
+        FORM_SINK_REGISTRY[name] = cls
+        return cls
+
+    return decorator
+
+
+@register_form_sink("log")
+class LogFormSink(FormSink):
+    """Logs submissions; useful during development and as a fallback."""
+
+    def __init__(self, settings: Dict[str, Any]):
+        self.settings = settings
+
+    def send(self, submission: FormSubmission) -> None:
+        logger.info(
+            "Form '%s' submission (%s) at %s: %s",
+            submission.form,
+            submission.lang,
+            submission.submitted_at,
+            submission.fields,
+        )
+
+
+def deliver_with_retries(
+    deliver: Callable[[], None],
+    settings: Dict[str, Any],
+    description: str,
+    retry_on: Tuple[Type[BaseException], ...] = (OSError,),
+    is_permanent: Callable[[BaseException], bool] = lambda error: False,
+) -> None:
+    """Runs a sink's delivery, retrying transient failures.
+
+    Waits `retry_delay` seconds (default 2), growing linearly, between up to
+    `retries` (default 2) extra attempts, both taken from the sink settings.
+    The submitter waits for the delivery, so no retry starts that would end
+    its wait later than `retry_deadline_seconds` (default 10) after the
+    first attempt.
+
+    Args:
+        deliver: Performs one delivery attempt.
+        settings: The sink's settings.
+        description: What is being delivered, for log messages.
+        retry_on: The exceptions that may be transient.
+        is_permanent: Tells which of those will not go away on retry.
+
+    Raises:
+        The last error once every attempt failed, or a permanent error.
+    """
+    attempts = 1 + int(settings.get("retries", 2))
+    delay = float(settings.get("retry_delay", 2.0))
+    deadline = Deadline(
+        float(settings.get("retry_deadline_seconds", DEFAULT_RETRY_DEADLINE_SECONDS))
+    )
+    deadline.remaining()  # Counts from the first attempt.
+    for attempt in range(1, attempts + 1):
+        try:
+            deliver()
+            return
+        except retry_on as e:
+            if (
+                attempt == attempts
+                or is_permanent(e)
+                or (deadline.remaining() or 0.0) < delay * attempt
+            ):
+                raise
+            logger.warning(
+                "Delivering %s failed (attempt %d of %d): %s",
+                description,
+                attempt,
+                attempts,
+                e,
+            )
+            time.sleep(delay * attempt)
+
+
+def create_form_sinks(
+    names: List[str], settings: Dict[str, Any]
+) -> Dict[str, FormSink]:
+    """Instantiates the named sinks with their configured settings.
+
+    Args:
+        names: The sink names, as listed in a form's `sinks`.
+        settings: The `backend.form_sinks` section of the app configuration.
+
+    Returns:
+        The sinks by name. Unknown or misconfigured sinks are skipped and
+        logged.
+    """
+    sinks: Dict[str, FormSink] = {}
+    for name in names:
+        sink_class = FORM_SINK_REGISTRY.get(name)
+        if sink_class is None:
+            logger.warning("Unknown form sink '%s'; skipping it.", name)
+            continue
+        try:
+            sinks[name] = sink_class(settings=settings.get(name) or {})
+        except ValueError as e:
+            logger.error("Form sink '%s' is misconfigured; skipping it: %s", name, e)
+    return sinks
+
+
+def validate_submission(fields: Dict[str, str], rules: Any) -> List[Tuple[str, str]]:
+    """Checks submitted values against field rules.
+
+    Args:
+        fields: The submitted values, already stripped of surrounding spaces.
+        rules: The `FormFieldRule` messages of the form.
+
+    Returns:
+        A `(field name, error message key)` pair per failed field, in rule
+        order; empty if the submission is valid.
+    """
+    errors: List[Tuple[str, str]] = []
+    for rule in rules:
+        value = fields.get(rule.name, "")
+        if not value:
+            if rule.required:
+                errors.append((rule.name, DEFAULT_ERROR_KEYS["required"]))
+            continue
+        problem = None
+        if rule.min_length and len(value) < rule.min_length:
+            problem = "too_short"
+        elif rule.max_length and len(value) > rule.max_length:
+            problem = "too_long"
+        elif rule.format and not _matches_format(value, rule.format):
+            problem = "invalid"
+        elif rule.pattern and not re.fullmatch(rule.pattern, value):
+            problem = "invalid"
+        if problem:
+            key = rule.error_message_key or DEFAULT_ERROR_KEYS[problem]
+            errors.append((rule.name, key))
+    return errors
+
+
+def _matches_format(value: str, format_name: str) -> bool:
+    """Returns whether a value has a known format; unknown formats pass."""
+    pattern = FORMATS.get(format_name)
+    if pattern is None:
+        logger.warning("Unknown form field format '%s'; not checking it.", format_name)
+        return True
+    return bool(pattern.match(value))
+
+
+class FormHandler:
+    """A base class for backend endpoints receiving a form from the site.
+
+    It reads the submitted fields and the page's language, applies the
+    form's spam protection and answers scripts with JSON and plain HTML form
+    posts with a redirect back to the page. Subclasses implement `handle`.
+    """
+
+    success_message_key = ""
+    """The I18n key of the message answering a successful submission."""
+
+    def __init__(
+        self,
+        translations_by_lang: Dict[str, Translations],
+        default_lang: str,
+        spam_guard: Optional[SpamGuard] = None,
+    ):
+        """
+        Args:
+            translations_by_lang: Translations for every supported language.
+            default_lang: The language used when the form sends none or an
+                          unsupported one.
+            spam_guard: Checks submissions for spam before they are handled.
+        """
+        self.translations_by_lang = translations_by_lang
+        self.default_lang = default_lang
+        self.spam_guard = spam_guard
+
+    def __call__(self, request: BackendRequest) -> BackendResponse:
+        if request.method == "GET":
+            return self.form_token()
+        submitted = {name: value.strip() for name, value in request.form().items()}
+        lang = self.answer_lang(submitted.pop(LANG_FIELD, ""))
+        translations = self.translations_by_lang.get(lang, {})
+
+        if self.spam_guard:
+            verdict = self.spam_guard.check(submitted, request.remote_addr)
+            for name in self.spam_guard.protocol_fields():
+                submitted.pop(name, None)
+            if verdict and verdict.message_key is None:
+                # Caught bots get the usual answer; nothing is delivered.
+                return self.success(request, translations, self.success_message_key)
+            if verdict:
+                return self.failure(
+                    request, verdict.status, translations, verdict.message_key
+                )
+        return self.handle(request, submitted, lang, translations)
+
+    def answer_lang(self, submitted_lang: str) -> str:
+        """Returns the language to answer a submission in."""
+        if submitted_lang in self.translations_by_lang:
+            return submitted_lang
+        return self.default_lang
+
+    def form_token(self) -> BackendResponse:
+        """Answers with a new form token of the spam protection."""
+        if self.spam_guard is None:
+            return json_response(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+        response = json_response(
+            HTTPStatus.OK, {"token": self.spam_guard.issue_token()}
+        )
+        response.headers.append(("Cache-Control", "no-store"))
+        return response
+
+    def handle(
+        self,
+        request: BackendRequest,
+        submitted: Dict[str, str],
+        lang: str,
+        translations: Translations,
+    ) -> BackendResponse:
+        """Handles a submission that passed the spam protection.
+
+        Args:
+            request: The request.
+            submitted: The submitted fields, stripped, without the language
+                       and spam protection fields.
+            lang: The language to answer in.
+            translations: The translations of that language.
+
+        Returns:
+            The response, usually from `success` or `failure`.
+        """
+        raise NotImplementedError
+
+    def success(
+        self, request: BackendRequest, translations: Translations, message_key: str
+    ) -> BackendResponse:
+        """Answers with `200 {"ok": true, "message": ...}`."""
+        message = translate_message(translations, message_key)
+        return self.respond(request, HTTPStatus.OK, {"ok": True, "message": message})
+
+    def failure(
+        self,
+        request: BackendRequest,
+        status: int,
+        translations: Translations,
+        message_key: str,
+        field: Optional[str] = None,
+    ) -> BackendResponse:
+        """Answers with `{"errors": [{"message": ...}]}` and an error status."""
+        error = {"message": translate_message(translations, message_key)}
+        if field:
+            error = {"field": field, **error}
+        return self.respond(request, status, {"errors": [error]})
+
+    def respond(
+        self, request: BackendRequest, status: int, payload: Dict[str, Any]
+    ) -> BackendResponse:
+        """Answers scripts with JSON and plain form posts with an HTML page."""
+        if wants_json(request):
+            return json_response(status, payload)
+        lang = self.answer_lang(request.form().get(LANG_FIELD, "").strip())
+        return html_response(
+            status,
+            response_page(
+                payload,
+                lang,
+                self.translations_by_lang.get(lang, {}),
+                request.headers.get("referer", ""),
+            ),
+        )
+
+
+class ContactFormHandler(FormHandler):
+    """Handles contact form submissions posted to the backend."""
+
+    def __init__(
+        self,
+        form_config: Any,
+        translations_by_lang: Dict[str, Translations],
+        default_lang: str,
+        sinks: Dict[str, FormSink],
+        form_name: str = "contact",
+        spam_guard: Optional[SpamGuard] = None,
+    ):
+        """
+        Args:
+            form_config: The `ContactFormConfig` message.
+            translations_by_lang: Translations for every supported language.
+            default_lang: The language used when the form sends none or an
+                          unsupported one.
+            sinks: The sinks that receive valid submissions, by name.
+            form_name: The name submissions are tagged with.
+            spam_guard: Checks submissions for spam before validating them.
+        """
+        super().__init__(translations_by_lang, default_lang, spam_guard)
+        self.form_config = form_config
+        self.sinks = sinks
+        self.form_name = form_name
+        self.success_message_key = form_config.success_message_key
+
+    def handle(
+        self,
+        request: BackendRequest,
+        submitted: Dict[str, str],
+        lang: str,
+        translations: Translations,
+    ) -> BackendResponse:
+        rules = list(self.form_config.fields)
+        errors = validate_submission(submitted, rules)
+        if errors:
+            return self.respond(
+                request,
+                HTTPStatus.UNPROCESSABLE_ENTITY,
+                {
+                    "errors": [
+                        {"field": name, "message": translate_message(translations, key)}
+                        for name, key in errors
+                    ]
+                },
+            )
+
+        if rules:
+            known = {rule.name for rule in rules}
+            submitted = {
+                name: value for name, value in submitted.items() if name in known
+            }
+        submission = FormSubmission(
+            form=self.form_name,
+            fields=submitted,
+            lang=lang,
+            submitted_at=datetime.now(timezone.utc).isoformat(timespec="seconds"),
+            remote_addr=request.remote_addr,
+        )
+        if not self.dispatch(submission):
+            return self.failure(
+                request,
+                HTTPStatus.BAD_GATEWAY,
+                translations,
+                self.form_config.error_message_key,
+            )
+        return self.success(request, translations, self.success_message_key)
+
+    def dispatch(self, submission: FormSubmission) -> bool:
+        """Sends a submission to every sink.
+
+        Returns:
+            Whether at least one sink accepted it. A failing sink is logged
+            and does not keep the others from receiving the submission.
+        """
+        if not self.sinks:
+            logger.error("No form sinks configured; dropping a submission.")
+            return False
+        delivered = False
+        for name, sink in self.sinks.items():
+            try:
+                sink.send(submission)
+                delivered = True
+            except Exception:  # pylint: disable=broad-except
+                logger.exception("Form sink '%s' failed.", name)
+        return delivered
+
+
+def response_page(
+    payload: Dict[str, Any], lang: str, translations: Translations, back_url: str
+) -> str:
+    """Renders a form's JSON answer as a page for plain HTML form posts.
+
+    Args:
+        payload: The JSON answer, with a `message` or a list of `errors`.
+        lang: The language of the messages.
+        translations: The translations of that language.
+        back_url: The page the form was posted from, linked unless it is
+            not an http(s) URL.
+    """
+    errors = payload.get("errors", [])
+    messages = [
+        f"{error['field']}: {error['message']}"
+        if error.get("field")
+        else error["message"]
+        for error in errors
+    ] or [payload.get("message", "")]
+    if errors:
+        messages.append(translate_message(translations, "form_error_go_back"))
+    items = "".join(f"<li>{html.escape(message)}</li>" for message in messages)
+    back_link = ""
+    if back_url.startswith(("https://", "http://")):
+        back_link = (
+            f'<p><a href="{html.escape(back_url)}">'
+            f"{html.escape(translate_message(translations, 'form_back_link'))}</a></p>"
+        )
+    return (
+        f'<!DOCTYPE html>\n<html lang="{html.escape(lang)}">\n<head>\n'
+        '<meta charset="utf-8" />\n'
+        '<meta name="viewport" content="width=device-width, initial-scale=1" />\n'
+        '<meta name="robots" content="noindex" />\n'
+        f"<title>{html.escape(messages[0])}</title>\n</head>\n<body>\n"
+        f'<main role="{"alert" if errors else "status"}">\n'
+        f"<ul>{items}</ul>\n{back_link}\n</main>\n</body>\n</html>\n"
+    )
+
+
+def translate_message(translations: Translations, key: Optional[str]) -> str:
+    """Translates a message key, falling back to the English default."""
+    if not key:
+        return ""
+    return translations.get(key) or DEFAULT_ERROR_MESSAGES.get(key, key)
@@ -3,25 +3,34 @@ HTML block generator classes for various content types.
 
 This module provides concrete implementations of the `HtmlBlockGenerator`
 protocol for different kinds of data (e.g., portfolio items, testimonials,
-features, hero sections, contact forms, and blog posts). Each generator
+features, hero sections, contact forms, blog posts, FAQ items, and business
+info). Each generator
 takes structured data (typically as protobuf messages) and translation data,
-and produces an HTML string representation for that block.
+and produces an HTML string representation for that block, or writes it to
+a stream as Jinja2 renders it (`write_html`).
 """
 
+import logging
 import random
-from typing import Any, Callable, Dict, List, Optional, Type
+from typing import IO, Any, Callable, Dict, Iterator, List, Optional, Type
 
 from jinja2 import Environment
 
 # Generated protobuf message types
 from generated.blog_post_pb2 import BlogPost
 from generated.contact_form_config_pb2 import ContactFormConfig
+from generated.faq_item_pb2 import FaqItem
 from generated.feature_item_pb2 import FeatureItem
 from generated.hero_item_pb2 import HeroItem, HeroItemContent
+from generated.local_business_pb2 import LocalBusiness
+from generated.newsletter_pb2 import NewsletterConfig
 from generated.portfolio_item_pb2 import PortfolioItem
 from generated.testimonial_item_pb2 import TestimonialItem
 
 from .interfaces import HtmlBlockGenerator, Translations
+from .spam_protection import CAPTCHA_PROVIDERS
+
+logger = logging.getLogger(__name__)
 
 # Registry for HTML block generators
 HTML_GENERATOR_REGISTRY: Dict[str, Type[HtmlBlockGenerator]] = {}
@@ -37,9 +46,10 @@ def register_html_generator(
 
     def decorator(cls: Type[HtmlBlockGenerator]) -> Type[HtmlBlockGenerator]:
         if block_name in HTML_GENERATOR_REGISTRY:
-            # Use a simple print for warnings if logging is not set up
-            print(
-                f"Warning: HTML generator for block '{block_name}' is being overridden by {cls.__name__}"
+            logger.warning(
+                "HTML generator is being overridden by %s",
+                cls.__name__,
+                extra={"block": block_name},
             )
         HTML_GENERATOR_REGISTRY[block_name] = cls
         cls.template_to_render = template_to_render
@@ -52,7 +62,10 @@ def register_html_generator(
 class BaseHtmlGenerator(HtmlBlockGenerator):
     """
     A base class for common HTML block generators that provides a default
-    implementation for __init__ and generate_html.
+    implementation for __init__, generate_html and write_html.
+
+    Both render through `render_chunks`, so subclasses with custom rendering
+    override that rather than either of them.
     """
 
     template_to_render: str  # Expected to be set by decorator or subclass
@@ -62,15 +75,24 @@ class BaseHtmlGenerator(HtmlBlockGenerator):
         self.jinja_env = jinja_env
 
     def generate_html(self, data: Any, translations: Translations) -> str:
+        """Generates an HTML string for a content block."""
+        return "".join(self.render_chunks(data, translations))
+
+    def write_html(self, data: Any, translations: Translations, out: IO[str]) -> None:
+        """Writes the block to `out` chunk by chunk as it renders."""
+        for chunk in self.render_chunks(data, translations):
+            out.write(chunk)
+
+    def render_chunks(self, data: Any, translations: Translations) -> Iterator[str]:
         """
-        Generates an HTML string for a content block using a common pattern.
-        Assumes 'template_to_render' and 'data_key_for_template' are set
-        (usually by the @register_html_generator decorator on the subclass).
+        Renders a content block as a sequence of HTML chunks using a common
+        pattern. Assumes 'template_to_render' and 'data_key_for_template' are
+        set (usually by the @register_html_generator decorator on the subclass).
         """
         if not data:
             # This basic guard might need to be overridden by subclasses
             # if they handle 'None' data differently (e.g. Hero, ContactForm)
-            return ""
+            return iter(())
 
         # Ensure template_to_render is set, which should be guaranteed by the decorator
         # and protocol, but a runtime check or better initialization could be added if needed.
@@ -88,7 +110,7 @@ class BaseHtmlGenerator(HtmlBlockGenerator):
             self.__class__.data_key_for_template: data,
             "translations": translations,
         }
-        return str(template.render(**context))
+        return template.generate(**context)
 
 
 @register_html_generator(
@@ -170,21 +192,21 @@ class HeroHtmlGenerator(BaseHtmlGenerator):
 
     # __init__ is inherited from BaseHtmlGenerator
 
-    # generate_html is custom due to variation logic
-    def generate_html(
+    # render_chunks is custom due to variation logic
+    def render_chunks(
         self, data: Optional[HeroItem], translations: Translations
-    ) -> str:
-        """Generates HTML for the hero section, selecting a variation.
+    ) -> Iterator[str]:
+        """Renders the hero section, selecting a variation.
 
         Args:
             data: An optional HeroItem protobuf message.
             translations: A dictionary containing translations.
 
         Returns:
-            An HTML string for the hero section.
+            The HTML chunks of the hero section.
         """
         if not data or not data.variations:
-            return "<!-- Hero data not found or no variations -->"
+            return iter(["<!-- Hero data not found or no variations -->"])
 
         selected_variation: Optional[HeroItemContent] = None
 
@@ -204,8 +226,8 @@ class HeroHtmlGenerator(BaseHtmlGenerator):
 
         template = self.jinja_env.get_template(self.__class__.template_to_render)
         # The template expects `hero_item` as the context variable for the selected variation
-        return str(
-            template.render(hero_item=selected_variation, translations=translations)
+        return template.generate(
+            hero_item=selected_variation, translations=translations
         )
 
 
@@ -219,21 +241,26 @@ class ContactFormHtmlGenerator(BaseHtmlGenerator):
 
     # __init__ is inherited
 
-    def generate_html(
+    def render_chunks(
         self, data: Optional[ContactFormConfig], translations: Translations
-    ) -> str:
-        """Generates HTML markup for the contact form section.
+    ) -> Iterator[str]:
+        """Renders the contact form section.
 
         Args:
             data: An optional ContactFormConfig protobuf message.
             translations: A dictionary containing translations.
 
         Returns:
-            An HTML string representing the contact form section.
+            The HTML chunks of the contact form section.
         """
-        # BaseHtmlGenerator.generate_html handles the 'if not data'
-        # and rendering using the 'data_key_for_template' which is now "config".
-        return super().generate_html(data, translations)
+        if not data:
+            return iter(())
+        template = self.jinja_env.get_template(self.__class__.template_to_render)
+        # The captcha widget to render, if spam protection asks for one.
+        captcha = CAPTCHA_PROVIDERS.get(data.spam_protection.captcha_provider)
+        return template.generate(
+            config=data, translations=translations, captcha=captcha
+        )
 
 
 @register_html_generator(block_name="blog.html", template_to_render="blocks/blog.html")
@@ -253,3 +280,72 @@ class BlogHtmlGenerator(BaseHtmlGenerator):
             An HTML string representing the blog posts.
         """
         return super().generate_html(data, translations)
+
+
+@register_html_generator(block_name="faq.html", template_to_render="blocks/faq.html")
+class FaqHtmlGenerator(BaseHtmlGenerator):
+    """Generates HTML for a list of FAQ items using Jinja2."""
+
+    # __init__ is inherited
+
+    def generate_html(self, data: List[FaqItem], translations: Translations) -> str:
+        """Generates HTML markup for FAQ items.
+
+        Args:
+            data: A list of FaqItem protobuf messages.
+            translations: A dictionary containing translations.
+
+        Returns:
+            An HTML string representing the FAQ items.
+        """
+        return super().generate_html(data, translations)
+
+
+@register_html_generator(
+    block_name="business-info.html",
+    template_to_render="blocks/business-info.html",
+    data_key="business",
+)
+class BusinessInfoHtmlGenerator(BaseHtmlGenerator):
+    """Generates HTML for the business contact and opening hours block."""
+
+    # __init__ is inherited
+
+    def generate_html(
+        self, data: Optional[LocalBusiness], translations: Translations
+    ) -> str:
+        """Generates HTML markup for the business info section.
+
+        Args:
+            data: An optional LocalBusiness protobuf message.
+            translations: A dictionary containing translations.
+
+        Returns:
+            An HTML string representing the business info section.
+        """
+        return super().generate_html(data, translations)
+
+
+@register_html_generator(
+    block_name="newsletter.html",
+    template_to_render="blocks/newsletter.html",
+    data_key="config",
+)
+class NewsletterHtmlGenerator(BaseHtmlGenerator):
+    """Generates HTML for the newsletter signup block."""
+
+    # __init__ is inherited
+
+    def generate_html(
+        self, data: Optional[NewsletterConfig], translations: Translations
+    ) -> str:
+        """Generates HTML markup for the newsletter signup section.
+
+        Args:
+            data: An optional NewsletterConfig protobuf message.
+            translations: A dictionary containing translations.
+
+        Returns:
+            An HTML string representing the newsletter signup section.
+        """
+        return super().generate_html(data, translations)
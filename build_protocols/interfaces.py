@@ -8,13 +8,30 @@ caching can be used interchangeably as long as they adhere to these defined
 contracts.
 """
 
-from typing import Any, Dict, List, Optional, Protocol, Type, TypeVar, Union
+from dataclasses import dataclass, field
+from typing import (
+    IO,
+    Any,
+    Callable,
+    Dict,
+    Iterable,
+    List,
+    Optional,
+    Protocol,
+    Tuple,
+    Type,
+    TypeVar,
+    Union,
+)
 
 from google.protobuf.message import Message
 from jinja2 import Environment  # Added for HtmlBlockGenerator.__init__
 
 # Import specific generated types used in protocols for clarity.
 from generated.nav_item_pb2 import Navigation as NavigationProto
+from generated.seo_meta_pb2 import SeoMeta as SeoMetaProto
+
+from .timeouts import Deadline
 
 # --- Type Aliases and TypeVariables ---
 
@@ -134,6 +151,17 @@ class HtmlBlockGenerator(Protocol):
         """
         ...
 
+    def write_html(self, data: Any, translations: Translations, out: IO[str]) -> None:
+        """Writes the same HTML as `generate_html` to `out` as it renders.
+
+        Args:
+            data: The data required to generate the HTML block.
+            translations: The Translations dictionary for the current language.
+            out: The text stream the block is written to, usually the page's
+                 output file.
+        """
+        ...
+
 
 class AppConfigManager(Protocol):
     """
@@ -191,6 +219,10 @@ class PageBuilder(Protocol):
         main_content: str,
         navigation_items: Optional[List[Dict[str, Any]]] = None,
         page_title: Optional[str] = None,
+        extra_context: Optional[Dict[str, Any]] = None,
+        seo_meta: Optional[SeoMetaProto] = None,
+        page_url: Optional[str] = None,
+        canonical_url: Optional[str] = None,
     ) -> str:
         """Assembles a full HTML page using translated and generated content.
 
@@ -202,12 +234,47 @@ class PageBuilder(Protocol):
             main_content: The main content area of the page, already processed
                           and translated.
             navigation_items: Optional list of navigation item dictionaries for the header.
+            page_title: Optional title for the page.
+            extra_context: Optional additional template variables contributed
+                           by site artifact generators (e.g., feed links).
+            seo_meta: Optional resolved SEO metadata used to emit description,
+                      OpenGraph and Twitter Card meta tags.
+            page_url: Optional absolute URL of the page, used for `og:url`
+                      and as the canonical link if none is given.
+            canonical_url: Optional absolute URL for `<link rel="canonical">`,
+                           when it differs from `page_url`.
 
         Returns:
             A string containing the complete HTML for the assembled page.
         """
         ...
 
+    def write_translated_page(
+        self,
+        out: IO[str],
+        lang: str,
+        translations: Translations,
+        write_main_content: Callable[[IO[str]], None],
+        navigation_items: Optional[List[Dict[str, Any]]] = None,
+        page_title: Optional[str] = None,
+        extra_context: Optional[Dict[str, Any]] = None,
+        seo_meta: Optional[SeoMetaProto] = None,
+        page_url: Optional[str] = None,
+        canonical_url: Optional[str] = None,
+    ) -> None:
+        """Writes the page `assemble_translated_page` would return to `out`.
+
+        The page is written as it renders, so neither it nor its main content
+        is ever held in memory as a whole.
+
+        Args:
+            out: The text stream the page is written to.
+            write_main_content: Called with `out` to write the main content
+                                where the base template places it.
+            Other arguments: As for `assemble_translated_page`.
+        """
+        ...
+
 
 class DataCache(Protocol[T]):
     """
@@ -253,6 +320,205 @@ class DataCache(Protocol[T]):
         """
         ...
 
+    def invalidate_sources(self, paths: Iterable[str]) -> List[str]:
+        """Drops the cached items loaded from any of the given files.
+
+        Args:
+            paths: Changed source files (e.g., "data/hero_item.json").
+
+        Returns:
+            The keys of the dropped items.
+        """
+        ...
+
+    def snapshot(self) -> "DataCache[T]":
+        """Returns a copy of the cache as it is now, for reading.
+
+        Later changes to the cache do not affect the snapshot, so a build
+        reading from it sees consistent data.
+        """
+        ...
+
+
+@dataclass
+class BuildContext:
+    """
+    Site-wide state shared with site artifact generators.
+
+    The orchestrator fills this in as the build progresses: configuration and
+    block data are available before any page is rendered, and
+    `translations_by_lang` gains an entry as each language is processed.
+    """
+
+    app_config: Dict[str, Any]
+    default_lang: str
+    supported_langs: List[str]
+    block_data: Dict[str, Any] = field(default_factory=dict)
+    """Loaded data per block name (e.g., "blog.html" -> List[BlogPost])."""
+    translations_by_lang: Dict[str, Translations] = field(default_factory=dict)
+    build_profile: str = "production"
+    """The build profile (e.g., "production" or "preview"), from BUILD_PROFILE."""
+    variant_pages: Dict[str, Dict[str, str]] = field(default_factory=dict)
+    """The variant page built per variation ID of each varied page, filled in
+    as pages are rendered with `--variants all`."""
+
+
+class SiteArtifactGenerator(Protocol):
+    """
+    Defines the interface for services that produce site-level output beyond
+    the HTML pages themselves (feeds, sitemaps, host configuration files) and
+    that may contribute extra variables to every page's template context.
+    """
+
+    def __init__(self, jinja_env: Environment) -> None: ...
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Returns template variables to add to the page for a language.
+
+        Args:
+            lang: The language code of the page being assembled.
+            build_context: The current build state.
+
+        Returns:
+            A dictionary of extra template variables. List values are
+            concatenated with those contributed by other generators.
+        """
+        ...
+
+    def process_page(
+        self, output_path: str, html: str, build_context: BuildContext
+    ) -> str:
+        """Inspects or rewrites a rendered page before it is written.
+
+        Args:
+            output_path: The path the page will be written to (e.g.,
+                         "index_es.html").
+            html: The rendered HTML of the page.
+            build_context: The current build state.
+
+        Returns:
+            The HTML to write, usually `html` unchanged.
+        """
+        ...
+
+    def processes_pages(self, build_context: BuildContext) -> bool:
+        """Returns whether `process_page` may inspect or rewrite pages.
+
+        Pages are only streamed to disk (see `page_streaming`) when no
+        generator needs to see them whole.
+        """
+        ...
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates the generator's output files once all pages are built.
+
+        Args:
+            build_context: The build state, including translations for every
+                           supported language.
+
+        Returns:
+            A dictionary mapping output paths (relative to the output root)
+            to file contents. An empty dictionary means nothing to write.
+        """
+        ...
+
+
+@dataclass
+class FormSubmission:
+    """A validated form submission, as handed to form sinks."""
+
+    form: str
+    """The form it was sent through (e.g., "contact")."""
+    fields: Dict[str, str]
+    """The validated field values, keyed by field name."""
+    lang: str
+    """The language of the page the form was sent from."""
+    submitted_at: str
+    """The time it was received, as an ISO 8601 UTC timestamp."""
+    remote_addr: str = ""
+
+
+class FormSink(Protocol):
+    """
+    Defines the interface for destinations of form submissions received by
+    the backend (logs, email, chat notifications, storage).
+    """
+
+    def __init__(self, settings: Dict[str, Any]) -> None:
+        """
+        Initializes the sink.
+
+        Args:
+            settings: The sink's section of `backend.form_sinks` in the app
+                      configuration (credentials, recipients, etc.).
+        """
+        ...
+
+    def send(self, submission: FormSubmission) -> None:
+        """Delivers a submission.
+
+        Args:
+            submission: The validated submission.
+
+        Raises:
+            Exception: If the submission could not be delivered.
+        """
+        ...
+
+
+@dataclass
+class DeploySummary:
+    """What a deploy changed on its target."""
+
+    uploaded: List[str] = field(default_factory=list)
+    """The paths of the files that were new or changed, relative to the site root."""
+    unchanged: List[str] = field(default_factory=list)
+    """The paths of the files the target already had."""
+    deleted: List[str] = field(default_factory=list)
+    """The paths removed from the target because the site no longer has them."""
+    uploaded_bytes: int = 0
+
+
+class DeployTarget(Protocol):
+    """
+    Defines the interface for places the built site is published to (storage
+    buckets, static hosting services).
+    """
+
+    host_formats: Tuple[str, ...]
+    """The header and redirect file formats its host reads (e.g., "netlify")."""
+
+    deadline: Deadline
+    """Bounds the deploy; checked before each upload, request or command."""
+
+    def __init__(self, settings: Dict[str, Any]) -> None:
+        """
+        Initializes the target.
+
+        Args:
+            settings: The target's section of `deploy.targets` in the app
+                      configuration (bucket, credentials, etc.).
+        """
+        ...
+
+    def deploy(self, files: Dict[str, str], dry_run: bool = False) -> DeploySummary:
+        """Publishes the site.
+
+        Args:
+            files: The local path of every file of the site, keyed by its
+                   path relative to the site root ("/"-separated).
+            dry_run: Report what would change without changing anything.
+
+        Returns:
+            What changed (or would change) on the target.
+
+        Raises:
+            DeployError: If the site could not be published.
+        """
+        ...
+
 
 # Notes on design choices:
 # - `HtmlBlockGenerator.generate_html` uses `data: Any` for maximum flexibility
@@ -262,3 +528,5 @@ class DataCache(Protocol[T]):
 #   `NavigationProto` type for `nav_data` as it's a specific, known type.
 # - The `DataCache.set_item` allows `value` to be `None`, enabling explicit
 #   caching of "not found" or clearing entries.
+# - `SiteArtifactGenerator` returns file contents instead of writing them so
+#   the orchestrator stays the single place that touches the output directory.
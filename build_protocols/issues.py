@@ -0,0 +1,241 @@
+"""
+The problems build checks find, and reporters that format them.
+
+Every check of the build (canonical link targets, SEO meta lengths,
+performance budgets) reports an `Issue`: its severity, a category naming
+the kind of problem (e.g., "unresolved-canonical"), the file it concerns
+with an optional position, a message and an optional suggestion. The
+orchestrator logs each issue as it is found and, with the `issue_report`
+section of `public/config.json` enabled, writes all of them in one of the
+registered report formats at the end of the build:
+
+    "issue_report": { "enabled": true, "format": "sarif", "path": "build-issues.sarif" }
+
+- `console`: one `file:line:column: severity [category] message` line per
+  issue, followed by a summary.
+- `json`: the summary and the issues as JSON objects.
+- `sarif`: SARIF 2.1.0, which code scanning tools (e.g., GitHub code
+  scanning) show as annotations on the files concerned.
+
+The `issue_levels` section sets the level of a category, so quality gates
+can be tightened one category at a time: `ignore` drops its issues, `warn`
+reports them as warnings and `error` as errors. A build that reports any
+error fails (see `IssueError`) once its report and manifest are written.
+
+    "issue_levels": { "oversized-image": "error", "long-meta": "ignore" }
+"""
+
+import json
+import logging
+from dataclasses import asdict, dataclass, replace
+from typing import Any, Callable, Dict, Iterable, List, Optional
+
+ERROR = "error"
+WARNING = "warning"
+NOTE = "note"
+SEVERITIES = (ERROR, WARNING, NOTE)
+"""The severities from most to least severe; they match SARIF levels."""
+LOG_LEVELS = {ERROR: logging.ERROR, WARNING: logging.WARNING, NOTE: logging.INFO}
+ISSUE_LEVELS: Dict[str, Optional[str]] = {
+    "ignore": None,
+    "warn": WARNING,
+    "error": ERROR,
+}
+"""The `issue_levels` values and the severity each sets; None drops issues."""
+
+TOOL_NAME = "landing-build"
+SARIF_VERSION = "2.1.0"
+SARIF_SCHEMA = "https://json.schemastore.org/sarif-2.1.0.json"
+DEFAULT_REPORT_FORMAT = "sarif"
+DEFAULT_REPORT_PATHS = {
+    "console": "build-issues.txt",
+    "json": "build-issues.json",
+    "sarif": "build-issues.sarif",
+}
+
+IssueReporter = Callable[[List["Issue"]], str]
+
+# Registry for issue report formats
+ISSUE_REPORTER_REGISTRY: Dict[str, IssueReporter] = {}
+
+
+class IssueError(Exception):
+    """Raised when a build reports issues with the severity error."""
+
+
+@dataclass(frozen=True)
+class Issue:
+    """A problem found by a build check."""
+
+    severity: str
+    """One of `SEVERITIES`."""
+    category: str
+    """The kind of problem, e.g. "oversized-image"."""
+    message: str
+    checker: str
+    """The check that found it, e.g. "performance"."""
+    file: Optional[str] = None
+    """The file concerned, relative to the site root."""
+    line: Optional[int] = None
+    column: Optional[int] = None
+    suggestion: Optional[str] = None
+    """How to fix it, if the check knows."""
+
+    def location(self) -> str:
+        """Returns `file:line:column`, as much of it as is known."""
+        parts = [
+            str(part)
+            for part in (self.file, self.line, self.column)
+            if part is not None
+        ]
+        return ":".join(parts)
+
+
+def register_issue_reporter(name: str) -> Callable[[IssueReporter], IssueReporter]:
+    """A decorator to register a function formatting issues as a report."""
+
+    def decorator(reporter: IssueReporter) -> IssueReporter:
+        ISSUE_REPORTER_REGISTRY[name] = reporter
+        return reporter
+
+    return decorator
+
+
+def parse_issue_levels(settings: Dict[str, Any]) -> Dict[str, Optional[str]]:
+    """Maps each category of the `issue_levels` section to its severity.
+
+    Raises:
+        ValueError: If a category has an unknown level.
+    """
+    levels: Dict[str, Optional[str]] = {}
+    for category, level in settings.items():
+        if level not in ISSUE_LEVELS:
+            raise ValueError(
+                f"Unknown level '{level}' for issue category '{category}'; "
+                f"use one of {', '.join(ISSUE_LEVELS)}."
+            )
+        levels[category] = ISSUE_LEVELS[level]
+    return levels
+
+
+def apply_issue_levels(
+    issues: Iterable[Issue], levels: Dict[str, Optional[str]]
+) -> List[Issue]:
+    """Sets the severity of issues in the categories of `levels`.
+
+    Args:
+        issues: The issues as their checks reported them.
+        levels: The severity per category, from `parse_issue_levels`. Issues
+            of a category mapped to None are dropped.
+
+    Returns:
+        The issues to report.
+    """
+    leveled: List[Issue] = []
+    for issue in issues:
+        if issue.category not in levels:
+            leveled.append(issue)
+            continue
+        severity = levels[issue.category]
+        if severity is not None:
+            leveled.append(replace(issue, severity=severity))
+    return leveled
+
+
+def summarize_issues(issues: Iterable[Issue]) -> Dict[str, int]:
+    """Counts the issues per severity."""
+    counts = {severity: 0 for severity in SEVERITIES}
+    for issue in issues:
+        counts[issue.severity] = counts.get(issue.severity, 0) + 1
+    return counts
+
+
+def format_issue_summary(issues: List[Issue]) -> str:
+    """Formats e.g. "3 issues: 1 error, 2 warnings, 0 notes"."""
+    counts = summarize_issues(issues)
+    return f"{len(issues)} issue{'s' if len(issues) != 1 else ''}: " + ", ".join(
+        f"{count} {severity}{'s' if count != 1 else ''}"
+        for severity, count in counts.items()
+    )
+
+
+@register_issue_reporter("console")
+def format_console(issues: List[Issue]) -> str:
+    """Formats one line per issue, most severe first, and a summary."""
+    lines: List[str] = []
+    for issue in sorted(issues, key=lambda issue: SEVERITIES.index(issue.severity)):
+        location = issue.location()
+        line = f"{location + ': ' if location else ''}{issue.severity} "
+        line += f"[{issue.category}] {issue.message}"
+        if issue.suggestion:
+            line += f" ({issue.suggestion})"
+        lines.append(line)
+    lines.append(format_issue_summary(issues))
+    return "\n".join(lines)
+
+
+def _issue_dict(issue: Issue) -> Dict[str, Any]:
+    return {key: value for key, value in asdict(issue).items() if value is not None}
+
+
+@register_issue_reporter("json")
+def format_json(issues: List[Issue]) -> str:
+    """Formats the summary and the issues as a JSON document."""
+    return json.dumps(
+        {
+            "summary": summarize_issues(issues),
+            "issues": [_issue_dict(issue) for issue in issues],
+        },
+        indent=2,
+        ensure_ascii=False,
+    )
+
+
+@register_issue_reporter("sarif")
+def format_sarif(issues: List[Issue]) -> str:
+    """Formats the issues as a SARIF 2.1.0 log with one rule per category."""
+    categories = sorted({issue.category for issue in issues})
+    results: List[Dict[str, Any]] = []
+    for issue in issues:
+        result: Dict[str, Any] = {
+            "ruleId": issue.category,
+            "ruleIndex": categories.index(issue.category),
+            "level": issue.severity,
+            "message": {"text": issue.message},
+            "properties": {"checker": issue.checker},
+        }
+        if issue.file:
+            physical_location: Dict[str, Any] = {
+                "artifactLocation": {"uri": issue.file}
+            }
+            if issue.line is not None:
+                region = {"startLine": issue.line}
+                if issue.column is not None:
+                    region["startColumn"] = issue.column
+                physical_location["region"] = region
+            result["locations"] = [{"physicalLocation": physical_location}]
+        if issue.suggestion:
+            result["properties"]["suggestion"] = issue.suggestion
+        results.append(result)
+    return json.dumps(
+        {
+            "$schema": SARIF_SCHEMA,
+            "version": SARIF_VERSION,
+            "runs": [
+                {
+                    "tool": {
+                        "driver": {
+                            "name": TOOL_NAME,
+                            "rules": [
+                                {"id": category, "name": category}
+                                for category in categories
+                            ],
+                        }
+                    },
+                    "results": results,
+                }
+            ],
+        },
+        indent=2,
+        ensure_ascii=False,
+    )
@@ -0,0 +1,194 @@
+"""
+Subscribes newsletter signups with an email marketing provider.
+
+The `newsletter.html` block posts an email address to the backend's
+`/api/newsletter` endpoint, which forwards it to the configured provider so
+its API key never reaches the browser. It is configured by
+`backend.newsletter` in `public/config.json`:
+
+    "newsletter": {
+      "provider": "buttondown",
+      "api_key_env": "NEWSLETTER_API_KEY",
+      "list_id": "",
+      "form_id": "",
+      "double_opt_in": true
+    }
+
+Supported providers:
+
+- `mailchimp`: adds a member to the audience `list_id`, as "pending" (the
+  provider emails a confirmation link) when `double_opt_in` is set.
+- `buttondown`: creates a subscriber; double opt-in follows the newsletter's
+  own settings.
+- `convertkit`: subscribes the address to the form `form_id`, whose settings
+  decide on double opt-in.
+
+The answer tells the visitor whether they are subscribed
+(`success_message_key`), must confirm their address (`pending_message_key`)
+or were already subscribed (`exists_message_key`), in the page's language.
+"""
+
+import base64
+import json
+import logging
+import os
+import urllib.error
+from http import HTTPStatus
+from typing import Any, Callable, Dict, Optional
+
+from .backend import BackendRequest, BackendResponse
+from .form_notifications import post_json
+from .forms import FORMATS, FormHandler
+from .interfaces import Translations
+from .spam_protection import SpamGuard
+
+logger = logging.getLogger(__name__)
+
+NEWSLETTER_PATH = "/api/newsletter"
+SUBSCRIBED = "subscribed"
+PENDING = "pending"
+EXISTS = "exists"
+
+
+class NewsletterError(Exception):
+    """Raised when a provider could not subscribe an address."""
+
+
+def _error_body(error: urllib.error.HTTPError) -> Dict[str, Any]:
+    """Returns the JSON body of an error response, or an empty dict."""
+    try:
+        body = json.loads(error.read().decode("utf-8"))
+    except (OSError, UnicodeDecodeError, json.JSONDecodeError):
+        return {}
+    return body if isinstance(body, dict) else {}
+
+
+def subscribe_mailchimp(email: str, settings: Dict[str, Any], api_key: str) -> str:
+    """Adds a member to a Mailchimp audience."""
+    if "-" not in api_key or not settings.get("list_id"):
+        raise NewsletterError("Mailchimp needs an API key and a 'list_id'.")
+    data_center = api_key.rsplit("-", 1)[1]
+    credentials = base64.b64encode(f"key:{api_key}".encode("utf-8")).decode("ascii")
+    status = PENDING if settings.get("double_opt_in", True) else SUBSCRIBED
+    try:
+        result = post_json(
+            f"https://{data_center}.api.mailchimp.com/3.0/lists/"
+            f"{settings['list_id']}/members",
+            {"email_address": email, "status": status},
+            {"Authorization": f"Basic {credentials}"},
+        )
+    except urllib.error.HTTPError as e:
+        body = _error_body(e)
+        if body.get("title") == "Member Exists":
+            return EXISTS
+        raise NewsletterError(f"Mailchimp answered {e.code}: {body}") from e
+    return PENDING if (result or {}).get("status") == "pending" else SUBSCRIBED
+
+
+def subscribe_buttondown(email: str, settings: Dict[str, Any], api_key: str) -> str:
+    """Creates a Buttondown subscriber."""
+    try:
+        result = post_json(
+            "https://api.buttondown.email/v1/subscribers",
+            {"email_address": email},
+            {"Authorization": f"Token {api_key}"},
+        )
+    except urllib.error.HTTPError as e:
+        body = _error_body(e)
+        if e.code in (400, 409) and "already" in json.dumps(body).lower():
+            return EXISTS
+        raise NewsletterError(f"Buttondown answered {e.code}: {body}") from e
+    return PENDING if (result or {}).get("type") == "unactivated" else SUBSCRIBED
+
+
+def subscribe_convertkit(email: str, settings: Dict[str, Any], api_key: str) -> str:
+    """Subscribes an address to a ConvertKit form."""
+    if not settings.get("form_id"):
+        raise NewsletterError("ConvertKit needs a 'form_id'.")
+    try:
+        result = post_json(
+            f"https://api.convertkit.com/v3/forms/{settings['form_id']}/subscribe",
+            {"api_key": api_key, "email": email},
+        )
+    except urllib.error.HTTPError as e:
+        body = _error_body(e)
+        raise NewsletterError(f"ConvertKit answered {e.code}: {body}") from e
+    state = ((result or {}).get("subscription") or {}).get("state")
+    return SUBSCRIBED if state == "active" else PENDING
+
+
+NEWSLETTER_PROVIDERS: Dict[str, Callable[[str, Dict[str, Any], str], str]] = {
+    "mailchimp": subscribe_mailchimp,
+    "buttondown": subscribe_buttondown,
+    "convertkit": subscribe_convertkit,
+}
+
+
+class NewsletterHandler(FormHandler):
+    """Handles newsletter signups posted to the backend."""
+
+    def __init__(
+        self,
+        newsletter_config: Any,
+        settings: Dict[str, Any],
+        translations_by_lang: Dict[str, Translations],
+        default_lang: str,
+        spam_guard: Optional[SpamGuard] = None,
+    ):
+        """
+        Args:
+            newsletter_config: The `NewsletterConfig` message of the block.
+            settings: The `backend.newsletter` section of the app config.
+            translations_by_lang: Translations for every supported language.
+            default_lang: The language used when the form sends none or an
+                          unsupported one.
+            spam_guard: Checks signups for spam before subscribing them.
+
+        Raises:
+            ValueError: If the provider is unknown.
+        """
+        super().__init__(translations_by_lang, default_lang, spam_guard)
+        provider = settings.get("provider", "")
+        if provider not in NEWSLETTER_PROVIDERS:
+            raise ValueError(f"Unknown newsletter provider '{provider}'.")
+        self.subscribe = NEWSLETTER_PROVIDERS[provider]
+        self.provider = provider
+        self.settings = settings
+        self.api_key = os.environ.get(settings.get("api_key_env", ""), "")
+        self.config = newsletter_config
+        self.success_message_key = newsletter_config.success_message_key
+
+    def handle(
+        self,
+        request: BackendRequest,
+        submitted: Dict[str, str],
+        lang: str,
+        translations: Translations,
+    ) -> BackendResponse:
+        email = submitted.get("email", "")
+        if not FORMATS["email"].match(email):
+            return self.failure(
+                request,
+                HTTPStatus.UNPROCESSABLE_ENTITY,
+                translations,
+                "form_error_invalid",
+                field="email",
+            )
+        try:
+            outcome = self.subscribe(email, self.settings, self.api_key)
+        except (NewsletterError, OSError) as e:
+            logger.error("Subscribing with %s failed: %s", self.provider, e)
+            return self.failure(
+                request,
+                HTTPStatus.BAD_GATEWAY,
+                translations,
+                self.config.error_message_key,
+            )
+        message_keys = {
+            SUBSCRIBED: self.config.success_message_key,
+            PENDING: self.config.pending_message_key,
+            EXISTS: self.config.exists_message_key,
+        }
+        return self.success(
+            request, translations, message_keys[outcome] or self.success_message_key
+        )
@@ -0,0 +1,159 @@
+"""
+Decorates outbound links with UTM parameters and `rel`/`target` policies.
+
+Runs as a post-processing pass over every rendered page, so links from all
+blocks and templates get the same campaign tagging without each template
+repeating it. A link is outbound when its `href` is an absolute http(s) URL
+on a host other than the one of `base_url`.
+
+Configured by the `outbound_links` section of `public/config.json`. The
+top-level `rel`, `target` and `utm` apply to every outbound link; the first
+rule whose `domains` match the link's host (subdomains included) overrides
+them:
+
+    "outbound_links": {
+      "enabled": true,
+      "rel": "noopener noreferrer",
+      "target": "_blank",
+      "utm": {},
+      "rules": [
+        {
+          "domains": ["t.me"],
+          "utm": { "utm_source": "landing", "utm_medium": "website" }
+        }
+      ]
+    }
+
+Existing query parameters and `rel` tokens are kept; a `target` already set
+in the markup wins over the policy.
+"""
+
+import html as html_lib
+import re
+from typing import Any, Dict, List, Optional
+from urllib.parse import parse_qsl, urlencode, urlsplit, urlunsplit
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+
+_ANCHOR_TAG_RE = re.compile(r"<a\s[^>]*>", re.IGNORECASE)
+
+
+def _attribute_re(name: str) -> "re.Pattern[str]":
+    return re.compile(rf"""\s{name}=(["'])(.*?)\1""", re.IGNORECASE | re.DOTALL)
+
+
+_HREF_RE = _attribute_re("href")
+_REL_RE = _attribute_re("rel")
+_TARGET_RE = _attribute_re("target")
+
+
+def _host_matches(host: str, domains: List[str]) -> bool:
+    """Returns whether `host` is one of `domains` or a subdomain of one."""
+    return any(host == domain or host.endswith(f".{domain}") for domain in domains)
+
+
+def resolve_link_policy(settings: Dict[str, Any], host: str) -> Dict[str, Any]:
+    """Returns the effective rel/target/utm policy for an outbound host."""
+    policy = {
+        "rel": settings.get("rel", "noopener noreferrer"),
+        "target": settings.get("target", ""),
+        "utm": settings.get("utm", {}),
+    }
+    for rule in settings.get("rules", []):
+        if _host_matches(host, rule.get("domains", [])):
+            policy.update(
+                {key: rule[key] for key in ("rel", "target", "utm") if key in rule}
+            )
+            break
+    return policy
+
+
+def add_query_params(url: str, params: Dict[str, str]) -> str:
+    """Appends query parameters to a URL without overriding existing ones."""
+    if not params:
+        return url
+    parts = urlsplit(url)
+    query = parse_qsl(parts.query, keep_blank_values=True)
+    existing = {key for key, _ in query}
+    query.extend((key, value) for key, value in params.items() if key not in existing)
+    return urlunsplit(parts._replace(query=urlencode(query)))
+
+
+def _set_attribute(tag: str, pattern: "re.Pattern[str]", name: str, value: str) -> str:
+    """Sets an attribute on an opening tag, replacing any existing value."""
+    attribute = f' {name}="{html_lib.escape(value)}"'
+    match = pattern.search(tag)
+    if match is not None:
+        return tag[: match.start()] + attribute + tag[match.end() :]
+    closing = 2 if tag.endswith("/>") else 1
+    return tag[:-closing].rstrip() + attribute + tag[-closing:]
+
+
+def decorate_outbound_links(
+    html: str, settings: Dict[str, Any], site_host: Optional[str]
+) -> str:
+    """Applies the outbound link policies to every `<a>` tag of a page.
+
+    Args:
+        html: The rendered page.
+        settings: The `outbound_links` section of the app config.
+        site_host: The site's own host; links to it are not outbound.
+
+    Returns:
+        The page with decorated outbound links.
+    """
+
+    def decorate(match: "re.Match[str]") -> str:
+        tag = match.group(0)
+        href_match = _HREF_RE.search(tag)
+        if href_match is None:
+            return tag
+        href = html_lib.unescape(href_match.group(2))
+        try:
+            parts = urlsplit(href)
+            host = (parts.hostname or "").lower()
+        except ValueError:
+            # Malformed URLs (e.g., "http://[broken") are left as written.
+            return tag
+        if parts.scheme not in ("http", "https") or not host or host == site_host:
+            return tag
+
+        policy = resolve_link_policy(settings, host)
+        tag = _set_attribute(
+            tag, _HREF_RE, "href", add_query_params(href, policy["utm"])
+        )
+        if policy["rel"]:
+            rel_match = _REL_RE.search(tag)
+            tokens = rel_match.group(2).split() if rel_match else []
+            tokens.extend(
+                token for token in policy["rel"].split() if token not in tokens
+            )
+            tag = _set_attribute(tag, _REL_RE, "rel", " ".join(tokens))
+        if policy["target"] and _TARGET_RE.search(tag) is None:
+            tag = _set_attribute(tag, _TARGET_RE, "target", policy["target"])
+        return tag
+
+    return _ANCHOR_TAG_RE.sub(decorate, html)
+
+
+@register_artifact_generator("outbound_links")
+class OutboundLinkDecorator(BaseArtifactGenerator):
+    """Applies the configured outbound link policies to every page."""
+
+    def processes_pages(self, build_context: BuildContext) -> bool:
+        """Pages are processed while the section is enabled."""
+        return build_context.app_config.get("outbound_links", {}).get(
+            "enabled", False
+        )
+
+    def process_page(
+        self, output_path: str, html: str, build_context: BuildContext
+    ) -> str:
+        """Decorates the page's outbound links if the section is enabled."""
+        settings = build_context.app_config.get("outbound_links", {})
+        if not settings.get("enabled", False):
+            return html
+        base_url = build_context.app_config.get("base_url", "")
+        site_host = urlsplit(base_url).hostname if base_url else None
+        return decorate_outbound_links(html, settings, site_host)
@@ -4,17 +4,26 @@ Provides the `DefaultPageBuilder` for assembling final HTML pages.
 This module includes functionality to extract structural parts from a base
 HTML template, and then assemble these parts with translated content,
 main content, and language-specific attributes to form a complete HTML page.
+Pages can also be written to a stream as they render, with the main content
+streamed into its place in the base template (`write_translated_page`).
 """
 
 import logging
-from typing import Any, Dict, List, Optional
+from typing import IO, Any, Callable, Dict, List, Optional
 
 from jinja2 import Environment
 
+from generated.seo_meta_pb2 import SeoMeta
+
 from .interfaces import PageBuilder, TranslationProvider, Translations
+from .seo import build_meta_tags, resolve_page_title
 
 logger = logging.getLogger(__name__)
 
+# Stands in for the main content while the base template is streamed; the
+# content is written where the marker comes out.
+MAIN_CONTENT_MARKER = "<!--landing:main-content-->"
+
 
 class PageAssemblyError(Exception):
     """Custom exception for errors during page assembly."""
@@ -50,6 +59,10 @@ class DefaultPageBuilder(PageBuilder):
             List[Dict[str, Any]]
         ] = None,  # Processed navigation items
         page_title: Optional[str] = None,
+        extra_context: Optional[Dict[str, Any]] = None,
+        seo_meta: Optional[SeoMeta] = None,
+        page_url: Optional[str] = None,
+        canonical_url: Optional[str] = None,
     ) -> str:
         """Assembles a full HTML page using a Jinja2 base template.
 
@@ -60,20 +73,119 @@ class DefaultPageBuilder(PageBuilder):
                           (already rendered blocks).
             navigation_items: Optional list of navigation item dictionaries for the header.
             page_title: Optional title for the page.
-
+            extra_context: Optional additional template variables (e.g., feed
+                           links). They cannot override the core variables
+                           set by this builder.
+            seo_meta: Optional resolved SEO metadata for the page. When given,
+                      its title (with `title_suffix`) replaces `page_title`
+                      and description, OpenGraph and Twitter Card meta tags
+                      are emitted.
+            page_url: Optional absolute URL of the page, used for `og:url`,
+                      absolute image URLs and, unless `canonical_url` is
+                      given, the canonical link.
+            canonical_url: Optional absolute URL for the canonical link.
 
         Returns:
             The complete HTML string for the translated page.
         """
         base_template = self.jinja_env.get_template("base.html")
+        context = self._page_context(
+            lang,
+            translations,
+            main_content,
+            navigation_items,
+            page_title,
+            extra_context,
+            seo_meta,
+            page_url,
+            canonical_url,
+        )
+        return str(base_template.render(context))
+
+    def write_translated_page(
+        self,
+        out: IO[str],
+        lang: str,
+        translations: Translations,
+        write_main_content: Callable[[IO[str]], None],
+        navigation_items: Optional[List[Dict[str, Any]]] = None,
+        page_title: Optional[str] = None,
+        extra_context: Optional[Dict[str, Any]] = None,
+        seo_meta: Optional[SeoMeta] = None,
+        page_url: Optional[str] = None,
+        canonical_url: Optional[str] = None,
+    ) -> None:
+        """Writes a full HTML page to `out` as the base template renders.
+
+        The template renders `MAIN_CONTENT_MARKER` as the main content;
+        `write_main_content` is called in its place, so the blocks stream
+        straight into `out` as well.
 
-        context = {
+        Args:
+            out: The text stream the page is written to.
+            write_main_content: Called with `out` to write the main content.
+            Other arguments: As for `assemble_translated_page`.
+
+        Raises:
+            PageAssemblyError: If the base template does not output the main
+                               content exactly once.
+        """
+        base_template = self.jinja_env.get_template("base.html")
+        context = self._page_context(
+            lang,
+            translations,
+            MAIN_CONTENT_MARKER,
+            navigation_items,
+            page_title,
+            extra_context,
+            seo_meta,
+            page_url,
+            canonical_url,
+        )
+        markers = 0
+        for chunk in base_template.generate(context):
+            if MAIN_CONTENT_MARKER not in chunk:
+                out.write(chunk)
+                continue
+            markers += chunk.count(MAIN_CONTENT_MARKER)
+            before, after = chunk.split(MAIN_CONTENT_MARKER, 1)
+            out.write(before)
+            write_main_content(out)
+            out.write(after)
+        if markers != 1:
+            raise PageAssemblyError(
+                f"base.html output the main content {markers} times, expected once."
+            )
+
+    def _page_context(
+        self,
+        lang: str,
+        translations: Translations,
+        main_content: str,
+        navigation_items: Optional[List[Dict[str, Any]]],
+        page_title: Optional[str],
+        extra_context: Optional[Dict[str, Any]],
+        seo_meta: Optional[SeoMeta],
+        page_url: Optional[str],
+        canonical_url: Optional[str],
+    ) -> Dict[str, Any]:
+        """Builds the base template's variables."""
+        title = page_title or translations.get("default_page_title", "Landing Page")
+        meta_tags = []
+        if seo_meta is not None:
+            meta_tags = build_meta_tags(
+                seo_meta, translations, lang, title, page_url or ""
+            )
+            title = resolve_page_title(seo_meta, translations, title)
+
+        return {
+            **(extra_context or {}),
             "lang": lang,
-            "title": page_title
-            or translations.get("default_page_title", "Landing Page"),
+            "title": title,
             "translations": translations,
             "main_content": main_content,
             "navigation_items": navigation_items or [],
+            "meta_tags": meta_tags,
+            "canonical_url": canonical_url or page_url or "",
             # Add any other variables your base.html might need
         }
-        return str(base_template.render(context))
@@ -0,0 +1,268 @@
+"""
+Measures page weight and checks it against performance budgets.
+
+After all files are written, the orchestrator measures every generated HTML
+page: the page itself plus each resource it loads (stylesheets, scripts,
+images, media, fonts and icons). Same-site resources are sized from the
+output files; external resources count as requests but their size is
+unknown. Inline `data:` URIs are part of the page's own weight.
+
+Budgets come from the `performance_budgets` section of `public/config.json`.
+Each one is optional; sizes are in kilobytes (1 KB = 1024 bytes):
+
+    "performance_budgets": {
+      "enabled": true,
+      "strict": false,
+      "report": true,
+      "budgets": {
+        "page_weight_kb": 500,
+        "requests": 30,
+        "bundle_kb": 150,
+        "image_kb": 200
+      }
+    }
+
+`bundle_kb` applies to every single stylesheet and script, `image_kb` to the
+largest image of a page. Violations are reported as `page-weight`,
+`request-count`, `oversized-bundle` and `oversized-image` warnings (see
+`issues.py`); with `strict` they are errors, which fail the build.
+`issue_levels` can set the level of each category instead. With `report` a
+per-page weight breakdown is printed as well.
+"""
+
+import os
+from html.parser import HTMLParser
+from typing import Any, Dict, List, NamedTuple, Optional, Set, Tuple
+from urllib.parse import unquote, urlsplit
+
+from .issues import WARNING, Issue
+from .site_urls import absolute_url
+
+KILOBYTE = 1024
+DOCUMENT = "document"
+STYLESHEET = "stylesheet"
+SCRIPT = "script"
+IMAGE = "image"
+MEDIA = "media"
+FONT = "font"
+OTHER = "other"
+BUNDLE_KINDS = {STYLESHEET, SCRIPT}
+ICON_RELS = {"icon", "shortcut", "apple-touch-icon", "mask-icon"}
+PRELOAD_KINDS = {
+    "style": STYLESHEET,
+    "script": SCRIPT,
+    "image": IMAGE,
+    "font": FONT,
+    "video": MEDIA,
+    "audio": MEDIA,
+}
+
+
+class PageResource(NamedTuple):
+    """A file loaded by a page."""
+
+    url: str
+    kind: str
+    size: Optional[int]  # In bytes; None for external or missing files
+
+
+class PageWeight(NamedTuple):
+    """A page and everything it loads, the page itself first."""
+
+    page: str
+    resources: List[PageResource]
+
+    @property
+    def total_bytes(self) -> int:
+        """The summed size of all resources with a known size."""
+        return sum(resource.size or 0 for resource in self.resources)
+
+    @property
+    def requests(self) -> int:
+        """The number of requests needed to load the page."""
+        return len(self.resources)
+
+
+class PageResourceCollector(HTMLParser):
+    """Collects the URLs and kinds of the resources a rendered page loads."""
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.resources: List[Tuple[str, str]] = []
+        self._seen: Set[str] = set()
+
+    def handle_starttag(
+        self, tag: str, attrs: List[Tuple[str, Optional[str]]]
+    ) -> None:
+        attributes = {name: value or "" for name, value in attrs}
+        if tag == "link":
+            rels = set(attributes.get("rel", "").lower().split())
+            if "stylesheet" in rels:
+                self._add(attributes.get("href", ""), STYLESHEET)
+            elif rels & ICON_RELS:
+                self._add(attributes.get("href", ""), IMAGE)
+            elif "preload" in rels:
+                kind = PRELOAD_KINDS.get(attributes.get("as", "").lower(), OTHER)
+                self._add(attributes.get("href", ""), kind)
+            elif "modulepreload" in rels:
+                self._add(attributes.get("href", ""), SCRIPT)
+        elif tag == "script":
+            self._add(attributes.get("src", ""), SCRIPT)
+        elif tag == "img":
+            self._add(attributes.get("src", ""), IMAGE)
+        elif tag in ("video", "audio", "source"):
+            self._add(attributes.get("src", ""), MEDIA)
+            self._add(attributes.get("poster", ""), IMAGE)
+        elif tag == "iframe":
+            self._add(attributes.get("src", ""), OTHER)
+
+    def _add(self, url: str, kind: str) -> None:
+        url = url.strip()
+        if not url or url.startswith(("data:", "#", "about:")) or url in self._seen:
+            return
+        self._seen.add(url)
+        self.resources.append((url, kind))
+
+
+def collect_page_resources(html: str) -> List[Tuple[str, str]]:
+    """Returns the unique `(url, kind)` pairs a rendered page loads."""
+    collector = PageResourceCollector()
+    collector.feed(html)
+    collector.close()
+    return collector.resources
+
+
+def local_resource_path(url: str, page_path: str, base_url: str) -> Optional[str]:
+    """Maps a resource URL to the output file serving it.
+
+    Args:
+        url: The URL as written in the page.
+        page_path: The output path of the page that references it.
+        base_url: The public root of the site; may be empty.
+
+    Returns:
+        The output path, or None if the resource is on another site or its
+        URL is malformed (e.g., "http://[broken").
+    """
+    site_root = absolute_url(base_url, "") if base_url else ""
+    if site_root and url.startswith(site_root):
+        url = url[len(site_root) :]
+    try:
+        parts = urlsplit(url)
+    except ValueError:
+        return None
+    if parts.scheme or parts.netloc:
+        return None
+    path = unquote(parts.path)
+    if path.startswith("/"):
+        site_path = urlsplit(site_root).path if site_root else "/"
+        if path.startswith(site_path):
+            path = path[len(site_path) :]
+        return os.path.normpath(path.lstrip("/"))
+    return os.path.normpath(os.path.join(os.path.dirname(page_path), path))
+
+
+def _file_size(path: Optional[str]) -> Optional[int]:
+    """Returns the size of an output file, or None if it does not exist."""
+    if path is None or not os.path.isfile(path):
+        return None
+    return os.path.getsize(path)
+
+
+def measure_page(page_path: str, html: str, base_url: str) -> PageWeight:
+    """Measures a page and every resource it loads.
+
+    Args:
+        page_path: The output path of the page.
+        html: The page's final content.
+        base_url: The public root of the site; may be empty.
+
+    Returns:
+        The page weight, with the page itself as the first resource.
+    """
+    resources = [PageResource(page_path, DOCUMENT, len(html.encode("utf-8")))]
+    for url, kind in collect_page_resources(html):
+        local_path = local_resource_path(url, page_path, base_url)
+        resources.append(PageResource(url, kind, _file_size(local_path)))
+    return PageWeight(page_path, resources)
+
+
+def check_budgets(
+    weight: PageWeight, budgets: Dict[str, Any], severity: str = WARNING
+) -> List[Issue]:
+    """Returns an issue of `severity` for every budget the page exceeds."""
+    violations: List[Issue] = []
+
+    def violation(category: str, message: str, suggestion: str) -> None:
+        violations.append(
+            Issue(
+                severity,
+                category,
+                f"Performance budget exceeded: {message}",
+                "performance",
+                file=weight.page,
+                suggestion=suggestion,
+            )
+        )
+
+    page_weight_kb = budgets.get("page_weight_kb")
+    if page_weight_kb is not None and weight.total_bytes > page_weight_kb * KILOBYTE:
+        violation(
+            "page-weight",
+            f"page weight {_format_kb(weight.total_bytes)} exceeds "
+            f"{page_weight_kb} KB.",
+            "Load less, or lazy-load what is below the fold.",
+        )
+    max_requests = budgets.get("requests")
+    if max_requests is not None and weight.requests > max_requests:
+        violation(
+            "request-count",
+            f"{weight.requests} requests exceed {max_requests}.",
+            "Bundle or inline small resources.",
+        )
+    bundle_kb = budgets.get("bundle_kb")
+    if bundle_kb is not None:
+        for resource in weight.resources:
+            size = resource.size or 0
+            if resource.kind in BUNDLE_KINDS and size > bundle_kb * KILOBYTE:
+                violation(
+                    "oversized-bundle",
+                    f"{resource.kind} {resource.url} is "
+                    f"{_format_kb(size)}, over {bundle_kb} KB.",
+                    "Minify or split it.",
+                )
+    image_kb = budgets.get("image_kb")
+    images = [
+        resource
+        for resource in weight.resources
+        if resource.kind == IMAGE and resource.size is not None
+    ]
+    if image_kb is not None and images:
+        largest = max(images, key=lambda resource: resource.size or 0)
+        if (largest.size or 0) > image_kb * KILOBYTE:
+            violation(
+                "oversized-image",
+                f"largest image {largest.url} is "
+                f"{_format_kb(largest.size or 0)}, over {image_kb} KB.",
+                "Resize or compress it, or serve a modern format.",
+            )
+    return violations
+
+
+def _format_kb(size: int) -> str:
+    """Formats a byte count as kilobytes with one decimal."""
+    return f"{size / KILOBYTE:.1f} KB"
+
+
+def format_weight_breakdown(weight: PageWeight) -> str:
+    """Formats a page's weight as a table, largest resources first."""
+    lines = [
+        f"{weight.page}: {_format_kb(weight.total_bytes)} in "
+        f"{weight.requests} requests"
+    ]
+    for resource in sorted(
+        weight.resources, key=lambda resource: resource.size or -1, reverse=True
+    ):
+        size = _format_kb(resource.size) if resource.size is not None else "n/a"
+        lines.append(f"  {resource.kind:<10} {size:>10}  {resource.url}")
+    return "\n".join(lines)
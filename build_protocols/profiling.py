@@ -0,0 +1,115 @@
+"""
+Writes profiles of a build for the standard Python profiling tools.
+
+`python build.py` accepts three flags to diagnose slow builds:
+
+- `--cpuprofile build.prof`: a `cProfile` profile of the whole build. Read
+  it with `python -m pstats build.prof` or a viewer such as `snakeviz`.
+- `--memprofile build.mem`: a `tracemalloc` snapshot of the memory
+  allocated at the end of the build, e.g.
+  `tracemalloc.Snapshot.load("build.mem").statistics("lineno")[:10]`.
+- `--trace build.trace.json`: the build phases timed by `BuildTimer` (see
+  `build_metrics.py`) in the Trace Event format of `chrome://tracing` and
+  https://ui.perfetto.dev, one row per phase kind.
+"""
+
+import cProfile
+import json
+import logging
+import os
+import tracemalloc
+from contextlib import contextmanager
+from typing import Any, Dict, Iterator, Optional
+
+from .build_metrics import BuildTimer
+
+logger = logging.getLogger(__name__)
+
+# Keeps enough of each allocation's stack to tell callers apart.
+MEMPROFILE_FRAMES = 10
+TRACE_PID = 1
+
+
+@contextmanager
+def profiled(
+    cpuprofile: Optional[str] = None, memprofile: Optional[str] = None
+) -> Iterator[None]:
+    """Profiles the enclosed code and writes the requested profiles.
+
+    The profiles are written even if the code raises, since failed builds
+    are often the ones worth looking at.
+    """
+    profiler = cProfile.Profile() if cpuprofile else None
+    if memprofile:
+        tracemalloc.start(MEMPROFILE_FRAMES)
+    if profiler is not None:
+        profiler.enable()
+    try:
+        yield
+    finally:
+        if profiler is not None:
+            profiler.disable()
+            _ensure_directory(cpuprofile)
+            profiler.dump_stats(cpuprofile)
+            logger.info("Wrote CPU profile", extra={"file": cpuprofile})
+        if memprofile:
+            snapshot = tracemalloc.take_snapshot()
+            tracemalloc.stop()
+            _ensure_directory(memprofile)
+            snapshot.dump(memprofile)
+            logger.info("Wrote memory profile", extra={"file": memprofile})
+
+
+def trace_events(timer: BuildTimer) -> Dict[str, Any]:
+    """Converts the timed phases to Trace Event format "complete" events."""
+    phase_rows: Dict[str, int] = {}
+    events = []
+    for timing in timer.timings:
+        row = phase_rows.setdefault(timing.phase, len(phase_rows) + 1)
+        labels = {
+            name: value
+            for name, value in (
+                ("component", timing.component),
+                ("lang", timing.lang),
+                ("block", timing.block),
+            )
+            if value
+        }
+        events.append(
+            {
+                "name": " ".join([timing.phase, *labels.values()]),
+                "cat": timing.phase,
+                "ph": "X",
+                "ts": round(timing.offset_seconds * 1_000_000),
+                "dur": round(timing.seconds * 1_000_000),
+                "pid": TRACE_PID,
+                "tid": row,
+                "args": labels,
+            }
+        )
+    # Names the rows after their phase kinds.
+    events.extend(
+        {
+            "name": "thread_name",
+            "ph": "M",
+            "pid": TRACE_PID,
+            "tid": row,
+            "args": {"name": phase},
+        }
+        for phase, row in phase_rows.items()
+    )
+    return {"traceEvents": events, "displayTimeUnit": "ms"}
+
+
+def write_trace(path: str, timer: BuildTimer) -> None:
+    """Writes the build phases as a Trace Event file."""
+    _ensure_directory(path)
+    with open(path, "w", encoding="utf-8") as f:
+        json.dump(trace_events(timer), f)
+    logger.info("Wrote build trace", extra={"file": path})
+
+
+def _ensure_directory(path: str) -> None:
+    directory = os.path.dirname(path)
+    if directory:
+        os.makedirs(directory, exist_ok=True)
@@ -0,0 +1,304 @@
+"""
+Rebuilds the site when a CMS or repository reports a content change.
+
+The backend receives webhooks at `/api/webhooks/<provider>`, checks that
+they come from the provider and queues a rebuild. Bursts of events (e.g., a
+CMS publishing many entries at once) are debounced into one build, and
+events arriving during a build queue exactly one more. The CMS can poll
+`GET /api/rebuild/status` for the state of the queue. It is configured by
+`backend.rebuild` in `public/config.json`:
+
+    "rebuild": {
+      "enabled": true,
+      "debounce_seconds": 5,
+      "command": ["sh", "-c", "git pull && python build.py"],
+      "timeout_seconds": 1800,
+      "providers": {
+        "github": { "secret_env": "GITHUB_WEBHOOK_SECRET" },
+        "contentful": { "secret_env": "CONTENTFUL_WEBHOOK_SECRET" },
+        "strapi": { "secret_env": "STRAPI_WEBHOOK_SECRET" }
+      }
+    }
+
+Each provider proves itself with the secret read from its `secret_env`:
+
+- `github`: the `X-Hub-Signature-256` HMAC-SHA256 of the body. `ping`
+  events are acknowledged without a rebuild.
+- `contentful`: the `X-Contentful-Signature` request verification
+  signature, which also covers the signed headers and a timestamp younger
+  than `max_age_seconds` (default 30).
+- `strapi`: Strapi sends the configured webhook headers as is, so the
+  secret must be the value of its `header` (default `Authorization`),
+  e.g. "Bearer <secret>".
+
+A rebuild runs `command` when set (e.g., to pull new content first, or to
+deploy afterwards) and the build in the backend process otherwise. That
+build keeps its caches between rebuilds (see `build.incremental_build`),
+so a webhook only redoes what the changed content affects. A command running longer
+than `timeout_seconds` is killed and the rebuild fails, so a hung `git
+pull` or deploy does not hold up the queue forever.
+"""
+
+import hashlib
+import hmac
+import logging
+import os
+import subprocess
+import threading
+import time
+from datetime import datetime, timezone
+from http import HTTPStatus
+from typing import Any, Callable, Dict, List, Optional
+
+from .backend import BackendRequest, BackendResponse, json_response
+
+logger = logging.getLogger(__name__)
+
+WEBHOOK_PATH = "/api/webhooks/{provider}"
+REBUILD_STATUS_PATH = "/api/rebuild/status"
+IDLE = "idle"
+PENDING = "pending"
+BUILDING = "building"
+
+Verifier = Callable[[BackendRequest, str, Dict[str, Any]], bool]
+
+
+def _hmac_sha256(secret: str, message: bytes) -> bytes:
+    return hmac.new(secret.encode("utf-8"), message, hashlib.sha256).digest()
+
+
+def verify_github(
+    request: BackendRequest, secret: str, settings: Dict[str, Any]
+) -> bool:
+    """Checks GitHub's `X-Hub-Signature-256` header."""
+    expected = "sha256=" + _hmac_sha256(secret, request.body).hex()
+    return hmac.compare_digest(
+        request.headers.get("x-hub-signature-256", "").encode("utf-8"),
+        expected.encode("utf-8"),
+    )
+
+
+def verify_contentful(
+    request: BackendRequest,
+    secret: str,
+    settings: Dict[str, Any],
+    now: Optional[float] = None,
+) -> bool:
+    """Checks Contentful's request verification signature."""
+    signed_headers = [
+        name.strip().lower()
+        for name in request.headers.get("x-contentful-signed-headers", "").split(",")
+        if name.strip()
+    ]
+    if "x-contentful-timestamp" not in signed_headers:
+        return False
+    try:
+        timestamp_ms = int(request.headers.get("x-contentful-timestamp", ""))
+    except ValueError:
+        return False
+    now = time.time() if now is None else now
+    if abs(now - timestamp_ms / 1000) > float(settings.get("max_age_seconds", 30)):
+        return False
+
+    # Signed as sent: parsing the query would decode, reorder or merge it.
+    path = request.path
+    if request.query_string:
+        path += "?" + request.query_string
+    canonical = "\n".join(
+        [
+            request.method,
+            path,
+            ";".join(
+                f"{name}:{request.headers.get(name, '')}" for name in signed_headers
+            ),
+            request.body.decode("utf-8", errors="replace"),
+        ]
+    )
+    expected = _hmac_sha256(secret, canonical.encode("utf-8")).hex()
+    return hmac.compare_digest(
+        request.headers.get("x-contentful-signature", "").encode("utf-8"),
+        expected.encode("utf-8"),
+    )
+
+
+def verify_strapi(
+    request: BackendRequest, secret: str, settings: Dict[str, Any]
+) -> bool:
+    """Checks the secret header configured for a Strapi webhook."""
+    header = settings.get("header", "Authorization").lower()
+    return hmac.compare_digest(
+        request.headers.get(header, "").encode("utf-8"), secret.encode("utf-8")
+    )
+
+
+WEBHOOK_VERIFIERS: Dict[str, Verifier] = {
+    "github": verify_github,
+    "contentful": verify_contentful,
+    "strapi": verify_strapi,
+}
+
+
+def run_command_build(
+    command: List[str], timeout_seconds: Optional[float] = None
+) -> Callable[[], None]:
+    """Returns a build that runs an external command.
+
+    Raises:
+        subprocess.CalledProcessError: From the returned build, if the
+            command fails.
+        subprocess.TimeoutExpired: From the returned build, if the command
+            ran longer than `timeout_seconds` and was killed.
+    """
+
+    def build() -> None:
+        subprocess.run(command, check=True, timeout=timeout_seconds)
+
+    return build
+
+
+def _timestamp(seconds: Optional[float]) -> Optional[str]:
+    if seconds is None:
+        return None
+    return datetime.fromtimestamp(seconds, timezone.utc).isoformat(timespec="seconds")
+
+
+class RebuildQueue:
+    """Runs a build on a worker thread after events stop arriving."""
+
+    def __init__(self, build: Callable[[], None], debounce_seconds: float = 5.0):
+        """
+        Args:
+            build: Runs one build; exceptions mark the build as failed.
+            debounce_seconds: How long to wait for further events before
+                building.
+        """
+        self.build = build
+        self.debounce_seconds = debounce_seconds
+        self.state = IDLE
+        self.queued_events = 0
+        self.builds = 0
+        self.last_event_at: Optional[float] = None
+        self.last_started_at: Optional[float] = None
+        self.last_finished_at: Optional[float] = None
+        self.last_result: Optional[str] = None
+        self._condition = threading.Condition()
+        self._worker: Optional[threading.Thread] = None
+        self._worker_running = False
+
+    def request(self, source: str) -> None:
+        """Queues a rebuild for an event."""
+        with self._condition:
+            self.queued_events += 1
+            self.last_event_at = time.time()
+            if self.state == IDLE:
+                self.state = PENDING
+            logger.info("Rebuild requested by %s.", source)
+            if not self._worker_running:
+                self._worker_running = True
+                self._worker = threading.Thread(target=self._run, daemon=True)
+                self._worker.start()
+            self._condition.notify_all()
+
+    def _run(self) -> None:
+        """Builds until no events are left."""
+        while True:
+            with self._condition:
+                if not self.queued_events:
+                    self.state = IDLE
+                    self._worker_running = False
+                    return
+                # Wait until no event arrived for debounce_seconds.
+                while True:
+                    quiet_for = time.time() - (self.last_event_at or 0)
+                    if quiet_for >= self.debounce_seconds:
+                        break
+                    self._condition.wait(self.debounce_seconds - quiet_for)
+                events, self.queued_events = self.queued_events, 0
+                self.state = BUILDING
+                self.last_started_at = time.time()
+
+            logger.info("Rebuilding the site for %d event(s).", events)
+            try:
+                self.build()
+                result = "success"
+            except Exception:  # pylint: disable=broad-except
+                logger.exception("Rebuild failed.")
+                result = "failed"
+
+            with self._condition:
+                self.builds += 1
+                self.last_result = result
+                self.last_finished_at = time.time()
+                self.state = PENDING if self.queued_events else IDLE
+
+    def wait_until_idle(self, timeout: Optional[float] = None) -> bool:
+        """Waits for queued rebuilds to finish; returns whether they did."""
+        worker = self._worker
+        if worker is not None:
+            worker.join(timeout)
+        return self.state == IDLE
+
+    def status(self) -> Dict[str, Any]:
+        """Describes the queue for the status endpoint."""
+        with self._condition:
+            return {
+                "state": self.state,
+                "queued_events": self.queued_events,
+                "builds": self.builds,
+                "last_event_at": _timestamp(self.last_event_at),
+                "last_started_at": _timestamp(self.last_started_at),
+                "last_finished_at": _timestamp(self.last_finished_at),
+                "last_result": self.last_result,
+            }
+
+
+class WebhookHandler:
+    """Verifies a provider's webhooks and queues rebuilds for them."""
+
+    def __init__(
+        self,
+        provider: str,
+        settings: Dict[str, Any],
+        queue: RebuildQueue,
+    ):
+        """
+        Args:
+            provider: One of `WEBHOOK_VERIFIERS`.
+            settings: The provider's section of `backend.rebuild.providers`.
+            queue: Receives the rebuild requests.
+
+        Raises:
+            ValueError: If the provider is unknown or has no secret.
+        """
+        if provider not in WEBHOOK_VERIFIERS:
+            raise ValueError(f"Unknown webhook provider '{provider}'.")
+        self.provider = provider
+        self.verify = WEBHOOK_VERIFIERS[provider]
+        self.settings = settings
+        self.secret = os.environ.get(settings.get("secret_env", ""), "")
+        if not self.secret:
+            raise ValueError(
+                f"No secret for {provider} webhooks in ${settings.get('secret_env')}."
+            )
+        self.queue = queue
+
+    def __call__(self, request: BackendRequest) -> BackendResponse:
+        if not self.verify(request, self.secret, self.settings):
+            logger.warning("Rejected a %s webhook with a bad signature.", self.provider)
+            return json_response(HTTPStatus.UNAUTHORIZED, {"error": "Bad signature"})
+        if request.headers.get("x-github-event") == "ping":
+            return json_response(HTTPStatus.OK, {"ok": True})
+        self.queue.request(self.provider)
+        return json_response(HTTPStatus.ACCEPTED, self.queue.status())
+
+
+class RebuildStatusHandler:
+    """Reports the state of the rebuild queue."""
+
+    def __init__(self, queue: RebuildQueue):
+        self.queue = queue
+
+    def __call__(self, request: BackendRequest) -> BackendResponse:
+        response = json_response(HTTPStatus.OK, self.queue.status())
+        response.headers.append(("Cache-Control", "no-store"))
+        return response
@@ -0,0 +1,178 @@
+"""
+Writes redirect rules in the formats understood by common static hosts.
+
+Renaming a page or moving a file breaks old URLs unless the host redirects
+them. The `redirects` section of `public/config.json` lists the rules once
+and the `RedirectArtifactGenerator` writes them out per host:
+
+- `netlify`: `_redirects`
+- `vercel`: `vercel.json` (`redirects`, and `rewrites` for status 200)
+- `htaccess`: `.htaccess` (Apache `mod_rewrite`)
+- `nginx`: `nginx-redirects.conf`, to be included in a `server` block
+
+    "redirects": {
+      "enabled": true,
+      "formats": ["netlify", "vercel", "htaccess", "nginx"],
+      "rules": [
+        { "from": "/home.html", "to": "/", "status": 301 }
+      ]
+    }
+
+Paths are exact site-relative paths; `to` may also be an absolute URL. A
+status of 200 serves the target's content under the old path (a rewrite)
+instead of redirecting.
+"""
+
+import json
+import logging
+import re
+from typing import Any, Callable, Dict, List, NamedTuple
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+
+logger = logging.getLogger(__name__)
+
+REDIRECT_STATUSES = {200, 301, 302, 303, 307, 308}
+DEFAULT_STATUS = 301
+
+
+class RedirectRule(NamedTuple):
+    """A validated redirect from a site path to a path or URL."""
+
+    source: str
+    target: str
+    status: int
+
+
+def load_redirect_rules(settings: Dict[str, Any]) -> List[RedirectRule]:
+    """Validates the configured redirect rules.
+
+    Invalid rules (missing paths, unsupported status, sources that are not
+    site-relative, self-redirects and duplicate sources) are skipped with a
+    warning.
+
+    Args:
+        settings: The `redirects` section of the app config.
+
+    Returns:
+        The valid rules in configuration order.
+    """
+    rules: List[RedirectRule] = []
+    seen_sources = set()
+    for raw_rule in settings.get("rules", []):
+        source = str(raw_rule.get("from", "")).strip()
+        target = str(raw_rule.get("to", "")).strip()
+        status = raw_rule.get("status", DEFAULT_STATUS)
+
+        if not source or not target:
+            logger.warning(
+                "Redirect rule %s needs 'from' and 'to'. Skipping.", raw_rule
+            )
+            continue
+        if not source.startswith("/"):
+            logger.warning(
+                "Redirect source '%s' must start with '/'. Skipping.", source
+            )
+            continue
+        if status not in REDIRECT_STATUSES:
+            logger.warning(
+                "Unsupported status %s for redirect '%s'. Skipping.", status, source
+            )
+            continue
+        if source == target:
+            logger.warning("Redirect '%s' points to itself. Skipping.", source)
+            continue
+        if source in seen_sources:
+            logger.warning("Duplicate redirect source '%s'. Skipping.", source)
+            continue
+
+        seen_sources.add(source)
+        rules.append(RedirectRule(source, target, status))
+    return rules
+
+
+def format_netlify(rules: List[RedirectRule]) -> str:
+    """Formats rules as a Netlify `_redirects` file."""
+    return "".join(f"{rule.source} {rule.target} {rule.status}\n" for rule in rules)
+
+
+def format_vercel(rules: List[RedirectRule]) -> str:
+    """Formats rules as a `vercel.json` configuration."""
+    config: Dict[str, List[Dict[str, Any]]] = {"redirects": [], "rewrites": []}
+    for rule in rules:
+        if rule.status == 200:
+            config["rewrites"].append(
+                {"source": rule.source, "destination": rule.target}
+            )
+        else:
+            config["redirects"].append(
+                {
+                    "source": rule.source,
+                    "destination": rule.target,
+                    "statusCode": rule.status,
+                }
+            )
+    non_empty = {key: value for key, value in config.items() if value}
+    return json.dumps(non_empty, indent=2) + "\n"
+
+
+def format_htaccess(rules: List[RedirectRule]) -> str:
+    """Formats rules as Apache `mod_rewrite` directives for `.htaccess`."""
+    lines = ["RewriteEngine On"]
+    for rule in rules:
+        pattern = f"^{re.escape(rule.source.lstrip('/'))}$"
+        flags = "L" if rule.status == 200 else f"R={rule.status},L"
+        lines.append(f"RewriteRule {pattern} {rule.target} [{flags}]")
+    return "\n".join(lines) + "\n"
+
+
+def format_nginx(rules: List[RedirectRule]) -> str:
+    """Formats rules as nginx directives for inclusion in a `server` block."""
+    lines = []
+    for rule in rules:
+        if rule.status == 200:
+            lines.append(f"rewrite ^{re.escape(rule.source)}$ {rule.target} last;")
+        else:
+            lines.append(
+                f"location = {rule.source} {{ return {rule.status} {rule.target}; }}"
+            )
+    return "\n".join(lines) + "\n"
+
+
+REDIRECT_FORMATS: Dict[str, Callable[[List[RedirectRule]], str]] = {
+    "netlify": format_netlify,
+    "vercel": format_vercel,
+    "htaccess": format_htaccess,
+    "nginx": format_nginx,
+}
+
+REDIRECT_FILENAMES: Dict[str, str] = {
+    "netlify": "_redirects",
+    "vercel": "vercel.json",
+    "htaccess": ".htaccess",
+    "nginx": "nginx-redirects.conf",
+}
+
+
+@register_artifact_generator("redirects")
+class RedirectArtifactGenerator(BaseArtifactGenerator):
+    """Writes the configured redirects for each configured host format."""
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates one redirect file per configured format."""
+        settings = build_context.app_config.get("redirects", {})
+        if not settings.get("enabled", False):
+            return {}
+
+        rules = load_redirect_rules(settings)
+        artifacts: Dict[str, str] = {}
+        for redirect_format in settings.get("formats", list(REDIRECT_FORMATS)):
+            formatter = REDIRECT_FORMATS.get(redirect_format)
+            if formatter is None:
+                logger.warning(
+                    "Unknown redirect format '%s'. Skipping.", redirect_format
+                )
+                continue
+            artifacts[REDIRECT_FILENAMES[redirect_format]] = formatter(rules)
+        return artifacts
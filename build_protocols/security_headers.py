@@ -0,0 +1,360 @@
+"""
+Generates security header files for static hosts.
+
+The `SecurityHeadersGenerator` turns the `security_headers` section of
+`public/config.json` into host-specific header files:
+
+- `netlify`: `_headers` (also understood by Cloudflare Pages)
+- `nginx`: `nginx-headers.conf`, to be included in a `server` block
+- `caddy`: `Caddyfile.headers`, to be imported into a site block
+
+The Content-Security-Policy is built from the configured directives plus the
+script, style and frame sources found in the pages this build actually
+rendered: external origins, `'self'` for same-site files and a `'sha256-…'`
+hash for every inline `<script>`/`<style>` element. A captcha widget's
+script also allows the origins its provider loads scripts, frames and
+styles from (see `CaptchaProvider.csp_sources` in `spam_protection.py`).
+Static hosts cannot send headers everywhere (e.g., GitHub Pages), so with
+`meta_fallback` each page also gets `<meta http-equiv="Content-Security-Policy">`
+and `<meta name="referrer">` tags. Browsers ignore some directives in meta
+tags (`frame-ancestors`, `report-uri`, `sandbox`) and all other headers,
+which is why the header files remain the primary mechanism.
+
+Staging builds (see `staging.py`) add `X-Robots-Tag: noindex, nofollow` to
+every format and the staging password as a `Basic-Auth` rule to `_headers`.
+
+    "security_headers": {
+      "enabled": true,
+      "formats": ["netlify", "nginx", "caddy"],
+      "meta_fallback": true,
+      "headers": {
+        "Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+        "X-Frame-Options": "DENY",
+        "Referrer-Policy": "strict-origin-when-cross-origin"
+      },
+      "csp": {
+        "default-src": ["'self'"],
+        "img-src": ["'self'", "data:"]
+      }
+    }
+"""
+
+import base64
+import hashlib
+import html as html_lib
+import logging
+import re
+import threading
+from html.parser import HTMLParser
+from typing import Any, Callable, Dict, List, Optional, Set, Tuple
+from urllib.parse import urlsplit
+
+from jinja2 import Environment
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .spam_protection import CAPTCHA_PROVIDERS
+from .staging import ROBOTS_VALUE, get_staging_settings, staging_credentials
+
+logger = logging.getLogger(__name__)
+
+CSP_HEADER = "Content-Security-Policy"
+META_IGNORED_DIRECTIVES = {"frame-ancestors", "report-uri", "sandbox"}
+# Host names and IP addresses, as lowercased by `urlsplit`.
+CSP_HOST_RE = re.compile(r"[a-z0-9](?:[a-z0-9.-]*[a-z0-9])?|[0-9a-f:.]+")
+# Script types that browsers do not execute (e.g., JSON-LD data blocks).
+EXECUTABLE_SCRIPT_TYPES = {"", "text/javascript", "application/javascript", "module"}
+
+
+def source_expression(url: str) -> Optional[str]:
+    """Returns the CSP source expression that allows loading `url`.
+
+    Relative URLs map to `'self'`, data URIs to `data:` and absolute URLs to
+    their origin (e.g., "https://cdn.example.com"). Malformed URLs, and hosts
+    that are not plain names or IP addresses (which could smuggle directives
+    into the policy, e.g. "https://a.com;script-src *"), map to None: the
+    browser cannot load them from a valid source anyway.
+    """
+    try:
+        parts = urlsplit(url)
+        host, port = parts.hostname, parts.port
+    except ValueError:
+        return None
+    if parts.scheme == "data":
+        return "data:"
+    if not parts.netloc:
+        return "'self'"
+    if host and not host.isascii():
+        try:
+            host = host.encode("idna").decode("ascii")
+        except UnicodeError:
+            return None
+    if not host or not CSP_HOST_RE.fullmatch(host):
+        return None
+    if ":" in host:
+        host = f"[{host}]"
+    scheme = parts.scheme or "https"
+    return f"{scheme}://{host}" + (f":{port}" if port is not None else "")
+
+
+def hash_source(content: str) -> str:
+    """Returns the CSP `'sha256-…'` source for an inline element's content."""
+    digest = hashlib.sha256(content.encode("utf-8")).digest()
+    return f"'sha256-{base64.b64encode(digest).decode('ascii')}'"
+
+
+class PageSourceCollector(HTMLParser):
+    """Collects the script, style and frame sources a rendered page loads."""
+
+    def __init__(self) -> None:
+        super().__init__(convert_charrefs=False)
+        self.script_sources: Set[str] = set()
+        self.style_sources: Set[str] = set()
+        self.frame_sources: Set[str] = set()
+        self.widget_sources: Dict[str, Set[str]] = {}
+        """Sources of third-party widgets loaded by scripts, per directive."""
+        self._inline_kind: Optional[str] = None
+        self._inline_parts: List[str] = []
+
+    def handle_starttag(
+        self, tag: str, attrs: List[Tuple[str, Optional[str]]]
+    ) -> None:
+        attributes = {name: value or "" for name, value in attrs}
+        if tag == "script":
+            script_type = attributes.get("type", "").lower()
+            if script_type == "text/plain" and "data-consent-category" in attributes:
+                # Consent-gated scripts become executable once consent is given.
+                script_type = attributes.get("data-consent-type", "").lower()
+            if script_type not in EXECUTABLE_SCRIPT_TYPES:
+                return
+            if attributes.get("src"):
+                self._add_source(self.script_sources, attributes["src"])
+                self._add_widget_sources(attributes["src"])
+            else:
+                self._start_inline("script")
+        elif tag == "style":
+            self._start_inline("style")
+        elif tag == "link" and "stylesheet" in attributes.get("rel", "").split():
+            if attributes.get("href"):
+                self._add_source(self.style_sources, attributes["href"])
+        elif tag == "iframe" and attributes.get("src"):
+            self._add_source(self.frame_sources, attributes["src"])
+
+    def handle_endtag(self, tag: str) -> None:
+        if tag != self._inline_kind:
+            return
+        source = hash_source("".join(self._inline_parts))
+        if tag == "script":
+            self.script_sources.add(source)
+        else:
+            self.style_sources.add(source)
+        self._inline_kind = None
+
+    def handle_data(self, data: str) -> None:
+        if self._inline_kind is not None:
+            self._inline_parts.append(data)
+
+    @staticmethod
+    def _add_source(sources: Set[str], url: str) -> None:
+        source = source_expression(url)
+        if source is not None:
+            sources.add(source)
+
+    def _add_widget_sources(self, script_url: str) -> None:
+        """Adds a captcha provider's sources if the script is its widget."""
+        script_url = script_url.split("?", 1)[0]
+        for provider in CAPTCHA_PROVIDERS.values():
+            if script_url == provider.script_url:
+                for directive, sources in provider.csp_sources.items():
+                    self.widget_sources.setdefault(directive, set()).update(sources)
+
+    def _start_inline(self, kind: str) -> None:
+        self._inline_kind = kind
+        self._inline_parts = []
+
+
+def collect_page_sources(html: str) -> Dict[str, Set[str]]:
+    """Finds the script, style and frame sources used by a rendered page.
+
+    Returns:
+        A dictionary with `script-src`, `style-src` and `frame-src` source
+        sets, plus `connect-src` when a widget needs it.
+    """
+    collector = PageSourceCollector()
+    collector.feed(html)
+    collector.close()
+    sources = {
+        "script-src": collector.script_sources,
+        "style-src": collector.style_sources,
+        "frame-src": collector.frame_sources,
+    }
+    for directive, widget_sources in collector.widget_sources.items():
+        sources.setdefault(directive, set()).update(widget_sources)
+    return sources
+
+
+def build_csp(
+    directives: Dict[str, List[str]],
+    emitted_sources: Dict[str, Set[str]],
+    for_meta: bool = False,
+) -> str:
+    """Builds a Content-Security-Policy value.
+
+    Args:
+        directives: The configured directives, e.g., {"default-src": ["'self'"]}.
+        emitted_sources: Sources found in the rendered pages, per directive.
+        for_meta: Whether the policy is for a `<meta>` tag, which drops the
+            directives that browsers ignore there.
+
+    Returns:
+        The policy, with directives in configuration order followed by any
+        directive only needed for emitted sources.
+    """
+    merged: Dict[str, List[str]] = {
+        name: list(sources) for name, sources in directives.items()
+    }
+    for name, sources in emitted_sources.items():
+        if not sources:
+            continue
+        values = merged.setdefault(name, [])
+        values.extend(sorted(source for source in sources if source not in values))
+    return "; ".join(
+        " ".join([name, *values]).strip()
+        for name, values in merged.items()
+        if not (for_meta and name in META_IGNORED_DIRECTIVES)
+    )
+
+
+def format_netlify(headers: Dict[str, str]) -> str:
+    """Formats headers as a Netlify `_headers` file applying to every path."""
+    lines = ["/*"]
+    lines.extend(f"  {name}: {value}" for name, value in headers.items())
+    return "\n".join(lines) + "\n"
+
+
+def format_nginx(headers: Dict[str, str]) -> str:
+    """Formats headers as nginx `add_header` directives."""
+    return "".join(
+        f'add_header {name} "{value}" always;\n' for name, value in headers.items()
+    )
+
+
+def format_caddy(headers: Dict[str, str]) -> str:
+    """Formats headers as a Caddy `header` directive."""
+    lines = ["header {"]
+    lines.extend(f'\t{name} "{value}"' for name, value in headers.items())
+    lines.append("}")
+    return "\n".join(lines) + "\n"
+
+
+HEADER_FORMATS: Dict[str, Callable[[Dict[str, str]], str]] = {
+    "netlify": format_netlify,
+    "nginx": format_nginx,
+    "caddy": format_caddy,
+}
+
+HEADER_FILENAMES: Dict[str, str] = {
+    "netlify": "_headers",
+    "nginx": "nginx-headers.conf",
+    "caddy": "Caddyfile.headers",
+}
+
+
+# Last, so the CSP hashes and sources are those of the final page.
+@register_artifact_generator("security_headers", priority=900)
+class SecurityHeadersGenerator(BaseArtifactGenerator):
+    """Writes security header files and optional `<meta>` fallbacks."""
+
+    def __init__(self, jinja_env: Environment):
+        super().__init__(jinja_env)
+        self.page_sources: Dict[str, Dict[str, Set[str]]] = {}
+        self._lock = threading.Lock()  # Pages may be processed concurrently.
+
+    @staticmethod
+    def _get_settings(app_config: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+        """Returns the `security_headers` section if it is enabled."""
+        settings = app_config.get("security_headers", {})
+        return settings if settings.get("enabled", False) else None
+
+    def processes_pages(self, build_context: BuildContext) -> bool:
+        """Pages are processed while the section is enabled."""
+        return self._get_settings(build_context.app_config) is not None
+
+    def process_page(
+        self, output_path: str, html: str, build_context: BuildContext
+    ) -> str:
+        """Records the page's sources and injects the meta fallbacks."""
+        settings = self._get_settings(build_context.app_config)
+        if settings is None:
+            return html
+
+        sources = collect_page_sources(html)
+        with self._lock:
+            self.page_sources[output_path] = sources
+        if not settings.get("meta_fallback", False):
+            return html
+
+        meta_tags = []
+        if settings.get("csp"):
+            policy = build_csp(settings["csp"], sources, for_meta=True)
+            meta_tags.append(
+                f'<meta http-equiv="{CSP_HEADER}" '
+                f'content="{html_lib.escape(policy)}" />'
+            )
+        referrer_policy = settings.get("headers", {}).get("Referrer-Policy")
+        if referrer_policy:
+            escaped_policy = html_lib.escape(referrer_policy)
+            meta_tags.append(f'<meta name="referrer" content="{escaped_policy}" />')
+        if not meta_tags:
+            return html
+
+        # The CSP must precede every script, so insert right after <meta charset>.
+        anchor = re.search(r"<meta charset=[^>]*>", html, re.IGNORECASE)
+        if anchor is None:
+            anchor = re.search(r"<head[^>]*>", html, re.IGNORECASE)
+        if anchor is None:
+            logger.warning(
+                "No <head> in %s; skipping security meta tags.", output_path
+            )
+            return html
+        insertion = "".join(f"\n    {tag}" for tag in meta_tags)
+        return html[: anchor.end()] + insertion + html[anchor.end() :]
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates one header file per configured host format."""
+        settings = self._get_settings(build_context.app_config)
+        if settings is None:
+            return {}
+
+        headers: Dict[str, str] = {}
+        if settings.get("csp"):
+            emitted: Dict[str, Set[str]] = {}
+            with self._lock:
+                page_sources = list(self.page_sources.values())
+            for sources in page_sources:
+                for directive, values in sources.items():
+                    emitted.setdefault(directive, set()).update(values)
+            headers[CSP_HEADER] = build_csp(settings["csp"], emitted)
+        headers.update(settings.get("headers", {}))
+        netlify_headers = headers
+        staging = get_staging_settings(
+            build_context.app_config, build_context.build_profile
+        )
+        if staging is not None:
+            headers["X-Robots-Tag"] = ROBOTS_VALUE
+            credentials = staging_credentials(staging)
+            if credentials is not None:
+                netlify_headers = {**headers, "Basic-Auth": ":".join(credentials)}
+
+        artifacts: Dict[str, str] = {}
+        for header_format in settings.get("formats", list(HEADER_FORMATS)):
+            formatter = HEADER_FORMATS.get(header_format)
+            if formatter is None:
+                logger.warning(
+                    "Unknown headers format '%s'. Skipping.", header_format
+                )
+                continue
+            artifacts[HEADER_FILENAMES[header_format]] = formatter(
+                netlify_headers if header_format == "netlify" else headers
+            )
+        return artifacts
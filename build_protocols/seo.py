@@ -0,0 +1,191 @@
+"""
+Resolves SEO metadata and builds OpenGraph / Twitter Card meta tags.
+
+Metadata comes from a `SeoConfig` message (see `proto/seo_meta.proto`),
+typically loaded from `data/seo.json`. For a given page and language the
+site-wide defaults, the page's own metadata and the page's language override
+are merged in that order, so each level only needs the fields it changes.
+Text fields are `I18nString` keys resolved against the page's translations.
+The resolved title also becomes the page's `<title>`.
+"""
+
+import logging
+from typing import Dict, List, Optional
+from urllib.parse import urljoin
+
+from generated.seo_meta_pb2 import SeoConfig, SeoMeta
+
+from .interfaces import Translations
+from .issues import WARNING, Issue
+
+logger = logging.getLogger(__name__)
+
+# Lengths beyond which search engines usually truncate results.
+TITLE_MAX_LENGTH = 60
+DESCRIPTION_MAX_LENGTH = 160
+
+MetaTag = Dict[str, str]
+"""
+A meta tag as template data: `attribute` ("name" or "property"), `key` (the
+attribute's value, e.g., "og:title") and `content`.
+"""
+
+
+def resolve_seo_meta(
+    seo_config: Optional[SeoConfig], page_id: str, lang: str
+) -> SeoMeta:
+    """Merges defaults, page metadata and language overrides for a page.
+
+    Args:
+        seo_config: The site's SEO configuration, if any.
+        page_id: The identifier of the page (e.g., "index").
+        lang: The language code of the page.
+
+    Returns:
+        A new SeoMeta message with the effective values. It is empty if no
+        configuration is available.
+    """
+    resolved = SeoMeta()
+    if seo_config is None:
+        return resolved
+
+    resolved.MergeFrom(seo_config.defaults)
+    for page in seo_config.pages:
+        if page.page_id != page_id:
+            continue
+        resolved.MergeFrom(page.meta)
+        if lang in page.lang_overrides:
+            resolved.MergeFrom(page.lang_overrides[lang])
+        break
+    return resolved
+
+
+def _translate(key: str, translations: Translations) -> str:
+    """Returns the translation for a key, or an empty string if unset."""
+    if not key:
+        return ""
+    return translations.get(key, key)
+
+
+def resolve_page_title(
+    seo_meta: SeoMeta, translations: Translations, fallback: str
+) -> str:
+    """Returns the `<title>` text for a page.
+
+    Args:
+        seo_meta: The resolved metadata for the page.
+        translations: The translations for the page language.
+        fallback: The title to use if the metadata has no title.
+
+    Returns:
+        The translated meta title, or `fallback`, followed by the translated
+        `title_suffix` if one is set.
+    """
+    title = _translate(seo_meta.title.key, translations) or fallback
+    return title + _translate(seo_meta.title_suffix.key, translations)
+
+
+def validate_meta_lengths(
+    title: str,
+    description: str,
+    page_id: str,
+    lang: str,
+    page_file: Optional[str] = None,
+) -> List[Issue]:
+    """Finds missing or overlong page titles and descriptions.
+
+    Args:
+        title: The page's `<title>` text.
+        description: The page's meta description.
+        page_id: The identifier of the page, for the issue message.
+        lang: The language code of the page, for the issue message.
+        page_file: The page's output file, if known.
+
+    Returns:
+        A `missing-meta` or `long-meta` issue per problem.
+    """
+    page = f"{page_id} ({lang})"
+    issues: List[Issue] = []
+    for name, value, max_length in (
+        ("title", title, TITLE_MAX_LENGTH),
+        ("description", description, DESCRIPTION_MAX_LENGTH),
+    ):
+        if not value:
+            issues.append(
+                Issue(
+                    WARNING,
+                    "missing-meta",
+                    f"Page {page} has no meta {name}.",
+                    "seo",
+                    file=page_file,
+                    suggestion=f"Set its {name} in the SEO data file.",
+                )
+            )
+        elif len(value) > max_length:
+            issues.append(
+                Issue(
+                    WARNING,
+                    "long-meta",
+                    f"Meta {name} of page {page} is {len(value)} characters "
+                    f"long; search engines may truncate it after {max_length}.",
+                    "seo",
+                    file=page_file,
+                )
+            )
+    return issues
+
+
+def build_meta_tags(
+    seo_meta: SeoMeta,
+    translations: Translations,
+    lang: str,
+    page_title: str = "",
+    page_url: str = "",
+) -> List[MetaTag]:
+    """Builds the description, OpenGraph and Twitter Card meta tags.
+
+    Args:
+        seo_meta: The resolved metadata for the page.
+        translations: The translations for the page language.
+        lang: The language code of the page.
+        page_title: Fallback title if the metadata has no title.
+        page_url: The absolute URL of the page. Used for `og:url` and to
+            make relative image paths absolute; omitted if empty.
+
+    Returns:
+        The meta tags to render, in document order. Tags whose value would be
+        empty are left out.
+    """
+    title = _translate(seo_meta.title.key, translations) or page_title
+    description = _translate(seo_meta.description.key, translations)
+    keywords = _translate(seo_meta.keywords.key, translations)
+
+    image_url = ""
+    image_src = seo_meta.image.src
+    # Social networks cannot fetch inline data URIs or unresolvable paths.
+    if image_src and not image_src.startswith("data:"):
+        image_url = urljoin(page_url, image_src) if page_url else image_src
+    image_alt = _translate(seo_meta.image.alt_text.key, translations)
+
+    candidates = [
+        ("name", "description", description),
+        ("name", "keywords", keywords),
+        ("property", "og:type", seo_meta.og_type or "website"),
+        ("property", "og:title", title),
+        ("property", "og:description", description),
+        ("property", "og:url", page_url),
+        ("property", "og:locale", lang),
+        ("property", "og:image", image_url),
+        ("property", "og:image:alt", image_alt if image_url else ""),
+        ("name", "twitter:card", seo_meta.twitter_card or "summary"),
+        ("name", "twitter:site", seo_meta.twitter_site),
+        ("name", "twitter:title", title),
+        ("name", "twitter:description", description),
+        ("name", "twitter:image", image_url),
+        ("name", "twitter:image:alt", image_alt if image_url else ""),
+    ]
+    return [
+        {"attribute": attribute, "key": key, "content": content}
+        for attribute, key, content in candidates
+        if content
+    ]
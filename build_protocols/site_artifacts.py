@@ -0,0 +1,117 @@
+"""
+Registry and base class for site artifact generators.
+
+Site artifact generators implement the `SiteArtifactGenerator` protocol. They
+run alongside page assembly to contribute template variables to every page
+(e.g., `<link rel="alternate">` entries), may inspect or rewrite each rendered
+page and, once all pages are built, produce additional output files such as
+feeds or host configuration.
+
+Concrete generators live in their own modules (e.g., `feeds.py`) and register
+themselves with the `@register_artifact_generator` decorator, mirroring how
+HTML block generators are registered in `html_generation.py`.
+
+Generators run in the order of their `priority`, then name, never in the
+order their modules happen to be imported: page contexts are merged and
+pages processed in that order. A generator that must see the final page,
+like the CSP hashing of `security_headers`, registers with a late priority.
+"""
+
+import logging
+from typing import Any, Callable, Dict, List, Tuple, Type
+
+from jinja2 import Environment
+
+from .interfaces import BuildContext, SiteArtifactGenerator
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_PRIORITY = 100
+"""The priority of generators that do not care when they run."""
+
+# Registry for site artifact generators
+ARTIFACT_GENERATOR_REGISTRY: Dict[str, Type[SiteArtifactGenerator]] = {}
+ARTIFACT_GENERATOR_PRIORITIES: Dict[str, int] = {}
+
+
+def register_artifact_generator(
+    name: str, priority: int = DEFAULT_PRIORITY
+) -> Callable[[Type[SiteArtifactGenerator]], Type[SiteArtifactGenerator]]:
+    """
+    A decorator to register a site artifact generator class under a name.
+
+    Generators with a lower `priority` run first (see `ordered_generators`).
+    """
+
+    def decorator(cls: Type[SiteArtifactGenerator]) -> Type[SiteArtifactGenerator]:
+        if name in ARTIFACT_GENERATOR_REGISTRY:
+            logger.warning(
+                "Artifact generator '%s' is being overridden by %s", name, cls.__name__
+            )
+        ARTIFACT_GENERATOR_REGISTRY[name] = cls
+        ARTIFACT_GENERATOR_PRIORITIES[name] = priority
+        return cls
+
+    return decorator
+
+
+def ordered_generators() -> List[Tuple[str, Type[SiteArtifactGenerator]]]:
+    """Returns the registered generators by priority, then name."""
+    return sorted(
+        ARTIFACT_GENERATOR_REGISTRY.items(),
+        key=lambda item: (ARTIFACT_GENERATOR_PRIORITIES[item[0]], item[0]),
+    )
+
+
+class BaseArtifactGenerator(SiteArtifactGenerator):
+    """
+    A base class for site artifact generators providing no-op defaults, so
+    subclasses only implement the hooks they need.
+    """
+
+    def __init__(self, jinja_env: Environment):
+        self.jinja_env = jinja_env
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Contributes no page variables by default."""
+        return {}
+
+    def process_page(
+        self, output_path: str, html: str, build_context: BuildContext
+    ) -> str:
+        """Leaves pages unchanged by default."""
+        return html
+
+    def processes_pages(self, build_context: BuildContext) -> bool:
+        """Does not look at pages by default."""
+        return False
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Produces no output files by default."""
+        return {}
+
+
+def merge_page_contexts(contexts: List[Dict[str, Any]]) -> Dict[str, Any]:
+    """Merges page contexts from several generators into one.
+
+    List values under the same key are concatenated (so several generators can
+    contribute e.g. `alternate_links`); any other value is overwritten by the
+    later generator.
+
+    Args:
+        contexts: The page contexts in generator order.
+
+    Returns:
+        The merged page context.
+    """
+    merged: Dict[str, Any] = {}
+    for context in contexts:
+        for key, value in context.items():
+            existing = merged.get(key)
+            if isinstance(existing, list) and isinstance(value, list):
+                merged[key] = existing + value
+            else:
+                merged[key] = value
+    return merged
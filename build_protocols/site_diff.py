@@ -0,0 +1,180 @@
+"""
+Compares two builds of the site page by page.
+
+`python build.py diff OLD_DIR NEW_DIR` compares two output directories, e.g.
+two checkouts built before and after a change. Without directories it
+compares the current output with a fresh build: the files listed in the last
+build manifest (see `build_metrics.py`) are copied aside, the site is
+rebuilt, and the new files are compared with the copies.
+
+Pages are reported as added, removed or changed. Changed HTML is diffed one
+tag per line, so a change inside a long (or minified) line shows up as the
+tag that changed rather than the whole line. Other text files are diffed by
+line and binary files are only reported as changed.
+
+The command exits with status 1 when anything changed, so it can guard a
+config or dependency update in CI.
+"""
+
+import difflib
+import json
+import os
+import re
+import shutil
+from dataclasses import dataclass, field
+from typing import Dict, Iterable, List, Optional
+
+HTML_EXTENSIONS = (".html", ".htm", ".xml", ".svg")
+DIFF_CONTEXT_LINES = 2
+# Splits markup before each tag, comment or doctype.
+TAG_START_RE = re.compile(r"(?=<[a-zA-Z!/?])")
+
+
+@dataclass
+class SiteDiff:
+    """The differences between two builds, by site-relative path."""
+
+    added: List[str] = field(default_factory=list)
+    removed: List[str] = field(default_factory=list)
+    changed: Dict[str, List[str]] = field(default_factory=dict)
+    """Changed files and their unified diff lines (empty for binary files)."""
+
+    def is_empty(self) -> bool:
+        """Returns whether the builds are identical."""
+        return not (self.added or self.removed or self.changed)
+
+
+def html_lines(html: str) -> List[str]:
+    """Splits HTML into one tag (plus the text after it) per line."""
+    lines = []
+    for chunk in TAG_START_RE.split(html):
+        chunk = " ".join(chunk.split())
+        if chunk:
+            lines.append(chunk + "\n")
+    return lines
+
+
+def list_files(root: str) -> List[str]:
+    """Lists the files below `root` as sorted, `/`-separated relative paths."""
+    paths = []
+    for directory, subdirectories, filenames in os.walk(root):
+        subdirectories[:] = [name for name in subdirectories if name != ".git"]
+        for filename in filenames:
+            path = os.path.relpath(os.path.join(directory, filename), root)
+            paths.append(path.replace(os.sep, "/"))
+    return sorted(paths)
+
+
+def diff_file(old_path: str, new_path: str, name: str) -> Optional[List[str]]:
+    """Diffs two versions of a file.
+
+    Returns:
+        None if the files are identical, otherwise the unified diff lines
+        (empty for binary files).
+    """
+    with open(old_path, "rb") as f:
+        old_bytes = f.read()
+    with open(new_path, "rb") as f:
+        new_bytes = f.read()
+    if old_bytes == new_bytes:
+        return None
+    try:
+        old_text = old_bytes.decode("utf-8")
+        new_text = new_bytes.decode("utf-8")
+    except UnicodeDecodeError:
+        return []
+    if name.lower().endswith(HTML_EXTENSIONS):
+        old_lines, new_lines = html_lines(old_text), html_lines(new_text)
+    else:
+        old_lines = old_text.splitlines(keepends=True)
+        new_lines = new_text.splitlines(keepends=True)
+    # Differences in whitespace between tags only are not worth reporting.
+    if old_lines == new_lines:
+        return None
+    return list(
+        difflib.unified_diff(
+            old_lines,
+            new_lines,
+            f"a/{name}",
+            f"b/{name}",
+            n=DIFF_CONTEXT_LINES,
+        )
+    )
+
+
+def diff_trees(
+    old_root: str,
+    new_root: str,
+    old_files: Iterable[str],
+    new_files: Iterable[str],
+) -> SiteDiff:
+    """Compares the listed files of two directories."""
+    old_set, new_set = set(old_files), set(new_files)
+    site_diff = SiteDiff(
+        added=sorted(new_set - old_set), removed=sorted(old_set - new_set)
+    )
+    for name in sorted(old_set & new_set):
+        lines = diff_file(
+            os.path.join(old_root, name), os.path.join(new_root, name), name
+        )
+        if lines is not None:
+            site_diff.changed[name] = lines
+    return site_diff
+
+
+def diff_directories(old_dir: str, new_dir: str) -> SiteDiff:
+    """Compares every file of two output directories."""
+    return diff_trees(old_dir, new_dir, list_files(old_dir), list_files(new_dir))
+
+
+def load_manifest_files(manifest_path: str) -> List[str]:
+    """Returns the files a build manifest lists.
+
+    Raises:
+        OSError: If the manifest cannot be read.
+        ValueError: If it is not a build manifest.
+    """
+    with open(manifest_path, "r", encoding="utf-8") as f:
+        manifest = json.load(f)
+    files = manifest.get("files") if isinstance(manifest, dict) else None
+    if not isinstance(files, list):
+        raise ValueError(f"{manifest_path} is not a build manifest.")
+    return files
+
+
+def snapshot_files(paths: Iterable[str], destination: str) -> List[str]:
+    """Copies the existing files among `paths` into `destination`.
+
+    Returns:
+        The paths that were copied.
+    """
+    copied = []
+    for path in paths:
+        if not os.path.isfile(path):
+            continue
+        target = os.path.join(destination, path)
+        os.makedirs(os.path.dirname(target) or destination, exist_ok=True)
+        shutil.copy2(path, target)
+        copied.append(path)
+    return copied
+
+
+def format_site_diff(site_diff: SiteDiff, names_only: bool = False) -> str:
+    """Formats a diff as a summary line, the changed paths and their diffs."""
+    if site_diff.is_empty():
+        return "No changes."
+    lines = [
+        f"{len(site_diff.added)} added, {len(site_diff.removed)} removed, "
+        f"{len(site_diff.changed)} changed"
+    ]
+    lines.extend(f"  added:   {name}" for name in site_diff.added)
+    lines.extend(f"  removed: {name}" for name in site_diff.removed)
+    lines.extend(f"  changed: {name}" for name in site_diff.changed)
+    if not names_only:
+        for name, diff_lines in site_diff.changed.items():
+            lines.append("")
+            if not diff_lines:
+                lines.append(f"Binary file {name} changed")
+                continue
+            lines.extend(line.rstrip("\n") for line in diff_lines)
+    return "\n".join(lines)
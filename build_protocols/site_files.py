@@ -0,0 +1,201 @@
+"""
+Generates `.well-known/security.txt` and `humans.txt`.
+
+Both files are rebuilt from a `SiteFiles` message (see
+`proto/site_files.proto`) on every build, so contacts, expiry dates and
+credits live next to the rest of the site data instead of in hand-edited
+files that quietly go stale. Pages get a `<link rel="author">` to
+`humans.txt`.
+
+Configured by the `site_files` section of `public/config.json`:
+
+    "site_files": {
+      "enabled": true,
+      "data_file": "data/site_files.json"
+    }
+
+`security.txt` needs at least one contact and an `expires` date (RFC 9116);
+the build warns when the date has passed or is more than a year away, and
+skips the file if either is missing.
+"""
+
+import logging
+from datetime import date, datetime, timedelta, timezone
+from typing import Any, Dict, List, Optional
+
+from jinja2 import Environment
+
+from generated.site_files_pb2 import HumanCredit, HumansTxt, SecurityTxt, SiteFiles
+
+from .data_loading import load_dynamic_single_item_data
+from .feeds import parse_published_date
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import absolute_url
+
+logger = logging.getLogger(__name__)
+
+SECURITY_TXT_PATH = ".well-known/security.txt"
+HUMANS_TXT_PATH = "humans.txt"
+MAX_EXPIRY = timedelta(days=366)
+
+
+def format_security_txt(
+    security_txt: SecurityTxt,
+    canonical_url: str,
+    default_languages: List[str],
+    now: Optional[datetime] = None,
+) -> Optional[str]:
+    """Formats a security.txt file.
+
+    Args:
+        security_txt: The security.txt fields.
+        canonical_url: The absolute URL the file is served from, or "".
+        default_languages: Preferred languages if none are configured.
+        now: The current time, for expiry checks. Defaults to now in UTC.
+
+    Returns:
+        The file content, or None if required fields are missing.
+    """
+    expires = parse_published_date(security_txt.expires)
+    if not security_txt.contacts or expires is None:
+        logger.warning(
+            "security.txt needs at least one contact and a valid 'expires'. Skipping."
+        )
+        return None
+
+    now = now or datetime.now(timezone.utc)
+    if expires <= now:
+        logger.warning("security.txt expired on %s.", expires.date().isoformat())
+    elif expires - now > MAX_EXPIRY:
+        logger.warning(
+            "security.txt expires more than a year ahead (%s); RFC 9116 "
+            "recommends less.",
+            expires.date().isoformat(),
+        )
+
+    fields = [("Contact", contact) for contact in security_txt.contacts]
+    fields.append(
+        ("Expires", expires.astimezone(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ"))
+    )
+    fields.extend(("Encryption", uri) for uri in security_txt.encryption)
+    fields.extend(("Acknowledgments", uri) for uri in security_txt.acknowledgments)
+    languages = list(security_txt.preferred_languages) or default_languages
+    if languages:
+        fields.append(("Preferred-Languages", ", ".join(languages)))
+    if canonical_url:
+        fields.append(("Canonical", canonical_url))
+    fields.extend(("Policy", uri) for uri in security_txt.policy)
+    fields.extend(("Hiring", uri) for uri in security_txt.hiring)
+    return "".join(f"{name}: {value}\n" for name, value in fields)
+
+
+def _format_credits(credits: List[HumanCredit], default_role: str) -> List[str]:
+    """Formats humans.txt credit entries, separated by blank lines."""
+    lines: List[str] = []
+    for credit in credits:
+        if lines:
+            lines.append("")
+        lines.append(f"{credit.role or default_role}: {credit.name}")
+        if credit.contact:
+            lines.append(f"Contact: {credit.contact}")
+        if credit.location:
+            lines.append(f"From: {credit.location}")
+    return lines
+
+
+def format_humans_txt(
+    humans_txt: HumansTxt, languages: List[str], today: Optional[date] = None
+) -> str:
+    """Formats a humans.txt file.
+
+    Args:
+        humans_txt: The humans.txt content.
+        languages: The site's language codes.
+        today: The build date, used when `last_update` is unset.
+
+    Returns:
+        The file content.
+    """
+    sections: List[List[str]] = []
+    if humans_txt.team:
+        sections.append(["/* TEAM */", *_format_credits(humans_txt.team, "Team")])
+    if humans_txt.thanks:
+        sections.append(
+            ["/* THANKS */", *_format_credits(humans_txt.thanks, "Thanks")]
+        )
+
+    last_update = humans_txt.last_update or (today or date.today()).isoformat()
+    site = ["/* SITE */", f"Last update: {last_update.replace('-', '/')}"]
+    if languages:
+        site.append(f"Language: {', '.join(languages)}")
+    for label, values in (
+        ("Standards", humans_txt.standards),
+        ("Components", humans_txt.components),
+        ("Software", humans_txt.software),
+    ):
+        if values:
+            site.append(f"{label}: {', '.join(values)}")
+    sections.append(site)
+    return "\n\n".join("\n".join(section) for section in sections) + "\n"
+
+
+@register_artifact_generator("site_files")
+class SiteFilesGenerator(BaseArtifactGenerator):
+    """Writes security.txt and humans.txt from site data."""
+
+    def __init__(self, jinja_env: Environment):
+        super().__init__(jinja_env)
+        self._loaded: Dict[str, Optional[SiteFiles]] = {}
+
+    def _load_site_files(self, build_context: BuildContext) -> Optional[SiteFiles]:
+        """Loads and memoizes the SiteFiles message if the section is enabled."""
+        settings = build_context.app_config.get("site_files", {})
+        data_file = settings.get("data_file")
+        if not settings.get("enabled", False) or not data_file:
+            return None
+        if data_file not in self._loaded:
+            self._loaded[data_file] = load_dynamic_single_item_data(
+                data_file, SiteFiles  # type: ignore
+            )
+        return self._loaded[data_file]
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Links every page to humans.txt when it is generated."""
+        site_files = self._load_site_files(build_context)
+        if site_files is None or not site_files.HasField("humans_txt"):
+            return {}
+        return {
+            "alternate_links": [
+                {
+                    "rel": "author",
+                    "type": "text/plain",
+                    "href": HUMANS_TXT_PATH,
+                    "title": "humans.txt",
+                }
+            ]
+        }
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates the files whose section is present in the data."""
+        site_files = self._load_site_files(build_context)
+        if site_files is None:
+            return {}
+
+        artifacts: Dict[str, str] = {}
+        if site_files.HasField("security_txt"):
+            base_url = build_context.app_config.get("base_url", "")
+            security_txt = format_security_txt(
+                site_files.security_txt,
+                absolute_url(base_url, SECURITY_TXT_PATH) if base_url else "",
+                build_context.supported_langs,
+            )
+            if security_txt is not None:
+                artifacts[SECURITY_TXT_PATH] = security_txt
+        if site_files.HasField("humans_txt"):
+            artifacts[HUMANS_TXT_PATH] = format_humans_txt(
+                site_files.humans_txt, build_context.supported_langs
+            )
+        return artifacts
@@ -0,0 +1,112 @@
+"""
+Helpers for naming generated files and building site URLs.
+
+Pages and other per-language outputs share one naming convention: the
+default language gets the plain file name (e.g., `index.html`) and every
+other language gets a `_{lang}` suffix (e.g., `index_es.html`). Keeping the
+convention in one place lets feeds, sitemaps and link tags agree with the
+pages the orchestrator actually writes.
+
+A build can also be published below a sub-path of its host (e.g., a branch
+preview at `/previews/feature-x/`). Its `base_path` then replaces the path
+of `base_url`, and root-relative references in the pages are moved under it.
+"""
+
+import re
+from typing import Any, Dict
+from urllib.parse import urljoin, urlsplit, urlunsplit
+
+# Attribute values starting with a single "/" (not a "//host" URL).
+ROOT_RELATIVE_ATTR_RE = re.compile(r'(\s(?:href|src|action)=")/(?!/)')
+
+
+def localized_filename(stem: str, extension: str, lang: str, default_lang: str) -> str:
+    """Returns the output file name for a per-language artifact.
+
+    Args:
+        stem: The base name of the file without extension (e.g., "index").
+        extension: The file extension including the dot (e.g., ".html").
+        lang: The language code of the artifact.
+        default_lang: The site's default language code.
+
+    Returns:
+        `{stem}{extension}` for the default language, otherwise
+        `{stem}_{lang}{extension}`.
+    """
+    if lang == default_lang:
+        return f"{stem}{extension}"
+    return f"{stem}_{lang}{extension}"
+
+
+def page_filename(lang: str, default_lang: str) -> str:
+    """Returns the HTML file name of the landing page for a language."""
+    return localized_filename("index", ".html", lang, default_lang)
+
+
+def absolute_url(base_url: str, path: str) -> str:
+    """Joins a site-relative path onto the configured base URL.
+
+    Args:
+        base_url: The public root of the site (e.g., "https://example.com/").
+            A missing trailing slash is tolerated.
+        path: A path relative to the site root, or an absolute URL (which is
+            returned unchanged).
+
+    Returns:
+        The absolute URL for `path`.
+    """
+    if not base_url.endswith("/"):
+        base_url = f"{base_url}/"
+    return urljoin(base_url, path.lstrip("/"))
+
+
+def page_url(base_url: str, lang: str, default_lang: str) -> str:
+    """Returns the public URL of the landing page for a language.
+
+    The default language page is served as the directory index, so its URL is
+    the base URL itself rather than `.../index.html`.
+    """
+    filename = page_filename(lang, default_lang)
+    if filename == "index.html":
+        return absolute_url(base_url, "")
+    return absolute_url(base_url, filename)
+
+
+def normalize_base_path(base_path: str) -> str:
+    """Returns a base path with exactly one leading and trailing slash."""
+    path = base_path.strip().strip("/")
+    return f"/{path}/" if path else "/"
+
+
+def apply_base_path(app_config: Dict[str, Any], base_path: str) -> Dict[str, Any]:
+    """Returns a copy of the app config for a site served under `base_path`.
+
+    The path of `base_url` (if any) is replaced, so absolute URLs in
+    canonical links, sitemaps and feeds point at the same location.
+    """
+    base_path = normalize_base_path(base_path)
+    config = {**app_config, "base_path": base_path}
+    if app_config.get("base_url"):
+        parts = urlsplit(app_config["base_url"])
+        config["base_url"] = urlunsplit(parts._replace(path=base_path))
+    return config
+
+
+def site_base_path(app_config: Dict[str, Any]) -> str:
+    """Returns the path the site is served under, e.g. "/" or "/docs/"."""
+    if app_config.get("base_path"):
+        return normalize_base_path(app_config["base_path"])
+    base_url = app_config.get("base_url")
+    return normalize_base_path(urlsplit(base_url).path) if base_url else "/"
+
+
+def prefix_root_relative_urls(html: str, base_path: str) -> str:
+    """Moves root-relative `href`, `src` and `action` values under a base path.
+
+    Relative references (e.g., `public/style.css`) already resolve against
+    the page's own location and are left alone.
+    """
+    base_path = normalize_base_path(base_path)
+    if base_path == "/":
+        return html
+    return ROOT_RELATIVE_ATTR_RE.sub(lambda match: match.group(1) + base_path, html)
@@ -0,0 +1,277 @@
+"""
+Generates XML sitemaps with image and video extensions.
+
+The `SitemapGenerator` lists every language version of the landing page under
+its canonical URL. With `split_by_language` (the default) each language gets
+its own `sitemap_{lang}.xml` and `sitemap.xml` becomes a sitemap index
+pointing at them, so large multilingual sites stay below the protocol's
+50,000 URLs per file; otherwise all URLs go into a single `sitemap.xml`.
+
+Each URL entry carries the page's media, harvested from the data of the
+rendered blocks (see `MEDIA_HARVESTERS`): hero images and videos, portfolio
+images and blog post images. Images use Google's image sitemap extension and
+videos its video sitemap extension; videos need a thumbnail, a title, a
+description and either a `src` or a `player_url`.
+
+Configured by the `sitemaps` section of `public/config.json` and, since
+sitemap URLs must be absolute, the top-level `base_url`:
+
+    "sitemaps": {
+      "enabled": true,
+      "split_by_language": true,
+      "images": true,
+      "videos": true
+    }
+"""
+
+import logging
+import xml.etree.ElementTree as ET
+from datetime import date
+from typing import Any, Callable, Dict, List, NamedTuple, Optional, Tuple
+from urllib.parse import urljoin
+
+from generated.blog_post_pb2 import BlogPost
+from generated.common_pb2 import Video
+from generated.hero_item_pb2 import HeroItem
+from generated.portfolio_item_pb2 import PortfolioItem
+
+from .canonical import resolve_canonical_url
+from .interfaces import BuildContext, Translations
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import absolute_url
+
+logger = logging.getLogger(__name__)
+
+SITEMAP_NAMESPACE = "http://www.sitemaps.org/schemas/sitemap/0.9"
+IMAGE_NAMESPACE = "http://www.google.com/schemas/sitemap-image/1.1"
+VIDEO_NAMESPACE = "http://www.google.com/schemas/sitemap-video/1.1"
+INDEX_PATH = "sitemap.xml"
+MAX_URLS_PER_SITEMAP = 50000
+
+PageMedia = Tuple[List[str], List[Video]]
+"""Image references and videos of a page, in block order."""
+
+
+class SitemapVideo(NamedTuple):
+    """A video resolved for one language, ready to be serialized."""
+
+    thumbnail_loc: str
+    title: str
+    description: str
+    content_loc: str
+    player_loc: str
+    duration: int
+
+
+class SitemapUrl(NamedTuple):
+    """A `<url>` entry of a sitemap."""
+
+    loc: str
+    lastmod: str
+    images: List[str]
+    videos: List[SitemapVideo]
+
+
+def _hero_media(data: Optional[HeroItem]) -> PageMedia:
+    """Returns the images and videos of all hero variations."""
+    if not data:
+        return [], []
+    images = [content.image.src for content in data.variations]
+    videos = [
+        content.video
+        for content in data.variations
+        if content.video.src or content.video.player_url
+    ]
+    return images, videos
+
+
+def _portfolio_media(data: Optional[List[PortfolioItem]]) -> PageMedia:
+    """Returns the images of the portfolio items."""
+    return [item.image.src for item in data or []], []
+
+
+def _blog_media(data: Optional[List[BlogPost]]) -> PageMedia:
+    """Returns the images of the blog posts."""
+    return [post.image.src for post in data or []], []
+
+
+MEDIA_HARVESTERS: Dict[str, Callable[[Any], PageMedia]] = {
+    "hero.html": _hero_media,
+    "portfolio.html": _portfolio_media,
+    "blog.html": _blog_media,
+}
+
+
+def harvest_media(block_names: List[str], block_data: Dict[str, Any]) -> PageMedia:
+    """Collects the media of the rendered blocks that have a harvester.
+
+    Args:
+        block_names: The blocks rendered on the page, in page order.
+        block_data: The preloaded data of each block.
+
+    Returns:
+        The unique image references (empty and inline `data:` references are
+        dropped) and the videos, in block order.
+    """
+    images: List[str] = []
+    videos: List[Video] = []
+    for block_name in block_names:
+        harvester = MEDIA_HARVESTERS.get(block_name)
+        if harvester is None:
+            continue
+        block_images, block_videos = harvester(block_data.get(block_name))
+        for src in block_images:
+            if src and not src.startswith("data:") and src not in images:
+                images.append(src)
+        videos.extend(block_videos)
+    return images, videos
+
+
+def resolve_video(
+    video: Video, page_loc: str, translations: Translations
+) -> Optional[SitemapVideo]:
+    """Resolves a video for a page, or returns None if it lacks required data."""
+    title = translations.get(video.title.key, video.title.key)
+    description = translations.get(video.description.key, video.description.key)
+    if not (video.thumbnail and title and description):
+        logger.warning(
+            "Video '%s' needs a thumbnail, title and description to be listed "
+            "in the sitemap. Skipping.",
+            video.src or video.player_url,
+        )
+        return None
+    return SitemapVideo(
+        thumbnail_loc=urljoin(page_loc, video.thumbnail),
+        title=title,
+        description=description,
+        content_loc=urljoin(page_loc, video.src) if video.src else "",
+        player_loc=video.player_url,
+        duration=video.duration_seconds,
+    )
+
+
+def _serialize_xml(root: ET.Element) -> str:
+    """Serializes an element tree as a UTF-8 XML document string."""
+    ET.indent(root)
+    body = ET.tostring(root, encoding="unicode")
+    return f'<?xml version="1.0" encoding="utf-8"?>\n{body}\n'
+
+
+def render_urlset(urls: List[SitemapUrl]) -> str:
+    """Renders a sitemap, declaring the media namespaces only when used."""
+    attributes = {"xmlns": SITEMAP_NAMESPACE}
+    if any(url.images for url in urls):
+        attributes["xmlns:image"] = IMAGE_NAMESPACE
+    if any(url.videos for url in urls):
+        attributes["xmlns:video"] = VIDEO_NAMESPACE
+    urlset = ET.Element("urlset", attributes)
+
+    for url in urls:
+        url_element = ET.SubElement(urlset, "url")
+        ET.SubElement(url_element, "loc").text = url.loc
+        ET.SubElement(url_element, "lastmod").text = url.lastmod
+        for image in url.images:
+            image_element = ET.SubElement(url_element, "image:image")
+            ET.SubElement(image_element, "image:loc").text = image
+        for video in url.videos:
+            video_element = ET.SubElement(url_element, "video:video")
+            ET.SubElement(video_element, "video:thumbnail_loc").text = (
+                video.thumbnail_loc
+            )
+            ET.SubElement(video_element, "video:title").text = video.title
+            ET.SubElement(video_element, "video:description").text = (
+                video.description
+            )
+            if video.content_loc:
+                ET.SubElement(video_element, "video:content_loc").text = (
+                    video.content_loc
+                )
+            if video.player_loc:
+                ET.SubElement(video_element, "video:player_loc").text = (
+                    video.player_loc
+                )
+            if video.duration > 0:
+                ET.SubElement(video_element, "video:duration").text = str(
+                    video.duration
+                )
+    return _serialize_xml(urlset)
+
+
+def render_sitemap_index(sitemap_urls: List[str], lastmod: str) -> str:
+    """Renders a sitemap index pointing at the given sitemaps."""
+    index = ET.Element("sitemapindex", {"xmlns": SITEMAP_NAMESPACE})
+    for sitemap_url in sitemap_urls:
+        sitemap_element = ET.SubElement(index, "sitemap")
+        ET.SubElement(sitemap_element, "loc").text = sitemap_url
+        ET.SubElement(sitemap_element, "lastmod").text = lastmod
+    return _serialize_xml(index)
+
+
+@register_artifact_generator("sitemaps")
+class SitemapGenerator(BaseArtifactGenerator):
+    """Generates per-language sitemaps and a sitemap index."""
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Generates the sitemaps of all supported languages."""
+        app_config = build_context.app_config
+        settings: Dict[str, Any] = app_config.get("sitemaps", {})
+        if not settings.get("enabled", False):
+            return {}
+        base_url: str = app_config.get("base_url", "")
+        if not base_url:
+            logger.warning("Sitemaps are enabled but 'base_url' is not set. Skipping.")
+            return {}
+
+        lastmod = date.today().isoformat()
+        images, videos = harvest_media(
+            app_config.get("blocks", []), build_context.block_data
+        )
+        if not settings.get("images", True):
+            images = []
+        if not settings.get("videos", True):
+            videos = []
+
+        urls_by_lang: Dict[str, List[SitemapUrl]] = {}
+        seen_locs = set()
+        for lang in build_context.supported_langs:
+            loc = resolve_canonical_url(
+                app_config, "index", lang, build_context.default_lang
+            )
+            if loc is None or loc in seen_locs:
+                continue
+            seen_locs.add(loc)
+            translations = build_context.translations_by_lang.get(lang, {})
+            lang_videos = [
+                resolved
+                for resolved in (
+                    resolve_video(video, loc, translations) for video in videos
+                )
+                if resolved is not None
+            ]
+            urls_by_lang[lang] = [
+                SitemapUrl(
+                    loc=loc,
+                    lastmod=lastmod,
+                    images=[urljoin(loc, src) for src in images],
+                    videos=lang_videos,
+                )
+            ]
+
+        if not settings.get("split_by_language", True):
+            urls = [url for lang_urls in urls_by_lang.values() for url in lang_urls]
+            if len(urls) > MAX_URLS_PER_SITEMAP:
+                logger.warning(
+                    "Sitemap lists %d URLs, more than the %d allowed. Enable "
+                    "'split_by_language'.",
+                    len(urls),
+                    MAX_URLS_PER_SITEMAP,
+                )
+            return {INDEX_PATH: render_urlset(urls)}
+
+        artifacts: Dict[str, str] = {}
+        for lang, urls in urls_by_lang.items():
+            artifacts[f"sitemap_{lang}.xml"] = render_urlset(urls)
+        artifacts[INDEX_PATH] = render_sitemap_index(
+            [absolute_url(base_url, path) for path in artifacts], lastmod
+        )
+        return artifacts
@@ -0,0 +1,289 @@
+"""
+Keeps spam out of the contact form.
+
+`ContactFormConfig.spam_protection` turns on any combination of checks. The
+form block emits the fields they need at build time, and the backend's
+`SpamGuard` enforces them before a submission is validated:
+
+- Honeypot: a visually hidden field (`honeypot_field`) that people leave
+  empty and bots fill in. Such submissions get a normal success response so
+  bots don't learn they were caught, but they reach no sink.
+- Minimum submit time: when the page loads, the form's script asks the
+  backend (a GET to the form's endpoint) for a token signed with the
+  backend's secret, holding the time it was issued, and submits it in
+  `FORM_TOKEN_FIELD`. Submissions sent less than `min_submit_seconds`
+  after the token was issued are rejected, and so are unsigned, forged and
+  expired tokens. The client never reports the time itself, so it cannot
+  claim the form was open longer. Plain HTML posts, without the script,
+  carry no token and are rejected as well.
+- Rate limit: at most `rate_limit` submissions per client IP within
+  `rate_limit_window_seconds`.
+- Captcha: the block renders the `captcha_provider` widget ("recaptcha",
+  "hcaptcha" or "turnstile") with `captcha_site_key`, and the backend
+  verifies its token with the provider. The secret key is read from the
+  environment variable named by `backend.captcha_secret_env` (default
+  `CAPTCHA_SECRET`). The widget's script loads more scripts and an iframe
+  from the provider; `csp_sources` lists their origins, which
+  `security_headers.py` allows on every page loading the script.
+"""
+
+import hashlib
+import hmac
+import json
+import logging
+import secrets
+import threading
+import time
+import urllib.parse
+import urllib.request
+from collections import deque
+from typing import Any, Deque, Dict, NamedTuple, Optional, Set, Tuple
+
+logger = logging.getLogger(__name__)
+
+FORM_TOKEN_FIELD = "form_token"
+# Long enough for a visitor to fill in the form, short enough that a stolen
+# token is not reusable forever.
+MAX_FORM_TOKEN_AGE_SECONDS = 24 * 3600
+
+
+class CaptchaProvider(NamedTuple):
+    """How to render and verify one captcha service's widget."""
+
+    script_url: str
+    widget_class: str
+    response_field: str
+    verify_url: str
+    csp_sources: Dict[str, Tuple[str, ...]]
+    """The origins the widget loads from, per CSP directive."""
+
+
+CAPTCHA_PROVIDERS: Dict[str, CaptchaProvider] = {
+    "recaptcha": CaptchaProvider(
+        "https://www.google.com/recaptcha/api.js",
+        "g-recaptcha",
+        "g-recaptcha-response",
+        "https://www.google.com/recaptcha/api/siteverify",
+        {
+            "script-src": ("https://www.google.com", "https://www.gstatic.com"),
+            "frame-src": ("https://www.google.com", "https://recaptcha.google.com"),
+        },
+    ),
+    "hcaptcha": CaptchaProvider(
+        "https://js.hcaptcha.com/1/api.js",
+        "h-captcha",
+        "h-captcha-response",
+        "https://api.hcaptcha.com/siteverify",
+        {
+            directive: ("https://hcaptcha.com", "https://*.hcaptcha.com")
+            for directive in ("script-src", "frame-src", "style-src", "connect-src")
+        },
+    ),
+    "turnstile": CaptchaProvider(
+        "https://challenges.cloudflare.com/turnstile/v0/api.js",
+        "cf-turnstile",
+        "cf-turnstile-response",
+        "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+        {
+            "script-src": ("https://challenges.cloudflare.com",),
+            "frame-src": ("https://challenges.cloudflare.com",),
+        },
+    ),
+}
+
+
+class SpamVerdict(NamedTuple):
+    """Why a submission was refused."""
+
+    status: int
+    """The HTTP status to answer with; 200 pretends the submission went through."""
+    message_key: Optional[str]
+    """The I18n key of the error message, if the client should see one."""
+
+
+HONEYPOT_VERDICT = SpamVerdict(200, None)
+TOO_FAST_VERDICT = SpamVerdict(422, "form_error_too_fast")
+RATE_LIMITED_VERDICT = SpamVerdict(429, "form_error_rate_limited")
+CAPTCHA_VERDICT = SpamVerdict(422, "form_error_captcha")
+
+
+def issue_form_token(secret: bytes, now: Optional[float] = None) -> str:
+    """Returns a token holding the time it was issued, signed with `secret`."""
+    issued_at = str(int(time.time() if now is None else now))
+    signature = hmac.new(secret, issued_at.encode("ascii"), hashlib.sha256)
+    return f"{issued_at}.{signature.hexdigest()}"
+
+
+def form_token_age(
+    token: str, secret: bytes, now: Optional[float] = None
+) -> Optional[float]:
+    """Returns the seconds since a form token was issued.
+
+    Returns:
+        None if the token is not signed with `secret`, malformed, issued in
+        the future or older than `MAX_FORM_TOKEN_AGE_SECONDS`.
+    """
+    issued_at, _, signature = token.partition(".")
+    expected = hmac.new(secret, issued_at.encode("utf-8"), hashlib.sha256)
+    if not hmac.compare_digest(
+        signature.encode("utf-8"), expected.hexdigest().encode("ascii")
+    ):
+        return None
+    try:
+        age = (time.time() if now is None else now) - int(issued_at)
+    except ValueError:
+        return None
+    if not 0 <= age <= MAX_FORM_TOKEN_AGE_SECONDS:
+        return None
+    return age
+
+
+class RateLimiter:
+    """Counts events per key in a sliding time window; thread-safe."""
+
+    def __init__(self, limit: int, window_seconds: float):
+        self.limit = limit
+        self.window_seconds = window_seconds
+        self._events: Dict[str, Deque[float]] = {}
+        self._lock = threading.Lock()
+
+    def allow(self, key: str, now: Optional[float] = None) -> bool:
+        """Records an event for a key unless it is over its limit.
+
+        Returns:
+            Whether the event is allowed.
+        """
+        now = time.monotonic() if now is None else now
+        with self._lock:
+            # Forget idle keys so the table doesn't grow without bounds.
+            for idle_key in [
+                other
+                for other, events in self._events.items()
+                if events[-1] <= now - self.window_seconds
+            ]:
+                del self._events[idle_key]
+            events = self._events.setdefault(key, deque())
+            while events and events[0] <= now - self.window_seconds:
+                events.popleft()
+            if len(events) >= self.limit:
+                return False
+            events.append(now)
+            return True
+
+
+def verify_captcha(
+    provider: CaptchaProvider,
+    secret: str,
+    token: str,
+    remote_addr: str = "",
+    timeout: float = 10.0,
+) -> bool:
+    """Asks a captcha provider whether a widget token is valid.
+
+    Unreachable providers count as a failed verification.
+    """
+    if not token:
+        return False
+    params = {"secret": secret, "response": token}
+    if remote_addr:
+        params["remoteip"] = remote_addr
+    request = urllib.request.Request(
+        provider.verify_url,
+        data=urllib.parse.urlencode(params).encode("ascii"),
+        method="POST",
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=timeout) as response:
+            result = json.loads(response.read().decode("utf-8"))
+    except (OSError, ValueError) as e:
+        logger.error("Captcha verification failed: %s", e)
+        return False
+    return bool(result.get("success"))
+
+
+class SpamGuard:
+    """Applies a form's spam protection settings to submissions."""
+
+    def __init__(
+        self, spam_protection: Any, captcha_secret: str = "", token_secret: str = ""
+    ):
+        """
+        Args:
+            spam_protection: The `SpamProtection` message of the form.
+            captcha_secret: The captcha provider's secret key.
+            token_secret: Signs the form tokens of the minimum submit time.
+                Without one, a random secret is used, so tokens only hold
+                until the backend restarts.
+        """
+        self.settings = spam_protection
+        self.captcha_secret = captcha_secret
+        self.token_secret = (
+            token_secret.encode("utf-8") if token_secret else secrets.token_bytes(32)
+        )
+        self.captcha = CAPTCHA_PROVIDERS.get(spam_protection.captcha_provider)
+        if spam_protection.captcha_provider and self.captcha is None:
+            logger.warning(
+                "Unknown captcha provider '%s'; not checking captchas.",
+                spam_protection.captcha_provider,
+            )
+        if self.captcha and not captcha_secret:
+            logger.warning("No captcha secret configured; captchas will fail.")
+        if spam_protection.min_submit_seconds and not token_secret:
+            logger.warning(
+                "No form token secret configured; form tokens expire when the "
+                "backend restarts."
+            )
+        self.rate_limiter = (
+            RateLimiter(
+                spam_protection.rate_limit,
+                spam_protection.rate_limit_window_seconds or 3600,
+            )
+            if spam_protection.rate_limit
+            else None
+        )
+
+    def issue_token(self) -> str:
+        """Returns a new form token for the minimum submit time."""
+        return issue_form_token(self.token_secret)
+
+    def protocol_fields(self) -> Set[str]:
+        """Returns the names of the fields used only for spam protection."""
+        fields = {FORM_TOKEN_FIELD}
+        if self.settings.honeypot_field:
+            fields.add(self.settings.honeypot_field)
+        if self.captcha:
+            fields.add(self.captcha.response_field)
+        return fields
+
+    def check(
+        self, fields: Dict[str, str], remote_addr: str
+    ) -> Optional[SpamVerdict]:
+        """Checks a submission.
+
+        Args:
+            fields: All submitted fields, spam protection fields included.
+            remote_addr: The client's IP address.
+
+        Returns:
+            None if the submission looks legitimate, otherwise the verdict.
+        """
+        honeypot = self.settings.honeypot_field
+        if honeypot and fields.get(honeypot):
+            logger.info("Dropped a submission that filled in the honeypot.")
+            return HONEYPOT_VERDICT
+        if self.settings.min_submit_seconds:
+            elapsed = form_token_age(
+                fields.get(FORM_TOKEN_FIELD, ""), self.token_secret
+            )
+            if elapsed is None or elapsed < self.settings.min_submit_seconds:
+                return TOO_FAST_VERDICT
+        if self.rate_limiter and not self.rate_limiter.allow(remote_addr):
+            return RATE_LIMITED_VERDICT
+        if self.captcha and not verify_captcha(
+            self.captcha,
+            self.captcha_secret,
+            fields.get(self.captcha.response_field, ""),
+            remote_addr,
+        ):
+            return CAPTCHA_VERDICT
+        return None
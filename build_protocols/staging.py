@@ -0,0 +1,137 @@
+"""
+Keeps pre-launch builds private: password protection and no indexing.
+
+A build is a staging build when the `staging` section of
+`public/config.json` is enabled and the build profile (`BUILD_PROFILE`) is
+one of its `profiles`:
+
+    "staging": {
+      "enabled": true,
+      "profiles": ["staging"],
+      "username": "client",
+      "password_env": "STAGING_PASSWORD",
+      "realm": "Staging",
+      "htpasswd_path": "/etc/nginx/staging.htpasswd"
+    }
+
+Staging builds then:
+
+- mark every page `noindex, nofollow` (robots meta tag and, through
+  `security_headers.py`, the `X-Robots-Tag` header) and write a `robots.txt`
+  that disallows crawling;
+- ask for the username and password (read from the environment variable
+  named by `password_env`) on Netlify, via a `Basic-Auth` rule in the
+  `netlify` header file of `security_headers`. Cloudflare Pages reads the
+  same file but has no such rule and would send the password as a plain
+  response header, so the `cloudflare` deploy target refuses staging builds;
+- write `nginx-staging.conf`, to be included in the nginx `server` block,
+  and the password file it refers to, `staging.htpasswd`, to be copied to
+  `htpasswd_path`. Neither is published by `deploy`.
+
+`python build.py serve` asks for the same credentials when it serves a
+staging profile.
+"""
+
+import base64
+import hashlib
+import logging
+import os
+from typing import Any, Dict, Optional, Tuple
+
+from .interfaces import BuildContext
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+
+logger = logging.getLogger(__name__)
+
+ROBOTS_VALUE = "noindex, nofollow"
+DEFAULT_PROFILES = ["staging"]
+# "htpasswd" is not a host format, so deploys never publish the password file.
+STAGING_FILENAMES: Dict[str, str] = {
+    "nginx": "nginx-staging.conf",
+    "htpasswd": "staging.htpasswd",
+}
+
+
+def get_staging_settings(
+    app_config: Dict[str, Any], build_profile: str
+) -> Optional[Dict[str, Any]]:
+    """Returns the `staging` section if this build profile is a staging one."""
+    settings = app_config.get("staging", {})
+    if not settings.get("enabled", False):
+        return None
+    if build_profile not in settings.get("profiles", DEFAULT_PROFILES):
+        return None
+    return settings
+
+
+def staging_credentials(settings: Dict[str, Any]) -> Optional[Tuple[str, str]]:
+    """Returns the username and password, or None if no password is set."""
+    password_env = settings.get("password_env", "STAGING_PASSWORD")
+    password = os.environ.get(password_env, "")
+    if not password:
+        logger.error(
+            "Staging build without a password in $%s: pages are not protected.",
+            password_env,
+        )
+        return None
+    return settings.get("username", "staging"), password
+
+
+def htpasswd_line(username: str, password: str, salt: Optional[bytes] = None) -> str:
+    """Formats a password file entry with a salted SHA-1 hash ({SSHA}).
+
+    nginx's `auth_basic_user_file` understands this scheme.
+    """
+    salt = os.urandom(8) if salt is None else salt
+    digest = hashlib.sha1(password.encode("utf-8") + salt).digest()
+    encoded = base64.b64encode(digest + salt).decode("ascii")
+    return f"{username}:{{SSHA}}{encoded}\n"
+
+
+def format_nginx_auth(settings: Dict[str, Any]) -> str:
+    """Formats the nginx directives that require the staging password."""
+    realm = settings.get("realm", "Staging").replace('"', "")
+    htpasswd_path = settings.get("htpasswd_path", "/etc/nginx/staging.htpasswd")
+    return (
+        f'auth_basic "{realm}";\n'
+        f"auth_basic_user_file {htpasswd_path};\n"
+        f'add_header X-Robots-Tag "{ROBOTS_VALUE}" always;\n'
+    )
+
+
+@register_artifact_generator("staging")
+class StagingGenerator(BaseArtifactGenerator):
+    """Marks staging builds noindex and writes their access configuration."""
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Sets the robots meta tag of every page in staging builds."""
+        if not get_staging_settings(
+            build_context.app_config, build_context.build_profile
+        ):
+            return {}
+        return {"robots": ROBOTS_VALUE}
+
+    def generate_artifacts(self, build_context: BuildContext) -> Dict[str, str]:
+        """Writes robots.txt and the nginx access configuration."""
+        settings = get_staging_settings(
+            build_context.app_config, build_context.build_profile
+        )
+        if settings is None:
+            return {}
+
+        artifacts = {"robots.txt": "User-agent: *\nDisallow: /\n"}
+        credentials = staging_credentials(settings)
+        if credentials is not None:
+            artifacts[STAGING_FILENAMES["nginx"]] = format_nginx_auth(settings)
+            artifacts[STAGING_FILENAMES["htpasswd"]] = htpasswd_line(*credentials)
+        headers_settings = build_context.app_config.get("security_headers", {})
+        if not headers_settings.get("enabled", False) or "netlify" not in (
+            headers_settings.get("formats", ["netlify"])
+        ):
+            logger.warning(
+                "Enable security_headers with the 'netlify' format to require "
+                "the staging password on Netlify and Cloudflare Pages."
+            )
+        return artifacts
@@ -0,0 +1,291 @@
+"""
+Generates schema.org JSON-LD structured data for every page.
+
+The `StructuredDataGenerator` contributes a `structured_data` list to each
+page's template context; `base.html` renders every entry as a
+`<script type="application/ld+json">` block. Entities are built from proto
+data so rich results stay in sync with the visible content:
+
+- `Organization` and `Product` from a `StructuredData` message
+  (`proto/structured_data.proto`), loaded from `data_file`.
+- `WebSite` from the configuration and the page language.
+- `FAQPage` from the FAQ block's `FaqItem` data.
+- `Article` from the blog block's `BlogPost` data.
+- `LocalBusiness` (or the configured subtype) from the business info block's
+  `LocalBusiness` data, including address, geo and opening hours.
+
+Configured by the `structured_data` section of `public/config.json`:
+
+    "structured_data": {
+      "enabled": true,
+      "data_file": "data/structured_data.json",
+      "blog_block": "blog.html",
+      "faq_block": "faq.html",
+      "local_business_block": "business-info.html"
+    }
+"""
+
+from typing import Any, Dict, List, Optional
+from urllib.parse import urljoin
+
+from jinja2 import Environment
+
+from generated.blog_post_pb2 import BlogPost
+from generated.faq_item_pb2 import FaqItem
+from generated.local_business_pb2 import LocalBusiness
+from generated.structured_data_pb2 import Organization, Product, StructuredData
+
+from .data_loading import load_dynamic_single_item_data
+from .feeds import parse_published_date
+from .interfaces import BuildContext, Translations
+from .site_artifacts import BaseArtifactGenerator, register_artifact_generator
+from .site_urls import absolute_url, page_url
+
+SCHEMA_CONTEXT = "https://schema.org"
+
+JsonLd = Dict[str, Any]
+"""A single JSON-LD entity, ready to be serialized with `tojson`."""
+
+
+@register_artifact_generator("structured_data")
+class StructuredDataGenerator(BaseArtifactGenerator):
+    """Adds schema.org JSON-LD entities to every page."""
+
+    def __init__(self, jinja_env: Environment):
+        super().__init__(jinja_env)
+        self._loaded: Dict[str, Optional[StructuredData]] = {}
+
+    def _load_structured_data(self, data_file: str) -> Optional[StructuredData]:
+        """Loads and memoizes the StructuredData message for a data file."""
+        if data_file not in self._loaded:
+            self._loaded[data_file] = load_dynamic_single_item_data(
+                data_file, StructuredData  # type: ignore
+            )
+        return self._loaded[data_file]
+
+    def get_page_context(
+        self, lang: str, build_context: BuildContext
+    ) -> Dict[str, Any]:
+        """Builds the JSON-LD entities for the page of a language."""
+        settings = build_context.app_config.get("structured_data", {})
+        if not settings.get("enabled", False):
+            return {}
+
+        translations = build_context.translations_by_lang.get(lang, {})
+        base_url: str = build_context.app_config.get("base_url", "")
+        url = page_url(base_url, lang, build_context.default_lang) if base_url else ""
+
+        entities: List[JsonLd] = []
+        site_data = (
+            self._load_structured_data(settings["data_file"])
+            if settings.get("data_file")
+            else None
+        )
+        site_name = translations.get("page_title_default", "")
+        if site_data is not None and site_data.HasField("organization"):
+            organization = self._organization(
+                site_data.organization, translations, base_url
+            )
+            site_name = organization.get("name") or site_name
+            entities.append(organization)
+
+        website: JsonLd = {
+            "@context": SCHEMA_CONTEXT,
+            "@type": "WebSite",
+            "inLanguage": lang,
+        }
+        if site_name:
+            website["name"] = site_name
+        if url:
+            website["url"] = url
+        entities.append(website)
+
+        if site_data is not None:
+            entities.extend(
+                self._product(product, translations, url)
+                for product in site_data.products
+            )
+
+        faq_items: List[FaqItem] = (
+            build_context.block_data.get(settings.get("faq_block", "faq.html")) or []
+        )
+        if faq_items:
+            entities.append(self._faq_page(faq_items, translations))
+
+        posts: List[BlogPost] = (
+            build_context.block_data.get(settings.get("blog_block", "blog.html"))
+            or []
+        )
+        entities.extend(self._article(post, translations, lang, url) for post in posts)
+
+        business: Optional[LocalBusiness] = build_context.block_data.get(
+            settings.get("local_business_block", "business-info.html")
+        )
+        if business is not None and business.name.key:
+            entities.append(self._local_business(business, translations, base_url))
+
+        return {"structured_data": entities}
+
+    def _resolve_url(self, reference: str, url: str) -> str:
+        """Resolves an image or link reference against the page URL.
+
+        Inline data URIs are dropped, as search engines cannot use them.
+        """
+        if not reference or reference.startswith("data:"):
+            return ""
+        return urljoin(url, reference) if url else reference
+
+    def _organization(
+        self, organization: Organization, translations: Translations, base_url: str
+    ) -> JsonLd:
+        """Builds a schema.org Organization entity."""
+        entity: JsonLd = {"@context": SCHEMA_CONTEXT, "@type": "Organization"}
+        name = translations.get(organization.name.key, organization.name.key)
+        if name:
+            entity["name"] = name
+        org_url = organization.url or base_url
+        if org_url:
+            entity["url"] = org_url
+        logo = self._resolve_url(
+            organization.logo, absolute_url(base_url, "") if base_url else ""
+        )
+        if logo:
+            entity["logo"] = logo
+        if organization.same_as:
+            entity["sameAs"] = list(organization.same_as)
+        if organization.email:
+            entity["email"] = organization.email
+        if organization.telephone:
+            entity["telephone"] = organization.telephone
+        return entity
+
+    def _product(self, product: Product, translations: Translations, url: str) -> JsonLd:
+        """Builds a schema.org Product entity, including its Offer."""
+        entity: JsonLd = {
+            "@context": SCHEMA_CONTEXT,
+            "@type": "Product",
+            "name": translations.get(product.name.key, product.name.key),
+        }
+        if product.description.key:
+            entity["description"] = translations.get(
+                product.description.key, product.description.key
+            )
+        image = self._resolve_url(product.image.src, url)
+        if image:
+            entity["image"] = image
+        if product.brand:
+            entity["brand"] = {"@type": "Brand", "name": product.brand}
+        if product.sku:
+            entity["sku"] = product.sku
+        if product.HasField("offer"):
+            offer: JsonLd = {"@type": "Offer", "price": product.offer.price}
+            if product.offer.price_currency:
+                offer["priceCurrency"] = product.offer.price_currency
+            if product.offer.availability:
+                offer["availability"] = (
+                    f"{SCHEMA_CONTEXT}/{product.offer.availability}"
+                )
+            if url:
+                offer["url"] = url
+            entity["offers"] = offer
+        return entity
+
+    def _faq_page(self, faq_items: List[FaqItem], translations: Translations) -> JsonLd:
+        """Builds a schema.org FAQPage entity from the FAQ block's items."""
+        return {
+            "@context": SCHEMA_CONTEXT,
+            "@type": "FAQPage",
+            "mainEntity": [
+                {
+                    "@type": "Question",
+                    "name": translations.get(item.question.key, item.question.key),
+                    "acceptedAnswer": {
+                        "@type": "Answer",
+                        "text": translations.get(item.answer.key, item.answer.key),
+                    },
+                }
+                for item in faq_items
+            ],
+        }
+
+    def _article(
+        self, post: BlogPost, translations: Translations, lang: str, url: str
+    ) -> JsonLd:
+        """Builds a schema.org Article entity for a blog post."""
+        entity: JsonLd = {
+            "@context": SCHEMA_CONTEXT,
+            "@type": "Article",
+            "headline": translations.get(post.title.key, post.title.key),
+            "description": translations.get(post.excerpt.key, post.excerpt.key),
+            "inLanguage": lang,
+        }
+        published = parse_published_date(post.published_date)
+        if published:
+            entity["datePublished"] = published.isoformat()
+        image = self._resolve_url(post.image.src, url)
+        if image:
+            entity["image"] = image
+        article_url = self._resolve_url(post.cta.uri, url)
+        if article_url:
+            entity["url"] = article_url
+        return entity
+
+    def _local_business(
+        self, business: LocalBusiness, translations: Translations, base_url: str
+    ) -> JsonLd:
+        """Builds a schema.org LocalBusiness entity (or subtype)."""
+        entity: JsonLd = {
+            "@context": SCHEMA_CONTEXT,
+            "@type": business.business_type or "LocalBusiness",
+            "name": translations.get(business.name.key, business.name.key),
+        }
+        if business.description.key:
+            entity["description"] = translations.get(
+                business.description.key, business.description.key
+            )
+        business_url = business.url or base_url
+        if business_url:
+            entity["url"] = business_url
+        image = self._resolve_url(
+            business.image.src, absolute_url(base_url, "") if base_url else ""
+        )
+        if image:
+            entity["image"] = image
+        if business.telephone:
+            entity["telephone"] = business.telephone
+        if business.email:
+            entity["email"] = business.email
+        if business.price_range:
+            entity["priceRange"] = business.price_range
+        if business.HasField("address"):
+            address: JsonLd = {"@type": "PostalAddress"}
+            for field, key in (
+                ("street_address", "streetAddress"),
+                ("locality", "addressLocality"),
+                ("region", "addressRegion"),
+                ("postal_code", "postalCode"),
+                ("country", "addressCountry"),
+            ):
+                value = getattr(business.address, field)
+                if value:
+                    address[key] = value
+            entity["address"] = address
+        if business.HasField("geo"):
+            entity["geo"] = {
+                "@type": "GeoCoordinates",
+                "latitude": business.geo.latitude,
+                "longitude": business.geo.longitude,
+            }
+        if business.opening_hours:
+            entity["openingHoursSpecification"] = [
+                {
+                    "@type": "OpeningHoursSpecification",
+                    "dayOfWeek": list(hours.days),
+                    "opens": hours.opens,
+                    "closes": hours.closes,
+                }
+                for hours in business.opening_hours
+            ]
+        if business.map_url:
+            entity["hasMap"] = business.map_url
+        return entity
@@ -0,0 +1,58 @@
+"""
+Creates the Jinja2 environment shared by every part of a build.
+
+The page builder, the block generators and the site artifact generators all
+render through one `Environment`, so each template is parsed once and then
+served from the environment's cache (a thread-safe LRU cache) no matter how
+many blocks, languages or pages use it.
+
+`python build.py serve` keeps that environment across rebuilds. Templates
+are not checked for changes on every render (`auto_reload` is off); instead
+the watcher calls `invalidate_templates` with the changed files, which
+drops the cached templates when any of them is a template.
+"""
+
+import logging
+import os
+from typing import Iterable
+
+from jinja2 import Environment, FileSystemLoader
+
+logger = logging.getLogger(__name__)
+
+TEMPLATE_DIR = "templates"
+
+
+def create_template_environment(template_dir: str = TEMPLATE_DIR) -> Environment:
+    """Returns the environment that renders the site's HTML templates."""
+    return Environment(
+        loader=FileSystemLoader(template_dir),
+        autoescape=True,  # Enable autoescaping
+        auto_reload=False,  # See invalidate_templates.
+    )
+
+
+def invalidate_templates(
+    jinja_env: Environment,
+    changed_paths: Iterable[str],
+    template_dir: str = TEMPLATE_DIR,
+) -> bool:
+    """Drops the cached templates if any changed file is a template.
+
+    Parsing is cheap, so the whole cache is cleared rather than tracking
+    which cache entries belong to the changed files.
+
+    Returns:
+        Whether the cache was cleared.
+    """
+    template_root = os.path.normpath(template_dir) + os.sep
+    changed = [
+        path
+        for path in changed_paths
+        if os.path.normpath(path).startswith(template_root)
+    ]
+    if not changed or jinja_env.cache is None:
+        return False
+    jinja_env.cache.clear()
+    logger.debug("Reloading templates after changes to %s", ", ".join(changed))
+    return True
@@ -0,0 +1,128 @@
+"""
+Bounds how long the network calls and commands of a long-running stage may
+take, so a single hung call cannot stall the build indefinitely.
+
+Every operation gets its own timeout (e.g., one HTTP request, one `git
+push`), and a `Deadline` bounds the stage as a whole: each operation's
+timeout is cut to the time the stage has left, and no operation starts
+once the deadline passed or the stage was cancelled. Deploys read their
+limits from the `deploy` section of `public/config.json` (or a target's
+own section):
+
+    "deploy": {
+      "timeout_seconds": 600,
+      "request_timeout_seconds": 60,
+      "deadline_seconds": 1800
+    }
+
+`cancel_on_signal` cancels a deadline on SIGTERM (what CI systems send to
+a job they cancel), so a stage stops after the operation in progress
+instead of in the middle of it.
+"""
+
+import logging
+import signal
+import threading
+import time
+from contextlib import contextmanager
+from typing import Callable, Iterator, Optional
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_COMMAND_TIMEOUT_SECONDS = 600.0
+DEFAULT_REQUEST_TIMEOUT_SECONDS = 60.0
+
+
+class OperationCancelled(Exception):
+    """Raised instead of starting an operation after a stage was cancelled
+    or ran out of time."""
+
+
+class Deadline:
+    """The time a stage has left, counted from its first operation.
+
+    Without `seconds` the stage has no overall limit, but can still be
+    cancelled.
+    """
+
+    def __init__(
+        self,
+        seconds: Optional[float] = None,
+        clock: Callable[[], float] = time.monotonic,
+    ):
+        self.seconds = seconds
+        self._clock = clock
+        self._expires_at: Optional[float] = None
+        self._cancelled = threading.Event()
+
+    def cancel(self) -> None:
+        """Stops the stage before its next operation."""
+        self._cancelled.set()
+
+    @property
+    def cancelled(self) -> bool:
+        """Whether the stage was cancelled."""
+        return self._cancelled.is_set()
+
+    def remaining(self) -> Optional[float]:
+        """The seconds left, or None without an overall limit."""
+        if self.seconds is None:
+            return None
+        if self._expires_at is None:
+            self._expires_at = self._clock() + self.seconds
+        return max(0.0, self._expires_at - self._clock())
+
+    def timeout(self, operation_seconds: float, operation: str = "") -> float:
+        """Returns the timeout of an operation about to start.
+
+        Args:
+            operation_seconds: The operation's own timeout.
+            operation: Names the operation in the exception.
+
+        Raises:
+            OperationCancelled: If the stage was cancelled or has no time
+                left.
+        """
+        if self.cancelled:
+            raise OperationCancelled(f"Cancelled before {operation or 'finishing'}.")
+        remaining = self.remaining()
+        if remaining is None:
+            return operation_seconds
+        if remaining <= 0:
+            raise OperationCancelled(
+                f"Ran out of the {self.seconds:g} s deadline before "
+                f"{operation or 'finishing'}."
+            )
+        return min(operation_seconds, remaining)
+
+    def check(self, operation: str = "") -> None:
+        """Raises OperationCancelled if the operation may not start."""
+        self.timeout(0.0, operation)
+
+
+@contextmanager
+def cancel_on_signal(
+    deadline: Deadline, signum: int = signal.SIGTERM
+) -> Iterator[Deadline]:
+    """Cancels the deadline when the process receives a signal.
+
+    Signal handlers can only be set on the main thread; elsewhere the
+    deadline is returned as is.
+    """
+
+    def handle(received: int, _frame: object) -> None:
+        logger.warning(
+            "Received %s: stopping after the current operation",
+            signal.Signals(received).name,
+        )
+        deadline.cancel()
+
+    try:
+        previous = signal.signal(signum, handle)
+    except ValueError:
+        yield deadline
+        return
+    try:
+        yield deadline
+    finally:
+        signal.signal(signum, previous)
@@ -0,0 +1,76 @@
+"""
+Selects the A/B variants of blocks, reproducibly or all of them.
+
+A block's data has variants when it lists `variations`, each with a
+`variation_id`, and a `default_variation_id` (e.g., `HeroItem`). A page
+shows the default variant, or a random one when no default is set. Two
+options of `python build.py` change that:
+
+- `--variant-seed SEED` makes the random choice reproducible: the same seed
+  picks the same variant for the same page and language on every build.
+- `--variants all` also builds one page per variant next to each page that
+  has a block with variants, named after the variant (`index.a.html`,
+  `index_es.a.html`), so QA can review each of them and traffic can be split
+  between them at the edge. Variant pages declare the page they vary as
+  their canonical URL, and the build manifest lists them under `variants`.
+
+Variation IDs must be unique across the blocks of a page and usable in file
+names.
+"""
+
+import os
+import random
+from typing import Any, Dict, List, Optional
+
+VARIANT_MODES = ("default", "all")
+
+
+def variation_ids(data: Any) -> List[str]:
+    """Returns the variation IDs of a block's data; empty without variants."""
+    variations = getattr(data, "variations", None) if data else None
+    if not variations:
+        return []
+    return [variation.variation_id for variation in variations]
+
+
+def with_variation(data: Any, variation_id: str) -> Any:
+    """Returns a copy of a block's data that shows the given variation."""
+    selected = type(data)()
+    selected.CopyFrom(data)
+    selected.default_variation_id = variation_id
+    return selected
+
+
+def seeded_variation(data: Any, seed: str, page_key: str) -> Optional[str]:
+    """Picks a block's variation for a page, reproducibly for a seed.
+
+    Args:
+        data: The block's data.
+        seed: The `--variant-seed`.
+        page_key: Identifies the page and block, e.g. "index_es.html:hero.html".
+
+    Returns:
+        The default variation if it exists, otherwise one picked by a random
+        generator seeded with `seed` and `page_key`; None without variants.
+    """
+    ids = variation_ids(data)
+    if not ids:
+        return None
+    if data.default_variation_id in ids:
+        return data.default_variation_id
+    return random.Random(f"{seed}:{page_key}").choice(ids)
+
+
+def variant_filename(filename: str, variation_id: str) -> str:
+    """Names a page's variant, e.g. ("index_es.html", "a") -> "index_es.a.html"."""
+    root, extension = os.path.splitext(filename)
+    return f"{root}.{variation_id}{extension}"
+
+
+def variant_blocks(block_data: Dict[str, Any], blocks: List[str]) -> Dict[str, Any]:
+    """Returns the data of the given blocks that have variants."""
+    return {
+        block: block_data[block]
+        for block in blocks
+        if block in block_data and variation_ids(block_data[block])
+    }
@@ -0,0 +1,405 @@
+"""
+Catches styling regressions by comparing screenshots of the built pages.
+
+`python build.py test visual` builds the site, serves it on a local port
+and screenshots every generated page at each configured viewport with
+headless Chrome (or Chromium). Each screenshot is compared with its baseline
+in `baseline_dir`, pixel by pixel:
+
+- a pixel counts as changed when a color channel differs by more than
+  `pixel_tolerance` (anti-aliasing noise stays below it);
+- a screenshot has changed when more than `threshold` of its pixels did, or
+  when its size differs from the baseline.
+
+The results go to an HTML report in `report_dir` with the baseline, the new
+screenshot and a diff image (changed pixels in red) side by side, plus the
+tag-level diff of the page's HTML (see `site_diff.py`) when it changed too.
+`--update` accepts the new screenshots as baselines; screenshots without a
+baseline are stored as new baselines.
+
+    "visual_regression": {
+      "baseline_dir": "testdata/visual",
+      "report_dir": "visual-report",
+      "viewports": { "mobile": [375, 812], "desktop": [1280, 800] },
+      "threshold": 0.001,
+      "pixel_tolerance": 16
+    }
+
+Chrome is found on the `PATH` (`google-chrome`, `chromium`, ...), or set
+`chrome` to its path; `chrome_flags` adds flags, e.g. `--no-sandbox` in
+containers. Screenshots are decoded by a small PNG reader (8-bit RGB or RGBA,
+as Chrome writes them), so no imaging library is needed.
+"""
+
+import logging
+import os
+import shutil
+import struct
+import subprocess
+import threading
+import zlib
+from contextlib import contextmanager
+from dataclasses import dataclass, field
+from functools import partial
+from http.server import SimpleHTTPRequestHandler, ThreadingHTTPServer
+from typing import Any, Dict, Iterator, List, Optional, Tuple
+
+from jinja2 import Environment
+
+from .atomic_io import write_text_atomic
+from .site_diff import diff_file
+
+logger = logging.getLogger(__name__)
+
+CHROME_COMMANDS = (
+    "google-chrome",
+    "google-chrome-stable",
+    "chromium",
+    "chromium-browser",
+    "chrome",
+)
+DEFAULT_BASELINE_DIR = os.path.join("testdata", "visual")
+DEFAULT_REPORT_DIR = "visual-report"
+DEFAULT_VIEWPORTS = {"mobile": [375, 812], "desktop": [1280, 800]}
+DEFAULT_THRESHOLD = 0.001
+DEFAULT_PIXEL_TOLERANCE = 16
+REPORT_TEMPLATE = "reports/visual-regression.html"
+SCREENSHOT_TIMEOUT_SECONDS = 60
+# Lets fonts, images and entrance animations settle before the screenshot.
+VIRTUAL_TIME_BUDGET_MS = 5000
+
+PNG_SIGNATURE = b"\x89PNG\r\n\x1a\n"
+PNG_CHANNELS = {2: 3, 6: 4}  # Color type: RGB, RGBA.
+DIFF_COLOR = (255, 0, 0, 255)
+
+
+@dataclass
+class Image:
+    """An RGBA image, four bytes per pixel, row by row."""
+
+    width: int
+    height: int
+    pixels: bytearray
+
+
+@dataclass
+class VisualResult:
+    """The comparison of one page at one viewport with its baseline."""
+
+    page: str
+    viewport: str
+    status: str
+    """"unchanged", "changed", "new" (no baseline yet) or "updated"."""
+    changed_ratio: float = 0.0
+    size_changed: bool = False
+    html_diff: List[str] = field(default_factory=list)
+
+    @property
+    def name(self) -> str:
+        """The file name stem of the result's images."""
+        return f"{os.path.splitext(self.page)[0].replace('/', '_')}.{self.viewport}"
+
+
+def _paeth(left: int, up: int, up_left: int) -> int:
+    estimate = left + up - up_left
+    distance_left = abs(estimate - left)
+    distance_up = abs(estimate - up)
+    distance_up_left = abs(estimate - up_left)
+    if distance_left <= distance_up and distance_left <= distance_up_left:
+        return left
+    if distance_up <= distance_up_left:
+        return up
+    return up_left
+
+
+def _unfilter(row: bytearray, previous: bytearray, filter_type: int, bpp: int) -> None:
+    """Reverses a PNG scanline filter in place."""
+    if filter_type == 0:
+        return
+    for i in range(len(row)):
+        left = row[i - bpp] if i >= bpp else 0
+        if filter_type == 1:
+            row[i] = (row[i] + left) & 0xFF
+        elif filter_type == 2:
+            row[i] = (row[i] + previous[i]) & 0xFF
+        elif filter_type == 3:
+            row[i] = (row[i] + ((left + previous[i]) >> 1)) & 0xFF
+        elif filter_type == 4:
+            up_left = previous[i - bpp] if i >= bpp else 0
+            row[i] = (row[i] + _paeth(left, previous[i], up_left)) & 0xFF
+        else:
+            raise ValueError(f"Unknown PNG filter type {filter_type}.")
+
+
+def decode_png(data: bytes) -> Image:
+    """Decodes an 8-bit, non-interlaced RGB or RGBA PNG.
+
+    Raises:
+        ValueError: If the data is not such a PNG.
+    """
+    if not data.startswith(PNG_SIGNATURE):
+        raise ValueError("Not a PNG file.")
+    header: Optional[Tuple[int, ...]] = None
+    compressed = []
+    position = len(PNG_SIGNATURE)
+    while position + 8 <= len(data):
+        (length,) = struct.unpack(">I", data[position : position + 4])
+        kind = data[position + 4 : position + 8]
+        body = data[position + 8 : position + 8 + length]
+        position += length + 12  # Length, type and CRC.
+        if kind == b"IHDR":
+            header = struct.unpack(">IIBBBBB", body)
+        elif kind == b"IDAT":
+            compressed.append(body)
+        elif kind == b"IEND":
+            break
+    if header is None:
+        raise ValueError("PNG file without a header.")
+    width, height, bit_depth, color_type, _, _, interlace = header
+    if bit_depth != 8 or color_type not in PNG_CHANNELS or interlace:
+        raise ValueError("Only 8-bit, non-interlaced RGB(A) PNGs are supported.")
+    channels = PNG_CHANNELS[color_type]
+    raw = zlib.decompress(b"".join(compressed))
+    stride = width * channels
+    if len(raw) < height * (stride + 1):
+        raise ValueError("Truncated PNG image data.")
+    pixels = bytearray()
+    previous = bytearray(stride)
+    for y in range(height):
+        start = y * (stride + 1)
+        row = bytearray(raw[start + 1 : start + 1 + stride])
+        _unfilter(row, previous, raw[start], channels)
+        if channels == 4:
+            pixels += row
+        else:
+            for x in range(0, stride, 3):
+                pixels += row[x : x + 3] + b"\xff"
+        previous = row
+    return Image(width, height, pixels)
+
+
+def encode_png(image: Image) -> bytes:
+    """Encodes an image as an RGBA PNG without scanline filters."""
+
+    def chunk(kind: bytes, body: bytes) -> bytes:
+        return (
+            struct.pack(">I", len(body))
+            + kind
+            + body
+            + struct.pack(">I", zlib.crc32(kind + body) & 0xFFFFFFFF)
+        )
+
+    header = struct.pack(">IIBBBBB", image.width, image.height, 8, 6, 0, 0, 0)
+    stride = image.width * 4
+    raw = b"".join(
+        b"\x00" + bytes(image.pixels[y * stride : (y + 1) * stride])
+        for y in range(image.height)
+    )
+    return (
+        PNG_SIGNATURE
+        + chunk(b"IHDR", header)
+        + chunk(b"IDAT", zlib.compress(raw))
+        + chunk(b"IEND", b"")
+    )
+
+
+def read_png(path: str) -> Image:
+    """Reads a PNG file (see `decode_png`)."""
+    with open(path, "rb") as f:
+        return decode_png(f.read())
+
+
+def compare_images(
+    baseline: Image, actual: Image, pixel_tolerance: int = DEFAULT_PIXEL_TOLERANCE
+) -> Tuple[float, Image]:
+    """Compares two screenshots pixel by pixel.
+
+    Images of different sizes are compared over their common area, and the
+    rest counts as changed.
+
+    Returns:
+        The share of changed pixels and a diff image: the actual screenshot,
+        faded, with the changed pixels in `DIFF_COLOR`.
+    """
+    width = max(baseline.width, actual.width)
+    height = max(baseline.height, actual.height)
+    diff = Image(width, height, bytearray(DIFF_COLOR) * (width * height))
+    changed = width * height
+    for y in range(min(baseline.height, actual.height)):
+        for x in range(min(baseline.width, actual.width)):
+            b = (y * baseline.width + x) * 4
+            a = (y * actual.width + x) * 4
+            d = (y * width + x) * 4
+            old, new = baseline.pixels[b : b + 4], actual.pixels[a : a + 4]
+            if old == new or max(
+                abs(old[i] - new[i]) for i in range(4)
+            ) <= pixel_tolerance:
+                changed -= 1
+                # Fades the unchanged pixel towards white.
+                diff.pixels[d : d + 3] = bytes(
+                    255 - (255 - value) // 4 for value in new[:3]
+                )
+    return changed / (width * height or 1), diff
+
+
+def find_chrome(configured: Optional[str] = None) -> str:
+    """Returns the Chrome or Chromium executable to take screenshots with.
+
+    Raises:
+        RuntimeError: If none is installed.
+    """
+    for command in ([configured] if configured else []) + list(CHROME_COMMANDS):
+        path = shutil.which(command)
+        if path:
+            return path
+    raise RuntimeError(
+        "Install Chrome or Chromium, or set visual_regression.chrome to its path."
+    )
+
+
+def take_screenshot(
+    chrome: str,
+    url: str,
+    path: str,
+    width: int,
+    height: int,
+    extra_flags: Optional[List[str]] = None,
+) -> None:
+    """Screenshots a page with headless Chrome at the given viewport size.
+
+    Raises:
+        RuntimeError: If Chrome failed or wrote no screenshot.
+    """
+    command = [
+        chrome,
+        "--headless=new",
+        "--disable-gpu",
+        "--hide-scrollbars",
+        "--force-device-scale-factor=1",
+        f"--virtual-time-budget={VIRTUAL_TIME_BUDGET_MS}",
+        f"--window-size={width},{height}",
+        f"--screenshot={os.path.abspath(path)}",
+        *(extra_flags or []),
+        url,
+    ]
+    try:
+        subprocess.run(
+            command,
+            check=True,
+            capture_output=True,
+            timeout=SCREENSHOT_TIMEOUT_SECONDS,
+        )
+    except (subprocess.CalledProcessError, subprocess.TimeoutExpired) as e:
+        raise RuntimeError(f"Screenshot of {url} failed: {e}") from e
+    if not os.path.exists(path):
+        raise RuntimeError(f"Chrome wrote no screenshot of {url}.")
+
+
+class _QuietHandler(SimpleHTTPRequestHandler):
+    def log_message(self, format: str, *args: Any) -> None:  # noqa: A002
+        pass
+
+
+@contextmanager
+def serve_directory(root: str) -> Iterator[str]:
+    """Serves a directory on a free local port; yields its base URL."""
+    server = ThreadingHTTPServer(
+        ("127.0.0.1", 0), partial(_QuietHandler, directory=root)
+    )
+    thread = threading.Thread(target=server.serve_forever, daemon=True)
+    thread.start()
+    try:
+        yield f"http://127.0.0.1:{server.server_address[1]}/"
+    finally:
+        server.shutdown()
+        server.server_close()
+
+
+def run_visual_tests(
+    pages: List[str],
+    base_url: str,
+    settings: Dict[str, Any],
+    chrome: str,
+    update: bool = False,
+) -> List[VisualResult]:
+    """Screenshots each page at each viewport and compares it to its baseline.
+
+    The images of the report (baselines, screenshots, diffs) are written to
+    the report directory; baselines are written when missing or `update`.
+    """
+    baseline_dir = settings.get("baseline_dir", DEFAULT_BASELINE_DIR)
+    report_dir = settings.get("report_dir", DEFAULT_REPORT_DIR)
+    threshold = float(settings.get("threshold", DEFAULT_THRESHOLD))
+    tolerance = int(settings.get("pixel_tolerance", DEFAULT_PIXEL_TOLERANCE))
+    viewports = settings.get("viewports", DEFAULT_VIEWPORTS)
+    images_dir = os.path.join(report_dir, "images")
+    os.makedirs(images_dir, exist_ok=True)
+    os.makedirs(baseline_dir, exist_ok=True)
+
+    results = []
+    for page in pages:
+        for viewport, (width, height) in viewports.items():
+            result = VisualResult(page, viewport, "unchanged")
+            actual_path = os.path.join(images_dir, f"{result.name}.actual.png")
+            baseline_path = os.path.join(baseline_dir, f"{result.name}.png")
+            baseline_html = os.path.join(baseline_dir, f"{result.name}.html")
+            take_screenshot(
+                chrome,
+                base_url + page,
+                actual_path,
+                width,
+                height,
+                settings.get("chrome_flags"),
+            )
+            if update or not os.path.exists(baseline_path):
+                result.status = "updated" if os.path.exists(baseline_path) else "new"
+                shutil.copyfile(actual_path, baseline_path)
+                shutil.copyfile(page, baseline_html)
+            else:
+                baseline, actual = read_png(baseline_path), read_png(actual_path)
+                result.changed_ratio, diff = compare_images(baseline, actual, tolerance)
+                result.size_changed = (baseline.width, baseline.height) != (
+                    actual.width,
+                    actual.height,
+                )
+                if result.size_changed or result.changed_ratio > threshold:
+                    result.status = "changed"
+                    with open(
+                        os.path.join(images_dir, f"{result.name}.diff.png"), "wb"
+                    ) as f:
+                        f.write(encode_png(diff))
+                    if os.path.exists(baseline_html):
+                        result.html_diff = diff_file(baseline_html, page, page) or []
+            shutil.copyfile(
+                baseline_path,
+                os.path.join(images_dir, f"{result.name}.baseline.png"),
+            )
+            logger.info(
+                "%s at %s: %s (%.2f%% of pixels changed)",
+                page,
+                viewport,
+                result.status,
+                result.changed_ratio * 100,
+                extra={"file": page},
+            )
+            results.append(result)
+    return results
+
+
+def write_report(
+    results: List[VisualResult], settings: Dict[str, Any], jinja_env: Environment
+) -> str:
+    """Writes the HTML report of a visual test run; returns its path."""
+    report_path = os.path.join(
+        settings.get("report_dir", DEFAULT_REPORT_DIR), "index.html"
+    )
+    template = jinja_env.get_template(REPORT_TEMPLATE)
+    write_text_atomic(
+        report_path,
+        template.render(
+            results=results,
+            changed=[result for result in results if result.status == "changed"],
+            threshold=float(settings.get("threshold", DEFAULT_THRESHOLD)),
+        ),
+    )
+    return report_path
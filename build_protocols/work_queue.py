@@ -0,0 +1,198 @@
+"""
+Runs the pages of a build as jobs on a bounded pool of worker threads.
+
+The orchestrator describes every page it builds (each language's index
+page and error pages) as a `PageJob` and hands the jobs to `run_jobs` as it
+produces them. With the `page_queue` section of `public/config.json`
+enabled, `workers` threads build pages concurrently:
+
+    "page_queue": { "enabled": true, "workers": 4, "max_pending": 16 }
+
+At most `max_pending` jobs wait in the queue: producing jobs is held back
+until workers catch up, so memory stays flat however many pages the site
+has (with `page_streaming` enabled, pages are not held whole either).
+Without the section, jobs run one after another on the calling thread.
+
+Either way, a `ProgressReporter` reports how many pages are built and the
+throughput at the end. Deploys report uploaded files the same way. On a
+terminal, the count is redrawn in place with a spinner and percentage; in
+CI (`CI` is set) or when standard error is redirected, it is logged as a
+plain line at most every `progress_seconds` (default 2). `--quiet` hides
+both.
+"""
+
+import logging
+import os
+import queue
+import sys
+import threading
+import time
+from dataclasses import dataclass
+from typing import Callable, Iterable, List, Optional, TextIO
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_WORKERS = 4
+DEFAULT_PROGRESS_SECONDS = 2.0
+SPINNER_FRAMES = "|/-\\"
+SPINNER_SECONDS = 0.1
+
+
+def is_interactive(stream: TextIO) -> bool:
+    """Whether progress can be redrawn in place on a stream."""
+    isatty = getattr(stream, "isatty", None)
+    return bool(isatty and isatty()) and not os.environ.get("CI")
+
+
+@dataclass
+class PageJob:
+    """One page to build."""
+
+    name: str
+    """The output file, e.g. "index_es.html"."""
+    run: Callable[[], None]
+
+
+class ProgressReporter:
+    """Reports the number of finished items while a stage runs."""
+
+    def __init__(
+        self,
+        total: Optional[int] = None,
+        interval_seconds: float = DEFAULT_PROGRESS_SECONDS,
+        clock: Callable[[], float] = time.monotonic,
+        verb: str = "Built",
+        noun: str = "pages",
+        stream: Optional[TextIO] = None,
+        interactive: Optional[bool] = None,
+    ):
+        self.total = total
+        self.interval_seconds = interval_seconds
+        self.verb = verb
+        self.noun = noun
+        self.done = 0
+        self._clock = clock
+        self._started = clock()
+        self._last_report = self._started
+        self._lock = threading.Lock()
+        self._stream = stream or sys.stderr
+        self._interactive = (
+            is_interactive(self._stream) if interactive is None else interactive
+        )
+        self._frame = 0
+        self._drawn = False
+
+    def _status(self, done: int) -> str:
+        if self.total:
+            return (
+                f"{self.verb} {done} of {self.total} {self.noun} "
+                f"({100 * done // self.total}%)"
+            )
+        return f"{self.verb} {done} {self.noun}"
+
+    def advance(self) -> None:
+        """Counts a finished item and reports progress if it is due."""
+        with self._lock:
+            self.done += 1
+            now = self._clock()
+            interval = SPINNER_SECONDS if self._interactive else self.interval_seconds
+            if now - self._last_report < interval:
+                return
+            self._last_report = now
+            done = self.done
+            if self._interactive:
+                if logger.isEnabledFor(logging.INFO):
+                    spinner = SPINNER_FRAMES[self._frame % len(SPINNER_FRAMES)]
+                    self._frame += 1
+                    self._stream.write(f"\r\033[K{spinner} {self._status(done)}")
+                    self._stream.flush()
+                    self._drawn = True
+                return
+        logger.info(self._status(done))
+
+    def finish(self) -> None:
+        """Clears the progress line and logs the count and throughput."""
+        with self._lock:
+            if self._drawn:
+                self._stream.write("\r\033[K")
+                self._stream.flush()
+                self._drawn = False
+        seconds = self._clock() - self._started
+        logger.info(
+            "%s %d %s in %.1f s (%.1f %s/s)",
+            self.verb,
+            self.done,
+            self.noun,
+            seconds,
+            self.done / seconds if seconds > 0 else 0.0,
+            self.noun,
+        )
+
+
+def run_jobs(
+    jobs: Iterable[PageJob],
+    workers: int = 1,
+    max_pending: Optional[int] = None,
+    progress: Optional[ProgressReporter] = None,
+) -> None:
+    """Runs jobs as they are produced, on up to `workers` threads.
+
+    Args:
+        jobs: The jobs, typically a generator producing them lazily.
+        workers: The number of worker threads; 1 runs the jobs on the
+            calling thread.
+        max_pending: How many produced jobs may wait for a worker; defaults
+            to twice the number of workers.
+        progress: Counts each finished job.
+
+    Raises:
+        Exception: The first exception a job raised. No further jobs are
+            started once a job fails; running ones finish first.
+    """
+    if workers <= 1:
+        for job in jobs:
+            job.run()
+            if progress is not None:
+                progress.advance()
+        return
+
+    pending: "queue.Queue[Optional[PageJob]]" = queue.Queue(
+        maxsize=max_pending or 2 * workers
+    )
+    failures: List[Exception] = []
+    failed = threading.Event()
+
+    def work() -> None:
+        while True:
+            job = pending.get()
+            if job is None:
+                return
+            if failed.is_set():
+                continue  # Drain the queue without starting more jobs.
+            try:
+                job.run()
+            except Exception as e:  # pylint: disable=broad-except
+                failures.append(e)
+                failed.set()
+                continue
+            if progress is not None:
+                progress.advance()
+
+    threads = [
+        threading.Thread(target=work, name=f"page-worker-{index}", daemon=True)
+        for index in range(workers)
+    ]
+    for thread in threads:
+        thread.start()
+    try:
+        for job in jobs:
+            if failed.is_set():
+                break
+            pending.put(job)
+    finally:
+        for _ in threads:
+            pending.put(None)
+        for thread in threads:
+            thread.join()
+    if failures:
+        raise failures[0]
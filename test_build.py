@@ -8,22 +8,116 @@ file system structure to simulate real project files (configs, data, locales,
 blocks) and extensive mocking to isolate units under test.
 """
 
+import base64
+import difflib
+import hashlib
+import hmac
+import io
 import json
+import logging
 import os
+import pstats
+import random
 import re
 import shutil
+import signal
+import struct
+import sys
 import tempfile
+import threading
+import time
+import tracemalloc
 import unittest
-from typing import Any, Dict  # For type hinting self.dummy_config
+import zlib
+from datetime import date, datetime, timezone
+from http.server import ThreadingHTTPServer
+from typing import Any, Dict, List  # For type hinting self.dummy_config
 from unittest import mock
+from urllib.error import HTTPError
+from urllib.parse import urlencode
+from urllib.request import urlopen
+from wsgiref.simple_server import WSGIRequestHandler, make_server
+from wsgiref.util import setup_testing_defaults
 
-from google.protobuf import json_format
+from google.protobuf import descriptor_pool, json_format
 from google.protobuf.message import Message  # Explicit import for T = TypeVar bound
+from google.protobuf.message_factory import GetMessageClass
 from jinja2 import Environment, FileSystemLoader
 
+from build import BuildOrchestrator
+from build import incremental_build
 from build import main as build_main
-from build_protocols.data_loading import JsonProtoDataLoader
+from build_protocols.analytics import AnalyticsSnippetGenerator
+from build_protocols.atomic_io import atomic_write, write_text_atomic
+from build_protocols.backend import (
+    BackendApp,
+    BackendRequest,
+    ThreadingWSGIServer,
+    json_response,
+)
+from build_protocols.breadcrumbs import (
+    breadcrumb_list_json_ld,
+    resolve_breadcrumbs,
+)
+from build_protocols.build_cache import (
+    BuildCache,
+    cache_stats,
+    clean_cache,
+    parse_duration,
+)
+from build_protocols.build_logging import configure_logging
+from build_protocols.build_metrics import BuildTimer, build_manifest, format_timing_table
+from build_protocols.build_report import (
+    group_issues,
+    translation_coverage,
+    variant_inventory,
+)
+from build_protocols.canonical import (
+    find_unresolved_canonicals,
+    resolve_canonical_url,
+)
+from build_protocols.consent import gate_consent_scripts
+from build_protocols.content_api import ContentApiGenerator
+from build_protocols.data_loading import (
+    InMemoryDataCache,
+    JsonProtoDataLoader,
+    ReadWriteLock,
+)
+from build_protocols.deploy import (
+    BucketDeployTarget,
+    DeployError,
+    cache_control_for,
+    collect_site_files,
+    file_md5,
+    preview_directory,
+    select_host_config,
+)
+from build_protocols.deploy_hosts import (
+    CloudflarePagesDeployTarget,
+    GitHubPagesDeployTarget,
+    run_command,
+)
+from build_protocols.dev_server import (
+    LIVE_RELOAD_SCRIPT,
+    DevRequestHandler,
+    SourceWatcher,
+    create_dev_certificate,
+    create_ssl_context,
+    inject_live_reload,
+    served_site_files,
+)
+from build_protocols.edge_split import EdgeSplitGenerator, split_routes
+from build_protocols.feeds import FeedArtifactGenerator
+from build_protocols.form_email import EmailFormSink
+from build_protocols.form_notifications import TelegramFormSink, WebhookFormSink
+from build_protocols.form_storage import SqliteFormSink, SubmissionsHandler
+from build_protocols.forms import (
+    ContactFormHandler,
+    deliver_with_retries,
+    validate_submission,
+)
 from build_protocols.html_generation import (
+    HTML_GENERATOR_REGISTRY,
     BlogHtmlGenerator,
     ContactFormHtmlGenerator,
     FeaturesHtmlGenerator,
@@ -31,16 +125,109 @@ from build_protocols.html_generation import (
     PortfolioHtmlGenerator,
     TestimonialsHtmlGenerator,
 )
-from build_protocols.interfaces import Translations
+from build_protocols.interfaces import BuildContext, FormSubmission, Translations
+from build_protocols.issues import (
+    ERROR,
+    NOTE,
+    WARNING,
+    Issue,
+    apply_issue_levels,
+    format_console,
+    format_json,
+    format_sarif,
+    parse_issue_levels,
+)
+from build_protocols.newsletter import NewsletterHandler
+from build_protocols.outbound_links import (
+    OutboundLinkDecorator,
+    decorate_outbound_links,
+)
+from build_protocols.page_assembly import DefaultPageBuilder, PageAssemblyError
+from build_protocols.performance import (
+    check_budgets,
+    local_resource_path,
+    measure_page,
+)
+from build_protocols.profiling import profiled, trace_events
+from build_protocols.rebuilds import (
+    RebuildQueue,
+    RebuildStatusHandler,
+    WebhookHandler,
+    verify_contentful,
+)
+from build_protocols.redirects import RedirectArtifactGenerator
+from build_protocols.security_headers import (
+    SecurityHeadersGenerator,
+    collect_page_sources,
+    hash_source,
+    source_expression,
+)
+from build_protocols.seo import (
+    TITLE_MAX_LENGTH,
+    build_meta_tags,
+    resolve_page_title,
+    resolve_seo_meta,
+    validate_meta_lengths,
+)
+from build_protocols.site_artifacts import ordered_generators
+from build_protocols.site_diff import (
+    diff_directories,
+    format_site_diff,
+    html_lines,
+    load_manifest_files,
+    snapshot_files,
+)
+from build_protocols.site_files import format_humans_txt, format_security_txt
+from build_protocols.site_urls import (
+    apply_base_path,
+    prefix_root_relative_urls,
+    site_base_path,
+)
+from build_protocols.spam_protection import (
+    CAPTCHA_PROVIDERS,
+    RateLimiter,
+    SpamGuard,
+    issue_form_token,
+)
+from build_protocols.staging import StagingGenerator, htpasswd_line
+from build_protocols.sitemaps import SitemapGenerator
+from build_protocols.structured_data import StructuredDataGenerator
+from build_protocols.template_env import (
+    create_template_environment,
+    invalidate_templates,
+)
+from build_protocols.timeouts import Deadline, OperationCancelled, cancel_on_signal
 from build_protocols.translation import DefaultTranslationProvider
+from build_protocols.variants import (
+    seeded_variation,
+    variant_blocks,
+    variant_filename,
+)
+from build_protocols.visual_regression import (
+    Image,
+    compare_images,
+    decode_png,
+    encode_png,
+    run_visual_tests,
+)
+from build_protocols.work_queue import PageJob, ProgressReporter, run_jobs
 
 # Generated protobuf messages
 from generated.blog_post_pb2 import BlogPost
-from generated.contact_form_config_pb2 import ContactFormConfig
+from generated.contact_form_config_pb2 import (
+    ContactFormConfig,
+    FormFieldRule,
+    SpamProtection,
+)
+from generated.faq_item_pb2 import FaqItem
 from generated.feature_item_pb2 import FeatureItem
 from generated.hero_item_pb2 import HeroItem, HeroItemContent
+from generated.local_business_pb2 import LocalBusiness
 from generated.nav_item_pb2 import Navigation
+from generated.newsletter_pb2 import NewsletterConfig
 from generated.portfolio_item_pb2 import PortfolioItem
+from generated.seo_meta_pb2 import SeoConfig, SeoMeta
+from generated.site_files_pb2 import HumanCredit, HumansTxt, SecurityTxt
 from generated.testimonial_item_pb2 import TestimonialItem
 
 
@@ -755,6 +942,8 @@ class TestBuildScript(unittest.TestCase):
         html = self.hero_generator.generate_html(None, self.en_translations)
         self.assertEqual(html.strip(), "<!-- Hero data not found or no variations -->")
 
+    @mock.patch("build.atomic_write")
+    @mock.patch("build.write_text_atomic")
     @mock.patch("build.DefaultAppConfigManager.load_app_config")
     @mock.patch("build.DefaultTranslationProvider.load_translations")
     @mock.patch("build.DefaultTranslationProvider.translate_html_content")
@@ -795,6 +984,8 @@ class TestBuildScript(unittest.TestCase):
         mock_translate_content,
         mock_load_translations,
         mock_load_app_config,
+        mock_write_text_atomic,
+        mock_atomic_write,
     ):
         """Test that build_main (via BuildOrchestrator) creates output files."""
         mock_load_app_config.return_value = self.dummy_config
@@ -952,35 +1143,21 @@ class TestBuildScript(unittest.TestCase):
             os.path.join("public", "generated_configs", "config_es.json"),
             "index_es.html",
         ]
-        expected_write_calls = [
-            mock.call(os.path.normpath(p), "w", encoding="utf-8")
-            for p in expected_paths
-        ]
-
-        actual_write_calls = [
-            c for c in mock_builtin_open.call_args_list if c.args[1] == "w"
+        # Output files are written atomically, never through a plain open().
+        self.assertEqual(
+            [c for c in mock_builtin_open.call_args_list if c.args[1] == "w"], []
+        )
+        actual_paths = [
+            os.path.normpath(c.args[0])
+            for c in mock_atomic_write.call_args_list
+            + mock_write_text_atomic.call_args_list
         ]
-
         self.assertEqual(
-            len(actual_write_calls),
-            len(expected_write_calls),
-            "Number of write calls does not match.",
+            sorted(actual_paths),
+            sorted(os.path.normpath(p) for p in expected_paths),
+            "Written files do not match.",
         )
 
-        for expected_call in expected_write_calls:
-            normalized_actual_calls = [
-                mock.call(os.path.normpath(c.args[0]), *c.args[1:], **c.kwargs)
-                for c in actual_write_calls
-            ]
-            self.assertIn(
-                expected_call,
-                normalized_actual_calls,
-                (
-                    f"Expected write call not found: {expected_call}\n"
-                    f"Actual write calls: {actual_write_calls}"
-                ),
-            )
-
         mock_load_app_config.assert_called_once()
         self.assertEqual(mock_load_translations.call_count, 2)
         mock_data_cache_preload.assert_called_once()
@@ -1008,5 +1185,3376 @@ class TestBuildScript(unittest.TestCase):
         )
 
 
+
+# A small but complete project (config, data, locales and templates) that
+# the integration tests build from scratch; extend it along with features
+# that change what a build produces.
+FIXTURE_SITE_DIR = os.path.abspath(os.path.join("testdata", "fixture-site"))
+
+
+class TestFixtureSite(unittest.TestCase):
+    """Runs the whole build against the fixture project."""
+
+    def setUp(self) -> None:
+        """Copies the fixture project and builds from within the copy."""
+        site_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, site_dir)
+        shutil.copytree(FIXTURE_SITE_DIR, site_dir, dirs_exist_ok=True)
+        self.addCleanup(os.chdir, os.getcwd())
+        os.chdir(site_dir)
+
+    def _build(self, *argv: str, **config_overrides: Any) -> Any:
+        """Builds the project; returns the exit status (None on success)."""
+        if config_overrides:
+            config_path = os.path.join("public", "config.json")
+            with open(config_path, "r", encoding="utf-8") as f:
+                app_config = json.load(f)
+            app_config.update(config_overrides)
+            with open(config_path, "w", encoding="utf-8") as f:
+                json.dump(app_config, f)
+        try:
+            build_main(["--quiet", "--no-cache", *argv])
+        except SystemExit as e:
+            return e.code
+        return None
+
+    def _read(self, path: str) -> str:
+        with open(path, "r", encoding="utf-8") as f:
+            return f.read()
+
+    def test_build_writes_every_page_per_language(self):
+        """Each language gets its index and error pages, in its language."""
+        self.assertIsNone(self._build())
+
+        index_en, index_es = self._read("index.html"), self._read("index_es.html")
+        self.assertIn("<h1>Build once</h1>", index_en)
+        self.assertIn("<h3>Languages</h3>", index_en)
+        self.assertIn("<summary>Is it static?</summary>", index_en)
+        self.assertIn("<h1>Construye una vez</h1>", index_es)
+        self.assertIn('<html lang="es">', index_es)
+        self.assertIn(
+            '<link href="https://fixture.example.com/index_es.html" rel="canonical"',
+            index_es,
+        )
+        self.assertIn("Página no encontrada", self._read("404_es.html"))
+        self.assertIn('content="noindex"', self._read("404.html"))
+
+        manifest = json.loads(self._read("build-manifest.json"))
+        self.assertEqual(
+            manifest["files"],
+            ["404.html", "404_es.html", "index.html", "index_es.html"],
+        )
+        self.assertEqual(manifest["phases"]["assemble"]["count"], 4)
+
+    def test_error_pages_render_breadcrumbs(self):
+        """The built 404 page shows and declares its trail from the home page."""
+        self.assertIsNone(
+            self._build(
+                breadcrumbs={
+                    "enabled": True,
+                    "pages": {
+                        "index": {"title_key": "nav_home"},
+                        "404": {"parent": "index", "title_key": "error_404_title"},
+                    },
+                }
+            )
+        )
+        not_found_es = self._read("404_es.html")
+        self.assertIn(
+            '<a href="https://fixture.example.com/index_es.html">Inicio</a>',
+            not_found_es,
+        )
+        self.assertIn('"@type": "BreadcrumbList"', not_found_es)
+        self.assertNotIn("BreadcrumbList", self._read("index.html"))
+
+    def test_build_generates_language_configs(self):
+        """Each language's config carries its translated navigation."""
+        self.assertIsNone(self._build())
+        config_es = json.loads(
+            self._read(os.path.join("public", "generated_configs", "config_es.json"))
+        )
+        self.assertEqual(config_es["current_lang"], "es")
+        self.assertEqual(
+            [item["label"] for item in config_es["navigation"]],
+            ["Inicio", "Funciones"],
+        )
+        self.assertEqual(config_es["ui_strings"]["faq_one_answer"], "Sí.")
+
+    def test_link_check_reports_unresolved_canonical(self):
+        """A canonical URL pointing at no generated page is an issue."""
+        canonical = {
+            "verify": True,
+            "pages": {"index": {"lang_paths": {"es": "es/index.html"}}},
+        }
+        self.assertIsNone(self._build(canonical=canonical))
+
+        report = json.loads(self._read("build-issues.json"))
+        self.assertEqual(report["summary"], {"error": 0, "warning": 1, "note": 0})
+        issue = report["issues"][0]
+        self.assertEqual(issue["category"], "unresolved-canonical")
+        self.assertEqual(issue["file"], "index_es.html")
+
+    def test_error_issues_fail_the_build(self):
+        """The build exits with an error, after writing pages and report."""
+        status = self._build(
+            canonical={"pages": {"index": {"path": "home.html"}}},
+            issue_levels={"unresolved-canonical": "error"},
+        )
+        self.assertIn("Build failed", str(status))
+        self.assertTrue(os.path.exists("index_es.html"))
+        report = json.loads(self._read("build-issues.json"))
+        self.assertEqual(report["summary"]["error"], 2)
+
+    def test_all_variants_get_their_own_pages(self):
+        """`--variants all` builds a page per hero variant, listed in the manifest."""
+        self.assertIsNone(self._build("--variants", "all"))
+
+        self.assertIn('data-variation="a"', self._read("index.html"))
+        variant_b = self._read("index_es.b.html")
+        self.assertIn("<h1>Publica más rápido</h1>", variant_b)
+        self.assertIn(
+            '<link href="https://fixture.example.com/index_es.html" rel="canonical"',
+            variant_b,
+        )
+        manifest = json.loads(self._read("build-manifest.json"))
+        self.assertEqual(
+            manifest["variants"],
+            {
+                "index.html": {"a": "index.a.html", "b": "index.b.html"},
+                "index_es.html": {"a": "index_es.a.html", "b": "index_es.b.html"},
+            },
+        )
+        self.assertIn("index_es.b.html", manifest["files"])
+
+    def test_variant_seed_makes_the_choice_reproducible(self):
+        """Without a default variant, the same seed picks the same variants."""
+        hero_path = os.path.join("data", "hero_item.json")
+        hero = json.loads(self._read(hero_path))
+        del hero["default_variation_id"]
+        with open(hero_path, "w", encoding="utf-8") as f:
+            json.dump(hero, f)
+
+        pages = []
+        for _ in range(2):
+            self.assertIsNone(self._build("--variant-seed", "42"))
+            pages.append((self._read("index.html"), self._read("index_es.html")))
+        self.assertEqual(pages[0], pages[1])
+
+    def test_page_queue_builds_the_same_pages(self):
+        """Building on worker threads writes the same files."""
+        self.assertIsNone(self._build())
+        serial = {path: self._read(path) for path in ("index.html", "404_es.html")}
+        self.assertIsNone(self._build(page_queue={"enabled": True, "workers": 3}))
+        for path, content in serial.items():
+            self.assertEqual(self._read(path), content)
+
+
+class TestSiteArtifacts(unittest.TestCase):
+    """Test cases for site artifact generators (feeds, etc.)."""
+
+    def setUp(self) -> None:
+        """Creates a build context with two blog posts and two languages."""
+        self.jinja_env = Environment()
+        self.posts = [
+            BlogPost(
+                id="b1",
+                title={"key": "b1_title"},
+                excerpt={"key": "b1_excerpt"},
+                cta={"text": {"key": "b1_cta"}, "uri": "#b1"},
+                published_date="2024-01-10",
+                image={"src": "images/b1.png"},
+            ),
+            BlogPost(
+                id="b2",
+                title={"key": "b2_title"},
+                excerpt={"key": "b2_excerpt"},
+                cta={"text": {"key": "b2_cta"}, "uri": "#b2"},
+                published_date="2024-02-20T08:30:00Z",
+            ),
+        ]
+        self.build_context = BuildContext(
+            app_config={
+                "base_url": "https://example.com",
+                "feeds": {"enabled": True, "title_key": "blog_title"},
+            },
+            default_lang="en",
+            supported_langs=["en", "es"],
+            block_data={"blog.html": self.posts},
+            translations_by_lang={
+                "en": {"blog_title": "Blog & News", "b1_title": "First"},
+                "es": {"blog_title": "Noticias", "b1_title": "Primero"},
+            },
+        )
+
+    def test_feed_generator_emits_all_formats_per_language(self):
+        """Each language gets RSS, Atom and JSON feeds with localized items."""
+        artifacts = FeedArtifactGenerator(self.jinja_env).generate_artifacts(
+            self.build_context
+        )
+        self.assertEqual(
+            sorted(artifacts),
+            sorted(
+                [
+                    "feed.xml",
+                    "atom.xml",
+                    "feed.json",
+                    "feed_es.xml",
+                    "atom_es.xml",
+                    "feed_es.json",
+                ]
+            ),
+        )
+        rss = artifacts["feed.xml"]
+        self.assertIn("<title>Blog &amp; News</title>", rss)
+        self.assertIn("<link>https://example.com/#b1</link>", rss)
+        self.assertIn('url="https://example.com/images/b1.png"', rss)
+        self.assertIn('type="image/png"', rss)
+        # Newest post first.
+        self.assertLess(rss.index("#b2"), rss.index("#b1"))
+
+        self.assertIn("<title>Primero</title>", artifacts["atom_es.xml"])
+        self.assertIn("https://example.com/index_es.html#b1", artifacts["atom_es.xml"])
+
+        json_feed = json.loads(artifacts["feed.json"])
+        self.assertEqual(json_feed["version"], "https://jsonfeed.org/version/1.1")
+        self.assertEqual(json_feed["feed_url"], "https://example.com/feed.json")
+        self.assertEqual(
+            json_feed["items"][0]["date_published"], "2024-02-20T08:30:00+00:00"
+        )
+
+    def test_feed_generator_adds_alternate_links(self):
+        """Pages link to the feeds of their own language."""
+        context = FeedArtifactGenerator(self.jinja_env).get_page_context(
+            "es", self.build_context
+        )
+        hrefs = [link["href"] for link in context["alternate_links"]]
+        self.assertIn("https://example.com/feed_es.xml", hrefs)
+        self.assertIn("https://example.com/atom_es.xml", hrefs)
+        self.assertEqual(context["alternate_links"][0]["title"], "Noticias")
+
+    def test_structured_data_generator_builds_entities(self):
+        """JSON-LD covers the website, FAQ items and blog articles."""
+        self.build_context.app_config["structured_data"] = {"enabled": True}
+        self.build_context.block_data["faq.html"] = [
+            FaqItem(question={"key": "q1"}, answer={"key": "a1"})
+        ]
+        self.build_context.translations_by_lang["en"].update(
+            {"q1": "Why?", "a1": "Because."}
+        )
+        context = StructuredDataGenerator(self.jinja_env).get_page_context(
+            "en", self.build_context
+        )
+        entities = {entity["@type"]: entity for entity in context["structured_data"]}
+        self.assertEqual(entities["WebSite"]["url"], "https://example.com/")
+        question = entities["FAQPage"]["mainEntity"][0]
+        self.assertEqual(question["name"], "Why?")
+        self.assertEqual(question["acceptedAnswer"]["text"], "Because.")
+        articles = [
+            entity
+            for entity in context["structured_data"]
+            if entity["@type"] == "Article"
+        ]
+        self.assertEqual(len(articles), 2)
+        self.assertEqual(articles[0]["headline"], "First")
+        self.assertEqual(articles[0]["url"], "https://example.com/#b1")
+        self.assertEqual(articles[0]["datePublished"], "2024-01-10T00:00:00+00:00")
+
+    def test_structured_data_generator_builds_local_business(self):
+        """The business info block's data becomes a LocalBusiness entity."""
+        self.build_context.app_config["structured_data"] = {"enabled": True}
+        self.build_context.block_data["business-info.html"] = LocalBusiness(
+            business_type="Bakery",
+            name={"key": "biz"},
+            address={"street_address": "1 Main St", "locality": "Madrid"},
+            geo={"latitude": 40.4, "longitude": -3.7},
+            opening_hours=[
+                {"days": ["Monday", "Tuesday"], "opens": "08:00", "closes": "14:00"}
+            ],
+        )
+        self.build_context.translations_by_lang["en"]["biz"] = "Daily Bread"
+        context = StructuredDataGenerator(self.jinja_env).get_page_context(
+            "en", self.build_context
+        )
+        business = context["structured_data"][-1]
+        self.assertEqual(business["@type"], "Bakery")
+        self.assertEqual(business["name"], "Daily Bread")
+        self.assertEqual(business["url"], "https://example.com")
+        self.assertEqual(
+            business["address"],
+            {
+                "@type": "PostalAddress",
+                "streetAddress": "1 Main St",
+                "addressLocality": "Madrid",
+            },
+        )
+        self.assertEqual(business["geo"]["latitude"], 40.4)
+        self.assertEqual(
+            business["openingHoursSpecification"][0]["dayOfWeek"],
+            ["Monday", "Tuesday"],
+        )
+
+    def test_feed_generator_requires_base_url(self):
+        """Feeds are skipped when no base_url is configured."""
+        del self.build_context.app_config["base_url"]
+        generator = FeedArtifactGenerator(self.jinja_env)
+        self.assertEqual(generator.generate_artifacts(self.build_context), {})
+        self.assertEqual(generator.get_page_context("en", self.build_context), {})
+
+
+class TestSeoMeta(unittest.TestCase):
+    """Test cases for SEO metadata resolution and meta tag generation."""
+
+    def setUp(self) -> None:
+        """Parses a SeoConfig with defaults, a page and a language override."""
+        self.seo_config = SeoConfig()
+        json_format.ParseDict(
+            {
+                "defaults": {
+                    "title": {"key": "site_title"},
+                    "description": {"key": "site_desc"},
+                    "twitter_card": "summary_large_image",
+                },
+                "pages": [
+                    {
+                        "page_id": "index",
+                        "meta": {
+                            "title": {"key": "index_title"},
+                            "image": {
+                                "src": "img/share.png",
+                                "alt_text": {"key": "share_alt"},
+                            },
+                        },
+                        "lang_overrides": {
+                            "es": {"description": {"key": "index_desc_es"}}
+                        },
+                    }
+                ],
+            },
+            self.seo_config,
+        )
+
+    def test_resolve_seo_meta_merges_levels(self):
+        """Page values override defaults and language overrides win last."""
+        meta_en = resolve_seo_meta(self.seo_config, "index", "en")
+        self.assertEqual(meta_en.title.key, "index_title")
+        self.assertEqual(meta_en.description.key, "site_desc")
+        self.assertEqual(meta_en.twitter_card, "summary_large_image")
+
+        meta_es = resolve_seo_meta(self.seo_config, "index", "es")
+        self.assertEqual(meta_es.description.key, "index_desc_es")
+
+        other_page = resolve_seo_meta(self.seo_config, "about", "en")
+        self.assertEqual(other_page.title.key, "site_title")
+        self.assertEqual(resolve_seo_meta(None, "index", "en"), SeoMeta())
+
+    def test_build_meta_tags(self):
+        """OpenGraph and Twitter tags use translations and absolute URLs."""
+        meta = resolve_seo_meta(self.seo_config, "index", "en")
+        tags = build_meta_tags(
+            meta,
+            {"index_title": "Welcome", "site_desc": "About us", "share_alt": "Logo"},
+            "en",
+            page_url="https://example.com/",
+        )
+        contents = {tag["key"]: tag["content"] for tag in tags}
+        self.assertEqual(contents["description"], "About us")
+        self.assertEqual(contents["og:title"], "Welcome")
+        self.assertEqual(contents["og:url"], "https://example.com/")
+        self.assertEqual(contents["og:image"], "https://example.com/img/share.png")
+        self.assertEqual(contents["og:image:alt"], "Logo")
+        self.assertEqual(contents["twitter:card"], "summary_large_image")
+        self.assertNotIn("keywords", contents)
+        og_title = next(tag for tag in tags if tag["key"] == "og:title")
+        self.assertEqual(og_title["attribute"], "property")
+
+    def test_resolve_page_title_uses_meta_title_and_suffix(self):
+        """The <title> prefers the page's meta title and appends the suffix."""
+        meta = resolve_seo_meta(self.seo_config, "index", "en")
+        meta.title_suffix.key = "suffix"
+        translations = {"index_title": "Welcome", "suffix": " | Example"}
+        self.assertEqual(
+            resolve_page_title(meta, translations, "Fallback"), "Welcome | Example"
+        )
+        self.assertEqual(resolve_page_title(SeoMeta(), {}, "Fallback"), "Fallback")
+
+    def test_validate_meta_lengths_warns(self):
+        """Overlong titles and missing descriptions are reported."""
+        issues = validate_meta_lengths(
+            "x" * (TITLE_MAX_LENGTH + 1), "", "index", "es", "index_es.html"
+        )
+        self.assertEqual(
+            [issue.category for issue in issues], ["long-meta", "missing-meta"]
+        )
+        self.assertIn("index (es)", issues[0].message)
+        self.assertEqual(issues[1].file, "index_es.html")
+
+
+# Golden files: the expected HTML of each block, rendered from its sample data
+# in `data/` for every language. Refresh them after intended changes with
+# `UPDATE_GOLDEN=1 python -m unittest test_build.TestGoldenBlocks` and review
+# the diff like any other change.
+GOLDEN_DIR = os.path.join("testdata", "golden")
+UPDATE_GOLDEN = os.environ.get("UPDATE_GOLDEN", "") not in ("", "0")
+# Content that differs between renders of the same input.
+GOLDEN_NORMALIZERS = [
+    (re.compile(r'\bnonce="[^"]*"'), 'nonce="NONCE"'),
+    (
+        re.compile(
+            r"\b\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?"
+            r"(?:Z|[+-]\d{2}:\d{2})?"
+        ),
+        "DATETIME",
+    ),
+]
+
+
+def normalize_golden_html(html: str) -> str:
+    """Masks nondeterministic content and indentation of rendered HTML."""
+    for pattern, replacement in GOLDEN_NORMALIZERS:
+        html = pattern.sub(replacement, html)
+    lines = (line.strip() for line in html.splitlines())
+    return "\n".join(line for line in lines if line) + "\n"
+
+
+class TestGoldenBlocks(unittest.TestCase):
+    """Compares every block, rendered from the sample data, to its golden file."""
+
+    def setUp(self) -> None:
+        """Loads the real config, templates, data and translations."""
+        with open("public/config.json", "r", encoding="utf-8") as f:
+            self.app_config = json.load(f)
+        self.jinja_env = create_template_environment()
+        self.translation_provider = DefaultTranslationProvider()
+
+    def _load_sample_data(self, loader_config: Dict[str, Any]) -> Any:
+        descriptor = descriptor_pool.Default().FindMessageTypeByName(
+            f"{BuildOrchestrator.PROTO_PACKAGE_NAME}."
+            f"{loader_config['message_type_name']}"
+        )
+        message_type = GetMessageClass(descriptor)
+        loader = JsonProtoDataLoader[Message]()
+        if loader_config.get("is_list", True):
+            return loader.load_dynamic_list_data(
+                loader_config["data_file"], message_type
+            )
+        return loader.load_dynamic_single_item_data(
+            loader_config["data_file"], message_type
+        )
+
+    def _render(self, block_name: str, data: Any, lang: str) -> str:
+        generator = HTML_GENERATOR_REGISTRY[block_name](jinja_env=self.jinja_env)
+        translations = self.translation_provider.load_translations(lang)
+        # Heroes without a default variation show a random one; use the first.
+        with mock.patch(
+            "build_protocols.html_generation.random.choice",
+            side_effect=lambda variations: variations[0],
+        ):
+            return normalize_golden_html(generator.generate_html(data, translations))
+
+    def test_blocks_match_golden_files(self):
+        """Each block renders, in each language, exactly as its golden file."""
+        if not UPDATE_GOLDEN and not os.path.isdir(GOLDEN_DIR):
+            self.skipTest(f"No golden files in {GOLDEN_DIR}; set UPDATE_GOLDEN=1")
+        loaders = self.app_config.get("block_data_loaders", {})
+        block_names = [name for name in loaders if name in HTML_GENERATOR_REGISTRY]
+        self.assertTrue(block_names)
+        for block_name in block_names:
+            data = self._load_sample_data(loaders[block_name])
+            for lang in self.app_config.get("supported_langs", ["en"]):
+                with self.subTest(block=block_name, lang=lang):
+                    actual = self._render(block_name, data, lang)
+                    golden_path = os.path.join(
+                        GOLDEN_DIR, f"{os.path.splitext(block_name)[0]}.{lang}.html"
+                    )
+                    if UPDATE_GOLDEN:
+                        write_text_atomic(golden_path, actual)
+                        continue
+                    if not os.path.exists(golden_path):
+                        self.fail(f"Missing {golden_path}; set UPDATE_GOLDEN=1")
+                    with open(golden_path, "r", encoding="utf-8") as f:
+                        expected = f.read()
+                    if actual != expected:
+                        diff = difflib.unified_diff(
+                            expected.splitlines(keepends=True),
+                            actual.splitlines(keepends=True),
+                            golden_path,
+                            "rendered",
+                        )
+                        self.fail(
+                            f"{block_name} ({lang}) differs from its golden file; "
+                            "set UPDATE_GOLDEN=1 if intended:\n" + "".join(diff)
+                        )
+
+    def test_normalize_golden_html(self):
+        """Nonces, timestamps, indentation and blank lines are masked."""
+        html = (
+            '  <script nonce="r4nd0m">\n\n'
+            "    <time>2024-05-01T10:20:30+02:00</time>\n</script>"
+        )
+        self.assertEqual(
+            normalize_golden_html(html),
+            '<script nonce="NONCE">\n<time>DATETIME</time>\n</script>\n',
+        )
+
+
+class TestBuildLogging(unittest.TestCase):
+    """Test cases for the structured log output."""
+
+    def _log(self, log_format, verbosity=0):
+        root = logging.getLogger()
+        self.addCleanup(setattr, root, "handlers", root.handlers[:])
+        self.addCleanup(root.setLevel, root.level)
+        stream = io.StringIO()
+        configure_logging(verbosity, log_format, stream)
+        logger = logging.getLogger("build_protocols.sitemaps")
+        logger.info("Writing file", extra={"file": "sitemap.xml"})
+        logger.warning("Template not found.", extra={"lang": "es", "block": "faq.html"})
+        return stream.getvalue().splitlines()
+
+    def test_text_format(self):
+        """Text lines carry a severity prefix and the fields set."""
+        self.assertEqual(
+            self._log("text"),
+            [
+                "Writing file (file=sitemap.xml)",
+                "Warning: Template not found. (lang=es block=faq.html)",
+            ],
+        )
+        self.assertEqual(len(self._log("text", verbosity=-1)), 1)
+
+    def test_json_format(self):
+        """JSON lines name the level, component and fields."""
+        entry = json.loads(self._log("json")[1])
+        self.assertEqual(entry["level"], "warning")
+        self.assertEqual(entry["component"], "sitemaps")
+        self.assertEqual((entry["lang"], entry["block"]), ("es", "faq.html"))
+        self.assertEqual(entry["message"], "Template not found.")
+
+
+class TestAtomicWrites(unittest.TestCase):
+    """Test cases for atomically replaced output files."""
+
+    def test_failed_write_keeps_previous_file(self):
+        """A write that fails midway leaves the old content and no temp file."""
+        output_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, output_dir)
+        path = os.path.join(output_dir, "pages", "index.html")
+        write_text_atomic(path, "old")
+
+        with self.assertRaises(RuntimeError):
+            with atomic_write(path) as f:
+                f.write("new, but incomplete")
+                raise RuntimeError("render failed")
+        with open(path, encoding="utf-8") as f:
+            self.assertEqual(f.read(), "old")
+        self.assertEqual(os.listdir(os.path.dirname(path)), ["index.html"])
+
+        write_text_atomic(path, "new")
+        with open(path, encoding="utf-8") as f:
+            self.assertEqual(f.read(), "new")
+
+
+class TestPageStreaming(unittest.TestCase):
+    """Test cases for pages streamed to their output files."""
+
+    def setUp(self) -> None:
+        """Creates a Jinja2 environment whose templates render in chunks."""
+        self.template = mock.MagicMock()
+        self.jinja_env = mock.MagicMock()
+        self.jinja_env.get_template.return_value = self.template
+
+    def _orchestrator(self, app_config: Dict[str, Any]) -> BuildOrchestrator:
+        orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=DefaultPageBuilder(mock.MagicMock(), self.jinja_env),
+            html_generators={},
+            artifact_generators={
+                "outbound_links": OutboundLinkDecorator(self.jinja_env)
+            },
+            jinja_env=self.jinja_env,
+        )
+        orchestrator.app_config = app_config
+        orchestrator.build_context = BuildContext(app_config, "en", ["en"])
+        return orchestrator
+
+    def test_main_content_streamed_in_place(self):
+        """The main content is written where the base template places it."""
+        self.template.generate.side_effect = lambda context: iter(
+            ["<main>", context["main_content"], "</main>"]
+        )
+        out = io.StringIO()
+        DefaultPageBuilder(mock.MagicMock(), self.jinja_env).write_translated_page(
+            out, "en", {}, lambda stream: stream.write("<p>Blocks</p>")
+        )
+        self.assertEqual(out.getvalue(), "<main><p>Blocks</p></main>")
+
+    def test_base_template_without_main_content_fails(self):
+        """A base template that drops the main content is an error."""
+        self.template.generate.return_value = iter(["<main></main>"])
+        with self.assertRaises(PageAssemblyError):
+            DefaultPageBuilder(mock.MagicMock(), self.jinja_env).write_translated_page(
+                io.StringIO(), "en", {}, lambda stream: stream.write("<p>Blocks</p>")
+            )
+
+    def test_write_html_matches_generate_html(self):
+        """Blocks written to a stream equal the blocks rendered as strings."""
+        self.template.generate.side_effect = lambda **context: iter(
+            ["<ul>", "<li>One</li>", "</ul>"]
+        )
+        generator = FeaturesHtmlGenerator(self.jinja_env)
+        out = io.StringIO()
+        generator.write_html([FeatureItem()], {}, out)
+        self.assertEqual(out.getvalue(), generator.generate_html([FeatureItem()], {}))
+        self.assertEqual(out.getvalue(), "<ul><li>One</li></ul>")
+
+    def test_enabled_streaming_writes_page_without_assembling_it(self):
+        """With `page_streaming` enabled, pages go straight to their files."""
+        self.template.generate.side_effect = lambda context: iter(
+            ["<main>", context["main_content"], "</main>"]
+        )
+        output_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, output_dir)
+        path = os.path.join(output_dir, "index.html")
+        orchestrator = self._orchestrator({"page_streaming": {"enabled": True}})
+
+        with mock.patch.object(
+            DefaultPageBuilder, "assemble_translated_page"
+        ) as mock_assemble:
+            orchestrator._build_page(
+                path,
+                lambda stream: stream.write("<p>Blocks</p>"),
+                lang="en",
+                translations={},
+            )
+
+        mock_assemble.assert_not_called()
+        with open(path, encoding="utf-8") as f:
+            self.assertEqual(f.read(), "<main><p>Blocks</p></main>")
+        self.assertEqual(orchestrator.written_files, [path])
+
+    def test_streaming_off_while_pages_are_rewritten(self):
+        """Page processors and base paths need whole pages."""
+        streaming = {"enabled": True}
+        self.assertFalse(self._orchestrator({})._streams_pages())
+        self.assertTrue(
+            self._orchestrator({"page_streaming": streaming})._streams_pages()
+        )
+        self.assertFalse(
+            self._orchestrator(
+                {"page_streaming": streaming, "outbound_links": {"enabled": True}}
+            )._streams_pages()
+        )
+        self.assertFalse(
+            self._orchestrator(
+                {"page_streaming": streaming, "base_path": "/preview/"}
+            )._streams_pages()
+        )
+
+
+class _JsonMessage:
+    """A stand-in protobuf message that serializes its fields as JSON."""
+
+    DESCRIPTOR = mock.Mock(
+        full_name="test.JsonMessage", file=mock.Mock(serialized_pb=b"schema")
+    )
+
+    def __init__(self) -> None:
+        self.fields: Dict[str, Any] = {}
+
+    def SerializeToString(self) -> bytes:  # pylint: disable=invalid-name
+        return json.dumps(self.fields).encode("utf-8")
+
+    def ParseFromString(self, data: bytes) -> None:  # pylint: disable=invalid-name
+        self.fields = json.loads(data)
+
+
+class TestBuildCache(unittest.TestCase):
+    """Test cases for the on-disk build cache in `.landingcache/`."""
+
+    def setUp(self) -> None:
+        """Creates an empty cache directory and a data file."""
+        self.temp_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, self.temp_dir)
+        self.cache_dir = os.path.join(self.temp_dir, ".landingcache")
+        self.data_path = os.path.join(self.temp_dir, "items.json")
+        write_text_atomic(self.data_path, '[{"title": "One"}, {"title": "Two"}]')
+
+    def _load(self, loader: JsonProtoDataLoader) -> List[Any]:
+        with mock.patch(
+            "build_protocols.data_loading.json_format.ParseDict",
+            side_effect=lambda data, message: message.fields.update(data),
+        ) as self.mock_parse:
+            return loader.load_dynamic_list_data(self.data_path, _JsonMessage)
+
+    def test_parsed_data_is_decoded_from_the_cache(self):
+        """A second load of an unchanged file skips parsing the JSON."""
+        loader = JsonProtoDataLoader(BuildCache(self.cache_dir))
+        first = self._load(loader)
+        self.assertEqual(self.mock_parse.call_count, 2)
+
+        second = self._load(loader)
+        self.mock_parse.assert_not_called()
+        self.assertEqual(
+            [item.fields for item in second], [item.fields for item in first]
+        )
+
+        write_text_atomic(self.data_path, '[{"title": "Changed"}]')
+        changed = self._load(loader)
+        self.assertEqual(self.mock_parse.call_count, 1)
+        self.assertEqual([item.fields for item in changed], [{"title": "Changed"}])
+
+    def test_corrupt_entry_is_parsed_again(self):
+        """An unreadable entry is a miss, not an error."""
+        cache = BuildCache(self.cache_dir)
+        loader = JsonProtoDataLoader(cache)
+        self._load(loader)
+        [path] = [
+            os.path.join(directory, name)
+            for directory, _, names in os.walk(self.cache_dir)
+            for name in names
+        ]
+        write_text_atomic(path, "\x00\x00\x01")
+
+        items = self._load(loader)
+        self.assertEqual(self.mock_parse.call_count, 2)
+        self.assertEqual(len(items), 2)
+
+    def test_stats_and_clean_by_age(self):
+        """`clean --older-than` only deletes entries unused for that long."""
+        cache = BuildCache(self.cache_dir)
+        cache.put("data", "aa11", b"old")
+        cache.put("data", "bb22", b"recent")
+        cache.put("images", "cc33", b"image")
+        now = time.time()
+        os.utime(cache.path("data", "aa11"), (now - 40 * 86400,) * 2)
+
+        stats = {entry.namespace: entry for entry in cache_stats(self.cache_dir)}
+        self.assertEqual(stats["data"].files, 2)
+        self.assertEqual(stats["data"].size_bytes, 9)
+        self.assertEqual(stats["images"].files, 1)
+
+        self.assertEqual(
+            clean_cache(self.cache_dir, parse_duration("30d"), now), (1, 3)
+        )
+        self.assertIsNone(cache.get("data", "aa11"))
+        self.assertEqual(cache.get("data", "bb22"), b"recent")
+        self.assertFalse(os.path.exists(os.path.dirname(cache.path("data", "aa11"))))
+
+        self.assertEqual(clean_cache(self.cache_dir), (2, 11))
+        self.assertEqual(cache_stats(self.cache_dir), [])
+
+    def test_parse_duration(self):
+        """Durations take an optional s, m, h, d or w unit."""
+        self.assertEqual(parse_duration("90"), 90)
+        self.assertEqual(parse_duration("12h"), 12 * 3600)
+        self.assertEqual(parse_duration("1.5d"), 1.5 * 86400)
+        with self.assertRaises(ValueError):
+            parse_duration("30 days")
+
+
+class TestDataCache(unittest.TestCase):
+    """Test cases for the shared, thread-safe data cache."""
+
+    def test_ttl_and_source_invalidation(self):
+        """Entries expire after the TTL and are dropped with their source."""
+        now = [100.0]
+        cache = InMemoryDataCache(ttl_seconds=10, clock=lambda: now[0])
+        cache.set_item("data/faq_items.json", ["faq"])
+        cache.set_item("hero", "hero", source="./data/hero_item.json")
+        now[0] += 9
+        self.assertEqual(cache.get_item("data/faq_items.json"), ["faq"])
+
+        self.assertEqual(cache.invalidate_sources(["data/hero_item.json"]), ["hero"])
+        self.assertIsNone(cache.get_item("hero"))
+        now[0] += 2
+        self.assertIsNone(cache.get_item("data/faq_items.json"))
+
+    def test_snapshot_is_isolated(self):
+        """A snapshot keeps the data it was taken with."""
+        cache = InMemoryDataCache()
+        cache.set_item("data/a.json", "old")
+        snapshot = cache.snapshot()
+        cache.set_item("data/a.json", "new")
+        cache.set_item("data/b.json", "added")
+        self.assertEqual(snapshot.get_item("data/a.json"), "old")
+        self.assertIsNone(snapshot.get_item("data/b.json"))
+        cache.clear()
+        self.assertIsNone(cache.get_item("data/a.json"))
+
+    def test_writers_exclude_readers(self):
+        """No reader sees the lock while a writer holds it, and vice versa."""
+        lock = ReadWriteLock()
+        state = {"readers": 0, "writing": False, "violations": 0}
+        guard = threading.Lock()
+
+        def read() -> None:
+            for _ in range(200):
+                with lock.read():
+                    with guard:
+                        state["readers"] += 1
+                        state["violations"] += state["writing"]
+                    with guard:
+                        state["readers"] -= 1
+
+        def write() -> None:
+            for _ in range(200):
+                with lock.write():
+                    with guard:
+                        state["violations"] += state["writing"] or state["readers"]
+                        state["writing"] = True
+                    with guard:
+                        state["writing"] = False
+
+        threads = [threading.Thread(target=read) for _ in range(4)]
+        threads += [threading.Thread(target=write) for _ in range(2)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join(timeout=10)
+        self.assertFalse(any(thread.is_alive() for thread in threads))
+        self.assertEqual(state["violations"], 0)
+
+    def test_concurrent_use(self):
+        """Concurrent sets, reads, invalidations and snapshots do not race."""
+        cache = InMemoryDataCache()
+        errors = []
+
+        def work(worker: int) -> None:
+            try:
+                for i in range(300):
+                    key = f"data/{i % 7}.json"
+                    cache.set_item(key, [worker, i])
+                    value = cache.get_item(key)
+                    self.assertTrue(value is None or len(value) == 2)
+                    if i % 50 == 0:
+                        cache.invalidate_sources([key])
+                        cache.snapshot()
+            except Exception as e:  # pylint: disable=broad-except
+                errors.append(e)
+
+        threads = [threading.Thread(target=work, args=(n,)) for n in range(4)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join(timeout=10)
+        self.assertEqual(errors, [])
+
+
+class TestBuildMetrics(unittest.TestCase):
+    """Test cases for build phase timings and the build manifest."""
+
+    def test_timings_table_and_manifest(self):
+        """Phases are summed, listed and written to the manifest."""
+        timer = BuildTimer()
+        with timer.phase("config"):
+            pass
+        for block in ("hero.html", "faq.html"):
+            with timer.phase("render", lang="en", block=block):
+                pass
+        with self.assertRaises(ValueError):
+            with timer.phase("artifacts", component="feeds"):
+                raise ValueError("broken")
+
+        self.assertEqual(timer.summary()["render"]["count"], 2)
+        table = format_timing_table(timer)
+        self.assertEqual(
+            [line.split()[0] for line in table.splitlines()[:5]],
+            ["Phase", "config", "render", "artifacts", "total"],
+        )
+        self.assertIn("render en hero.html", table)
+
+        manifest = build_manifest(timer, ["./index.html", "feed.xml"], "production")
+        self.assertEqual(manifest["files"], ["feed.xml", "index.html"])
+        self.assertNotIn("variants", manifest)
+        self.assertEqual(manifest["phases"]["artifacts"]["count"], 1)
+        self.assertGreater(manifest["pages_per_second"], 0)
+        self.assertEqual(
+            manifest["timings"][1].keys(),
+            {"phase", "seconds", "offset_seconds", "lang", "block"},
+        )
+
+        events = trace_events(timer)["traceEvents"]
+        self.assertEqual(events[1]["name"], "render en hero.html")
+        self.assertEqual(events[1]["tid"], events[2]["tid"])
+        self.assertEqual(
+            [event["args"]["name"] for event in events if event["ph"] == "M"],
+            ["config", "render", "artifacts"],
+        )
+
+    def test_profiles_are_written(self):
+        """CPU and memory profiles load with pstats and tracemalloc."""
+        profile_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, profile_dir)
+        cpu_path = os.path.join(profile_dir, "build.prof")
+        mem_path = os.path.join(profile_dir, "mem", "build.mem")
+        with profiled(cpu_path, mem_path):
+            sorted(range(1000))
+        self.assertGreater(pstats.Stats(cpu_path).total_calls, 0)
+        self.assertIsNotNone(tracemalloc.Snapshot.load(mem_path))
+
+
+STRESS_THREADS = int(os.environ.get("STRESS_THREADS", "8"))
+STRESS_PAGES = int(os.environ.get("STRESS_PAGES", "200"))
+
+
+class TestConcurrentRendering(unittest.TestCase):
+    """Builds many pages from several threads at once.
+
+    Raise `STRESS_THREADS` and `STRESS_PAGES` to stress harder.
+    """
+
+    def setUp(self) -> None:
+        """Creates templates that render each page's items."""
+        self.output_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, self.output_dir)
+        self.template = mock.MagicMock()
+        self.template.generate.side_effect = lambda **context: iter(
+            ["<ul>"] + [f"<li>{item}</li>" for item in context["items"]] + ["</ul>"]
+        )
+        self.template.render.side_effect = lambda context: (
+            f"<html><head></head><body>{context['main_content']}</body></html>"
+        )
+        self.jinja_env = mock.MagicMock()
+        self.jinja_env.get_template.return_value = self.template
+
+    def _run_concurrently(self, work, count: int) -> None:
+        """Runs `work(i)` for each i below `count` on `STRESS_THREADS` threads."""
+        errors: List[BaseException] = []
+        indices = iter(range(count))
+        lock = threading.Lock()
+
+        def worker() -> None:
+            while True:
+                with lock:
+                    index = next(indices, None)
+                if index is None:
+                    return
+                try:
+                    work(index)
+                except BaseException as e:  # pylint: disable=broad-except
+                    errors.append(e)
+
+        threads = [threading.Thread(target=worker) for _ in range(STRESS_THREADS)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join()
+        self.assertEqual(errors, [])
+
+    def test_timer_records_every_concurrent_phase(self):
+        """No timing is lost when phases finish on several threads."""
+        timer = BuildTimer()
+
+        def work(index: int) -> None:
+            with timer.phase("render", lang="en", block=f"block{index}.html"):
+                pass
+
+        self._run_concurrently(work, STRESS_PAGES)
+        self.assertEqual(timer.summary()["render"]["count"], STRESS_PAGES)
+
+    def test_pages_built_concurrently_are_complete(self):
+        """Every page, issue and page source survives concurrent builds."""
+        app_config = {"security_headers": {"enabled": True}}
+        headers = SecurityHeadersGenerator(self.jinja_env)
+        orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=DefaultPageBuilder(mock.MagicMock(), self.jinja_env),
+            html_generators={},
+            artifact_generators={"security_headers": headers},
+            jinja_env=self.jinja_env,
+        )
+        orchestrator.app_config = app_config
+        orchestrator.build_context = BuildContext(app_config, "en", ["en"])
+        generator = FeaturesHtmlGenerator(self.jinja_env)
+
+        def work(index: int) -> None:
+            items = [f"page{index}-item{item}" for item in range(3)]
+            orchestrator._build_page(
+                os.path.join(self.output_dir, f"page{index}.html"),
+                lambda out: generator.write_html(items, {}, out),
+                lang="en",
+                translations={},
+            )
+            orchestrator._report_issues(
+                [Issue(NOTE, "stress", f"Page {index}.", "test")]
+            )
+
+        with mock.patch("build.logger"):
+            self._run_concurrently(work, STRESS_PAGES)
+
+        self.assertEqual(len(orchestrator.written_files), STRESS_PAGES)
+        self.assertEqual(len(orchestrator.issues), STRESS_PAGES)
+        self.assertEqual(len(headers.page_sources), STRESS_PAGES)
+        for index in range(STRESS_PAGES):
+            with open(
+                os.path.join(self.output_dir, f"page{index}.html"), encoding="utf-8"
+            ) as f:
+                self.assertIn(f"<li>page{index}-item2</li></ul></body>", f.read())
+
+
+class TestWorkQueue(unittest.TestCase):
+    """Test cases for running page jobs on bounded workers."""
+
+    def test_jobs_run_on_workers_with_bounded_backlog(self):
+        """Every job runs, and production waits for workers to catch up."""
+        lock = threading.Lock()
+        counts = {"produced": 0, "finished": 0, "backlog": 0}
+
+        def finish() -> None:
+            time.sleep(0.001)
+            with lock:
+                counts["finished"] += 1
+
+        def jobs():
+            for index in range(100):
+                with lock:
+                    counts["produced"] += 1
+                    counts["backlog"] = max(
+                        counts["backlog"], counts["produced"] - counts["finished"]
+                    )
+                yield PageJob(f"page{index}.html", finish)
+
+        progress = ProgressReporter(total=100)
+        run_jobs(jobs(), workers=4, max_pending=4, progress=progress)
+        self.assertEqual(counts["finished"], 100)
+        self.assertEqual(progress.done, 100)
+        # Queued jobs, jobs in the workers' hands and the one being produced.
+        self.assertLessEqual(counts["backlog"], 4 + 4 + 1)
+
+    def test_failing_job_stops_the_queue(self):
+        """The first failure is raised and no further jobs start."""
+        started = []
+
+        def job(index: int) -> PageJob:
+            def run() -> None:
+                started.append(index)
+                if index == 0:
+                    raise ValueError("broken page")
+
+            return PageJob(f"page{index}.html", run)
+
+        with self.assertRaisesRegex(ValueError, "broken page"):
+            run_jobs((job(index) for index in range(1000)), workers=2)
+        self.assertLess(len(started), 1000)
+
+    def test_progress_is_logged_at_intervals(self):
+        """Progress is logged once per interval, then the throughput."""
+        now = [0.0]
+        progress = ProgressReporter(
+            total=4, interval_seconds=2, clock=lambda: now[0], interactive=False
+        )
+        with self.assertLogs("build_protocols.work_queue", "INFO") as logs:
+            for _ in range(4):
+                now[0] += 1
+                progress.advance()
+            progress.finish()
+        self.assertEqual(
+            [record.getMessage() for record in logs.records],
+            [
+                "Built 2 of 4 pages (50%)",
+                "Built 4 of 4 pages (100%)",
+                "Built 4 pages in 4.0 s (1.0 pages/s)",
+            ],
+        )
+
+    def test_progress_is_redrawn_on_a_terminal(self):
+        """On a terminal the progress line is redrawn, then cleared."""
+        now = [0.0]
+        stream = io.StringIO()
+        progress = ProgressReporter(
+            total=2,
+            clock=lambda: now[0],
+            verb="Uploaded",
+            noun="files",
+            stream=stream,
+            interactive=True,
+        )
+        with self.assertLogs("build_protocols.work_queue", "INFO") as logs:
+            for _ in range(2):
+                now[0] += 1
+                progress.advance()
+            progress.finish()
+        self.assertEqual(
+            stream.getvalue(),
+            "\r\033[K| Uploaded 1 of 2 files (50%)"
+            "\r\033[K/ Uploaded 2 of 2 files (100%)"
+            "\r\033[K",
+        )
+        self.assertEqual(
+            [record.getMessage() for record in logs.records],
+            ["Uploaded 2 files in 2.0 s (1.0 files/s)"],
+        )
+
+
+class TestSiteDiff(unittest.TestCase):
+    """Test cases for comparing the output of two builds."""
+
+    def setUp(self) -> None:
+        """Creates an old and a new output directory."""
+        self.old_dir = tempfile.mkdtemp()
+        self.new_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, self.old_dir)
+        self.addCleanup(shutil.rmtree, self.new_dir)
+
+    def _write(self, root: str, name: str, content: Any) -> None:
+        path = os.path.join(root, name)
+        os.makedirs(os.path.dirname(path), exist_ok=True)
+        mode = "wb" if isinstance(content, bytes) else "w"
+        with open(path, mode) as f:
+            f.write(content)
+
+    def test_html_lines(self):
+        """Markup is split before each tag and whitespace is collapsed."""
+        self.assertEqual(
+            html_lines("<!DOCTYPE html><p>Hello\n   world</p>\n<br/>"),
+            ["<!DOCTYPE html>\n", "<p>Hello world\n", "</p>\n", "<br/>\n"],
+        )
+
+    def test_diff_directories(self):
+        """Added, removed and changed files are reported with tag-level diffs."""
+        page = '<html><body><h1 class="title">{}</h1><p>Same</p></body></html>'
+        self._write(self.old_dir, "index.html", page.format("Old"))
+        self._write(self.new_dir, "index.html", page.format("New"))
+        self._write(self.old_dir, "index_es.html", page.format("Hola"))
+        self._write(self.new_dir, "index_es.html", page.format("Hola") + "\n  ")
+        self._write(self.old_dir, "gone.html", "<p>x</p>")
+        self._write(self.new_dir, "public/feed.xml", "<rss/>")
+        self._write(self.old_dir, "logo.png", b"\x89PNG\xff")
+        self._write(self.new_dir, "logo.png", b"\x89PNG\xfe")
+
+        site_diff = diff_directories(self.old_dir, self.new_dir)
+        self.assertEqual(site_diff.added, ["public/feed.xml"])
+        self.assertEqual(site_diff.removed, ["gone.html"])
+        self.assertEqual(sorted(site_diff.changed), ["index.html", "logo.png"])
+        self.assertIn('-<h1 class="title">Old\n', site_diff.changed["index.html"])
+        self.assertIn('+<h1 class="title">New\n', site_diff.changed["index.html"])
+        self.assertEqual(site_diff.changed["logo.png"], [])
+
+        report = format_site_diff(site_diff)
+        self.assertTrue(report.startswith("1 added, 1 removed, 2 changed"))
+        self.assertIn("Binary file logo.png changed", report)
+        self.assertNotIn("@@", format_site_diff(site_diff, names_only=True))
+
+    def test_diff_command_exit_status(self):
+        """The command exits with 1 when the builds differ."""
+        self._write(self.old_dir, "index.html", "<p>Same</p>")
+        self._write(self.new_dir, "index.html", "<p>Same</p>")
+        with mock.patch("sys.stdout", new_callable=io.StringIO) as stdout:
+            build_main(["diff", self.old_dir, self.new_dir])
+        self.assertEqual(stdout.getvalue().strip(), "No changes.")
+
+        self._write(self.new_dir, "about.html", "<p>New</p>")
+        with mock.patch("sys.stdout", new_callable=io.StringIO) as stdout:
+            with self.assertRaises(SystemExit) as raised:
+                build_main(["diff", self.old_dir, self.new_dir])
+        self.assertEqual(raised.exception.code, 1)
+        self.assertIn("added:   about.html", stdout.getvalue())
+
+    def test_snapshot_of_manifest_files(self):
+        """Only the listed files that still exist are copied."""
+        self._write(self.old_dir, "build-manifest.json", '{"files": ["a.html"]}')
+        self.assertEqual(
+            load_manifest_files(os.path.join(self.old_dir, "build-manifest.json")),
+            ["a.html"],
+        )
+        cwd = os.getcwd()
+        os.chdir(self.old_dir)
+        self.addCleanup(os.chdir, cwd)
+        self._write(self.old_dir, "public/a.css", "a {}")
+        self.assertEqual(
+            snapshot_files(["public/a.css", "missing.html"], self.new_dir),
+            ["public/a.css"],
+        )
+        self.assertTrue(os.path.isfile(os.path.join(self.new_dir, "public/a.css")))
+
+
+class TestVisualRegression(unittest.TestCase):
+    """Test cases for screenshot comparison and its PNG handling."""
+
+    def _image(self, width: int, height: int, color: bytes) -> Image:
+        return Image(width, height, bytearray(color * (width * height)))
+
+    def test_png_round_trip(self):
+        """Encoded images decode to the same pixels."""
+        image = self._image(3, 2, b"\x10\x20\x30\xff")
+        image.pixels[4:8] = b"\x00\x00\x00\x80"
+        self.assertEqual(decode_png(encode_png(image)), image)
+        with self.assertRaises(ValueError):
+            decode_png(b"GIF89a")
+
+    def test_decode_filtered_rgb_png(self):
+        """RGB rows with Sub and Up filters are reconstructed as RGBA."""
+        # Two pixels per row: (1, 2, 3) then (2, 4, 6), both rows.
+        raw = bytes([1, 1, 2, 3, 1, 2, 3]) + bytes([2, 0, 0, 0, 0, 0, 0])
+        header = struct.pack(">IIBBBBB", 2, 2, 8, 2, 0, 0, 0)
+
+        def chunk(kind: bytes, body: bytes) -> bytes:
+            crc = struct.pack(">I", zlib.crc32(kind + body) & 0xFFFFFFFF)
+            return struct.pack(">I", len(body)) + kind + body + crc
+
+        data = (
+            b"\x89PNG\r\n\x1a\n"
+            + chunk(b"IHDR", header)
+            + chunk(b"IDAT", zlib.compress(raw))
+            + chunk(b"IEND", b"")
+        )
+        self.assertEqual(
+            bytes(decode_png(data).pixels),
+            bytes([1, 2, 3, 255, 2, 4, 6, 255] * 2),
+        )
+
+    def test_compare_images(self):
+        """Changes within the tolerance are ignored; size changes count."""
+        baseline = self._image(2, 2, b"\x80\x80\x80\xff")
+        noisy = self._image(2, 2, b"\x84\x80\x80\xff")
+        self.assertEqual(compare_images(baseline, noisy, pixel_tolerance=8)[0], 0.0)
+        changed = self._image(2, 2, b"\x80\x80\x80\xff")
+        changed.pixels[0:4] = b"\x00\x00\x00\xff"
+        ratio, diff = compare_images(baseline, changed)
+        self.assertEqual(ratio, 0.25)
+        self.assertEqual(bytes(diff.pixels[0:4]), b"\xff\x00\x00\xff")
+        taller = self._image(2, 4, b"\x80\x80\x80\xff")
+        self.assertEqual(compare_images(baseline, taller)[0], 0.5)
+
+    def test_run_visual_tests(self):
+        """Missing baselines are created, then changed screenshots reported."""
+        work_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, work_dir)
+        cwd = os.getcwd()
+        os.chdir(work_dir)
+        self.addCleanup(os.chdir, cwd)
+        with open("index.html", "w", encoding="utf-8") as f:
+            f.write("<h1>Hi</h1>")
+        settings = {"viewports": {"mobile": [2, 2]}}
+        screenshots = [
+            self._image(2, 2, b"\xff\xff\xff\xff"),
+            self._image(2, 2, b"\x00\x00\x00\xff"),
+        ]
+
+        def fake_screenshot(chrome, url, path, width, height, flags):
+            self.assertEqual(url, "http://site/index.html")
+            with open(path, "wb") as f:
+                f.write(encode_png(screenshots.pop(0)))
+
+        with mock.patch(
+            "build_protocols.visual_regression.take_screenshot",
+            side_effect=fake_screenshot,
+        ):
+            first = run_visual_tests(["index.html"], "http://site/", settings, "c")
+            with open("index.html", "w", encoding="utf-8") as f:
+                f.write("<h1>Hello</h1>")
+            second = run_visual_tests(["index.html"], "http://site/", settings, "c")
+
+        self.assertEqual(first[0].status, "new")
+        self.assertTrue(os.path.exists("testdata/visual/index.mobile.png"))
+        self.assertEqual(second[0].status, "changed")
+        self.assertEqual(second[0].changed_ratio, 1.0)
+        self.assertIn("+<h1>Hello\n", second[0].html_diff)
+        self.assertTrue(os.path.exists("visual-report/images/index.mobile.diff.png"))
+
+
+class TestIssues(unittest.TestCase):
+    """Test cases for build check issues and their reports."""
+
+    def setUp(self) -> None:
+        """Creates a warning without and an error with a position."""
+        self.issues = [
+            Issue(
+                WARNING,
+                "unresolved-canonical",
+                "Canonical URL does not resolve.",
+                "canonical",
+                file="index_es.html",
+            ),
+            Issue(
+                ERROR,
+                "oversized-image",
+                "Image is too large.",
+                "performance",
+                file="index.html",
+                line=12,
+                column=5,
+                suggestion="Compress it.",
+            ),
+        ]
+
+    def test_console_report_lists_errors_first(self):
+        """Each issue gets a line with its location, then a summary."""
+        self.assertEqual(
+            format_console(self.issues).splitlines(),
+            [
+                "index.html:12:5: error [oversized-image] Image is too large. "
+                "(Compress it.)",
+                "index_es.html: warning [unresolved-canonical] "
+                "Canonical URL does not resolve.",
+                "2 issues: 1 error, 1 warning, 0 notes",
+            ],
+        )
+
+    def test_json_report(self):
+        """The JSON report counts the issues and omits unknown fields."""
+        report = json.loads(format_json(self.issues))
+        self.assertEqual(report["summary"], {"error": 1, "warning": 1, "note": 0})
+        self.assertNotIn("line", report["issues"][0])
+        self.assertEqual(report["issues"][1]["suggestion"], "Compress it.")
+
+    def test_sarif_report(self):
+        """The SARIF log has a rule per category and a result per issue."""
+        run = json.loads(format_sarif(self.issues))["runs"][0]
+        self.assertEqual(
+            [rule["id"] for rule in run["tool"]["driver"]["rules"]],
+            ["oversized-image", "unresolved-canonical"],
+        )
+        result = run["results"][1]
+        self.assertEqual(result["ruleId"], "oversized-image")
+        self.assertEqual(result["ruleIndex"], 0)
+        self.assertEqual(result["level"], "error")
+        location = result["locations"][0]["physicalLocation"]
+        self.assertEqual(location["artifactLocation"]["uri"], "index.html")
+        self.assertEqual(location["region"], {"startLine": 12, "startColumn": 5})
+        self.assertEqual(result["properties"]["suggestion"], "Compress it.")
+        unpositioned = run["results"][0]["locations"][0]["physicalLocation"]
+        self.assertNotIn("region", unpositioned)
+
+    def test_issue_levels(self):
+        """Categories can be ignored, or reported as warnings or errors."""
+        levels = parse_issue_levels(
+            {"unresolved-canonical": "error", "oversized-image": "ignore"}
+        )
+        [issue] = apply_issue_levels(self.issues, levels)
+        self.assertEqual(issue.category, "unresolved-canonical")
+        self.assertEqual(issue.severity, ERROR)
+        self.assertEqual(apply_issue_levels(self.issues, {}), self.issues)
+        with self.assertRaises(ValueError):
+            parse_issue_levels({"long-meta": "fatal"})
+
+    def test_orchestrator_logs_and_writes_issues(self):
+        """Reported issues are logged and written in the configured format."""
+        output_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, output_dir)
+        path = os.path.join(output_dir, "issues.json")
+        orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=mock.MagicMock(),
+            html_generators={},
+            artifact_generators={},
+            jinja_env=mock.MagicMock(),
+        )
+        orchestrator.app_config = {
+            "issue_report": {"enabled": True, "format": "json", "path": path}
+        }
+        orchestrator.issue_levels = {"unresolved-canonical": ERROR}
+
+        with self.assertLogs("build", level="WARNING") as logs:
+            orchestrator._report_issues(self.issues)
+        self.assertEqual(len(logs.output), 2)
+        self.assertTrue(logs.output[0].startswith("ERROR:build:Canonical URL"))
+        self.assertTrue(logs.output[1].startswith("ERROR:build:Image is too large."))
+
+        orchestrator._write_issue_report()
+        with open(path, encoding="utf-8") as f:
+            report = json.load(f)
+        self.assertEqual(report["summary"], {"error": 2, "warning": 0, "note": 0})
+
+
+class TestBuildReport(unittest.TestCase):
+    """Test cases for the build report's summaries."""
+
+    def test_translation_coverage_compares_with_default_language(self):
+        """Keys missing or empty in a language count as untranslated."""
+        coverage = translation_coverage(
+            {
+                "en": {"title": "Title", "cta": "Go", "footer": "Footer"},
+                "es": {"title": "Titulo", "cta": ""},
+            },
+            "en",
+        )
+        self.assertEqual([entry.lang for entry in coverage], ["en", "es"])
+        self.assertEqual(coverage[0].percent, 100.0)
+        self.assertEqual((coverage[1].translated, coverage[1].total), (1, 3))
+        self.assertEqual(coverage[1].missing, ["cta", "footer"])
+
+    def test_variant_inventory_lists_items_with_variations(self):
+        """Only block items with variations are listed, with their default."""
+        hero = mock.Mock(
+            variations=[mock.Mock(variation_id="a"), mock.Mock(variation_id="b")],
+            default_variation_id="a",
+        )
+        inventory = variant_inventory(
+            {"hero.html": hero, "blog.html": [mock.Mock(variations=[])]}
+        )
+        self.assertEqual(len(inventory), 1)
+        self.assertEqual(inventory[0].block, "hero.html")
+        self.assertEqual(inventory[0].variation_ids, ["a", "b"])
+        self.assertEqual(inventory[0].default_id, "a")
+
+    def test_issues_are_grouped_most_severe_category_first(self):
+        """A category with an error comes before categories of warnings."""
+        groups = group_issues(
+            [
+                Issue(WARNING, "long-meta", "Too long.", "seo"),
+                Issue(WARNING, "oversized-image", "Too large.", "performance"),
+                Issue(ERROR, "oversized-image", "Far too large.", "performance"),
+            ]
+        )
+        self.assertEqual(list(groups), ["oversized-image", "long-meta"])
+        self.assertEqual(len(groups["oversized-image"]), 2)
+
+
+class TestVariants(unittest.TestCase):
+    """Test cases for selecting block variants."""
+
+    def setUp(self) -> None:
+        """Creates hero data with three variants and no default."""
+        self.hero = mock.Mock(
+            variations=[mock.Mock(variation_id=name) for name in ("a", "b", "c")],
+            default_variation_id="",
+        )
+
+    def test_seeded_variation_is_reproducible(self):
+        """A seed picks the same variant for a page every time."""
+        picks = {
+            seeded_variation(self.hero, "7", f"index.html:hero.html:{attempt}")
+            for attempt in range(20)
+        }
+        self.assertGreater(len(picks), 1)
+        self.assertEqual(
+            seeded_variation(self.hero, "7", "index_es.html:hero.html"),
+            seeded_variation(self.hero, "7", "index_es.html:hero.html"),
+        )
+
+    def test_default_variation_wins_over_seed(self):
+        """The seed only picks a variant when no valid default is set."""
+        self.hero.default_variation_id = "b"
+        self.assertEqual(seeded_variation(self.hero, "7", "index.html"), "b")
+        self.assertIsNone(seeded_variation(mock.Mock(variations=[]), "7", "x"))
+
+    def test_variant_blocks_and_filenames(self):
+        """Only blocks with variants count; variant pages keep the extension."""
+        block_data = {"hero.html": self.hero, "faq.html": [mock.Mock()]}
+        self.assertEqual(
+            list(variant_blocks(block_data, ["faq.html", "hero.html"])), ["hero.html"]
+        )
+        self.assertEqual(variant_filename("index_es.html", "b"), "index_es.b.html")
+
+    def test_progress_total_counts_variant_pages(self):
+        """With `--variants all` each variant page counts towards the total."""
+        orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=mock.MagicMock(),
+            html_generators={},
+            artifact_generators={},
+            jinja_env=Environment(),
+            all_variants=True,
+        )
+        orchestrator.app_config = {
+            "blocks": ["hero.html", "faq.html"],
+            "error_pages": {"enabled": True, "pages": {"404": {}}},
+        }
+        orchestrator.build_context = BuildContext(
+            app_config=orchestrator.app_config,
+            default_lang="en",
+            supported_langs=["en"],
+            block_data={"hero.html": self.hero, "faq.html": [mock.Mock()]},
+        )
+        self.assertEqual(orchestrator._pages_per_language(), 1 + 3 + 1)
+        orchestrator.all_variants = False
+        self.assertEqual(orchestrator._pages_per_language(), 1 + 1)
+
+    @mock.patch("build.create_template_environment")
+    @mock.patch("build.DefaultAppConfigManager.load_app_config", return_value={})
+    @mock.patch("build.create_orchestrator")
+    @mock.patch("build.serve")
+    def test_serve_builds_with_variant_options(
+        self, mock_serve, mock_create, _mock_config, _mock_env
+    ):
+        """`serve` rebuilds with the variant options given before it."""
+        build_main(["--no-cache", "--variants", "all", "--variant-seed", "7", "serve"])
+        mock_serve.call_args.args[0]()
+        kwargs = mock_create.call_args.kwargs
+        self.assertEqual((kwargs["variant_seed"], kwargs["all_variants"]), ("7", True))
+
+    def test_commands_refuse_build_options_they_ignore(self):
+        """Options a command would ignore are an error, not silently dropped."""
+        for argv in (
+            ["--variants", "all", "deploy", "s3"],
+            ["--variant-seed", "7", "backend"],
+            ["--base-path", "/x/", "serve"],
+            ["--cpuprofile", "build.prof", "serve"],
+            ["--memprofile", "build.mem", "diff"],
+            ["--trace", "trace.json", "deploy", "s3"],
+            ["--no-cache", "backend"],
+            ["--no-cache", "test-email"],
+        ):
+            with self.subTest(argv=argv):
+                with mock.patch("sys.stderr", new_callable=io.StringIO) as stderr:
+                    with self.assertRaises(SystemExit) as raised:
+                        build_main(argv)
+                self.assertEqual(raised.exception.code, 2)
+                self.assertIn("does not apply to", stderr.getvalue())
+
+
+    def test_edge_split_routes(self):
+        """Each URL path of a varied page maps to its variant pages."""
+        routes = split_routes(
+            {"index.html": {"b": "index.b.html", "a": "index.a.html"}}, "/site/"
+        )
+        self.assertEqual(list(routes), ["/site/", "/site/index.html", "/site/index"])
+        self.assertEqual(
+            routes["/site/"], {"a": "/site/index.a.html", "b": "/site/index.b.html"}
+        )
+        self.assertEqual(
+            list(split_routes({"index_es.html": {"a": "index_es.a.html"}}, "/")),
+            ["/index_es.html", "/index_es"],
+        )
+
+    def test_edge_split_writes_edge_code(self):
+        """The recorded variant pages end up in the Netlify and Cloudflare code."""
+        build_context = BuildContext(
+            app_config={"edge_split": {"enabled": True, "weights": {"a": 3}}},
+            default_lang="en",
+            supported_langs=["en"],
+        )
+        generator = EdgeSplitGenerator(Environment())
+        with self.assertLogs("build_protocols.edge_split", level="INFO"):
+            self.assertEqual(generator.generate_artifacts(build_context), {})
+        build_context.variant_pages["index.html"] = {
+            "a": "index.a.html",
+            "b": "index.b.html",
+        }
+        artifacts = generator.generate_artifacts(build_context)
+        self.assertEqual(
+            sorted(artifacts), ["_worker.js", "netlify/edge-functions/ab-split.js"]
+        )
+        for code in artifacts.values():
+            self.assertIn('"/index.html": {', code)
+            self.assertIn('"maxAge": 2592000', code)
+            self.assertIn('"a": 3', code)
+        netlify_function = artifacts["netlify/edge-functions/ab-split.js"]
+        self.assertIn('path: ["/", "/index.html", "/index"]', netlify_function)
+        self.assertIn("env.ASSETS.fetch", artifacts["_worker.js"])
+
+
+class TestCanonicalUrls(unittest.TestCase):
+    """Test cases for canonical URL resolution and verification."""
+
+    def test_resolve_canonical_url_defaults_to_page_url(self):
+        """Without overrides, each language page is its own canonical."""
+        config = {"base_url": "https://example.com"}
+        self.assertEqual(
+            resolve_canonical_url(config, "index", "en", "en"),
+            "https://example.com/",
+        )
+        self.assertEqual(
+            resolve_canonical_url(config, "index", "es", "en"),
+            "https://example.com/index_es.html",
+        )
+        self.assertIsNone(resolve_canonical_url({}, "index", "en", "en"))
+
+    def test_resolve_canonical_url_applies_overrides(self):
+        """Per-language paths win over the page path."""
+        config = {
+            "base_url": "https://example.com/",
+            "canonical": {
+                "pages": {
+                    "index": {"path": "home.html", "lang_paths": {"es": "es/"}}
+                }
+            },
+        }
+        self.assertEqual(
+            resolve_canonical_url(config, "index", "en", "en"),
+            "https://example.com/home.html",
+        )
+        self.assertEqual(
+            resolve_canonical_url(config, "index", "es", "en"),
+            "https://example.com/es/",
+        )
+
+    def test_find_unresolved_canonicals(self):
+        """Only internal targets missing from the build are reported."""
+        problems = find_unresolved_canonicals(
+            "https://example.com/",
+            {
+                "index.html": "https://example.com/",
+                "index_es.html": "https://example.com/es/",
+                "index_de.html": "https://other.example.org/de/",
+            },
+            ["index.html", "index_es.html"],
+        )
+        self.assertEqual(len(problems), 1)
+        self.assertEqual(problems[0].category, "unresolved-canonical")
+        self.assertEqual(problems[0].file, "index_es.html")
+        self.assertIn("es/index.html", problems[0].message)
+
+    def test_base_path(self):
+        """A base path moves absolute and root-relative URLs under it."""
+        config = apply_base_path(
+            {"base_url": "https://example.com/"}, "previews/feature-x"
+        )
+        self.assertEqual(config["base_url"], "https://example.com/previews/feature-x/")
+        self.assertEqual(site_base_path(config), "/previews/feature-x/")
+        self.assertEqual(
+            resolve_canonical_url(config, "index", "es", "en"),
+            "https://example.com/previews/feature-x/index_es.html",
+        )
+        self.assertEqual(
+            prefix_root_relative_urls(
+                '<a href="/api/contact"></a><img src="//cdn.example.com/a.png" />'
+                '<link href="public/style.css" />',
+                "/previews/feature-x/",
+            ),
+            '<a href="/previews/feature-x/api/contact"></a>'
+            '<img src="//cdn.example.com/a.png" /><link href="public/style.css" />',
+        )
+
+
+class TestErrorPages(unittest.TestCase):
+    """Test cases for per-language error page generation."""
+
+    def setUp(self) -> None:
+        """Creates an orchestrator with mocked services and real templates."""
+        self.page_builder = mock.MagicMock()
+        self.page_builder.assemble_translated_page.return_value = "<html></html>"
+        self.orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=self.page_builder,
+            html_generators={},
+            artifact_generators={},
+            jinja_env=Environment(loader=FileSystemLoader("templates")),
+        )
+
+    @mock.patch.object(BuildOrchestrator, "_write_output_file")
+    def test_error_pages_written_per_language(self, mock_write):
+        """Each configured code yields a localized, noindex error page."""
+        self.orchestrator.app_config = {
+            "base_url": "https://example.com/site/",
+            "error_pages": {"enabled": True, "pages": {"404": {}}},
+        }
+        for lang in ["en", "es"]:
+            for job in self.orchestrator._error_page_jobs(
+                lang, "en", {"error_404_title": f"Missing {lang}"}, {}, []
+            ):
+                job.run()
+
+        written = [call.args[0] for call in mock_write.call_args_list]
+        self.assertEqual(written, ["404.html", "404_es.html"])
+        kwargs = self.page_builder.assemble_translated_page.call_args.kwargs
+        self.assertEqual(kwargs["page_title"], "Missing es")
+        self.assertEqual(
+            kwargs["extra_context"], {"base_href": "/site/", "robots": "noindex"}
+        )
+
+    @mock.patch.object(BuildOrchestrator, "_write_output_file")
+    def test_error_pages_get_generator_context(self, _mock_write):
+        """Error pages get analytics and consent, not the landing page's JSON-LD."""
+        generator = mock.MagicMock()
+        generator.get_page_context.return_value = {
+            "head_snippets": ["<script>track();</script>"],
+            "body_snippets": ["<div>Cookies?</div>"],
+            "structured_data": [{"@type": "FAQPage"}],
+        }
+        self.orchestrator.artifact_generators = {"analytics": generator}
+        self.orchestrator.app_config = {
+            "error_pages": {"enabled": True, "pages": {"404": {}}}
+        }
+        self.orchestrator.build_context = BuildContext(
+            app_config=self.orchestrator.app_config,
+            default_lang="en",
+            supported_langs=["en"],
+        )
+        for job in self.orchestrator._error_page_jobs("en", "en", {}, {}, []):
+            job.run()
+
+        kwargs = self.page_builder.assemble_translated_page.call_args.kwargs
+        self.assertEqual(
+            kwargs["extra_context"],
+            {
+                "base_href": "/",
+                "robots": "noindex",
+                "head_snippets": ["<script>track();</script>"],
+                "body_snippets": ["<div>Cookies?</div>"],
+            },
+        )
+
+    @mock.patch.object(BuildOrchestrator, "_write_output_file")
+    def test_error_pages_disabled_by_default(self, mock_write):
+        """Nothing is written without an enabled `error_pages` section."""
+        self.orchestrator.app_config = {}
+        self.assertEqual(
+            list(self.orchestrator._error_page_jobs("en", "en", {}, {}, [])), []
+        )
+        mock_write.assert_not_called()
+
+
+class TestRedirects(unittest.TestCase):
+    """Test cases for redirect rule validation and host formats."""
+
+    def setUp(self) -> None:
+        """Creates a build context with valid and invalid redirect rules."""
+        self.build_context = BuildContext(
+            app_config={
+                "redirects": {
+                    "enabled": True,
+                    "rules": [
+                        {"from": "/home.html", "to": "/"},
+                        {"from": "/blog", "to": "/index.html#blog", "status": 200},
+                        {"from": "/home.html", "to": "/other"},
+                        {"from": "relative.html", "to": "/"},
+                        {"from": "/gone", "to": "/", "status": 404},
+                    ],
+                }
+            },
+            default_lang="en",
+            supported_langs=["en"],
+        )
+
+    def test_redirects_written_for_every_host(self):
+        """Valid rules are written once per host format."""
+        artifacts = RedirectArtifactGenerator(Environment()).generate_artifacts(
+            self.build_context
+        )
+        self.assertEqual(
+            artifacts["_redirects"],
+            "/home.html / 301\n/blog /index.html#blog 200\n",
+        )
+        vercel = json.loads(artifacts["vercel.json"])
+        self.assertEqual(
+            vercel["redirects"],
+            [{"source": "/home.html", "destination": "/", "statusCode": 301}],
+        )
+        self.assertEqual(vercel["rewrites"][0]["source"], "/blog")
+        self.assertIn("RewriteRule ^home\\.html$ / [R=301,L]", artifacts[".htaccess"])
+        self.assertIn(
+            "location = /home.html { return 301 /; }",
+            artifacts["nginx-redirects.conf"],
+        )
+
+    def test_canonical_pointing_at_redirect_is_reported(self):
+        """The canonical check treats redirect sources as unresolved."""
+        problems = find_unresolved_canonicals(
+            "https://example.com/",
+            {"index.html": "https://example.com/home.html"},
+            ["index.html", "home.html"],
+            redirect_sources=["/home.html"],
+        )
+        self.assertEqual(len(problems), 1)
+        self.assertEqual(problems[0].category, "redirected-canonical")
+        self.assertIn("is redirected", problems[0].message)
+
+
+class TestSecurityHeaders(unittest.TestCase):
+    """Test cases for security header files and CSP meta fallbacks."""
+
+    PAGE = (
+        "<html><head><meta charset=\"utf-8\" />"
+        "<link href=\"public/style.css\" rel=\"stylesheet\" />"
+        "<script src=\"https://cdn.example.com/lib.js\"></script>"
+        "<script type=\"application/ld+json\">{}</script>"
+        "</head><body><script>init();</script></body></html>"
+    )
+
+    def setUp(self) -> None:
+        """Creates a build context with CSP, headers and meta fallback."""
+        self.build_context = BuildContext(
+            app_config={
+                "security_headers": {
+                    "enabled": True,
+                    "meta_fallback": True,
+                    "headers": {
+                        "X-Frame-Options": "DENY",
+                        "Referrer-Policy": "no-referrer",
+                    },
+                    "csp": {
+                        "default-src": ["'self'"],
+                        "frame-ancestors": ["'none'"],
+                    },
+                }
+            },
+            default_lang="en",
+            supported_langs=["en"],
+        )
+        self.generator = SecurityHeadersGenerator(Environment())
+
+    def test_csp_uses_emitted_sources(self):
+        """Scripts and styles found in pages are allowed in the header CSP."""
+        self.generator.process_page("index.html", self.PAGE, self.build_context)
+        artifacts = self.generator.generate_artifacts(self.build_context)
+
+        self.assertEqual(
+            sorted(artifacts), ["Caddyfile.headers", "_headers", "nginx-headers.conf"]
+        )
+        netlify_lines = artifacts["_headers"].splitlines()
+        csp_line = next(line for line in netlify_lines if "Content-Security" in line)
+        self.assertIn("default-src 'self'; frame-ancestors 'none'", csp_line)
+        self.assertIn("https://cdn.example.com", csp_line)
+        self.assertIn(hash_source("init();"), csp_line)
+        self.assertNotIn(hash_source("{}"), csp_line)
+        self.assertIn("style-src 'self'", csp_line)
+        self.assertIn("  X-Frame-Options: DENY", netlify_lines)
+        self.assertIn(
+            'add_header X-Frame-Options "DENY" always;',
+            artifacts["nginx-headers.conf"],
+        )
+
+    def test_csp_allows_captcha_widgets(self):
+        """A page with a captcha allows the provider's scripts and iframe."""
+        for name, provider in CAPTCHA_PROVIDERS.items():
+            with self.subTest(provider=name):
+                page = (
+                    "<html><head><meta charset=\"utf-8\" /></head><body><form>"
+                    f'<div class="{provider.widget_class}" data-sitekey="k"></div>'
+                    f'<script src="{provider.script_url}" async defer></script>'
+                    "</form></body></html>"
+                )
+                generator = SecurityHeadersGenerator(Environment())
+                generator.process_page("index.html", page, self.build_context)
+                csp_line = next(
+                    line
+                    for line in generator.generate_artifacts(self.build_context)[
+                        "_headers"
+                    ].splitlines()
+                    if "Content-Security" in line
+                )
+                directives = {
+                    part.split()[0]: part.split()[1:]
+                    for part in csp_line.split(": ", 1)[1].split("; ")
+                }
+                for directive, sources in provider.csp_sources.items():
+                    for source in sources:
+                        self.assertIn(source, directives[directive])
+
+    def test_security_headers_see_the_final_page(self):
+        """Generators run by priority; the CSP is collected after all others."""
+        names = [name for name, _ in ordered_generators()]
+        self.assertEqual(names[-2:], ["consent", "security_headers"])
+        self.assertEqual(names[:-2], sorted(names[:-2]))
+
+    def test_csp_allows_iframes(self):
+        """Embedded frames are allowed in `frame-src`."""
+        sources = collect_page_sources(
+            '<iframe src="https://www.youtube.com/embed/x"></iframe>'
+        )
+        self.assertEqual(sources["frame-src"], {"https://www.youtube.com"})
+
+    def test_meta_fallback_injected_after_charset(self):
+        """Pages get CSP and referrer meta tags without ignored directives."""
+        html = self.generator.process_page(
+            "index.html", self.PAGE, self.build_context
+        )
+        self.assertTrue(
+            html.startswith(
+                "<html><head><meta charset=\"utf-8\" />\n"
+                "    <meta http-equiv=\"Content-Security-Policy\""
+            )
+        )
+        self.assertIn('<meta name="referrer" content="no-referrer" />', html)
+        self.assertNotIn("frame-ancestors", html)
+
+
+class TestSiteFiles(unittest.TestCase):
+    """Test cases for security.txt and humans.txt generation."""
+
+    def test_format_security_txt(self):
+        """Required fields, derived Canonical and default languages are written."""
+        content = format_security_txt(
+            SecurityTxt(
+                contacts=["mailto:security@example.com"],
+                expires="2025-01-31",
+                policy=["https://example.com/policy"],
+            ),
+            "https://example.com/.well-known/security.txt",
+            ["en", "es"],
+            now=datetime(2024, 6, 1, tzinfo=timezone.utc),
+        )
+        self.assertEqual(
+            content,
+            "Contact: mailto:security@example.com\n"
+            "Expires: 2025-01-31T00:00:00Z\n"
+            "Preferred-Languages: en, es\n"
+            "Canonical: https://example.com/.well-known/security.txt\n"
+            "Policy: https://example.com/policy\n",
+        )
+
+    def test_format_security_txt_requires_expiry(self):
+        """Without an expiry date no security.txt is produced."""
+        with self.assertLogs("build_protocols.site_files", level="WARNING"):
+            content = format_security_txt(
+                SecurityTxt(contacts=["mailto:security@example.com"]), "", ["en"]
+            )
+        self.assertIsNone(content)
+
+    def test_format_humans_txt(self):
+        """Team credits and site info are grouped in humans.txt sections."""
+        content = format_humans_txt(
+            HumansTxt(
+                team=[
+                    HumanCredit(role="Developer", name="Ana", location="Madrid")
+                ],
+                standards=["HTML5", "CSS3"],
+            ),
+            ["en", "es"],
+            today=date(2024, 6, 1),
+        )
+        self.assertEqual(
+            content,
+            "/* TEAM */\nDeveloper: Ana\nFrom: Madrid\n\n"
+            "/* SITE */\nLast update: 2024/06/01\nLanguage: en, es\n"
+            "Standards: HTML5, CSS3\n",
+        )
+
+
+class TestBreadcrumbs(unittest.TestCase):
+    """Test cases for breadcrumb trails and their BreadcrumbList JSON-LD."""
+
+    def setUp(self) -> None:
+        """Places the 404 page below the landing page."""
+        self.app_config = {
+            "base_url": "https://example.com/",
+            "breadcrumbs": {
+                "enabled": True,
+                "pages": {
+                    "index": {"title_key": "home"},
+                    "404": {"parent": "index", "title_key": "error_404_title"},
+                },
+            },
+            "error_pages": {"enabled": True, "pages": {"404": {}}},
+        }
+
+    def test_resolve_breadcrumbs_follows_parents(self):
+        """The trail runs from the localized home page to the current page."""
+        crumbs = resolve_breadcrumbs(
+            self.app_config, "404", "es", "en", {"home": "Inicio"}
+        )
+        self.assertEqual(
+            crumbs,
+            [
+                {"title": "Inicio", "url": "https://example.com/index_es.html"},
+                {"title": "error_404_title", "url": "https://example.com/404_es.html"},
+            ],
+        )
+        self.assertEqual(
+            resolve_breadcrumbs(self.app_config, "index", "en", "en", {}), []
+        )
+
+    def test_breadcrumb_list_json_ld(self):
+        """JSON-LD positions match the trail and omit the current page URL."""
+        crumbs = resolve_breadcrumbs(self.app_config, "404", "en", "en", {})
+        entity = breadcrumb_list_json_ld(crumbs)
+        self.assertEqual(entity["@type"], "BreadcrumbList")
+        first, last = entity["itemListElement"]
+        self.assertEqual(first["position"], 1)
+        self.assertEqual(first["item"], "https://example.com/")
+        self.assertEqual(last["name"], "error_404_title")
+        self.assertNotIn("item", last)
+
+    @mock.patch.object(BuildOrchestrator, "_write_output_file")
+    def test_error_pages_are_built_with_breadcrumbs(self, mock_write):
+        """Built error pages get the trail and its JSON-LD; the index does not."""
+        page_builder = mock.MagicMock()
+        page_builder.assemble_translated_page.return_value = "<html></html>"
+        orchestrator = BuildOrchestrator(
+            app_config_manager=mock.MagicMock(),
+            translation_provider=mock.MagicMock(),
+            data_loader=mock.MagicMock(),
+            data_cache=mock.MagicMock(),
+            page_builder=page_builder,
+            html_generators={},
+            artifact_generators={},
+            jinja_env=Environment(loader=FileSystemLoader("templates")),
+        )
+        orchestrator.app_config = self.app_config
+        translations = {"home": "Home", "error_404_title": "Page not found"}
+        for job in orchestrator._error_page_jobs("en", "en", translations, {}, []):
+            job.run()
+
+        context = page_builder.assemble_translated_page.call_args.kwargs[
+            "extra_context"
+        ]
+        self.assertEqual(
+            [crumb["title"] for crumb in context["breadcrumbs"]],
+            ["Home", "Page not found"],
+        )
+        self.assertEqual(context["structured_data"][0]["@type"], "BreadcrumbList")
+        self.assertEqual(
+            orchestrator._breadcrumb_context("index", "en", "en", translations), {}
+        )
+
+
+class TestAnalytics(unittest.TestCase):
+    """Test cases for analytics snippet injection."""
+
+    def setUp(self) -> None:
+        """Creates a Plausible configuration and a real template environment."""
+        self.jinja_env = Environment(loader=FileSystemLoader("templates"))
+        self.app_config: Dict[str, Any] = {
+            "analytics": {
+                "enabled": True,
+                "provider": "plausible",
+                "site_id": "example.com",
+            }
+        }
+
+    def _page_context(self, build_profile: str) -> Dict[str, Any]:
+        build_context = BuildContext(
+            app_config=self.app_config,
+            default_lang="en",
+            supported_langs=["en"],
+            build_profile=build_profile,
+        )
+        return AnalyticsSnippetGenerator(self.jinja_env).get_page_context(
+            "en", build_context
+        )
+
+    def test_snippet_injected_for_production(self):
+        """The provider's snippet is tagged for consent management."""
+        snippets = self._page_context("production")["head_snippets"]
+        self.assertEqual(len(snippets), 1)
+        self.assertIn('data-domain="example.com"', snippets[0])
+        self.assertIn("https://plausible.io/js/script.js", snippets[0])
+        self.assertIn('data-consent-category="analytics"', snippets[0])
+
+    def test_snippet_skipped_for_preview_profile(self):
+        """Profiles not listed in `profiles` get no analytics."""
+        self.assertEqual(self._page_context("preview"), {})
+
+    def test_matomo_requires_tracker_url(self):
+        """Providers with required options are skipped without them."""
+        self.app_config["analytics"]["provider"] = "matomo"
+        with self.assertLogs("build_protocols.analytics", level="WARNING"):
+            self.assertEqual(self._page_context("production"), {})
+
+
+class TestConsent(unittest.TestCase):
+    """Test cases for consent-gated script loading."""
+
+    def test_gate_consent_scripts(self):
+        """Tagged scripts become inert unless their category is exempt."""
+        html = (
+            '<script data-consent-category="analytics" src="a.js"></script>'
+            '<script type="module" data-consent-category="marketing"></script>'
+            '<script data-consent-category="necessary">run();</script>'
+            "<script>always();</script>"
+        )
+        self.assertEqual(
+            gate_consent_scripts(html, {"necessary"}),
+            '<script type="text/plain" data-consent-category="analytics" '
+            'src="a.js"></script>'
+            '<script type="text/plain" data-consent-type="module" '
+            'data-consent-category="marketing"></script>'
+            '<script data-consent-category="necessary">run();</script>'
+            "<script>always();</script>",
+        )
+
+    def test_gated_scripts_keep_their_csp_hash(self):
+        """The CSP builder still allows gated inline scripts once activated."""
+        gated = gate_consent_scripts(
+            '<script data-consent-category="analytics">track();</script>', set()
+        )
+        sources = collect_page_sources(gated)
+        self.assertEqual(sources["script-src"], {hash_source("track();")})
+
+
+class TestOutboundLinks(unittest.TestCase):
+    """Test cases for outbound link decoration."""
+
+    SETTINGS = {
+        "rel": "noopener noreferrer",
+        "target": "_blank",
+        "rules": [
+            {"domains": ["t.me"], "utm": {"utm_source": "landing", "ref": "x"}}
+        ],
+    }
+
+    def test_marketing_links_get_utm_and_rel(self):
+        """Matching rules add UTM parameters without overriding existing ones."""
+        html = '<a href="https://t.me/bot?ref=ad&amp;start=1" rel="nofollow">Bot</a>'
+        self.assertEqual(
+            decorate_outbound_links(html, self.SETTINGS, "example.com"),
+            '<a href="https://t.me/bot?ref=ad&amp;start=1&amp;utm_source=landing" '
+            'rel="nofollow noopener noreferrer" target="_blank">Bot</a>',
+        )
+
+    def test_internal_and_relative_links_untouched(self):
+        """Links to the site itself, anchors and mailto links are not outbound."""
+        html = (
+            '<a href="#features">F</a><a href="https://example.com/x">X</a>'
+            '<a href="mailto:hi@example.com">M</a>'
+        )
+        self.assertEqual(
+            decorate_outbound_links(html, self.SETTINGS, "example.com"), html
+        )
+
+    def test_existing_target_is_kept(self):
+        """A target set in the markup wins over the policy."""
+        html = '<a target="_self" href="https://other.org/">O</a>'
+        self.assertEqual(
+            decorate_outbound_links(html, self.SETTINGS, "example.com"),
+            '<a target="_self" href="https://other.org/" '
+            'rel="noopener noreferrer">O</a>',
+        )
+
+
+# Inputs that crashed or misled the HTML extractors, plus seeds to mutate:
+# one file per input in a directory per target. Save every new failing
+# input there so it stays fixed.
+FUZZ_CORPUS_DIR = os.path.join("testdata", "fuzz")
+# Random mutations per target; raise them for a longer run, e.g.
+# `FUZZ_ITERATIONS=100000 FUZZ_SEED=7 python -m unittest test_build.TestFuzzing`.
+FUZZ_ITERATIONS = int(os.environ.get("FUZZ_ITERATIONS", "200"))
+FUZZ_SEED = int(os.environ.get("FUZZ_SEED", "0"))
+FUZZ_TOKENS = [
+    "<",
+    ">",
+    '"',
+    "'",
+    "=",
+    " ",
+    "\x00",
+    "%",
+    "//",
+    "http://[",
+    "::1]",
+    ";",
+    ",",
+    " 2x",
+    "<a href=",
+    "<img src=",
+    "<script src=",
+    '<link rel="stylesheet" href=',
+    "</script>",
+    "<!--",
+    "data:",
+]
+
+
+class TestFuzzing(unittest.TestCase):
+    """Feeds the HTML extractors their corpus and random mutations of it."""
+
+    def _inputs(self, target: str):
+        corpus_dir = os.path.join(FUZZ_CORPUS_DIR, target)
+        corpus = []
+        for name in sorted(os.listdir(corpus_dir)):
+            with open(os.path.join(corpus_dir, name), "r", encoding="utf-8") as f:
+                corpus.append(f.read())
+        yield from corpus
+        rng = random.Random(f"{FUZZ_SEED}:{target}")
+        for _ in range(FUZZ_ITERATIONS):
+            html = rng.choice(corpus)
+            for _ in range(rng.randint(1, 4)):
+                start = rng.randrange(len(html) + 1)
+                end = min(len(html), start + rng.randint(0, 16))
+                operation = rng.randrange(3)
+                if operation == 0:
+                    html = html[:start] + rng.choice(FUZZ_TOKENS) + html[start:]
+                elif operation == 1:
+                    html = html[:start] + html[end:]
+                else:
+                    donor = rng.choice(corpus)
+                    offset = rng.randrange(len(donor) + 1)
+                    html = html[:start] + donor[offset : offset + 32] + html[end:]
+            yield html
+
+    def _fuzz(self, target: str, check) -> None:
+        for html in self._inputs(target):
+            try:
+                check(html)
+            except Exception as e:  # pylint: disable=broad-except
+                self.fail(
+                    f"{target} failed on {html!r}: {e!r}; "
+                    f"save the input to {FUZZ_CORPUS_DIR}/{target}/"
+                )
+
+    def test_page_resources(self):
+        """Measuring a page never raises, whatever its URLs."""
+
+        def check(html: str) -> None:
+            weight = measure_page("index.html", html, "https://example.com/")
+            assert weight.total_bytes >= len(html.encode("utf-8"))
+            assert all(resource.url for resource in weight.resources)
+
+        self._fuzz("page_resources", check)
+
+    def test_csp_sources(self):
+        """Page sources are always single, well-formed CSP source expressions."""
+        source_re = re.compile(
+            r"'self'|data:|'sha256-[A-Za-z0-9+/]+=*'"
+            r"|[a-z][a-z0-9+.-]*://(?:\*\.)?[a-z0-9.:\[\]-]+"
+        )
+
+        def check(html: str) -> None:
+            for sources in collect_page_sources(html).values():
+                for source in sources:
+                    assert source_re.fullmatch(source), source
+
+        self._fuzz("csp_sources", check)
+
+    def test_outbound_links(self):
+        """Decorating links never raises and only changes opening `<a>` tags."""
+        settings = {
+            "rel": "noopener",
+            "target": "_blank",
+            "utm": {"utm_source": "site"},
+            "rules": [{"domains": ["ext.com"], "rel": "sponsored"}],
+        }
+        anchor_re = re.compile(r"<a\s[^>]*>", re.IGNORECASE)
+
+        def check(html: str) -> None:
+            decorated = decorate_outbound_links(html, settings, "example.com")
+            assert anchor_re.split(decorated) == anchor_re.split(html)
+
+        self._fuzz("outbound_links", check)
+
+    def test_malformed_urls(self):
+        """URLs that urlsplit rejects are skipped rather than failing the build."""
+        self.assertIsNone(local_resource_path("http://[::1", "index.html", ""))
+        self.assertIsNone(source_expression("http://[broken/x.js"))
+        self.assertIsNone(source_expression("https://a.com;script-src *"))
+        self.assertEqual(
+            source_expression("https://user@CDN.example.com:8443/x.js"),
+            "https://cdn.example.com:8443",
+        )
+        self.assertEqual(
+            source_expression("https://bücher.example/x.js"),
+            "https://xn--bcher-kva.example",
+        )
+        html = '<a href="http://[::1">broken</a>'
+        self.assertEqual(decorate_outbound_links(html, {}, "example.com"), html)
+
+
+class TestSitemaps(unittest.TestCase):
+    """Test cases for sitemap and sitemap index generation."""
+
+    def setUp(self) -> None:
+        """Sets up a two-language build with hero and portfolio media."""
+        self.build_context = BuildContext(
+            app_config={
+                "base_url": "https://example.com/",
+                "blocks": ["hero.html", "portfolio.html"],
+                "sitemaps": {"enabled": True},
+            },
+            default_lang="en",
+            supported_langs=["en", "es"],
+            block_data={
+                "hero.html": HeroItem(
+                    variations=[
+                        {
+                            "image": {"src": "img/hero.jpg"},
+                            "video": {
+                                "src": "media/intro.mp4",
+                                "thumbnail": "img/intro.jpg",
+                                "title": {"key": "video_title"},
+                                "description": {"key": "video_desc"},
+                                "duration_seconds": 42,
+                            },
+                        }
+                    ]
+                ),
+                "portfolio.html": [
+                    PortfolioItem(id="p1", image={"src": "img/hero.jpg"}),
+                    PortfolioItem(id="p2", image={"src": "data:image/png;base64,"}),
+                ],
+            },
+            translations_by_lang={
+                "en": {"video_title": "Intro", "video_desc": "A tour."},
+                "es": {"video_title": "Intro ES", "video_desc": "Un recorrido."},
+            },
+        )
+
+    def test_split_sitemaps_with_index_and_media(self):
+        """Each language gets a sitemap with media; sitemap.xml indexes them."""
+        artifacts = SitemapGenerator(None).generate_artifacts(self.build_context)
+        self.assertEqual(
+            sorted(artifacts), ["sitemap.xml", "sitemap_en.xml", "sitemap_es.xml"]
+        )
+        self.assertIn("<sitemapindex", artifacts["sitemap.xml"])
+        self.assertIn(
+            "<loc>https://example.com/sitemap_es.xml</loc>", artifacts["sitemap.xml"]
+        )
+
+        spanish = artifacts["sitemap_es.xml"]
+        self.assertIn("<loc>https://example.com/index_es.html</loc>", spanish)
+        self.assertEqual(spanish.count("<image:image>"), 1)
+        self.assertIn(
+            "<image:loc>https://example.com/img/hero.jpg</image:loc>", spanish
+        )
+        self.assertNotIn("data:image", spanish)
+        self.assertIn("<video:title>Intro ES</video:title>", spanish)
+        self.assertIn(
+            "<video:content_loc>https://example.com/media/intro.mp4"
+            "</video:content_loc>",
+            spanish,
+        )
+        self.assertIn("<video:duration>42</video:duration>", spanish)
+
+    def test_single_sitemap_skips_incomplete_videos(self):
+        """Without splitting, one urlset lists all pages; bad videos are skipped."""
+        self.build_context.app_config["sitemaps"]["split_by_language"] = False
+        self.build_context.translations_by_lang["en"]["video_desc"] = ""
+        with self.assertLogs("build_protocols.sitemaps", level="WARNING"):
+            artifacts = SitemapGenerator(None).generate_artifacts(self.build_context)
+        self.assertEqual(list(artifacts), ["sitemap.xml"])
+        sitemap = artifacts["sitemap.xml"]
+        self.assertIn("<urlset", sitemap)
+        self.assertEqual(sitemap.count("<url>"), 2)
+        self.assertEqual(sitemap.count("<video:video>"), 1)
+
+
+class TestPerformanceBudgets(unittest.TestCase):
+    """Test cases for page weight measurement and budget checks."""
+
+    def setUp(self) -> None:
+        """Creates an output directory with a stylesheet and an image."""
+        self.output_dir = tempfile.mkdtemp()
+        os.makedirs(os.path.join(self.output_dir, "public"))
+        with open(os.path.join(self.output_dir, "public", "style.css"), "wb") as f:
+            f.write(b"x" * 2048)
+        with open(os.path.join(self.output_dir, "hero.png"), "wb") as f:
+            f.write(b"x" * 3072)
+        self.page_path = os.path.join(self.output_dir, "index.html")
+        self.html = (
+            '<link href="public/style.css" rel="stylesheet" />'
+            '<img src="https://example.com/hero.png" /><img src="hero.png" />'
+            '<img src="data:image/png;base64,AAAA" />'
+            '<script src="https://cdn.example.org/app.js"></script>'
+        )
+
+    def tearDown(self) -> None:
+        """Removes the output directory."""
+        shutil.rmtree(self.output_dir)
+
+    def test_local_resource_path(self):
+        """Same-site URLs map to output files; other sites are external."""
+        page = os.path.join("out", "index.html")
+        base_url = "https://example.com/site/"
+        self.assertEqual(
+            local_resource_path("https://example.com/site/a.css", page, base_url),
+            os.path.join("out", "a.css"),
+        )
+        self.assertEqual(
+            local_resource_path("/site/img/b.png?v=2", page, base_url),
+            os.path.join("img", "b.png"),
+        )
+        self.assertIsNone(local_resource_path("//cdn.example.org/c.js", page, ""))
+
+    def test_measure_page(self):
+        """The page, local files and external requests are all accounted for."""
+        weight = measure_page(
+            self.page_path,
+            self.html.replace("https://example.com/hero.png", "hero.png"),
+            "https://example.com/",
+        )
+        self.assertEqual(
+            [resource.kind for resource in weight.resources],
+            ["document", "stylesheet", "image", "script"],
+        )
+        self.assertIsNone(weight.resources[-1].size)
+        self.assertEqual(
+            weight.total_bytes, (weight.resources[0].size or 0) + 2048 + 3072
+        )
+
+    def test_check_budgets_reports_violations(self):
+        """Every exceeded budget produces a message."""
+        weight = measure_page(self.page_path, self.html, "")
+        violations = check_budgets(
+            weight,
+            {"page_weight_kb": 4, "requests": 3, "bundle_kb": 1, "image_kb": 4},
+        )
+        self.assertEqual(
+            [violation.category for violation in violations],
+            ["page-weight", "request-count", "oversized-bundle"],
+        )
+        self.assertIn("page weight", violations[0].message)
+        self.assertIn("5 requests exceed 3", violations[1].message)
+        self.assertIn("stylesheet public/style.css is 2.0 KB", violations[2].message)
+        self.assertEqual(violations[0].file, self.page_path)
+
+
+class TestDevServer(unittest.TestCase):
+    """Test cases for the live reload development server helpers."""
+
+    def test_inject_live_reload(self):
+        """The script goes before </body> and the CSP meta tag is dropped."""
+        html = (
+            '<head><meta http-equiv="Content-Security-Policy" '
+            "content=\"script-src 'self'\" /></head><body><p>Hi</p></body>"
+        )
+        self.assertEqual(
+            inject_live_reload(html),
+            f"<head></head><body><p>Hi</p>{LIVE_RELOAD_SCRIPT}</body>",
+        )
+        self.assertEqual(inject_live_reload("<p>"), f"<p>{LIVE_RELOAD_SCRIPT}")
+
+    def test_source_watcher_reports_changes(self):
+        """Added, modified and deleted files are reported once."""
+        source_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, source_dir)
+        existing = os.path.join(source_dir, "a.json")
+        with open(existing, "w", encoding="utf-8") as f:
+            f.write("{}")
+        watcher = SourceWatcher([source_dir])
+        self.assertEqual(watcher.changed(), [])
+
+        added = os.path.join(source_dir, "b.json")
+        with open(added, "w", encoding="utf-8") as f:
+            f.write("{}")
+        os.utime(existing, (0, 0))
+        self.assertEqual(watcher.changed(), sorted([existing, added]))
+        os.remove(added)
+        self.assertEqual(watcher.changed(), [added])
+        self.assertEqual(watcher.changed(), [])
+
+    def test_invalidate_templates(self):
+        """Only changes to templates clear the shared template cache."""
+        jinja_env = mock.Mock()
+        self.assertFalse(
+            invalidate_templates(jinja_env, [os.path.join("data", "faq.json")])
+        )
+        jinja_env.cache.clear.assert_not_called()
+        self.assertTrue(
+            invalidate_templates(
+                jinja_env, [os.path.join("templates", "blocks", "faq.html")]
+            )
+        )
+        jinja_env.cache.clear.assert_called_once_with()
+
+    def test_serves_only_the_site(self):
+        """Project files, host config and dotfiles are never served."""
+        project_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, project_dir)
+        for path in (
+            "index.html",
+            "_headers",
+            "submissions.sqlite3",
+            ".git/config",
+            ".well-known/security.txt",
+            "public/style.css",
+            "public/config.json",
+        ):
+            os.makedirs(os.path.join(project_dir, os.path.dirname(path)), exist_ok=True)
+            with open(os.path.join(project_dir, path), "w", encoding="utf-8") as f:
+                f.write("<body></body>")
+        old_cwd = os.getcwd()
+        os.chdir(project_dir)
+        self.addCleanup(os.chdir, old_cwd)
+        handler = type(
+            "TestDevRequestHandler",
+            (DevRequestHandler,),
+            {
+                "served_files": served_site_files(
+                    ["index.html", "_headers", ".well-known/security.txt"]
+                ),
+                "log_message": lambda *args: None,
+            },
+        )
+        server = ThreadingHTTPServer(
+            ("127.0.0.1", 0),
+            lambda *args: handler(*args, directory=project_dir),
+        )
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        base_url = f"http://127.0.0.1:{server.server_port}"
+
+        def status(path: str) -> int:
+            try:
+                with urlopen(base_url + path, timeout=5) as response:
+                    return response.status
+            except HTTPError as e:
+                return e.code
+
+        for path in (
+            "/",
+            "/index.html",
+            "/public/style.css",
+            "/.well-known/security.txt",
+        ):
+            self.assertEqual(status(path), 200, path)
+        for path in (
+            "/.git/config",
+            "/submissions.sqlite3",
+            "/_headers",
+            "/public/config.json",
+            "/public/",
+            "/%2e%2e/etc/passwd",
+        ):
+            self.assertEqual(status(path), 404, path)
+
+    @unittest.skipUnless(
+        shutil.which("mkcert") or shutil.which("openssl"), "needs mkcert or openssl"
+    )
+    def test_create_dev_certificate(self):
+        """A localhost certificate is created once and loads into a TLS context."""
+        cert_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, cert_dir)
+        cert_file, key_file = create_dev_certificate(cert_dir)
+        self.assertIsNotNone(create_ssl_context(cert_file, key_file))
+        mtime = os.path.getmtime(cert_file)
+        self.assertEqual(create_dev_certificate(cert_dir), (cert_file, key_file))
+        self.assertEqual(os.path.getmtime(cert_file), mtime)
+
+class TestFormBackend(unittest.TestCase):
+    """Test cases for the contact form backend endpoint."""
+
+    def setUp(self):
+        self.rules = [
+            FormFieldRule(name="name", required=True, max_length=5),
+            FormFieldRule(name="email", required=True, format="email"),
+        ]
+        self.sent = []
+        sink = mock.Mock()
+        sink.send.side_effect = self.sent.append
+        self.app = BackendApp(allowed_origins=["https://example.com"])
+        self.app.add_route(
+            "/api/contact",
+            ContactFormHandler(
+                ContactFormConfig(
+                    success_message_key="ok_key",
+                    error_message_key="error_key",
+                    fields=self.rules,
+                ),
+                {"en": {"ok_key": "Sent"}, "es": {"ok_key": "Enviado"}},
+                "en",
+                {"mock": sink},
+                spam_guard=SpamGuard(
+                    SpamProtection(honeypot_field="website", min_submit_seconds=3),
+                    token_secret="s3cret",
+                ),
+            ),
+            methods=("GET", "POST"),
+        )
+        # Issued long enough ago to pass the minimum submit time.
+        self.form_token = issue_form_token(b"s3cret", time.time() - 10)
+
+    def _post(self, fields, path="/api/contact", headers=None):
+        body = urlencode(fields).encode("utf-8")
+        environ = {
+            "REQUEST_METHOD": "POST",
+            "PATH_INFO": path,
+            "CONTENT_TYPE": "application/x-www-form-urlencoded",
+            "CONTENT_LENGTH": str(len(body)),
+            "HTTP_ACCEPT": "application/json",
+            **(headers or {}),
+        }
+        setup_testing_defaults(environ)
+        environ["wsgi.input"].write(body)
+        environ["wsgi.input"].seek(0)
+        response = {}
+
+        def start_response(status, response_headers):
+            response["status"] = status
+            response["headers"] = dict(response_headers)
+
+        body = b"".join(self.app(environ, start_response))
+        return response["status"], response["headers"], body
+
+    def test_validate_submission(self):
+        """Missing, too long and badly formatted values are reported."""
+        valid = {"name": "Ann", "email": "ann@example.com"}
+        self.assertEqual(validate_submission(valid, self.rules), [])
+        self.assertEqual(
+            validate_submission({"name": "Annabel", "email": "ann"}, self.rules),
+            [("name", "form_error_too_long"), ("email", "form_error_invalid")],
+        )
+        self.assertEqual(
+            validate_submission({}, self.rules)[0], ("name", "form_error_required")
+        )
+
+    def test_valid_submission_reaches_sinks(self):
+        """Valid submissions are sent on with only the known fields."""
+        status, headers, body = self._post(
+            {
+                "name": " Ann ",
+                "email": "ann@example.com",
+                "lang": "es",
+                "website": "",
+                "form_token": self.form_token,
+            },
+            headers={"HTTP_ORIGIN": "https://example.com"},
+        )
+        self.assertEqual(status, "200 OK")
+        self.assertEqual(json.loads(body), {"ok": True, "message": "Enviado"})
+        self.assertEqual(
+            headers["Access-Control-Allow-Origin"], "https://example.com"
+        )
+        self.assertEqual(len(self.sent), 1)
+        self.assertEqual(
+            self.sent[0].fields, {"name": "Ann", "email": "ann@example.com"}
+        )
+        self.assertEqual(self.sent[0].lang, "es")
+
+    def test_invalid_submission_returns_errors(self):
+        """Invalid submissions get a 422 with translated field errors."""
+        fields = {"name": "Ann", "email": "ann", "form_token": self.form_token}
+        status, _, body = self._post(fields)
+        self.assertEqual(status, "422 Unprocessable Entity")
+        self.assertEqual(
+            json.loads(body),
+            {"errors": [{"field": "email", "message": "This value is not valid."}]},
+        )
+        self.assertEqual(self.sent, [])
+
+    def test_plain_form_posts_get_a_page(self):
+        """Without the script, the messages come as a page linking back."""
+        browser = {
+            "HTTP_ACCEPT": "text/html,application/xhtml+xml,*/*;q=0.8",
+            "HTTP_REFERER": "https://example.com/index_es.html?a=1&b=2",
+        }
+        fields = {"name": "Ann", "lang": "es", "form_token": self.form_token}
+        status, headers, body = self._post({**fields, "email": "ann"}, headers=browser)
+        self.assertEqual(status, "422 Unprocessable Entity")
+        self.assertEqual(headers["Content-Type"], "text/html; charset=utf-8")
+        page = body.decode("utf-8")
+        self.assertIn('<html lang="es">', page)
+        self.assertIn("<li>email: This value is not valid.</li>", page)
+        self.assertIn("Go back with your browser", page)
+        self.assertIn(
+            '<a href="https://example.com/index_es.html?a=1&amp;b=2">', page
+        )
+        self.assertEqual(self.sent, [])
+
+        status, _, body = self._post(
+            {**fields, "email": "ann@example.com"},
+            headers={**browser, "HTTP_REFERER": "javascript:alert(1)"},
+        )
+        page = body.decode("utf-8")
+        self.assertEqual(status, "200 OK")
+        self.assertIn('<main role="status">\n<ul><li>Enviado</li></ul>', page)
+        self.assertNotIn("javascript:", page)
+
+    def test_email_sink_builds_localized_message(self):
+        """The subject follows the submission's language and fields."""
+        sink = EmailFormSink(
+            {
+                "host": "smtp.example.com",
+                "to": ["owner@example.com"],
+                "subject": {"en": "From {name}", "es": "De {name}"},
+            }
+        )
+        submission = FormSubmission(
+            form="contact",
+            fields={"name": "Ann\nBcc: x", "email": "ann@example.com"},
+            lang="es",
+            submitted_at="2024-01-01T00:00:00+00:00",
+        )
+        message = sink.build_message(submission)
+        self.assertEqual(message["Subject"], "De Ann Bcc: x")
+        self.assertEqual(message["To"], "owner@example.com")
+        self.assertEqual(message["Reply-To"], "ann@example.com")
+
+    @mock.patch("build_protocols.forms.time.sleep")
+    @mock.patch("build_protocols.form_email.smtplib.SMTP")
+    def test_email_sink_retries_failures(self, smtp_class, sleep):
+        """Transient SMTP errors are retried before giving up."""
+        smtp = smtp_class.return_value
+        smtp.send_message.side_effect = [OSError("timeout"), None]
+        sink = EmailFormSink(
+            {"host": "smtp.example.com", "to": ["a@example.com"], "retries": 1}
+        )
+        submission = FormSubmission("contact", {"name": "Ann"}, "en", "now")
+        sink.send(submission)
+        self.assertEqual(smtp.send_message.call_count, 2)
+        smtp.starttls.assert_called()
+        sleep.assert_called_once()
+
+        smtp.send_message.side_effect = OSError("down")
+        with self.assertRaises(OSError):
+            sink.send(submission)
+
+    @mock.patch("build_protocols.forms.time.sleep")
+    @mock.patch("build_protocols.form_notifications.post_json")
+    def test_notification_sinks(self, post_json, sleep):
+        """Chat and webhook sinks post the submission and retry failures."""
+        submission = FormSubmission("contact", {"name": "Ann"}, "en", "now")
+        telegram = TelegramFormSink({"bot_token": "T0KEN", "chat_id": 42})
+        telegram.send(submission)
+        url, payload = post_json.call_args[0][:2]
+        self.assertEqual(url, "https://api.telegram.org/botT0KEN/sendMessage")
+        self.assertEqual(payload["chat_id"], "42")
+        self.assertEqual(payload["text"], "New contact form submission (en)\nname: Ann")
+
+        post_json.reset_mock()
+        post_json.side_effect = [OSError("unreachable"), None]
+        WebhookFormSink({"url": "https://hooks.example.com", "retries": 1}).send(
+            submission
+        )
+        self.assertEqual(post_json.call_count, 2)
+        self.assertEqual(post_json.call_args[0][1]["fields"], {"name": "Ann"})
+        with self.assertRaises(ValueError):
+            WebhookFormSink({})
+
+    @mock.patch("build_protocols.forms.time.sleep")
+    def test_retries_stop_at_the_deadline(self, sleep):
+        """No retry starts that would keep the submitter waiting too long."""
+        deliver = mock.Mock(side_effect=OSError("down"))
+        settings = {"retries": 3, "retry_delay": 5, "retry_deadline_seconds": 8}
+        with self.assertRaises(OSError):
+            deliver_with_retries(deliver, settings, "a submission")
+        self.assertEqual(deliver.call_count, 2)
+        sleep.assert_called_once_with(5.0)
+
+    def test_backend_serves_requests_concurrently(self):
+        """A slow request does not hold up the next one."""
+        released = threading.Event()
+
+        def slow(_request):
+            released.wait(5)
+            return json_response(200, {"released": released.is_set()})
+
+        def release(_request):
+            released.set()
+            return json_response(200, {})
+
+        self.app.add_route("/slow", slow, ("GET",))
+        self.app.add_route("/release", release, ("GET",))
+        server = make_server(
+            "127.0.0.1",
+            0,
+            self.app,
+            server_class=ThreadingWSGIServer,
+            handler_class=type(
+                "QuietHandler",
+                (WSGIRequestHandler,),
+                {"log_message": lambda *args: None},
+            ),
+        )
+        threading.Thread(target=server.serve_forever, daemon=True).start()
+        self.addCleanup(server.server_close)
+        self.addCleanup(server.shutdown)
+        base_url = f"http://127.0.0.1:{server.server_port}"
+
+        responses = []
+        slow_request = threading.Thread(
+            target=lambda: responses.append(
+                json.loads(urlopen(base_url + "/slow", timeout=10).read())
+            )
+        )
+        slow_request.start()
+        time.sleep(0.1)
+        urlopen(base_url + "/release", timeout=5).read()
+        slow_request.join(10)
+        self.assertEqual(responses, [{"released": True}])
+
+    def test_spam_protection(self):
+        """Honeypot hits are silently dropped and hasty submissions refused."""
+        fields = {
+            "name": "Ann",
+            "email": "ann@example.com",
+            "form_token": self.form_token,
+        }
+        status, _, body = self._post({**fields, "website": "http://spam"})
+        self.assertEqual(status, "200 OK")
+        self.assertEqual(json.loads(body)["message"], "Sent")
+        self.assertEqual(self.sent, [])
+
+        status, _, _ = self._post({**fields, "form_token": issue_form_token(b"s3cret")})
+        self.assertEqual(status, "422 Unprocessable Entity")
+        status, _, _ = self._post(fields)
+        self.assertEqual(status, "200 OK")
+        self.assertNotIn("form_token", self.sent[0].fields)
+
+    def test_form_tokens_cannot_be_forged(self):
+        """Only tokens the backend signed count towards the minimum time."""
+        status, headers, body = self._post({}, headers={"REQUEST_METHOD": "GET"})
+        self.assertEqual(status, "200 OK")
+        self.assertEqual(headers["Cache-Control"], "no-store")
+        self.assertRegex(json.loads(body)["token"], r"^\d+\.[0-9a-f]{64}$")
+
+        issued_at = str(int(time.time()) - 10)
+        for token in (
+            "999",
+            "nan",
+            "inf",
+            f"{issued_at}.{'0' * 64}",
+            issue_form_token(b"other", time.time() - 10),
+            issue_form_token(b"s3cret", time.time() - 2 * 24 * 3600),
+            issue_form_token(b"s3cret", time.time() + 60),
+            "",
+        ):
+            with self.subTest(token=token):
+                status, _, _ = self._post(
+                    {"name": "Ann", "email": "ann@example.com", "form_token": token}
+                )
+                self.assertEqual(status, "422 Unprocessable Entity")
+        self.assertEqual(self.sent, [])
+
+    def test_rate_limiter(self):
+        """Only `limit` events per key fit in the sliding window."""
+        limiter = RateLimiter(limit=2, window_seconds=60)
+        self.assertTrue(limiter.allow("1.2.3.4", now=0))
+        self.assertTrue(limiter.allow("1.2.3.4", now=10))
+        self.assertFalse(limiter.allow("1.2.3.4", now=20))
+        self.assertTrue(limiter.allow("5.6.7.8", now=20))
+        self.assertTrue(limiter.allow("1.2.3.4", now=61))
+
+    def test_forwarded_for_ignores_client_entries(self):
+        """Spoofed `X-Forwarded-For` entries never become the client address."""
+        environ = {
+            "REMOTE_ADDR": "10.0.0.2",
+            "HTTP_X_FORWARDED_FOR": "6.6.6.6, 7.7.7.7, 1.2.3.4, 10.0.0.1",
+        }
+        self.assertEqual(self.app._client_address(environ), "10.0.0.2")
+        self.assertEqual(
+            BackendApp(trust_forwarded_for=True)._client_address(environ), "10.0.0.1"
+        )
+        self.assertEqual(
+            BackendApp(trust_forwarded_for=True, trusted_proxies=2)._client_address(
+                environ
+            ),
+            "1.2.3.4",
+        )
+        self.assertEqual(
+            BackendApp(trust_forwarded_for=True, trusted_proxies=3)._client_address(
+                {"HTTP_X_FORWARDED_FOR": "1.2.3.4"}
+            ),
+            "1.2.3.4",
+        )
+
+    def test_submission_storage_listing(self):
+        """Stored submissions are listed to the admin as JSON or CSV."""
+        db_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, db_dir)
+        sink = SqliteFormSink({"path": os.path.join(db_dir, "db.sqlite3")})
+        for name in ("Ann", "=cmd()"):
+            sink.send(
+                FormSubmission(
+                    form="contact",
+                    fields={"name": name},
+                    lang="en",
+                    submitted_at="2024-01-01T00:00:00+00:00",
+                )
+            )
+        self.app.add_route(
+            "/api/submissions", SubmissionsHandler(sink.store, "t0ken"), ("GET",)
+        )
+        get = {"REQUEST_METHOD": "GET", "HTTP_AUTHORIZATION": "Bearer t0ken"}
+
+        status, _, _ = self._post({}, "/api/submissions", {"REQUEST_METHOD": "GET"})
+        self.assertEqual(status, "401 Unauthorized")
+        status, _, body = self._post({}, "/api/submissions", get)
+        self.assertEqual(status, "200 OK")
+        listing = json.loads(body)
+        self.assertEqual(listing["count"], 2)
+        self.assertEqual(listing["submissions"][1]["fields"], {"name": "Ann"})
+
+        status, headers, body = self._post(
+            {}, "/api/submissions", {**get, "QUERY_STRING": "format=csv&limit=1"}
+        )
+        self.assertEqual(headers["Content-Type"], "text/csv; charset=utf-8")
+        self.assertEqual(
+            body.decode("utf-8").splitlines(),
+            [
+                "id,form,lang,submitted_at,remote_addr,name",
+                "2,contact,en,2024-01-01T00:00:00+00:00,,'=cmd()",
+            ],
+        )
+
+    def test_webhook_rebuilds(self):
+        """Signed webhooks queue one debounced rebuild; others are refused."""
+        builds = []
+        queue = RebuildQueue(lambda: builds.append(1), debounce_seconds=0.2)
+        with mock.patch.dict(os.environ, {"HOOK_SECRET": "s3cret"}):
+            handler = WebhookHandler("github", {"secret_env": "HOOK_SECRET"}, queue)
+            with self.assertRaises(ValueError):
+                WebhookHandler("github", {"secret_env": "MISSING_SECRET"}, queue)
+        self.app.add_route("/api/webhooks/github", handler)
+        self.app.add_route("/api/rebuild/status", RebuildStatusHandler(queue), ("GET",))
+
+        body = urlencode({}).encode("utf-8")
+        signature = "sha256=" + hmac.new(b"s3cret", body, hashlib.sha256).hexdigest()
+        status, _, _ = self._post(
+            {}, "/api/webhooks/github", {"HTTP_X_HUB_SIGNATURE_256": "sha256=00"}
+        )
+        self.assertEqual(status, "401 Unauthorized")
+        status, _, _ = self._post(
+            {},
+            "/api/webhooks/github",
+            {"HTTP_X_HUB_SIGNATURE_256": signature, "HTTP_X_GITHUB_EVENT": "ping"},
+        )
+        self.assertEqual(status, "200 OK")
+        for _ in range(3):
+            status, _, _ = self._post(
+                {}, "/api/webhooks/github", {"HTTP_X_HUB_SIGNATURE_256": signature}
+            )
+            self.assertEqual(status, "202 Accepted")
+
+        self.assertTrue(queue.wait_until_idle(timeout=5))
+        self.assertEqual(builds, [1])
+        _, _, body = self._post({}, "/api/rebuild/status", {"REQUEST_METHOD": "GET"})
+        status_report = json.loads(body)
+        self.assertEqual(status_report["state"], "idle")
+        self.assertEqual(status_report["last_result"], "success")
+
+    @mock.patch("build.create_template_environment")
+    @mock.patch("build.SourceWatcher")
+    @mock.patch("build.create_orchestrator")
+    def test_in_process_rebuilds_are_incremental(
+        self, mock_create, mock_watcher, _mock_env
+    ):
+        """Rebuilds share their caches and drop only what changed files affect."""
+        changed = os.path.join("data", "faq.json")
+        mock_watcher.return_value.changed.side_effect = [[], [changed]]
+        build = incremental_build("production")
+        with mock.patch("build.invalidate_build_caches") as invalidate:
+            build()
+            build()
+        first, second = (call.kwargs for call in mock_create.call_args_list)
+        for cache in ("jinja_env", "data_cache", "build_cache"):
+            self.assertIsNotNone(first[cache])
+            self.assertIs(first[cache], second[cache])
+        self.assertEqual(invalidate.call_args.args[2], [changed])
+        self.assertEqual(mock_create.return_value.build_all_languages.call_count, 2)
+
+    def test_contentful_signature(self):
+        """Contentful signatures cover the signed headers and expire."""
+        body = b'{"sys": {}}'
+        headers = {
+            "x-contentful-timestamp": "1700000000000",
+            "x-contentful-signed-headers": "x-contentful-timestamp",
+        }
+        canonical = "POST\n/api/webhooks/contentful\n"
+        canonical += "x-contentful-timestamp:1700000000000\n" + body.decode("utf-8")
+        headers["x-contentful-signature"] = hmac.new(
+            b"s3cret", canonical.encode("utf-8"), hashlib.sha256
+        ).hexdigest()
+        request = BackendRequest(
+            "POST", "/api/webhooks/contentful", {}, headers, body, "1.2.3.4"
+        )
+        self.assertTrue(verify_contentful(request, "s3cret", {}, now=1700000010))
+        self.assertFalse(verify_contentful(request, "s3cret", {}, now=1700000100))
+        self.assertFalse(verify_contentful(request, "other", {}, now=1700000010))
+
+        # The query is signed as sent: encoded, with repeated keys.
+        query_string = "space=a%2Fb%20c&env=x&env=y"
+        canonical = (
+            f"POST\n/api/webhooks/contentful?{query_string}\n"
+            "x-contentful-timestamp:1700000000000\n"
+        )
+        headers["x-contentful-signature"] = hmac.new(
+            b"s3cret", canonical.encode("utf-8"), hashlib.sha256
+        ).hexdigest()
+        queue = mock.Mock(**{"status.return_value": {}})
+        with mock.patch.dict(os.environ, {"HOOK_SECRET": "s3cret"}):
+            handler = WebhookHandler("contentful", {"secret_env": "HOOK_SECRET"}, queue)
+        self.app.add_route("/api/webhooks/contentful", handler)
+        environ = {
+            "HTTP_" + name.upper().replace("-", "_"): value
+            for name, value in headers.items()
+        }
+        with mock.patch("build_protocols.rebuilds.time.time", return_value=1700000010):
+            status, _, _ = self._post(
+                {},
+                "/api/webhooks/contentful",
+                {**environ, "QUERY_STRING": query_string},
+            )
+        self.assertEqual(status, "202 Accepted")
+        queue.request.assert_called_once_with("contentful")
+
+    @mock.patch("build_protocols.newsletter.post_json")
+    def test_newsletter_signup(self, post_json):
+        """Signups are proxied to the provider and answered per outcome."""
+        self.app.add_route(
+            "/api/newsletter",
+            NewsletterHandler(
+                NewsletterConfig(
+                    success_message_key="subscribed",
+                    pending_message_key="pending",
+                    exists_message_key="exists",
+                    error_message_key="failed",
+                ),
+                {"provider": "buttondown"},
+                {"en": {"pending": "Check your inbox"}},
+                "en",
+            ),
+        )
+        post_json.return_value = {"type": "unactivated"}
+        status, _, body = self._post({"email": "ann@example.com"}, "/api/newsletter")
+        self.assertEqual(status, "200 OK")
+        self.assertEqual(json.loads(body)["message"], "Check your inbox")
+        payload = post_json.call_args[0][1]
+        self.assertEqual(payload, {"email_address": "ann@example.com"})
+
+        error_body = io.BytesIO(b'{"detail": "Already subscribed"}')
+        post_json.side_effect = HTTPError("url", 400, "Bad Request", {}, error_body)
+        _, _, body = self._post({"email": "ann@example.com"}, "/api/newsletter")
+        self.assertEqual(json.loads(body)["message"], "exists")
+
+        status, _, _ = self._post({"email": "not-an-email"}, "/api/newsletter")
+        self.assertEqual(status, "422 Unprocessable Entity")
+
+    def test_unknown_route_and_method(self):
+        """Unknown paths get a 404 and other methods a 405."""
+        status, _, _ = self._post({}, path="/api/other")
+        self.assertEqual(status, "404 Not Found")
+        status, headers, _ = self._post({}, headers={"REQUEST_METHOD": "PUT"})
+        self.assertEqual(status, "405 Method Not Allowed")
+        self.assertEqual(headers["Allow"], "GET, POST")
+
+class TestContentApi(unittest.TestCase):
+    """Test cases for the static JSON content API export."""
+
+    @mock.patch(
+        "build_protocols.content_api.json_format.MessageToDict",
+        side_effect=lambda message, **kwargs: message,
+    )
+    def test_generates_localized_endpoints(self, _):
+        """Collections are written per language with translated keys."""
+        context = BuildContext(
+            app_config={
+                "content_api": {
+                    "enabled": True,
+                    "collections": {"blog": "blog.html", "hero": "hero.html"},
+                }
+            },
+            default_lang="en",
+            supported_langs=["en", "es"],
+            block_data={
+                "blog.html": [
+                    {"id": "post1", "title": {"key": "t1"}},
+                    {"id": "../x", "title": {"key": "t2"}},
+                ],
+                "hero.html": {"title": {"key": "missing"}},
+            },
+            translations_by_lang={"en": {"t1": "First"}, "es": {"t1": "Primero"}},
+        )
+        artifacts = ContentApiGenerator(jinja_env=None).generate_artifacts(context)
+
+        self.assertEqual(
+            sorted(artifacts),
+            [
+                "api/en/blog/index.json",
+                "api/en/blog/post1.json",
+                "api/en/hero.json",
+                "api/es/blog/index.json",
+                "api/es/blog/post1.json",
+                "api/es/hero.json",
+                "api/index.json",
+            ],
+        )
+        blog = json.loads(artifacts["api/es/blog/index.json"])
+        self.assertEqual(blog["count"], 2)
+        self.assertEqual(blog["items"][0]["title"], {"key": "t1", "text": "Primero"})
+        hero = json.loads(artifacts["api/en/hero.json"])
+        self.assertEqual(hero["item"]["title"]["text"], "missing")
+        manifest = json.loads(artifacts["api/index.json"])
+        self.assertEqual(
+            manifest["collections"]["blog"]["es"], "/api/es/blog/index.json"
+        )
+
+class FakeBucketTarget(BucketDeployTarget):
+    """A bucket target keeping its objects in memory."""
+
+    def __init__(self, settings, objects):
+        super().__init__(settings)
+        self.objects = objects
+        self.uploads = []
+
+    def list_objects(self, prefix):
+        return dict(self.objects)
+
+    def upload(self, key, local_path, content_type, cache_control):
+        self.uploads.append((key, content_type, cache_control))
+        self.objects[key] = file_md5(local_path)
+
+    def delete(self, keys):
+        for key in keys:
+            del self.objects[key]
+
+
+class TestDeploy(unittest.TestCase):
+    """Test cases for collecting and syncing the site to a deploy target."""
+
+    def setUp(self):
+        self.site_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, self.site_dir)
+        cwd = os.getcwd()
+        os.chdir(self.site_dir)
+        self.addCleanup(os.chdir, cwd)
+        for path in ("index.html", "public/style.css", "public/config.json"):
+            os.makedirs(os.path.dirname(path) or ".", exist_ok=True)
+            with open(path, "w", encoding="utf-8") as f:
+                f.write(path)
+
+    def test_collect_site_files(self):
+        """Written files and included static files, minus excluded ones."""
+        files = collect_site_files(["index.html"], ["public"], ["public/config.json"])
+        self.assertEqual(list(files), ["index.html", "public/style.css"])
+
+    def test_cache_control(self):
+        """HTML is revalidated and fingerprinted assets are immutable."""
+        self.assertEqual(cache_control_for("es/index.html", {}), "no-cache")
+        self.assertIn("immutable", cache_control_for("app.3f2a9c1d.js", {}))
+        self.assertEqual(
+            cache_control_for("style.css", {"default": "max-age=60"}), "max-age=60"
+        )
+
+    def test_sync_uploads_changes_and_deletes_removed(self):
+        """Unchanged objects are kept, removed ones deleted under the prefix."""
+        files = collect_site_files(["index.html"], ["public/style.css"], [])
+        target = FakeBucketTarget(
+            {"bucket": "site", "prefix": "www"},
+            {
+                "www/public/style.css": file_md5("public/style.css"),
+                "www/old.html": "0" * 32,
+                "other/keep.html": "0" * 32,
+            },
+        )
+
+        dry_summary = target.deploy(files, dry_run=True)
+        self.assertEqual(dry_summary.uploaded, ["index.html"])
+        self.assertEqual(target.uploads, [])
+
+        summary = target.deploy(files)
+        self.assertEqual(summary.uploaded, ["index.html"])
+        self.assertEqual(summary.unchanged, ["public/style.css"])
+        self.assertEqual(summary.deleted, ["old.html"])
+        self.assertEqual(
+            target.uploads,
+            [("www/index.html", "text/html; charset=utf-8", "no-cache")],
+        )
+        self.assertEqual(
+            sorted(target.objects),
+            ["other/keep.html", "www/index.html", "www/public/style.css"],
+        )
+
+    def test_cancelled_deploy_uploads_nothing_more(self):
+        """A cancelled deploy stops before its next upload."""
+        files = collect_site_files(["index.html"], ["public/style.css"], [])
+        target = FakeBucketTarget({"bucket": "site"}, {})
+        upload = target.upload
+
+        def upload_then_cancel(*args):
+            upload(*args)
+            target.deadline.cancel()
+
+        target.upload = upload_then_cancel
+        with self.assertRaises(OperationCancelled):
+            target.deploy(files)
+        self.assertEqual([key for key, _, _ in target.uploads], ["index.html"])
+
+    def test_branch_previews(self):
+        """Previews sync their subdirectory; main deploys keep the previews."""
+        self.assertEqual(
+            preview_directory("previews", "feature/New_Form"),
+            "previews/feature-new-form",
+        )
+        files = collect_site_files(["index.html"], [], [])
+        objects = {"index.html": "0" * 32, "previews/old/index.html": "0" * 32}
+        preview = FakeBucketTarget(
+            {"bucket": "site", "subdirectory": "previews/feature-x"}, objects
+        )
+        self.assertEqual(preview.deploy(files).deleted, [])
+        self.assertEqual(preview.uploads[0][0], "previews/feature-x/index.html")
+
+        main = FakeBucketTarget({"bucket": "site", "preserve": ["previews"]}, objects)
+        self.assertEqual(main.deploy(files).deleted, [])
+        self.assertEqual(
+            sorted(objects),
+            [
+                "index.html",
+                "previews/feature-x/index.html",
+                "previews/old/index.html",
+            ],
+        )
+
+    def test_select_host_config(self):
+        """Only the header and redirect files of the target's host are kept."""
+        files = {"index.html": "", "_headers": "", "nginx-headers.conf": ""}
+        app_config = {"redirects": {"enabled": True, "formats": ["nginx"]}}
+        with self.assertLogs("build_protocols.deploy", level="WARNING") as logs:
+            selected = select_host_config(files, app_config, ["netlify"])
+        self.assertEqual(list(selected), ["index.html", "_headers"])
+        self.assertIn("_redirects", logs.output[0])
+        self.assertEqual(list(select_host_config(files, {}, [])), ["index.html"])
+
+    def test_select_host_config_keeps_edge_code_of_the_host(self):
+        """Only Cloudflare gets `_worker.js`; Netlify's edge function never ships."""
+        files = {
+            "index.html": "",
+            "_worker.js": "",
+            "netlify/edge-functions/ab-split.js": "",
+        }
+        self.assertEqual(
+            list(select_host_config(files, {}, ["netlify", "cloudflare"])),
+            ["index.html", "_worker.js"],
+        )
+        self.assertEqual(
+            list(select_host_config(files, {}, ["netlify"])), ["index.html"]
+        )
+
+    @mock.patch.dict(
+        os.environ,
+        {
+            "GIT_AUTHOR_NAME": "Test",
+            "GIT_AUTHOR_EMAIL": "test@example.com",
+            "GIT_COMMITTER_NAME": "Test",
+            "GIT_COMMITTER_EMAIL": "test@example.com",
+        },
+    )
+    def test_gh_pages_commits_and_pushes_site(self):
+        """The site is pushed as the branch's tree, once per change."""
+        remote_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, remote_dir)
+        run_command(["git", "init", "--quiet", "--bare", remote_dir])
+        run_command(["git", "init", "--quiet"])
+        run_command(["git", "remote", "add", "origin", remote_dir])
+        target = GitHubPagesDeployTarget({"cname": "www.example.com"})
+        files = collect_site_files(["index.html"], ["public/style.css"], [])
+
+        summary = target.deploy(files)
+        self.assertEqual(
+            summary.uploaded, [".nojekyll", "CNAME", "index.html", "public/style.css"]
+        )
+        published = run_command(
+            ["git", "--git-dir", remote_dir, "ls-tree", "-r", "--name-only", "gh-pages"]
+        ).split()
+        self.assertEqual(
+            published, [".nojekyll", "CNAME", "index.html", "public/style.css"]
+        )
+
+        os.remove("public/style.css")
+        summary = target.deploy(collect_site_files(["index.html"], [], []))
+        self.assertEqual(summary.uploaded, [])
+        self.assertEqual(summary.deleted, ["public/style.css"])
+        self.assertEqual(target.deploy(files={}, dry_run=True).deleted, ["index.html"])
+
+        preview = GitHubPagesDeployTarget({"subdirectory": "previews/feature-x"})
+        summary = preview.deploy(collect_site_files(["index.html"], [], []))
+        self.assertEqual(summary.deleted, [])
+        published = run_command(
+            ["git", "--git-dir", remote_dir, "ls-tree", "-r", "--name-only", "gh-pages"]
+        ).split()
+        self.assertEqual(
+            published,
+            [".nojekyll", "CNAME", "index.html", "previews/feature-x/index.html"],
+        )
+
+
+class TestTimeouts(unittest.TestCase):
+    """Test cases for operation timeouts and cancelling long-running stages."""
+
+    def test_deadline_caps_operation_timeouts(self):
+        """Operations get their own timeout, cut to the time left."""
+        now = [100.0]
+        deadline = Deadline(10, clock=lambda: now[0])
+        self.assertEqual(deadline.timeout(60), 10)
+        now[0] += 8
+        self.assertEqual(deadline.timeout(1), 1)
+        self.assertEqual(deadline.timeout(60), 2)
+        now[0] += 2
+        with self.assertRaisesRegex(OperationCancelled, "10 s deadline"):
+            deadline.timeout(60, "uploading index.html")
+        self.assertEqual(Deadline().timeout(60), 60)
+
+    def test_cancelled_deadline_stops_operations(self):
+        """No operation starts once the stage is cancelled."""
+        deadline = Deadline()
+        deadline.check()
+        deadline.cancel()
+        with self.assertRaisesRegex(OperationCancelled, "before git push"):
+            deadline.check("git push")
+
+    def test_sigterm_cancels_deadline(self):
+        """SIGTERM cancels the deadline; the handler is restored afterwards."""
+        previous = signal.getsignal(signal.SIGTERM)
+        with self.assertLogs("build_protocols.timeouts", "WARNING"):
+            with cancel_on_signal(Deadline()) as deadline:
+                signal.raise_signal(signal.SIGTERM)
+        self.assertTrue(deadline.cancelled)
+        self.assertEqual(signal.getsignal(signal.SIGTERM), previous)
+
+    def test_hung_command_times_out(self):
+        """A command running past its timeout is killed and reported."""
+        started = time.monotonic()
+        with self.assertRaisesRegex(DeployError, "timed out after 0.2 s"):
+            run_command(
+                [sys.executable, "-c", "import time; time.sleep(30)"], timeout=0.2
+            )
+        self.assertLess(time.monotonic() - started, 10)
+
+
+class TestStaging(unittest.TestCase):
+    """Test cases for password-protected, noindex staging builds."""
+
+    def setUp(self):
+        self.app_config = {
+            "staging": {"enabled": True, "username": "client"},
+            "security_headers": {"enabled": True, "formats": ["netlify", "nginx"]},
+        }
+
+    def _context(self, build_profile):
+        return BuildContext(
+            app_config=self.app_config,
+            default_lang="en",
+            supported_langs=["en"],
+            build_profile=build_profile,
+        )
+
+    @mock.patch.dict(os.environ, {"STAGING_PASSWORD": "s3cret"})
+    def test_staging_profile_is_protected_and_noindex(self):
+        """Staging builds require the password and keep crawlers out."""
+        context = self._context("staging")
+        generator = StagingGenerator(Environment())
+        self.assertEqual(
+            generator.get_page_context("en", context), {"robots": "noindex, nofollow"}
+        )
+        artifacts = generator.generate_artifacts(context)
+        self.assertEqual(artifacts["robots.txt"], "User-agent: *\nDisallow: /\n")
+        self.assertIn("auth_basic_user_file", artifacts["nginx-staging.conf"])
+        self.assertTrue(artifacts["staging.htpasswd"].startswith("client:{SSHA}"))
+
+        headers = SecurityHeadersGenerator(Environment()).generate_artifacts(context)
+        self.assertIn("  Basic-Auth: client:s3cret", headers["_headers"])
+        self.assertIn("X-Robots-Tag", headers["nginx-headers.conf"])
+        self.assertNotIn("Basic-Auth", headers["nginx-headers.conf"])
+
+    @mock.patch.dict(os.environ, {"STAGING_PASSWORD": "s3cret"})
+    def test_cloudflare_never_publishes_basic_auth(self):
+        """Cloudflare Pages would send the password as a header: refused."""
+        headers = SecurityHeadersGenerator(Environment()).generate_artifacts(
+            self._context("staging")
+        )
+        site_dir = tempfile.mkdtemp()
+        self.addCleanup(shutil.rmtree, site_dir)
+        headers_path = os.path.join(site_dir, "_headers")
+        with open(headers_path, "w", encoding="utf-8") as f:
+            f.write(headers["_headers"])
+        target = CloudflarePagesDeployTarget(
+            {"project_name": "site", "wrangler": ["wrangler-must-not-run"]}
+        )
+        for dry_run in (True, False):
+            with self.assertRaisesRegex(DeployError, "staging password"):
+                target.deploy({"_headers": headers_path}, dry_run=dry_run)
+
+    def test_other_profiles_are_public(self):
+        """Production builds are left untouched."""
+        context = self._context("production")
+        generator = StagingGenerator(Environment())
+        self.assertEqual(generator.get_page_context("en", context), {})
+        self.assertEqual(generator.generate_artifacts(context), {})
+
+    def test_htpasswd_line(self):
+        """The {SSHA} entry is the salted SHA-1 digest followed by the salt."""
+        encoded = base64.b64encode(hashlib.sha1(b"pwsalt").digest() + b"salt")
+        self.assertEqual(
+            htpasswd_line("client", "pw", salt=b"salt"),
+            f"client:{{SSHA}}{encoded.decode('ascii')}\n",
+        )
+
+
 if __name__ == "__main__":
     unittest.main()